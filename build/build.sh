@@ -36,7 +36,8 @@ export GOARCH="${ARCH}"
 export GOOS="${OS}"
 export GOFLAGS="-mod=vendor"
 
-go install                                                      \
-    -installsuffix "static"                                     \
-    -ldflags "-X $(go list -m)/pkg/version.VERSION=${VERSION}"  \
+go install                                                                  \
+    -installsuffix "static"                                                 \
+    -ldflags "-X $(go list -m)/pkg/version.VERSION=${VERSION}               \
+              -X $(go list -m)/pkg/version.GIT_COMMIT=${GIT_COMMIT:-UNKNOWN}" \
     ./...
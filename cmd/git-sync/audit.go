@@ -0,0 +1,213 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How the link was flipped, for the audit log.
+const (
+	auditActionSync     = "sync"
+	auditActionRollback = "rollback"
+)
+
+// auditRecord is one line of the --audit-log-file.
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	OldHash string    `json:"oldHash,omitempty"`
+	NewHash string    `json:"newHash"`
+	Ref     string    `json:"ref"`
+	Action  string    `json:"action"`
+	// Trigger is what started the sync or rollback, e.g. triggerPoll.
+	Trigger string `json:"trigger"`
+	// Prev is the SHA-256 of the previous line of the file, so that
+	// removing or changing a record breaks the chain.
+	Prev string `json:"prev"`
+}
+
+// auditLog appends records to the --audit-log-file.
+type auditLog struct {
+	mutex sync.Mutex
+	path  string
+	// tip is the checksum of the last line, or nil if it has not been read
+	// yet.
+	tip *string
+}
+
+var audit auditLog
+
+// lineSum returns the chaining checksum of one line, without its newline.
+func lineSum(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLineSum returns the checksum of the last line in the file at path,
+// or "" if the file is empty or does not exist.
+func lastLineSum(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return "", nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return lineSum(data), nil
+}
+
+// append writes one record to the log, chained to the previous one.
+func (a *auditLog) append(rec auditRecord) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.tip == nil {
+		tip, err := lastLineSum(a.path)
+		if err != nil {
+			return err
+		}
+		a.tip = &tip
+	}
+	rec.Prev = *a.tip
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	tip := lineSum(line)
+	a.tip = &tip
+	return nil
+}
+
+// auditFlip records that the link was flipped from oldWorktree to
+// newWorktree by action, which trigger started, if --audit-log-file is set.
+// Failures are logged, since the flip has already happened.
+func auditFlip(action, trigger, oldWorktree, newWorktree string) {
+	if *flAuditLogFile == "" {
+		return
+	}
+	rec := auditRecord{
+		Time:    time.Now().UTC(),
+		NewHash: worktreeHash(newWorktree),
+		Ref:     refForRev(*flBranch, *flRev),
+		Action:  action,
+		Trigger: trigger,
+	}
+	if oldWorktree != "" {
+		rec.OldHash = worktreeHash(oldWorktree)
+	}
+	if *flSource == sourceOCI {
+		rec.Ref = *flOCIRef
+	}
+	audit.path = *flAuditLogFile
+	if err := audit.append(rec); err != nil {
+		log.Error(err, "can't write audit log", "path", *flAuditLogFile, "hash", rec.NewHash)
+	}
+}
+
+// verifyAuditLog checks the hash chain of an audit log, and returns how many
+// records it holds.
+func verifyAuditLog(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	prev := ""
+	n := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		var rec auditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return n, fmt.Errorf("record %d is invalid: %v", n, err)
+		}
+		if rec.Prev != prev {
+			return n, fmt.Errorf("record %d does not follow record %d", n, n-1)
+		}
+		prev = lineSum(line)
+	}
+	return n, scanner.Err()
+}
+
+// serveAudit handles requests to the /api/v1/audit endpoint, which returns
+// the audit log.  With "verify=true", it checks the hash chain instead.
+func serveAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	audit.mutex.Lock()
+	data, err := ioutil.ReadFile(*flAuditLogFile)
+	audit.mutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(err, "can't read audit log", "path", *flAuditLogFile)
+		http.Error(w, "can't read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("verify") == "true" {
+		n, err := verifyAuditLog(bytes.NewReader(data))
+		w.Header().Set("Content-Type", "application/json")
+		result := struct {
+			Valid   bool   `json:"valid"`
+			Records int    `json:"records"`
+			Error   string `json:"error,omitempty"`
+		}{err == nil, n, errorString(err)}
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Write(data)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	a := &auditLog{path: path}
+	if err := a.append(auditRecord{Time: now, NewHash: hash1, Ref: "main", Action: auditActionSync, Trigger: triggerStartup}); err != nil {
+		t.Fatal(err)
+	}
+	// A new process must continue the chain from the file.
+	a = &auditLog{path: path}
+	if err := a.append(auditRecord{Time: now, OldHash: hash1, NewHash: hash2, Ref: "main", Action: auditActionSync, Trigger: triggerPoll}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.append(auditRecord{Time: now, OldHash: hash2, NewHash: hash1, Ref: "main", Action: auditActionRollback, Trigger: triggerHTTP}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(lines))
+	}
+	var first auditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Prev != "" || first.NewHash != hash1 {
+		t.Errorf("unexpected first record: %+v", first)
+	}
+	if n, err := verifyAuditLog(bytes.NewReader(data)); err != nil || n != 3 {
+		t.Errorf("expected a valid chain of 3, got %d, %v", n, err)
+	}
+
+	// Dropping a record breaks the chain.
+	tampered := lines[0] + "\n" + lines[2] + "\n"
+	if _, err := verifyAuditLog(strings.NewReader(tampered)); err == nil {
+		t.Errorf("expected an error for a missing record")
+	}
+	// So does editing one.
+	tampered = lines[0] + "\n" + strings.Replace(lines[1], hash2, hash1, 1) + "\n" + lines[2] + "\n"
+	if _, err := verifyAuditLog(strings.NewReader(tampered)); err == nil {
+		t.Errorf("expected an error for an edited record")
+	}
+}
+
+func TestLastLineSum(t *testing.T) {
+	dir := t.TempDir()
+	if sum, err := lastLineSum(filepath.Join(dir, "missing")); err != nil || sum != "" {
+		t.Errorf("expected empty sum for a missing file, got %q, %v", sum, err)
+	}
+	path := filepath.Join(dir, "log")
+	if err := ioutil.WriteFile(path, []byte("one\ntwo\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := lastLineSum(path); err != nil || sum != lineSum([]byte("two")) {
+		t.Errorf("expected the sum of the last line, got %q, %v", sum, err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := lastLineSum(path); err != nil || sum != "" {
+		t.Errorf("expected empty sum for an empty file, got %q, %v", sum, err)
+	}
+}
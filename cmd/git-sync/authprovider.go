@@ -0,0 +1,547 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --auth-provider.
+const (
+	authProviderAzureDevOps = "azure-devops"
+	authProviderCodeCommit  = "codecommit-sigv4"
+	authProviderGCP         = "gcp-source-repos"
+)
+
+// authProviderRefreshMargin is how long before they expire cached tokens are
+// fetched again, so that they can't expire during a sync.
+const authProviderRefreshMargin = 5 * time.Minute
+
+var (
+	authProviderCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_sync_auth_provider_calls_total",
+		Help: "How many times --auth-provider was asked for credentials, partitioned by state (success, error)",
+	}, []string{"status"})
+	authTokenRefreshCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_sync_auth_token_refresh_total",
+		Help: "How many times an --auth-provider token was fetched, partitioned by provider and state (success, error)",
+	}, []string{"provider", "status"})
+	authTokenExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "git_sync_auth_token_expiry_timestamp_seconds",
+		Help: "When the current --auth-provider token expires, as a Unix time",
+	}, []string{"provider"})
+)
+
+func init() {
+	prometheus.MustRegister(authProviderCount)
+	prometheus.MustRegister(authTokenRefreshCount)
+	prometheus.MustRegister(authTokenExpiry)
+}
+
+// authProvider generates short-lived git credentials for a platform, from
+// the identity git-sync runs as, so that no askpass service is needed.
+type authProvider interface {
+	// validate returns an error if credentials for repo can't be generated.
+	validate(repo string) error
+	// credentials returns a username and password for repo, which are valid
+	// for at least one sync.
+	credentials(ctx context.Context, repo string, now time.Time) (string, string, error)
+	// forget drops any cached token, so that the next call to credentials
+	// gets a new one.
+	forget()
+}
+
+var authProviders = map[string]authProvider{
+	authProviderAzureDevOps: &azureDevOpsProvider{token: cachedToken{provider: authProviderAzureDevOps}},
+	authProviderCodeCommit:  &codeCommitProvider{},
+	authProviderGCP:         &gcpProvider{token: cachedToken{provider: authProviderGCP}},
+}
+
+func authProviderNames() []string {
+	names := []string{}
+	for name := range authProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// authClient is used for token requests, which should be quick.
+var authClient = &http.Client{Timeout: 10 * time.Second}
+
+// callAuthProvider stores credentials from --auth-provider for git to use.
+// Like --askpass-url, this is done before every sync.
+func callAuthProvider(ctx context.Context) error {
+	username, password, err := authProviders[*flAuthProvider].credentials(ctx, *flRepo, time.Now())
+	if err != nil {
+		authProviderCount.WithLabelValues(metricKeyError).Inc()
+		return fmt.Errorf("can't get credentials from --auth-provider=%s: %w", *flAuthProvider, err)
+	}
+	authProviderCount.WithLabelValues(metricKeySuccess).Inc()
+	return setupGitAuth(ctx, username, password, *flRepo)
+}
+
+// cachedToken is a provider's token and when it expires.
+type cachedToken struct {
+	provider string
+	mutex    sync.Mutex
+	value    string
+	expires  time.Time
+}
+
+// get returns the token, calling fetch for a new one if there is none yet or
+// it expires within authProviderRefreshMargin.
+func (c *cachedToken) get(now time.Time, fetch func() (string, time.Time, error)) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.value != "" && now.Add(authProviderRefreshMargin).Before(c.expires) {
+		return c.value, nil
+	}
+	value, expires, err := fetch()
+	if err != nil {
+		authTokenRefreshCount.WithLabelValues(c.provider, metricKeyError).Inc()
+		return "", err
+	}
+	authTokenRefreshCount.WithLabelValues(c.provider, metricKeySuccess).Inc()
+	authTokenExpiry.WithLabelValues(c.provider).Set(float64(expires.Unix()))
+	log.V(2).Info("fetched auth token", "provider", c.provider, "expires", expires)
+	c.value = value
+	c.expires = expires
+	return value, nil
+}
+
+// forget drops the token.
+func (c *cachedToken) forget() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value = ""
+}
+
+// readAuthResponse reads the body of a token response, which must be 200.
+func readAuthResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d: %q", resp.Request.URL.Host, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// azureDevOpsResource is the Azure AD application ID of Azure DevOps, for
+// which tokens are requested.
+const azureDevOpsResource = "499b84ac-1321-427f-aa17-267ca6975798"
+
+// azureIMDSEndpoint is the Azure instance metadata service's token endpoint,
+// for managed identities.
+var azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// azureDevOpsProvider gets an Azure AD token for Azure DevOps, with AKS
+// workload identity if it is configured (AZURE_FEDERATED_TOKEN_FILE and
+// friends), or else the managed identity of the VM (AZURE_CLIENT_ID selects
+// one of several).  Azure DevOps accepts the token as the password.
+type azureDevOpsProvider struct {
+	token cachedToken
+}
+
+func (p *azureDevOpsProvider) validate(repo string) error {
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("--repo must be an https URL")
+	}
+	return nil
+}
+
+func (p *azureDevOpsProvider) forget() {
+	p.token.forget()
+}
+
+func (p *azureDevOpsProvider) credentials(ctx context.Context, repo string, now time.Time) (string, string, error) {
+	token, err := p.token.get(now, func() (string, time.Time, error) {
+		if os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "" {
+			return azureWorkloadIdentityToken(ctx, now)
+		}
+		return azureManagedIdentityToken(ctx)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return "git-sync", token, nil
+}
+
+// azureWorkloadIdentityToken exchanges the federated token which AKS
+// projects into the pod for an Azure AD token.
+func azureWorkloadIdentityToken(ctx context.Context, now time.Time) (string, time.Time, error) {
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	if clientID == "" || tenantID == "" {
+		return "", time.Time{}, fmt.Errorf("AZURE_CLIENT_ID and AZURE_TENANT_ID must be set with AZURE_FEDERATED_TOKEN_FILE")
+	}
+	assertion, err := ioutil.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("can't read federated token: %w", err)
+	}
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com/"
+	}
+	endpoint := strings.TrimSuffix(authority, "/") + "/" + tenantID + "/oauth2/v2.0/token"
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"scope":                 {azureDevOpsResource + "/.default"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := authClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body, err := readAuthResponse(resp)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", time.Time{}, fmt.Errorf("can't parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response has no access_token")
+	}
+	return tok.AccessToken, now.Add(time.Duration(tok.ExpiresIn) * time.Second), nil
+}
+
+// azureManagedIdentityToken gets an Azure AD token from the instance
+// metadata service.
+func azureManagedIdentityToken(ctx context.Context) (string, time.Time, error) {
+	q := url.Values{"api-version": {"2018-02-01"}, "resource": {azureDevOpsResource}}
+	if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+		q.Set("client_id", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azureIMDSEndpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := authClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body, err := readAuthResponse(resp)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", time.Time{}, fmt.Errorf("can't parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response has no access_token")
+	}
+	expiresOn, err := strconv.ParseInt(tok.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid expires_on %q in token response", tok.ExpiresOn)
+	}
+	return tok.AccessToken, time.Unix(expiresOn, 0), nil
+}
+
+// awsCredentials are AWS access keys.
+type awsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+}
+
+// awsSTSEndpoint returns the STS endpoint for region.  It is a variable for
+// tests.
+var awsSTSEndpoint = func(region string) string {
+	return "https://sts." + region + ".amazonaws.com/"
+}
+
+// codeCommitProvider signs a password for CodeCommit's HTTPS endpoint with
+// SigV4, as the AWS CLI's credential helper does.  The AWS credentials come
+// from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (and AWS_SESSION_TOKEN),
+// or from IRSA, with AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE.
+type codeCommitProvider struct {
+	mutex   sync.Mutex
+	creds   *awsCredentials
+	expires time.Time
+}
+
+// codeCommitRegion returns the region of a CodeCommit HTTPS host, e.g.
+// "git-codecommit.us-east-1.amazonaws.com".
+func codeCommitRegion(host string) (string, error) {
+	parts := strings.Split(host, ".")
+	if len(parts) < 4 || !strings.HasPrefix(parts[0], "git-codecommit") || parts[2] != "amazonaws" {
+		return "", fmt.Errorf("%q is not a CodeCommit HTTPS host", host)
+	}
+	return parts[1], nil
+}
+
+func (p *codeCommitProvider) validate(repo string) error {
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("--repo must be an https URL")
+	}
+	_, err = codeCommitRegion(u.Hostname())
+	return err
+}
+
+func (p *codeCommitProvider) forget() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.creds = nil
+}
+
+func (p *codeCommitProvider) credentials(ctx context.Context, repo string, now time.Time) (string, string, error) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", "", err
+	}
+	region, err := codeCommitRegion(u.Hostname())
+	if err != nil {
+		return "", "", err
+	}
+	creds, err := p.awsCredentials(ctx, region, now)
+	if err != nil {
+		return "", "", err
+	}
+	username := creds.AccessKeyID
+	if creds.SessionToken != "" {
+		secrets.register(creds.SessionToken)
+		username += "%" + creds.SessionToken
+	}
+	return username, codeCommitPassword(creds.SecretAccessKey, region, u.Hostname(), u.EscapedPath(), now), nil
+}
+
+// awsCredentials returns static credentials from the environment, or else
+// credentials for the IRSA role, which are cached until they expire.
+func (p *codeCommitProvider) awsCredentials(ctx context.Context, region string, now time.Time) (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	role := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if role == "" || tokenFile == "" {
+		return nil, fmt.Errorf("no AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.creds != nil && now.Add(authProviderRefreshMargin).Before(p.expires) {
+		return p.creds, nil
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read web identity token: %w", err)
+	}
+	creds, expires, err := assumeRoleWithWebIdentity(ctx, region, role, strings.TrimSpace(string(token)))
+	if err != nil {
+		return nil, err
+	}
+	p.creds = creds
+	p.expires = expires
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentity exchanges an OIDC token for credentials for
+// role.  This STS call is not signed.
+func assumeRoleWithWebIdentity(ctx context.Context, region, role, token string) (*awsCredentials, time.Time, error) {
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = "git-sync"
+	}
+	q := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {token},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, awsSTSEndpoint(region), strings.NewReader(q.Encode()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := authClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := readAuthResponse(resp)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var result struct {
+		Credentials struct {
+			awsCredentials
+			Expiration time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, time.Time{}, fmt.Errorf("can't parse STS response: %w", err)
+	}
+	if result.Credentials.AccessKeyID == "" {
+		return nil, time.Time{}, fmt.Errorf("STS response has no credentials")
+	}
+	creds := result.Credentials.awsCredentials
+	return &creds, result.Credentials.Expiration, nil
+}
+
+// codeCommitPassword returns the password for a git request to host and
+// path, signed with secretKey.  CodeCommit accepts it for 15 minutes.
+func codeCommitPassword(secretKey, region, host, path string, now time.Time) string {
+	timestamp := now.UTC().Format("20060102T150405")
+	date := timestamp[:8]
+	canonical := fmt.Sprintf("GIT\n%s\n\nhost:%s\n\nhost\n", path, host)
+	scope := fmt.Sprintf("%s/%s/codecommit/aws4_request", date, region)
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", timestamp, scope, hex.EncodeToString(sum[:]))
+
+	key := []byte("AWS4" + secretKey)
+	for _, s := range []string{date, region, "codecommit", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	return timestamp + "Z" + hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// gcpMetadataHost is the GCE metadata server, which serves tokens for the
+// pod's workload identity on GKE, or the VM's service account.
+func gcpMetadataHost() string {
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		return host
+	}
+	return "metadata.google.internal"
+}
+
+// gcpProvider gets an OAuth token for the service account of the pod (with
+// GKE workload identity) or VM from the metadata server, for Cloud Source
+// Repositories.  The username is the service account's email.
+type gcpProvider struct {
+	token cachedToken
+	mutex sync.Mutex
+	email string
+}
+
+func (p *gcpProvider) validate(repo string) error {
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("--repo must be an https URL")
+	}
+	return nil
+}
+
+func (p *gcpProvider) forget() {
+	p.token.forget()
+}
+
+func (p *gcpProvider) credentials(ctx context.Context, repo string, now time.Time) (string, string, error) {
+	email, err := p.serviceAccount(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := p.token.get(now, func() (string, time.Time, error) {
+		body, err := gcpMetadata(ctx, "instance/service-accounts/default/token")
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		var tok struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}
+		if err := json.Unmarshal(body, &tok); err != nil {
+			return "", time.Time{}, fmt.Errorf("can't parse token response: %w", err)
+		}
+		if tok.AccessToken == "" {
+			return "", time.Time{}, fmt.Errorf("token response has no access_token")
+		}
+		return tok.AccessToken, now.Add(time.Duration(tok.ExpiresIn) * time.Second), nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return email, token, nil
+}
+
+// serviceAccount returns the email of the default service account, which
+// doesn't change.
+func (p *gcpProvider) serviceAccount(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.email != "" {
+		return p.email, nil
+	}
+	body, err := gcpMetadata(ctx, "instance/service-accounts/default/email")
+	if err != nil {
+		return "", err
+	}
+	p.email = strings.TrimSpace(string(body))
+	return p.email, nil
+}
+
+// gcpMetadata gets a path under computeMetadata/v1 from the metadata server.
+func gcpMetadata(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+gcpMetadataHost()+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := authClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readAuthResponse(resp)
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// setenv sets env vars for the rest of the test.
+func setenv(t *testing.T, kv map[string]string) {
+	for k, v := range kv {
+		old, found := os.LookupEnv(k)
+		os.Setenv(k, v)
+		t.Cleanup(func() {
+			if found {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestCodeCommitPassword(t *testing.T) {
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := codeCommitPassword("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", "us-east-1",
+		"git-codecommit.us-east-1.amazonaws.com", "/v1/repos/my-repo", now)
+	exp := "20210102T030405Z3aea41e663e213fe791cb960b753a969fdfe8cb273a66eddc9a5a616d2a24e6a"
+	if got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestCodeCommitRegion(t *testing.T) {
+	cases := map[string]string{
+		"git-codecommit.us-east-1.amazonaws.com":      "us-east-1",
+		"git-codecommit-fips.us-west-2.amazonaws.com": "us-west-2",
+		"git-codecommit.cn-north-1.amazonaws.com.cn":  "cn-north-1",
+		"github.com":                         "",
+		"git-codecommit.example.com":         "",
+		"codecommit.us-east-1.amazonaws.com": "",
+	}
+	for host, exp := range cases {
+		got, err := codeCommitRegion(host)
+		if exp == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", host, got)
+			}
+		} else if err != nil || got != exp {
+			t.Errorf("%s: expected %q, got %q, %v", host, exp, got, err)
+		}
+	}
+}
+
+func TestCodeCommitCredentials(t *testing.T) {
+	repo := "https://git-codecommit.us-east-1.amazonaws.com/v1/repos/my-repo"
+	now := time.Now()
+
+	t.Run("static", func(t *testing.T) {
+		setenv(t, map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": "tok"})
+		user, pass, err := (&codeCommitProvider{}).credentials(context.Background(), repo, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user != "AKID%tok" {
+			t.Errorf("unexpected username %q", user)
+		}
+		if exp := codeCommitPassword("secret", "us-east-1", "git-codecommit.us-east-1.amazonaws.com", "/v1/repos/my-repo", now); pass != exp {
+			t.Errorf("expected password %q, got %q", exp, pass)
+		}
+	})
+
+	t.Run("web identity", func(t *testing.T) {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			r.ParseForm()
+			if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "oidc" || r.Form.Get("RoleArn") != "arn:role" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>s3</SecretAccessKey><SessionToken>st</SessionToken>
+<Expiration>` + now.Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>
+</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+		}))
+		defer srv.Close()
+		defer func(f func(string) string) { awsSTSEndpoint = f }(awsSTSEndpoint)
+		awsSTSEndpoint = func(string) string { return srv.URL }
+
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		if err := ioutil.WriteFile(tokenFile, []byte("oidc\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		setenv(t, map[string]string{"AWS_ACCESS_KEY_ID": "", "AWS_ROLE_ARN": "arn:role", "AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile})
+
+		p := &codeCommitProvider{}
+		for i := 0; i < 2; i++ {
+			user, _, err := p.credentials(context.Background(), repo, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if user != "ASIA%st" {
+				t.Errorf("unexpected username %q", user)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("expected credentials to be cached, got %d calls", calls)
+		}
+		p.forget()
+		if _, _, err := p.credentials(context.Background(), repo, now); err != nil || calls != 2 {
+			t.Errorf("expected credentials to be fetched again, got %d calls, %v", calls, err)
+		}
+	})
+}
+
+func TestAzureDevOpsCredentials(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	now := time.Now()
+
+	t.Run("managed identity", func(t *testing.T) {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != azureDevOpsResource {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"aad","expires_on":"` + strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + `"}`))
+		}))
+		defer srv.Close()
+		defer func(s string) { azureIMDSEndpoint = s }(azureIMDSEndpoint)
+		azureIMDSEndpoint = srv.URL
+		setenv(t, map[string]string{"AZURE_FEDERATED_TOKEN_FILE": ""})
+
+		p := &azureDevOpsProvider{}
+		for i := 0; i < 2; i++ {
+			_, pass, err := p.credentials(context.Background(), "https://dev.azure.com/org/proj/_git/repo", now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pass != "aad" {
+				t.Errorf("unexpected password %q", pass)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("expected the token to be cached, got %d calls", calls)
+		}
+	})
+
+	t.Run("workload identity", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.URL.Path != "/tenant/oauth2/v2.0/token" || r.Form.Get("client_assertion") != "fed" || r.Form.Get("client_id") != "client" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"wi","expires_in":3600}`))
+		}))
+		defer srv.Close()
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		if err := ioutil.WriteFile(tokenFile, []byte("fed"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		setenv(t, map[string]string{"AZURE_FEDERATED_TOKEN_FILE": tokenFile, "AZURE_CLIENT_ID": "client",
+			"AZURE_TENANT_ID": "tenant", "AZURE_AUTHORITY_HOST": srv.URL + "/"})
+
+		_, pass, err := (&azureDevOpsProvider{}).credentials(context.Background(), "https://dev.azure.com/org/proj/_git/repo", now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pass != "wi" {
+			t.Errorf("unexpected password %q", pass)
+		}
+	})
+}
+
+func TestGCPCredentials(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	now := time.Now()
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/email":
+			w.Write([]byte("sa@project.iam.gserviceaccount.com"))
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			w.Write([]byte(`{"access_token":"ya29","expires_in":3599,"token_type":"Bearer"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	setenv(t, map[string]string{"GCE_METADATA_HOST": strings.TrimPrefix(srv.URL, "http://")})
+
+	p := &gcpProvider{token: cachedToken{provider: authProviderGCP}}
+	repo := "https://source.developers.google.com/p/project/r/repo"
+	for i := 0; i < 2; i++ {
+		user, pass, err := p.credentials(context.Background(), repo, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user != "sa@project.iam.gserviceaccount.com" || pass != "ya29" {
+			t.Errorf("unexpected credentials %q, %q", user, pass)
+		}
+	}
+	// The token is fetched again when it is about to expire.
+	if _, _, err := p.credentials(context.Background(), repo, now.Add(time.Hour-authProviderRefreshMargin)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls["/computeMetadata/v1/instance/service-accounts/default/token"] != 2 || calls["/computeMetadata/v1/instance/service-accounts/default/email"] != 1 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
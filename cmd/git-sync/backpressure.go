@@ -0,0 +1,203 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	throttledCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_sync_upstream_throttled_total",
+		Help: "How many syncs failed because the upstream was throttling git-sync, partitioned by HTTP status",
+	}, []string{"status"})
+
+	backpressureWait = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "git_sync_upstream_backoff_seconds",
+		Help: "How long git-sync is waiting before the next sync because the upstream is throttling it (0 if it is not)",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(throttledCount)
+	prometheus.MustRegister(backpressureWait)
+}
+
+// throttledStatusRE matches git's reports of an HTTP 429 (Too Many Requests)
+// or 503 (Service Unavailable) from the upstream, e.g. "RPC failed; HTTP 429"
+// or "The requested URL returned error: 503".
+var throttledStatusRE = regexp.MustCompile(`(?:HTTP |returned error: )(429|503)\b`)
+
+// throttledError is returned when the upstream is throttling git-sync.
+type throttledError struct {
+	status string
+	// retryAfter is from the upstream's Retry-After header, or 0 if it
+	// didn't send one.
+	retryAfter time.Duration
+	err        error
+}
+
+func (e throttledError) Error() string {
+	return fmt.Sprintf("upstream is throttling (HTTP %s): %v", e.status, e.err)
+}
+
+func (e throttledError) Unwrap() error {
+	return e.err
+}
+
+// isThrottled returns true if err says that the upstream is throttling.
+func isThrottled(err error) bool {
+	return errors.As(err, &throttledError{})
+}
+
+// checkThrottled returns err as a throttledError if git's output in it shows
+// that the upstream is throttling.  trace is the curl trace of the command,
+// which holds the Retry-After header, if there was one.
+func checkThrottled(err error, trace string, now time.Time) error {
+	if err == nil || isThrottled(err) {
+		return err
+	}
+	m := throttledStatusRE.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	retryAfter, _ := retryAfterFromTrace(trace, now)
+	return throttledError{status: m[1], retryAfter: retryAfter, err: err}
+}
+
+// retryAfterFromTrace returns the last Retry-After header received in a
+// GIT_TRACE_CURL trace.
+func retryAfterFromTrace(trace string, now time.Time) (time.Duration, bool) {
+	var d time.Duration
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(trace))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, "<= Recv header: ")
+		if i < 0 {
+			continue
+		}
+		header := line[i+len("<= Recv header: "):]
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "Retry-After") {
+			continue
+		}
+		if v, ok := parseRetryAfter(kv[1], now); ok {
+			d, found = v, true
+		}
+	}
+	return d, found
+}
+
+// runRemoteCommand runs git with args in cwd, for a command which talks to
+// the upstream.  Over HTTP(S), the response headers are traced, so that if
+// the upstream is throttling, its Retry-After can be honored.
+func runRemoteCommand(ctx context.Context, cwd string, args ...string) (string, error) {
+	return runRemoteCommandWithEnv(ctx, cwd, nil, args...)
+}
+
+// runRemoteCommandWithEnv is like runRemoteCommand, but adds env (in
+// "key=value" form) to the environment of the command.
+func runRemoteCommandWithEnv(ctx context.Context, cwd string, env []string, args ...string) (string, error) {
+	if repoInfo.kind != repoKindURL || (repoInfo.scheme != "http" && repoInfo.scheme != "https") {
+		return runCommandWithEnv(ctx, cwd, env, *flGitCmd, args...)
+	}
+	f, err := ioutil.TempFile("", "git-sync-curl-trace-")
+	if err != nil {
+		log.V(2).Info("can't trace HTTP headers", "error", err.Error())
+		return runCommandWithEnv(ctx, cwd, env, *flGitCmd, args...)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	env = append(env,
+		"GIT_TRACE_CURL="+f.Name(),
+		"GIT_TRACE_CURL_NO_DATA=1",
+		// Keep credentials out of the trace (this is git's default).
+		"GIT_TRACE_REDACT=1",
+	)
+	out, err := runCommandWithEnv(ctx, cwd, env, *flGitCmd, args...)
+	if err != nil {
+		trace, _ := ioutil.ReadFile(f.Name())
+		err = checkThrottled(err, string(trace), time.Now())
+	}
+	return out, err
+}
+
+// backpressureState tracks how long to wait between syncs while the
+// upstream is throttling git-sync.
+type backpressureState struct {
+	mutex sync.Mutex
+	// delay is the current backoff, or 0 if the upstream isn't throttling.
+	delay time.Duration
+}
+
+var backpressure backpressureState
+
+// next returns how long to wait before the next sync after one which failed
+// with err, and true, if err says that the upstream is throttling.  The wait
+// starts at period and doubles, up to max, with each throttled sync in a
+// row, but is never less than the upstream's Retry-After.
+func (b *backpressureState) next(err error, period, max time.Duration) (time.Duration, bool) {
+	var te throttledError
+	if !errors.As(err, &te) {
+		b.reset()
+		return 0, false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.delay == 0 {
+		b.delay = period
+		if b.delay < time.Second {
+			b.delay = time.Second
+		}
+		if max > 0 && b.delay > max {
+			b.delay = max
+		}
+	} else {
+		b.delay = nextBackoff(b.delay, max)
+	}
+	wait := b.delay
+	if te.retryAfter > wait {
+		wait = te.retryAfter
+	}
+	throttledCount.WithLabelValues(te.status).Inc()
+	backpressureWait.Set(wait.Seconds())
+	return wait, true
+}
+
+// reset clears the backoff once the upstream stops throttling.
+func (b *backpressureState) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.delay != 0 {
+		log.V(0).Info("upstream is no longer throttling")
+	}
+	b.delay = 0
+	backpressureWait.Set(0)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const throttledTrace = `12:00:00.000000 http.c:700 => Send header: GET /repo/info/refs?service=git-upload-pack HTTP/1.1
+12:00:00.000000 http.c:700 => Send header: Authorization: Basic <redacted>
+12:00:00.100000 http.c:700 <= Recv header: HTTP/1.1 429 Too Many Requests
+12:00:00.100000 http.c:700 <= Recv header: retry-after: 90
+12:00:00.100000 http.c:700 <= Recv header: Content-Length: 0
+`
+
+func TestCheckThrottled(t *testing.T) {
+	now := time.Now()
+	plain := errors.New("Run(git fetch): exit status 128: { stderr: \"fatal: couldn't find remote ref\" }")
+	if err := checkThrottled(plain, throttledTrace, now); err != plain {
+		t.Errorf("expected an unrelated error to be left alone, got %v", err)
+	}
+	if err := checkThrottled(nil, throttledTrace, now); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	cases := []struct {
+		stderr string
+		trace  string
+		status string
+		after  time.Duration
+	}{
+		{stderr: "error: RPC failed; HTTP 429 curl 22 The requested URL returned error: 429", trace: throttledTrace, status: "429", after: 90 * time.Second},
+		{stderr: "fatal: unable to access 'https://example.com/repo/': The requested URL returned error: 503", status: "503"},
+	}
+	for _, tc := range cases {
+		err := checkThrottled(fmt.Errorf("Run(git fetch): exit status 128: { stderr: %q }", tc.stderr), tc.trace, now)
+		var te throttledError
+		if !errors.As(err, &te) {
+			t.Errorf("%s: expected a throttledError, got %v", tc.stderr, err)
+			continue
+		}
+		if te.status != tc.status || te.retryAfter != tc.after {
+			t.Errorf("%s: expected %s after %v, got %s after %v", tc.stderr, tc.status, tc.after, te.status, te.retryAfter)
+		}
+	}
+}
+
+func TestRetryAfterFromTrace(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	trace := "<= Recv header: Retry-After: 10\n<= Recv header: Retry-After: Fri, 01 Jan 2021 12:05:00 GMT\n"
+	if d, ok := retryAfterFromTrace(trace, now); !ok || d != 5*time.Minute {
+		t.Errorf("expected the last Retry-After, got %v, %v", d, ok)
+	}
+	if _, ok := retryAfterFromTrace("<= Recv header: HTTP/1.1 429\n", now); ok {
+		t.Errorf("expected no Retry-After")
+	}
+}
+
+func TestBackpressureNext(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	var b backpressureState
+	throttled := throttledError{status: "429", err: errors.New("throttled")}
+
+	if _, ok := b.next(errors.New("other"), time.Minute, 10*time.Minute); ok {
+		t.Errorf("expected other errors not to back off")
+	}
+	for i, expect := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		if wait, ok := b.next(throttled, time.Minute, 10*time.Minute); !ok || wait != expect {
+			t.Errorf("%d: expected %v, got %v, %v", i, expect, wait, ok)
+		}
+	}
+
+	// Retry-After wins when it is longer.
+	throttled.retryAfter = time.Hour
+	if wait, _ := b.next(throttled, time.Minute, 10*time.Minute); wait != time.Hour {
+		t.Errorf("expected Retry-After to be honored, got %v", wait)
+	}
+
+	b.reset()
+	throttled.retryAfter = 0
+	if wait, _ := b.next(throttled, 0, 10*time.Minute); wait != time.Second {
+		t.Errorf("expected the backoff to start again, at least 1s, got %v", wait)
+	}
+}
+
+func TestWithRetriesThrottled(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	calls := 0
+	err := withRetries(context.Background(), "fetch", 3, time.Millisecond, func() error {
+		calls++
+		return throttledError{status: "429", err: errors.New("throttled")}
+	})
+	if !isThrottled(err) || calls != 1 {
+		t.Errorf("expected no retries when throttled, got %d calls, %v", calls, err)
+	}
+}
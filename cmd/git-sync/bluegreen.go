@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// blueGreenPointer is the content of the --blue-green pointer file, which
+// names the active content directory.
+type blueGreenPointer struct {
+	// Active is the name of the active content directory, under --root.
+	Active string    `json:"active"`
+	Hash   string    `json:"hash"`
+	Time   time.Time `json:"time"`
+}
+
+// blueGreenSlots returns the names of the two content directories for dest.
+func blueGreenSlots(dest string) (string, string) {
+	return dest + "-a", dest + "-b"
+}
+
+// blueGreenPointerFile returns the name of the pointer file for dest.
+func blueGreenPointerFile(dest string) string {
+	return dest + ".json"
+}
+
+// readBlueGreenPointer reads the pointer file under gitRoot.  It returns an
+// empty pointer if there is none yet.
+func readBlueGreenPointer(gitRoot, dest string) (blueGreenPointer, error) {
+	pointer := blueGreenPointer{}
+	data, err := ioutil.ReadFile(filepath.Join(gitRoot, blueGreenPointerFile(dest)))
+	if os.IsNotExist(err) {
+		return pointer, nil
+	}
+	if err != nil {
+		return pointer, err
+	}
+	if err := json.Unmarshal(data, &pointer); err != nil {
+		return pointer, fmt.Errorf("can't parse %s: %w", blueGreenPointerFile(dest), err)
+	}
+	return pointer, nil
+}
+
+// publishBlueGreen replaces the content of the inactive content directory with
+// worktree, and then atomically points the pointer file at it, so it becomes
+// the active one.  The content is hard-linked rather than copied, so this is
+// cheap even for large worktrees.  The worktree's git metadata is left out.
+func publishBlueGreen(gitRoot, dest, worktree string) error {
+	pointer, err := readBlueGreenPointer(gitRoot, dest)
+	if err != nil {
+		return err
+	}
+	slotA, slotB := blueGreenSlots(dest)
+	inactive := slotA
+	if pointer.Active == slotA {
+		inactive = slotB
+	}
+
+	slot := filepath.Join(gitRoot, inactive)
+	log.V(1).Info("filling inactive content directory", "path", slot, "worktree", worktree)
+	if err := os.RemoveAll(slot); err != nil {
+		return err
+	}
+	if err := linkTree(worktree, slot); err != nil {
+		return fmt.Errorf("can't fill %s: %w", slot, err)
+	}
+
+	pointer = blueGreenPointer{Active: inactive, Hash: worktreeHash(worktree), Time: time.Now().UTC()}
+	data, err := json.MarshalIndent(pointer, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(gitRoot, blueGreenPointerFile(dest))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	log.V(0).Info("flipped active content directory", "active", inactive, "hash", pointer.Hash)
+	return nil
+}
+
+// reconcileBlueGreen publishes the worktree that the link points at to the
+// content directories, if the pointer file names a different hash, e.g. when
+// --blue-green is first turned on for an existing root.
+func reconcileBlueGreen(gitRoot, dest string) error {
+	current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pointer, err := readBlueGreenPointer(gitRoot, dest)
+	if err != nil {
+		return err
+	}
+	if pointer.Hash == worktreeHash(current) {
+		return nil
+	}
+	return publishBlueGreen(gitRoot, dest, current)
+}
+
+// linkTree recreates the tree at src under dst, hard-linking files and
+// copying symlinks.  Any .git file or directory is left out.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			// Mkdir is subject to the umask.
+			return os.Chmod(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return os.Link(path, target)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestPublishBlueGreen(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	root := t.TempDir()
+
+	mkWorktree := func(hash, content string) string {
+		dir := filepath.Join(root, hash)
+		if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, ".git"), []byte("gitdir: ../.git/worktrees/"+hash), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("sub/file", filepath.Join(dir, "link")); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	check := func(active, hash, content string) {
+		t.Helper()
+		pointer, err := readBlueGreenPointer(root, "repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pointer.Active != active || pointer.Hash != hash {
+			t.Fatalf("expected %s at %s, got %+v", active, hash, pointer)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(root, active, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("expected %q in %s, got %q", content, active, string(data))
+		}
+		if _, err := os.Lstat(filepath.Join(root, active, ".git")); !os.IsNotExist(err) {
+			t.Errorf("expected no .git in %s, got %v", active, err)
+		}
+	}
+
+	one := mkWorktree(hash1, "one")
+	if err := publishBlueGreen(root, "repo", one); err != nil {
+		t.Fatal(err)
+	}
+	check("repo-a", hash1, "one")
+
+	// Files are hard-linked, not copied.
+	src, _ := os.Stat(filepath.Join(one, "sub", "file"))
+	dst, _ := os.Stat(filepath.Join(root, "repo-a", "sub", "file"))
+	if !os.SameFile(src, dst) {
+		t.Errorf("expected the file to be hard-linked")
+	}
+
+	two := mkWorktree(hash2, "two")
+	if err := publishBlueGreen(root, "repo", two); err != nil {
+		t.Fatal(err)
+	}
+	check("repo-b", hash2, "two")
+
+	// The other slot is reused for the next one.
+	if err := publishBlueGreen(root, "repo", one); err != nil {
+		t.Fatal(err)
+	}
+	check("repo-a", hash1, "one")
+
+	// Reconciling follows the link.
+	if err := os.Symlink(hash2, filepath.Join(root, "repo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileBlueGreen(root, "repo"); err != nil {
+		t.Fatal(err)
+	}
+	check("repo-b", hash2, "two")
+	if err := reconcileBlueGreen(root, "repo"); err != nil {
+		t.Fatal(err)
+	}
+	check("repo-b", hash2, "two")
+}
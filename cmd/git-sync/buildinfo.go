@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"runtime"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/git-sync/pkg/version"
+)
+
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "git_sync_build_info",
+	Help: "A metric with a constant '1' value, labeled by the version, commit, and Go version from which git-sync was built",
+}, []string{"version", "commit", "goversion"})
+
+func init() {
+	prometheus.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version.VERSION, version.GIT_COMMIT, runtime.Version()).Set(1)
+}
+
+// versionInfo is the response to /api/v1/version.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+	// Features are the optional capabilities of this binary on this
+	// platform, e.g. "vcs:hg" or "lock-timeout".
+	Features []string `json:"features"`
+	// Flags are the names of all supported flags.
+	Flags []string `json:"flags"`
+}
+
+// buildFeatures returns the optional capabilities of this binary, sorted.
+func buildFeatures() []string {
+	features := []string{
+		"source:" + sourceRepo,
+		"source:" + sourceOCI,
+		"subcommand:" + completionCmd,
+		"subcommand:" + credentialHelperCmd,
+		"subcommand:" + credentialMemoryCmd,
+		"subcommand:" + doctorCmd,
+		"subcommand:" + migrateFlagsCmd,
+		"subcommand:" + validateCmd,
+	}
+	for name := range vcsBackends {
+		features = append(features, "vcs:"+name)
+	}
+	for name := range authProviders {
+		features = append(features, "auth-provider:"+name)
+	}
+	platform := []struct {
+		name      string
+		supported bool
+	}{
+		{"change-permissions", canChangePermissions},
+		{"match-root-group", canMatchRootGroup},
+		{"lock-timeout", canLockRoot},
+	}
+	for _, f := range platform {
+		if f.supported {
+			features = append(features, f.name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// getVersionInfo describes this binary.
+func getVersionInfo() versionInfo {
+	info := versionInfo{
+		Version:   version.VERSION,
+		Commit:    version.GIT_COMMIT,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Features:  buildFeatures(),
+		Flags:     []string{},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		info.Flags = append(info.Flags, f.Name)
+	})
+	sort.Strings(info.Flags)
+	return info
+}
+
+// serveVersion handles requests to /api/v1/version.
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := json.MarshalIndent(getVersionInfo(), "", "  ")
+	if err != nil {
+		log.Error(err, "can't encode version")
+		http.Error(w, "can't encode version", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
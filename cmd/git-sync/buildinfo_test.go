@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/git-sync/pkg/version"
+)
+
+func TestServeVersion(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	rec := httptest.NewRecorder()
+	serveVersion(rec, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var info versionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("can't decode response: %v", err)
+	}
+	if info.Version != version.VERSION || info.Commit != version.GIT_COMMIT || info.GoVersion == "" {
+		t.Errorf("unexpected version info: %+v", info)
+	}
+	contains := func(list []string, item string) bool {
+		for _, s := range list {
+			if s == item {
+				return true
+			}
+		}
+		return false
+	}
+	for _, feature := range []string{"vcs:git", "source:oci", "subcommand:doctor"} {
+		if !contains(info.Features, feature) {
+			t.Errorf("expected feature %q, got %v", feature, info.Features)
+		}
+	}
+	for _, name := range []string{"repo", "root", "http-bind"} {
+		if !contains(info.Flags, name) {
+			t.Errorf("expected flag %q, got %v", name, info.Flags)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	serveVersion(rec, httptest.NewRequest(http.MethodPost, "/api/v1/version", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", rec.Code)
+	}
+}
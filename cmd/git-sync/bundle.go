@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// cloneFromBundle initializes gitRoot from the --from-bundle file, points it
+// at repo, and fetches whatever is newer than the bundle.  This returns
+// false, after cleaning up, if that didn't work, in which case the caller
+// should clone from repo as usual.  A stale bundle just means more to fetch.
+func cloneFromBundle(ctx context.Context, repo, branch string, depth int, gitRoot string) bool {
+	err := seedFromBundle(ctx, repo, branch, depth, gitRoot)
+	if err == nil {
+		return true
+	}
+	log.Error(err, "can't start from bundle, cloning from scratch", "bundle", *flFromBundle)
+	if err := os.RemoveAll(gitRoot); err != nil {
+		log.Error(err, "can't clean up after bundle", "path", gitRoot)
+	}
+	return false
+}
+
+func seedFromBundle(ctx context.Context, repo, branch string, depth int, gitRoot string) error {
+	if _, err := os.Stat(*flFromBundle); err != nil {
+		return err
+	}
+	log.V(0).Info("cloning repo from bundle", "bundle", *flFromBundle, "origin", secrets.redact(repo), "path", gitRoot)
+	if _, err := runCommand(ctx, "", *flGitCmd, "clone", "--no-checkout", *flFromBundle, gitRoot); err != nil {
+		return err
+	}
+	if _, err := runCommand(ctx, gitRoot, *flGitCmd, "remote", "set-url", "origin", repo); err != nil {
+		return err
+	}
+
+	args := append([]string{"fetch", "-f", "--tags"}, shallowArgs(depth)...)
+	args = append(args, "origin", branch)
+	if err := fetch(ctx, gitRoot, args); err != nil {
+		return fmt.Errorf("can't fetch from the upstream: %v", err)
+	}
+
+	// Make the clone look like `git clone -b <branch>` would have.
+	for _, ref := range []string{"refs/remotes/origin/" + branch, "refs/heads/" + branch} {
+		if _, err := runCommand(ctx, gitRoot, *flGitCmd, "update-ref", ref, "FETCH_HEAD"); err != nil {
+			return err
+		}
+	}
+	_, err := runCommand(ctx, gitRoot, *flGitCmd, "symbolic-ref", "HEAD", "refs/heads/"+branch)
+	return err
+}
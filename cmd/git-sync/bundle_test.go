@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestCloneFromBundle(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(b string) { *flFromBundle = b }(*flFromBundle)
+
+	tmp := t.TempDir()
+	upstream := filepath.Join(tmp, "upstream")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(tmp, "init", "-q", "-b", "main", upstream)
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "one")
+	*flFromBundle = filepath.Join(tmp, "seed.bundle")
+	git(upstream, "bundle", "create", "-q", *flFromBundle, "--all")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "two")
+	want := git(upstream, "rev-parse", "HEAD")
+
+	ctx := context.Background()
+	root := filepath.Join(tmp, "root")
+	if !cloneFromBundle(ctx, upstream, "main", 0, root) {
+		t.Fatalf("expected the bundle to be used")
+	}
+	if got := git(root, "rev-parse", "HEAD"); got != want {
+		t.Errorf("expected HEAD to be %s, got %s", want, got)
+	}
+	if got := git(root, "remote", "get-url", "origin"); got != upstream {
+		t.Errorf("expected origin to be %s, got %s", upstream, got)
+	}
+
+	// A missing bundle falls back, leaving nothing behind.
+	*flFromBundle = filepath.Join(tmp, "missing.bundle")
+	root = filepath.Join(tmp, "root2")
+	if cloneFromBundle(ctx, upstream, "main", 0, root) {
+		t.Errorf("expected a missing bundle not to be used")
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be cleaned up: %v", root, err)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --case-collisions.
+const (
+	casePolicyWarn = "warn"
+	casePolicyFail = "fail"
+	casePolicyOff  = "off"
+)
+
+var caseCollisionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_case_collisions",
+	Help: "How many paths in the last checked out hash differ only by case from another path, on a case-insensitive filesystem",
+})
+
+func init() {
+	prometheus.MustRegister(caseCollisionsGauge)
+}
+
+// errCaseCollision is returned when a hash has paths which differ only by
+// case, and --case-collisions=fail.
+var errCaseCollision = errors.New("paths differ only by case")
+
+// caseProbe remembers whether --root is on a case-insensitive filesystem,
+// which doesn't change while git-sync runs.
+var caseProbe struct {
+	once        sync.Once
+	insensitive bool
+	err         error
+}
+
+// probeCaseInsensitive returns true if the filesystem under dir treats
+// names which differ only by case as the same.
+func probeCaseInsensitive(dir string) (bool, error) {
+	f, err := ioutil.TempFile(dir, ".git-sync.case.")
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	lower, err := os.Stat(f.Name())
+	if err != nil {
+		return false, err
+	}
+	upper, err := os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(f.Name()))))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(lower, upper), nil
+}
+
+// findCaseCollisions returns the groups of paths (or of their parent
+// directories) which differ only by case, each sorted, in order.
+func findCaseCollisions(paths []string) [][]string {
+	names := map[string]map[string]bool{}
+	for _, p := range paths {
+		parts := strings.Split(p, "/")
+		for i := range parts {
+			prefix := strings.Join(parts[:i+1], "/")
+			key := strings.ToLower(prefix)
+			if names[key] == nil {
+				names[key] = map[string]bool{}
+			}
+			names[key][prefix] = true
+		}
+	}
+	groups := [][]string{}
+	for _, set := range names {
+		if len(set) < 2 {
+			continue
+		}
+		group := []string{}
+		for name := range set {
+			group = append(group, name)
+		}
+		sort.Strings(group)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// checkCaseCollisions looks for paths in hash which would overwrite each
+// other when checked out on gitRoot's filesystem, if it is case-insensitive,
+// and reports them according to --case-collisions.  It returns an error
+// wrapping errCaseCollision if the hash should not be published.
+func checkCaseCollisions(ctx context.Context, gitRoot, hash string) error {
+	if *flCaseCollisions == casePolicyOff {
+		return nil
+	}
+	caseProbe.once.Do(func() {
+		caseProbe.insensitive, caseProbe.err = probeCaseInsensitive(gitRoot)
+	})
+	if caseProbe.err != nil {
+		log.Error(caseProbe.err, "can't tell if the filesystem is case-insensitive", "root", gitRoot)
+		return nil
+	}
+	if !caseProbe.insensitive {
+		return nil
+	}
+
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "ls-tree", "-r", "-z", "--name-only", hash)
+	if err != nil {
+		return fmt.Errorf("can't list files of %s: %w", hash, err)
+	}
+	paths := []string{}
+	for _, p := range strings.Split(output, "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	groups := findCaseCollisions(paths)
+	n := 0
+	for _, g := range groups {
+		n += len(g)
+	}
+	caseCollisionsGauge.Set(float64(n))
+	if len(groups) == 0 {
+		return nil
+	}
+	if *flCaseCollisions == casePolicyFail {
+		return fmt.Errorf("%w on this case-insensitive filesystem, in %s: %v", errCaseCollision, hash, groups)
+	}
+	log.V(0).Info("WARNING: paths differ only by case, and will overwrite each other on this case-insensitive filesystem", "hash", hash, "collisions", groups)
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFindCaseCollisions(t *testing.T) {
+	paths := []string{
+		"README.md",
+		"readme.md",
+		"Docs/a.txt",
+		"docs/b.txt",
+		"src/Main.go",
+		"src/main_test.go",
+	}
+	expect := [][]string{{"Docs", "docs"}, {"README.md", "readme.md"}}
+	if got := findCaseCollisions(paths); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+	if got := findCaseCollisions([]string{"a", "b/a", "c/A"}); len(got) != 0 {
+		t.Errorf("expected no collisions, got %q", got)
+	}
+}
+
+func TestProbeCaseInsensitive(t *testing.T) {
+	insensitive, err := probeCaseInsensitive(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runtime.GOOS == "linux" && insensitive {
+		t.Errorf("expected the temp dir to be case-sensitive on Linux")
+	}
+}
+
+func TestCheckCaseCollisions(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(policy string) { *flCaseCollisions = policy }(*flCaseCollisions)
+	// Pretend the filesystem is case-insensitive.
+	caseProbe.once.Do(func() {})
+	caseProbe.insensitive = true
+	defer func() { caseProbe.insensitive = false }()
+
+	repo := t.TempDir()
+	git := func(stdin string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = os.Environ()
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("", "init", "-q")
+	// Make a tree with colliding names without touching the filesystem.
+	blob := git("content\n", "hash-object", "-w", "--stdin")
+	tree := git("100644 blob "+blob+"\tREADME\n100644 blob "+blob+"\treadme\n", "mktree")
+	commit := git("", "commit-tree", "-m", "collide", tree)
+
+	ctx := context.Background()
+	*flCaseCollisions = casePolicyWarn
+	if err := checkCaseCollisions(ctx, repo, commit); err != nil {
+		t.Errorf("expected a warning only, got %v", err)
+	}
+	*flCaseCollisions = casePolicyFail
+	if err := checkCaseCollisions(ctx, repo, commit); !errors.Is(err, errCaseCollision) {
+		t.Errorf("expected errCaseCollision, got %v", err)
+	}
+	*flCaseCollisions = casePolicyOff
+	if err := checkCaseCollisions(ctx, repo, commit); err != nil {
+		t.Errorf("expected no check, got %v", err)
+	}
+}
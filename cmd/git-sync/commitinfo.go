@@ -0,0 +1,126 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// commitInfoFormat is the `git log` format which getCommitInfo parses: hash,
+// author name, author email, committer date, ref names, and subject,
+// separated by NULs.
+const commitInfoFormat = "%H%x00%an%x00%ae%x00%cI%x00%D%x00%s"
+
+// commitInfo describes a commit, for hooks.
+type commitInfo struct {
+	Hash        string
+	Author      string
+	AuthorEmail string
+	Subject     string
+	Committed   time.Time
+	// Tag is a tag which points at the commit, if any.
+	Tag string
+}
+
+// parseCommitInfo parses the output of `git log -1 --format=<commitInfoFormat>`.
+func parseCommitInfo(output string) (commitInfo, error) {
+	fields := strings.SplitN(strings.TrimRight(output, "\n"), "\x00", 6)
+	if len(fields) != 6 {
+		return commitInfo{}, fmt.Errorf("can't parse commit info %q", output)
+	}
+	committed, err := time.Parse(time.RFC3339, fields[3])
+	if err != nil {
+		return commitInfo{}, fmt.Errorf("can't parse commit time: %w", err)
+	}
+	info := commitInfo{
+		Hash:        fields[0],
+		Author:      fields[1],
+		AuthorEmail: fields[2],
+		Committed:   committed,
+		Subject:     fields[5],
+	}
+	for _, ref := range strings.Split(fields[4], ", ") {
+		if strings.HasPrefix(ref, "tag: ") {
+			info.Tag = strings.TrimPrefix(ref, "tag: ")
+			break
+		}
+	}
+	return info, nil
+}
+
+// getCommitInfo describes hash, from the clone at gitRoot, with one `git log`.
+// Only the hash is known for other sources.
+func getCommitInfo(ctx context.Context, gitRoot, hash string) (commitInfo, error) {
+	if *flSource != sourceRepo || *flVCS != "git" {
+		return commitInfo{Hash: hash}, nil
+	}
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "log", "-1", "--decorate=short", "--format="+commitInfoFormat, hash, "--")
+	if err != nil {
+		return commitInfo{Hash: hash}, err
+	}
+	return parseCommitInfo(output)
+}
+
+// env returns the commit info as env vars for hooks.
+func (c commitInfo) env() []string {
+	env := []string{"GIT_SYNC_HASH=" + c.Hash}
+	if c.Committed.IsZero() {
+		return env
+	}
+	return append(env,
+		"GIT_SYNC_COMMIT_AUTHOR="+c.Author,
+		"GIT_SYNC_COMMIT_AUTHOR_EMAIL="+c.AuthorEmail,
+		"GIT_SYNC_COMMIT_SUBJECT="+c.Subject,
+		"GIT_SYNC_COMMIT_TIME="+c.Committed.Format(time.RFC3339),
+		"GIT_SYNC_COMMIT_TAG="+c.Tag,
+	)
+}
+
+// webhookPayload is the data for --webhook-template.
+type webhookPayload struct {
+	commitInfo
+	Rollback bool
+}
+
+// templateFuncs are the functions available to --webhook-template.
+var templateFuncs = template.FuncMap{
+	// json quotes a value for use in a JSON document.
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseWebhookTemplate parses --webhook-template.
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderWebhookTemplate renders tmpl with payload.
+func renderWebhookTemplate(tmpl *template.Template, payload webhookPayload) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
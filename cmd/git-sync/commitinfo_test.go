@@ -0,0 +1,189 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseCommitInfo(t *testing.T) {
+	committed := time.Date(2021, 6, 1, 12, 30, 0, 0, time.FixedZone("", 2*60*60))
+	cases := []struct {
+		name    string
+		output  string
+		expect  commitInfo
+		wantErr bool
+	}{{
+		name:   "plain",
+		output: hash1 + "\x00Jane Doe\x00jane@example.com\x002021-06-01T12:30:00+02:00\x00HEAD -> main\x00fix: the thing\n",
+		expect: commitInfo{Hash: hash1, Author: "Jane Doe", AuthorEmail: "jane@example.com", Subject: "fix: the thing", Committed: committed},
+	}, {
+		name:   "tagged",
+		output: hash1 + "\x00Jane Doe\x00jane@example.com\x002021-06-01T12:30:00+02:00\x00HEAD, tag: v1.2.3, tag: latest, origin/main\x00Release v1.2.3\n",
+		expect: commitInfo{Hash: hash1, Author: "Jane Doe", AuthorEmail: "jane@example.com", Subject: "Release v1.2.3", Committed: committed, Tag: "v1.2.3"},
+	}, {
+		name:    "short",
+		output:  hash1 + "\x00Jane Doe\n",
+		wantErr: true,
+	}, {
+		name:    "bad time",
+		output:  hash1 + "\x00Jane Doe\x00jane@example.com\x00yesterday\x00\x00subject\n",
+		wantErr: true,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := parseCommitInfo(tc.output)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !info.Committed.Equal(tc.expect.Committed) {
+				t.Errorf("expected committed %v, got %v", tc.expect.Committed, info.Committed)
+			}
+			info.Committed, tc.expect.Committed = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(info, tc.expect) {
+				t.Errorf("expected %+v, got %+v", tc.expect, info)
+			}
+		})
+	}
+}
+
+func TestCommitInfoEnv(t *testing.T) {
+	if env := (commitInfo{Hash: hash1}).env(); !reflect.DeepEqual(env, []string{"GIT_SYNC_HASH=" + hash1}) {
+		t.Errorf("unexpected env for a bare hash: %q", env)
+	}
+
+	info := commitInfo{
+		Hash:        hash1,
+		Author:      "Jane Doe",
+		AuthorEmail: "jane@example.com",
+		Subject:     "fix: the thing",
+		Committed:   time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC),
+		Tag:         "v1.2.3",
+	}
+	expect := []string{
+		"GIT_SYNC_HASH=" + hash1,
+		"GIT_SYNC_COMMIT_AUTHOR=Jane Doe",
+		"GIT_SYNC_COMMIT_AUTHOR_EMAIL=jane@example.com",
+		"GIT_SYNC_COMMIT_SUBJECT=fix: the thing",
+		"GIT_SYNC_COMMIT_TIME=2021-06-01T12:30:00Z",
+		"GIT_SYNC_COMMIT_TAG=v1.2.3",
+	}
+	if env := info.env(); !reflect.DeepEqual(env, expect) {
+		t.Errorf("expected %q, got %q", expect, env)
+	}
+}
+
+func TestRenderWebhookTemplate(t *testing.T) {
+	payload := webhookPayload{
+		commitInfo: commitInfo{
+			Hash:      hash1,
+			Author:    "Jane Doe",
+			Subject:   `say "hi"`,
+			Committed: time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC),
+		},
+		Rollback: true,
+	}
+
+	tmpl, err := parseWebhookTemplate(`{"hash":{{json .Hash}},"subject":{{json .Subject}},"time":{{json .Committed}},"rollback":{{.Rollback}}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := renderWebhookTemplate(tmpl, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := `{"hash":"` + hash1 + `","subject":"say \"hi\"","time":"2021-06-01T12:30:00Z","rollback":true}`
+	if string(body) != expect {
+		t.Errorf("expected %s, got %s", expect, body)
+	}
+
+	if _, err := parseWebhookTemplate(`{{.Hash`); err == nil {
+		t.Errorf("expected an error for an unterminated action")
+	}
+	tmpl, err = parseWebhookTemplate(`{{.Branch}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := renderWebhookTemplate(tmpl, payload); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+func TestGetCommitInfo(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe", "GIT_COMMITTER_EMAIL=jane@example.com",
+			"GIT_COMMITTER_DATE=2021-06-01T12:30:00Z")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := exec.Command(*flGitCmd, "init", repo).Run(); err != nil {
+		t.Fatalf("git init failed: %v", err)
+	}
+	git("commit", "--allow-empty", "-m", "first")
+	git("tag", "v1.0.0")
+	first := git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "second")
+	second := git("rev-parse", "HEAD")
+
+	info, err := getCommitInfo(context.Background(), repo, first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Hash != first || info.Author != "Jane Doe" || info.AuthorEmail != "jane@example.com" || info.Subject != "first" || info.Tag != "v1.0.0" {
+		t.Errorf("unexpected info for the first commit: %+v", info)
+	}
+	if !info.Committed.Equal(time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected commit time: %v", info.Committed)
+	}
+
+	info, err = getCommitInfo(context.Background(), repo, second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Subject != "second" || info.Tag != "" {
+		t.Errorf("unexpected info for the second commit: %+v", info)
+	}
+}
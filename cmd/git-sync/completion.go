@@ -0,0 +1,179 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// completionCmd is the subcommand which prints a shell completion script,
+// e.g.:
+//
+//	source <(git-sync completion bash)
+const completionCmd = "completion"
+
+// completionSubcommands are the subcommands to complete, with their
+// descriptions.  The credential-memory subcommand is only for git to run.
+var completionSubcommands = []struct {
+	name string
+	desc string
+}{
+	{completionCmd, "print a shell completion script"},
+	{credentialHelperCmd, "act as a git credential helper"},
+	{doctorCmd, "inspect an existing --root"},
+	{migrateFlagsCmd, "translate v3 flags for v4"},
+	{validateCmd, "check the configuration without syncing"},
+}
+
+// completionMain runs the completion subcommand with args, and returns the
+// exit code.
+func completionMain(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintf(stderr, "usage: %s %s <bash|zsh|fish>\n", os.Args[0], completionCmd)
+		return 2
+	}
+	opts := manOptions()
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(stdout, opts)
+	case "zsh":
+		writeZshCompletion(stdout, opts)
+	case "fish":
+		writeFishCompletion(stdout, opts)
+	default:
+		fmt.Fprintf(stderr, "ERROR: unknown shell %q: must be bash, zsh, or fish\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// completionDesc returns the description of o, with the env vars which can
+// set it.
+func completionDesc(o manOption) string {
+	desc := strings.ReplaceAll(o.Help, "\n", " ")
+	if len(o.EnvVars) > 0 {
+		desc += " [" + strings.Join(o.EnvVars, ", ") + "]"
+	}
+	return desc
+}
+
+// completionWord returns what to complete o as: "--name" for a bool flag,
+// which needs no value, and "--name=" for others.
+func completionWord(o manOption) string {
+	if o.Type == "bool" {
+		return "--" + o.Name
+	}
+	return "--" + o.Name + "="
+}
+
+func writeBashCompletion(w io.Writer, opts []manOption) {
+	words := make([]string, 0, len(opts))
+	for _, o := range opts {
+		words = append(words, completionWord(o))
+	}
+	subcommands := make([]string, 0, len(completionSubcommands))
+	for _, s := range completionSubcommands {
+		subcommands = append(subcommands, s.name)
+	}
+	fmt.Fprintf(w, `# bash completion for git-sync
+_git_sync() {
+    # Bash splits words at "=", so find the whole word being completed.
+    local line="${COMP_LINE:0:COMP_POINT}"
+    local cur="${line##* }"
+    if [[ "$cur" == -*=* ]]; then
+        COMPREPLY=($(compgen -f -- "${cur#*=}"))
+        return
+    fi
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W %s -- "$cur"))
+        if [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == *= ]]; then
+            compopt -o nospace
+        fi
+        return
+    fi
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W %s -- "$cur"))
+    fi
+}
+complete -o default -F _git_sync git-sync /git-sync
+`, sqQuote(strings.Join(words, " ")), sqQuote(strings.Join(subcommands, " ")))
+}
+
+func writeZshCompletion(w io.Writer, opts []manOption) {
+	fmt.Fprint(w, `#compdef git-sync /git-sync
+_git_sync() {
+    local -a subcommands bools values
+    subcommands=(
+`)
+	for _, s := range completionSubcommands {
+		fmt.Fprintf(w, "        %s\n", sqQuote(s.name+":"+s.desc))
+	}
+	fmt.Fprint(w, "    )\n    bools=(\n")
+	for _, o := range opts {
+		if o.Type == "bool" {
+			fmt.Fprintf(w, "        %s\n", sqQuote("--"+o.Name+":"+completionDesc(o)))
+		}
+	}
+	fmt.Fprint(w, "    )\n    values=(\n")
+	for _, o := range opts {
+		if o.Type != "bool" {
+			fmt.Fprintf(w, "        %s\n", sqQuote("--"+o.Name+"=:"+completionDesc(o)))
+		}
+	}
+	fmt.Fprint(w, `    )
+    if compset -P '-*='; then
+        _files
+        return
+    fi
+    if [[ $PREFIX == -* ]]; then
+        _describe -t options 'flag' bools
+        _describe -t options 'flag' values -S ''
+        return
+    fi
+    if (( CURRENT == 2 )); then
+        _describe -t commands 'subcommand' subcommands
+    fi
+}
+compdef _git_sync git-sync /git-sync
+`)
+}
+
+// fishQuote quotes s for fish, where only \ and ' are special in single
+// quotes.
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func writeFishCompletion(w io.Writer, opts []manOption) {
+	fmt.Fprintln(w, "# fish completion for git-sync")
+	for _, cmd := range []string{"git-sync", "/git-sync"} {
+		for _, s := range completionSubcommands {
+			fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -f -a %s -d %s\n", cmd, s.name, fishQuote(s.desc))
+		}
+		for _, o := range opts {
+			arg := "-r"
+			if o.Type == "bool" {
+				arg = "-f"
+			}
+			fmt.Fprintf(w, "complete -c %s -l %s %s -d %s\n", cmd, o.Name, arg, fishQuote(completionDesc(o)))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletionMain(t *testing.T) {
+	for _, args := range [][]string{nil, {"tcsh"}, {"bash", "zsh"}} {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		if code := completionMain(args, stdout, stderr); code != 2 || stdout.Len() != 0 {
+			t.Errorf("%v: expected exit 2 and no script, got %d, %q", args, code, stdout.String())
+		}
+	}
+
+	cases := map[string][]string{
+		"bash": {"--repo= ", "--one-time ", "complete -o default -F _git_sync git-sync /git-sync"},
+		"zsh": {
+			"#compdef git-sync",
+			"'--repo=:the git repository to clone [GIT_SYNC_REPO]'",
+			"'--one-time:exit after the first sync [GIT_SYNC_ONE_TIME]'",
+			"'doctor:inspect an existing --root'",
+		},
+		"fish": {
+			"complete -c git-sync -l repo -r -d 'the git repository to clone [GIT_SYNC_REPO]'",
+			"complete -c git-sync -l one-time -f -d 'exit after the first sync [GIT_SYNC_ONE_TIME]'",
+			"complete -c /git-sync -n __fish_use_subcommand -f -a validate -d ",
+		},
+	}
+	for shell, expect := range cases {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		if code := completionMain([]string{shell}, stdout, stderr); code != 0 {
+			t.Fatalf("%s: unexpected exit %d: %s", shell, code, stderr.String())
+		}
+		for _, e := range expect {
+			if !strings.Contains(stdout.String(), e) {
+				t.Errorf("%s: expected %q in the script", shell, e)
+			}
+		}
+		if strings.Contains(stdout.String(), credentialMemoryCmd) {
+			t.Errorf("%s: expected no %s subcommand", shell, credentialMemoryCmd)
+		}
+		if _, err := exec.LookPath(shell); err == nil {
+			cmd := exec.Command(shell, "-n")
+			cmd.Stdin = stdout
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s: invalid script: %v: %s", shell, err, out)
+			}
+		}
+	}
+}
+
+func TestFishQuote(t *testing.T) {
+	if got, expect := fishQuote(`git-sync's \ path`), `'git-sync\'s \\ path'`; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Most flags can be set from an env var named GIT_SYNC_<FLAG>, with dashes
+// replaced by underscores.  These are the exceptions, mostly for historical
+// reasons.
+var flagEnvExceptions = map[string][]string{
+	"askpass-url":            {"GIT_ASKPASS_URL"},
+	"change-permissions":     {"GIT_SYNC_PERMISSIONS"},
+	"cookie-file":            {"GIT_COOKIE_FILE"},
+	"otel-exporter-endpoint": {"GIT_SYNC_OTEL_EXPORTER_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"},
+	"ssh-key-file":           {"GIT_SSH_KEY_FILE"},
+	"ssh-known-hosts":        {"GIT_KNOWN_HOSTS"},
+	"ssh-known-hosts-file":   {"GIT_SSH_KNOWN_HOSTS_FILE"},
+	"sync-hook-command":      {"GIT_SYNC_HOOK_COMMAND"},
+	"sync-hook-parallelism":  {"GIT_SYNC_HOOK_PARALLELISM"},
+	"sync-hook-policy":       {"GIT_SYNC_HOOK_POLICY"},
+	"sync-hook-timeout":      {"GIT_SYNC_HOOK_TIMEOUT"},
+
+	// These have no env var.
+	"fix":        nil,
+	"man":        nil,
+	"man-format": nil,
+	"version":    nil,
+
+	// These come from glog, and have no env var.
+	"alsologtostderr":  nil,
+	"log_backtrace_at": nil,
+	"log_dir":          nil,
+	"logtostderr":      nil,
+	"stderrthreshold":  nil,
+	"v":                nil,
+	"vmodule":          nil,
+}
+
+// envVarsForFlag returns the env vars which can set the named flag, in order
+// of precedence.
+func envVarsForFlag(name string) []string {
+	if vars, found := flagEnvExceptions[name]; found {
+		return vars
+	}
+	return []string{"GIT_SYNC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))}
+}
+
+// flagSetFromEnv returns true if the named flag was set by an env var.
+func flagSetFromEnv(name string) bool {
+	for _, env := range envVarsForFlag(name) {
+		if os.Getenv(env) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// configFileKeys are the fields in the --config file, as last read.
+var configFileKeys = map[string]bool{}
+
+// loadConfigFile reads the config file at path and applies its values to any
+// flags which were not set on the command line or by env vars.  All problems
+// are reported, rather than just the first.
+func loadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values, err := parseConfigFile(string(data))
+	if err != nil {
+		return err
+	}
+
+	fromArgs := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		fromArgs[f.Name] = true
+	})
+
+	errs := []string{}
+	for _, kv := range values {
+		f := flag.Lookup(kv.key)
+		if f == nil || kv.key == "config" {
+			errs = append(errs, fmt.Sprintf("unknown field %q", kv.key))
+			continue
+		}
+		configFileKeys[kv.key] = true
+		if fromArgs[kv.key] || flagSetFromEnv(kv.key) {
+			continue
+		}
+		if err := f.Value.Set(kv.val); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value for %q: %v", kv.key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// parseConfigFile parses a config file.  The format is a YAML mapping of flag
+// names to scalar values, e.g.:
+//
+//	repo: https://github.com/kubernetes/git-sync
+//	wait: 30
+//	webhook-url: "http://localhost:9090/-/reload"
+//
+// Only the subset of YAML needed for this is supported: comments, plain,
+// single-quoted, and double-quoted scalars.  Nested values are rejected, as
+// are duplicate keys.
+func parseConfigFile(data string) ([]keyVal, error) {
+	result := []keyVal{}
+	seen := map[string]bool{}
+
+	for i, line := range strings.Split(data, "\n") {
+		lineno := i + 1
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line == "---" && len(result) == 0 {
+			continue
+		}
+		if trimmed != line {
+			return nil, fmt.Errorf("line %d: nested values are not supported", lineno)
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			return nil, fmt.Errorf("line %d: lists are not supported", lineno)
+		}
+
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineno)
+		}
+		key := line[:colon]
+		if strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineno, key)
+		}
+		rest := line[colon+1:]
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			return nil, fmt.Errorf("line %d: expected a space after ':'", lineno)
+		}
+		val, err := parseConfigScalar(strings.TrimLeft(rest, " \t"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key %q: %v", lineno, key, err)
+		}
+
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineno, key)
+		}
+		seen[key] = true
+		result = append(result, keyVal{key: key, val: val})
+	}
+
+	return result, nil
+}
+
+// parseConfigScalar parses a YAML scalar value, including any trailing
+// comment.
+func parseConfigScalar(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"':
+		end := -1
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated double-quoted string")
+		}
+		if err := checkTrailer(s[end+1:]); err != nil {
+			return "", err
+		}
+		val, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted string: %v", err)
+		}
+		return val, nil
+	case '\'':
+		buf := strings.Builder{}
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				buf.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				buf.WriteByte('\'')
+				i++
+				continue
+			}
+			if err := checkTrailer(s[i+1:]); err != nil {
+				return "", err
+			}
+			return buf.String(), nil
+		}
+		return "", fmt.Errorf("unterminated single-quoted string")
+	case '|', '>':
+		return "", fmt.Errorf("block scalars are not supported")
+	case '[', '{':
+		return "", fmt.Errorf("flow collections are not supported")
+	case '&', '*', '!':
+		return "", fmt.Errorf("anchors, aliases, and tags are not supported")
+	}
+
+	// A plain scalar ends at a comment.
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "\t#"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimRight(s, " \t")
+	if s == "~" || s == "null" {
+		return "", nil
+	}
+	return s, nil
+}
+
+// checkTrailer verifies that whatever follows a quoted scalar is only
+// whitespace and an optional comment.
+func checkTrailer(s string) error {
+	t := strings.TrimLeft(s, " \t")
+	if t == "" || (strings.HasPrefix(t, "#") && t != s) {
+		return nil
+	}
+	return fmt.Errorf("unexpected characters after quoted string: %q", s)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		expect []keyVal
+		fail   bool
+	}{{
+		name:   "empty",
+		input:  ``,
+		expect: []keyVal{},
+	}, {
+		name:   "comments-only",
+		input:  "# a comment\n\n  # indented comment\n",
+		expect: []keyVal{},
+	}, {
+		name:   "document-start",
+		input:  "---\nrepo: foo\n",
+		expect: []keyVal{{"repo", "foo"}},
+	}, {
+		name:   "plain",
+		input:  "repo: https://example.com/repo.git\nwait: 30\none-time: true\n",
+		expect: []keyVal{{"repo", "https://example.com/repo.git"}, {"wait", "30"}, {"one-time", "true"}},
+	}, {
+		name:   "plain-with-comment",
+		input:  "wait: 30 # seconds\n",
+		expect: []keyVal{{"wait", "30"}},
+	}, {
+		name:   "plain-with-hash",
+		input:  "branch: feature#1\n",
+		expect: []keyVal{{"branch", "feature#1"}},
+	}, {
+		name:   "double-quoted",
+		input:  `git-config: "k1:v1,k2:\"v 2\"" # comment` + "\n",
+		expect: []keyVal{{"git-config", `k1:v1,k2:"v 2"`}},
+	}, {
+		name:   "single-quoted",
+		input:  "sync-hook-command: 'it''s here'\n",
+		expect: []keyVal{{"sync-hook-command", "it's here"}},
+	}, {
+		name:   "empty-and-null",
+		input:  "dest:\nerror-file: ~\nrev: null\n",
+		expect: []keyVal{{"dest", ""}, {"error-file", ""}, {"rev", ""}},
+	}, {
+		name:   "crlf",
+		input:  "repo: foo\r\nwait: 1\r\n",
+		expect: []keyVal{{"repo", "foo"}, {"wait", "1"}},
+	}, {
+		name:  "nested",
+		input: "auth:\n  username: me\n",
+		fail:  true,
+	}, {
+		name:  "list",
+		input: "- repo\n",
+		fail:  true,
+	}, {
+		name:  "flow",
+		input: "repo: [a, b]\n",
+		fail:  true,
+	}, {
+		name:  "block",
+		input: "repo: |\n",
+		fail:  true,
+	}, {
+		name:  "duplicate",
+		input: "repo: a\nrepo: b\n",
+		fail:  true,
+	}, {
+		name:  "no-colon",
+		input: "repo\n",
+		fail:  true,
+	}, {
+		name:  "no-space",
+		input: "repo:foo\n",
+		fail:  true,
+	}, {
+		name:  "unterminated-double",
+		input: `repo: "foo` + "\n",
+		fail:  true,
+	}, {
+		name:  "unterminated-single",
+		input: "repo: 'foo\n",
+		fail:  true,
+	}, {
+		name:  "trailing-garbage",
+		input: `repo: "foo" bar` + "\n",
+		fail:  true,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			kvs, err := parseConfigFile(tc.input)
+			if err != nil && !tc.fail {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tc.fail {
+				t.Errorf("unexpected success: %v", kvs)
+			}
+			if !tc.fail && !reflect.DeepEqual(kvs, tc.expect) {
+				t.Errorf("bad result: expected %q, got %q", tc.expect, kvs)
+			}
+		})
+	}
+}
+
+func TestEnvVarsForFlag(t *testing.T) {
+	cases := []struct {
+		name   string
+		expect []string
+	}{
+		{"repo", []string{"GIT_SYNC_REPO"}},
+		{"max-sync-failures", []string{"GIT_SYNC_MAX_SYNC_FAILURES"}},
+		{"ssh-key-file", []string{"GIT_SSH_KEY_FILE"}},
+		{"change-permissions", []string{"GIT_SYNC_PERMISSIONS"}},
+		{"version", nil},
+		{"v", nil},
+	}
+
+	for _, tc := range cases {
+		if got := envVarsForFlag(tc.name); !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%q: expected %q, got %q", tc.name, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// contentPrefix is where --http-files serves the published tree.
+const contentPrefix = "/content/"
+
+// contentFS is a read-only view of one worktree.  It hides VCS metadata,
+// refuses symlinks which lead outside of the worktree, and, unless listing
+// is set, refuses directories which have no index.html.
+type contentFS struct {
+	root    string
+	listing bool
+}
+
+func (fs contentFS) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	meta := backend.metaDir()
+	for _, part := range strings.Split(name, "/") {
+		if meta != "" && part == meta {
+			return nil, os.ErrNotExist
+		}
+	}
+
+	full, err := filepath.EvalSymlinks(filepath.Join(fs.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	if full != fs.root && !strings.HasPrefix(full, fs.root+string(filepath.Separator)) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, err
+	}
+	if !fs.listing {
+		if fi, err := f.Stat(); err == nil && fi.IsDir() {
+			if _, err := os.Stat(filepath.Join(full, "index.html")); err != nil {
+				f.Close()
+				return nil, os.ErrNotExist
+			}
+		}
+	}
+	return f, nil
+}
+
+// serveContent handles requests under contentPrefix, from whichever
+// worktree is published at the time of the request.
+func serveContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	worktree, err := filepath.EvalSymlinks(filepath.Join(*flRoot, *flDest))
+	if err != nil {
+		http.Error(w, "nothing is published", http.StatusServiceUnavailable)
+		return
+	}
+	fs := contentFS{root: worktree, listing: *flHTTPFilesListing}
+	http.StripPrefix(strings.TrimSuffix(contentPrefix, "/"), http.FileServer(fs)).ServeHTTP(w, r)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeContent(t *testing.T) {
+	defer func(root, dest string, listing bool) {
+		*flRoot, *flDest, *flHTTPFilesListing = root, dest, listing
+	}(*flRoot, *flDest, *flHTTPFilesListing)
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree := filepath.Join(root, hash1)
+	secret := filepath.Join(root, "secret")
+	for name, content := range map[string]string{
+		filepath.Join(worktree, "file"):            "content",
+		filepath.Join(worktree, "dir", "nested"):   "nested",
+		filepath.Join(worktree, "site/index.html"): "index",
+		filepath.Join(worktree, ".git"):            "gitdir: ../.git/worktrees/x",
+		secret:                                     "secret",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(worktree, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(worktree, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	*flRoot = root
+	*flDest = "link"
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		serveContent(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := get("/content/file"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before anything is published, got %d", code)
+	}
+	if err := os.Symlink(hash1, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/content/file", http.StatusOK, "content"},
+		{"/content/alias", http.StatusOK, "content"},
+		{"/content/dir/nested", http.StatusOK, "nested"},
+		{"/content/site/", http.StatusOK, "index"},
+		{"/content/dir/", http.StatusNotFound, ""},
+		{"/content/.git", http.StatusNotFound, ""},
+		{"/content/escape", http.StatusNotFound, ""},
+		{"/content/../secret", http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		code, body := get(tc.path)
+		if code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.path, tc.code, code)
+		} else if tc.body != "" && body != tc.body {
+			t.Errorf("%s: expected %q, got %q", tc.path, tc.body, body)
+		}
+	}
+
+	*flHTTPFilesListing = true
+	if code, _ := get("/content/dir/"); code != http.StatusOK {
+		t.Errorf("expected a listing, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	serveContent(rec, httptest.NewRequest(http.MethodPost, "/content/file", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("POST: expected Allow: GET, HEAD, got %q", allow)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var credentialRefreshCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_credential_refresh_count_total",
+	Help: "How many times credentials were refreshed after an authentication failure, partitioned by state (success, error)",
+}, []string{"status"})
+
+func init() {
+	prometheus.MustRegister(credentialRefreshCount)
+}
+
+// authErrorPatterns are substrings of git (and ssh, and curl) error output
+// which indicate that the upstream rejected our credentials.
+var authErrorPatterns = []string{
+	"authentication failed",
+	"could not read username",
+	"could not read password",
+	"terminal prompts disabled",
+	"permission denied (publickey",
+	"http basic: access denied",
+	"invalid username or password",
+	"the requested url returned error: 401",
+	"the requested url returned error: 403",
+}
+
+// isAuthError returns true if err looks like an authentication failure.
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pat := range authErrorPatterns {
+		if strings.Contains(msg, pat) {
+			return true
+		}
+	}
+	return false
+}
+
+// canRefreshCreds returns true if git-sync is configured with credentials
+// which might be different if they were fetched again.
+func canRefreshCreds() bool {
+	return *flAskPassURL != "" || *flAuthProvider != "" || (*flUsername != "" && *flPasswordFile != "")
+}
+
+// refreshCreds fetches credentials again.  Credentials from --askpass-url
+// are fetched at the start of every sync, so there is nothing to do for
+// those here.  Tokens cached by --auth-provider are dropped, so the retry
+// gets new ones.
+func refreshCreds(ctx context.Context) error {
+	if *flUsername != "" && *flPasswordFile != "" {
+		return reloadGitAuth(ctx)
+	}
+	if *flAuthProvider != "" {
+		authProviders[*flAuthProvider].forget()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIsAuthError(t *testing.T) {
+	cases := []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{fmt.Errorf("Run(git fetch): exit status 128: { stdout: \"\", stderr: \"fatal: Authentication failed for 'https://example.com/repo'\\n\" }"), true},
+		{fmt.Errorf("Run(git ls-remote): exit status 128: { stdout: \"\", stderr: \"fatal: could not read Username for 'https://example.com': terminal prompts disabled\\n\" }"), true},
+		{fmt.Errorf("git@example.com: Permission denied (publickey).\\r\\nfatal: Could not read from remote repository."), true},
+		{fmt.Errorf("fatal: unable to access 'https://example.com/repo/': The requested URL returned error: 403"), true},
+		{fmt.Errorf("fatal: unable to access 'https://example.com/repo/': The requested URL returned error: 404"), false},
+		{fmt.Errorf("fatal: couldn't find remote ref refs/heads/nope"), false},
+	}
+
+	for _, tc := range cases {
+		if got := isAuthError(tc.err); got != tc.expect {
+			t.Errorf("%v: expected %v, got %v", tc.err, tc.expect, got)
+		}
+	}
+}
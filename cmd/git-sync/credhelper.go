@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/glogr"
+)
+
+// credentialHelperCmd is the subcommand which runs git-sync as a git
+// credential helper, e.g. for other containers in the pod:
+//
+//	git config credential.helper '/git-sync credential-helper'
+const credentialHelperCmd = "credential-helper"
+
+// credentialHelperMain runs the credential-helper subcommand with args, which
+// are any git-sync flags followed by the action git asks for, and returns the
+// exit code.
+func credentialHelperMain(args []string) int {
+	setFlagDefaults()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+	log = &customLogger{glogr.New(), "", ""}
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [flags] <get|store|erase>\n", os.Args[0], credentialHelperCmd)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	defer cancel()
+	if err := runCredentialHelper(ctx, flag.Arg(0), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runCredentialHelper implements the helper side of git's credential
+// protocol (see gitcredentials(7)): for "get", it reads the request from in
+// and writes a username and password to out, from --askpass-url or
+// --username and --password(-file), as git-sync would use them.  It only
+// answers for the protocol and host of --repo, so that the credentials are
+// not sent anywhere else.  Credentials can't be stored or erased, so those actions do
+// nothing.
+func runCredentialHelper(ctx context.Context, action string, in io.Reader, out io.Writer) error {
+	switch action {
+	case "get":
+	case "store", "erase":
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	req, err := readCredentialRequest(in)
+	if err != nil {
+		return err
+	}
+	if !credentialHostMatches(*flRepo, req["protocol"], req["host"]) {
+		log.V(1).Info("not answering for a different host", "protocol", req["protocol"], "host", req["host"])
+		return nil
+	}
+
+	username, password, err := helperCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	if username == "" && password == "" {
+		return nil
+	}
+	_, err = fmt.Fprintf(out, "username=%s\npassword=%s\n", username, password)
+	return err
+}
+
+// readCredentialRequest reads "key=value" lines until a blank line or EOF.
+func readCredentialRequest(in io.Reader) (map[string]string, error) {
+	req := map[string]string{}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed credential request line %q", line)
+		}
+		req[kv[0]] = kv[1]
+	}
+	return req, scanner.Err()
+}
+
+// credentialHostMatches returns true if credentials for repo may be given for
+// protocol and host.  If repo is not an HTTP(S) URL, git never asks for its
+// credentials, so nothing matches.
+func credentialHostMatches(repo, protocol, host string) bool {
+	u, err := url.Parse(repo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return u.Scheme == protocol && strings.EqualFold(u.Host, host)
+}
+
+// helperCredentials returns the credentials which git-sync would use.
+func helperCredentials(ctx context.Context) (string, string, error) {
+	if *flAskPassURL != "" {
+		return fetchAskPassCreds(ctx, *flAskPassURL)
+	}
+	if *flAuthProvider != "" {
+		return authProviders[*flAuthProvider].credentials(ctx, *flRepo, time.Now())
+	}
+	if *flUsername == "" {
+		return "", "", nil
+	}
+	password := *flPassword
+	if *flPasswordFile != "" {
+		data, err := ioutil.ReadFile(*flPasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("can't read --password-file: %w", err)
+		}
+		password = string(data)
+	}
+	return *flUsername, password, nil
+}
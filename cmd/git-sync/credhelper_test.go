@@ -0,0 +1,153 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRunCredentialHelper(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(repo, user, pass, askpass string) {
+		*flRepo = repo
+		*flUsername = user
+		*flPassword = pass
+		*flAskPassURL = askpass
+	}(*flRepo, *flUsername, *flPassword, *flAskPassURL)
+
+	askpass := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "username=bot\npassword=token\n")
+	}))
+	defer askpass.Close()
+
+	testCases := []struct {
+		name     string
+		repo     string
+		username string
+		password string
+		askpass  string
+		action   string
+		request  string
+		expect   string
+		err      bool
+	}{{
+		name:     "password",
+		repo:     "https://example.com/repo.git",
+		username: "me",
+		password: "secret",
+		action:   "get",
+		request:  "protocol=https\nhost=example.com\n\n",
+		expect:   "username=me\npassword=secret\n",
+	}, {
+		name:    "askpass",
+		repo:    "https://example.com/repo.git",
+		askpass: askpass.URL,
+		action:  "get",
+		request: "protocol=https\nhost=example.com\n",
+		expect:  "username=bot\npassword=token\n",
+	}, {
+		name:     "other host",
+		repo:     "https://example.com/repo.git",
+		username: "me",
+		password: "secret",
+		action:   "get",
+		request:  "protocol=https\nhost=evil.example.com\n\n",
+		expect:   "",
+	}, {
+		name:     "other protocol",
+		repo:     "https://example.com/repo.git",
+		username: "me",
+		password: "secret",
+		action:   "get",
+		request:  "protocol=http\nhost=example.com\n\n",
+		expect:   "",
+	}, {
+		name:     "no protocol",
+		repo:     "https://example.com/repo.git",
+		username: "me",
+		password: "secret",
+		action:   "get",
+		request:  "host=example.com\n\n",
+		expect:   "",
+	}, {
+		name:     "ssh repo",
+		repo:     "git@example.com:repo.git",
+		username: "me",
+		password: "secret",
+		action:   "get",
+		request:  "protocol=https\nhost=example.com\n\n",
+		expect:   "",
+	}, {
+		name:     "unparsable repo",
+		repo:     "https://example.com:port/repo.git",
+		username: "me",
+		password: "secret",
+		action:   "get",
+		request:  "protocol=https\nhost=example.com\n\n",
+		expect:   "",
+	}, {
+		name:    "no credentials",
+		repo:    "https://example.com/repo.git",
+		action:  "get",
+		request: "protocol=https\nhost=example.com\n\n",
+		expect:  "",
+	}, {
+		name:     "store",
+		repo:     "https://example.com/repo.git",
+		username: "me",
+		action:   "store",
+		request:  "protocol=https\nhost=example.com\nusername=me\npassword=x\n\n",
+		expect:   "",
+	}, {
+		name:    "malformed",
+		action:  "get",
+		request: "garbage\n\n",
+		err:     true,
+	}, {
+		name:   "unknown action",
+		action: "list",
+		err:    true,
+	}}
+	for _, tc := range testCases {
+		*flRepo = tc.repo
+		*flUsername = tc.username
+		*flPassword = tc.password
+		*flAskPassURL = tc.askpass
+		out := bytes.NewBuffer(nil)
+		err := runCredentialHelper(context.Background(), tc.action, strings.NewReader(tc.request), out)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if out.String() != tc.expect {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expect, out.String())
+		}
+	}
+}
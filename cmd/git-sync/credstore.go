@@ -0,0 +1,227 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The values of --credential-store.
+const (
+	credentialStoreFile   = "file"
+	credentialStoreMemory = "memory"
+)
+
+// credentialMemoryCmd is the subcommand which git runs as its credential
+// helper with --credential-store=memory.  It passes git's request to the
+// git-sync process which is listening on the socket, and its answer back:
+//
+//	git-sync credential-memory <socket> <get|store|erase>
+const credentialMemoryCmd = "credential-memory"
+
+// credentialSocketTimeout is how long the helper waits for git-sync.
+const credentialSocketTimeout = 10 * time.Second
+
+// gitCredentialsFile is the name of the file in which git's "store" helper
+// keeps credentials, beside --git-config-file.
+const gitCredentialsFile = ".git-credentials"
+
+// credentialHelper returns the credential.helper for git to use.
+func credentialHelper() string {
+	if *flCredentialStore == credentialStoreMemory {
+		// The "!" makes git run it with the shell, which the quoting is for.
+		return fmt.Sprintf("!%s %s %s", sqQuote(memoryCredentials.exe), credentialMemoryCmd, sqQuote(memoryCredentials.socket))
+	}
+	helper := "store"
+	if *flGitConfigFile != "" {
+		helper += " --file=" + filepath.Join(filepath.Dir(*flGitConfigFile), gitCredentialsFile)
+	}
+	return helper
+}
+
+// memoryCredentialStore holds the credentials git has been given, with
+// --credential-store=memory, and serves them to the helper.
+type memoryCredentialStore struct {
+	mutex sync.Mutex
+	// creds are the credentials, by protocol, host, and (if git sends it)
+	// path.
+	creds map[string]storedCredential
+	// exe is the git-sync binary, which git runs as the helper.
+	exe string
+	// socket is where the helper reaches this store.
+	socket string
+}
+
+type storedCredential struct {
+	username string
+	password string
+}
+
+var memoryCredentials = &memoryCredentialStore{creds: map[string]storedCredential{}}
+
+// credentialKey returns the key for the credential which git asks for in req.
+// Unless the path is given, it is not part of the key.
+func credentialKey(req map[string]string, withPath bool) string {
+	key := req["protocol"] + "://" + req["host"]
+	if withPath && req["path"] != "" {
+		key += "/" + req["path"]
+	}
+	return key
+}
+
+// handle does what git asks for with action and req, as the "cache" and
+// "store" helpers would, and returns the response.
+func (s *memoryCredentialStore) handle(action string, req map[string]string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch action {
+	case "get":
+		c, found := s.creds[credentialKey(req, true)]
+		if !found {
+			c, found = s.creds[credentialKey(req, false)]
+		}
+		if !found || (req["username"] != "" && req["username"] != c.username) {
+			return ""
+		}
+		return fmt.Sprintf("username=%s\npassword=%s\n", c.username, c.password)
+	case "store":
+		if req["username"] == "" || req["password"] == "" {
+			return ""
+		}
+		s.creds[credentialKey(req, true)] = storedCredential{username: req["username"], password: req["password"]}
+	case "erase":
+		key := credentialKey(req, true)
+		if c, found := s.creds[key]; found && (req["username"] == "" || req["username"] == c.username) {
+			delete(s.creds, key)
+		}
+	}
+	return ""
+}
+
+// start listens for the helper on a socket in a new directory which only
+// git-sync's user can use.
+func (s *memoryCredentialStore) start() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("can't find the git-sync binary: %w", err)
+	}
+	dir, err := ioutil.TempDir("", "git-sync-credentials-")
+	if err != nil {
+		return fmt.Errorf("can't make a directory for the credential socket: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	socket := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("can't listen for the credential helper: %w", err)
+	}
+	s.exe, s.socket = exe, socket
+	log.V(1).Info("serving git credentials from memory", "socket", socket)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Error(err, "credential socket stopped")
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// serve answers one request from the helper: the action, followed by git's
+// request.
+func (s *memoryCredentialStore) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(credentialSocketTimeout))
+	r := bufio.NewReader(conn)
+	action, err := r.ReadString('\n')
+	if err != nil {
+		log.V(0).Info("can't read credential request", "error", err.Error())
+		return
+	}
+	req, err := readCredentialRequest(r)
+	if err != nil {
+		log.V(0).Info("can't read credential request", "error", err.Error())
+		return
+	}
+	action = strings.TrimSpace(action)
+	log.V(3).Info("credential request", "action", action, "protocol", req["protocol"], "host", req["host"])
+	if _, err := io.WriteString(conn, s.handle(action, req)); err != nil {
+		log.V(0).Info("can't answer credential request", "error", err.Error())
+	}
+}
+
+// credentialMemoryMain runs the credential-memory subcommand with args, and
+// returns the exit code.
+func credentialMemoryMain(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s %s <socket> <get|store|erase>\n", os.Args[0], credentialMemoryCmd)
+		return 2
+	}
+	if err := askMemoryCredentials(args[0], args[1], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// askMemoryCredentials sends action and the request from in to the git-sync
+// process listening on socket, and copies its answer to out.
+func askMemoryCredentials(socket, action string, in io.Reader, out io.Writer) error {
+	switch action {
+	case "get", "store", "erase":
+	default:
+		// Like git's helpers, ignore actions this doesn't know.
+		return nil
+	}
+	req, err := readCredentialRequest(in)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", socket, credentialSocketTimeout)
+	if err != nil {
+		return fmt.Errorf("can't reach git-sync: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(credentialSocketTimeout))
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s\n", action)
+	for k, v := range req {
+		fmt.Fprintf(w, "%s=%s\n", k, v)
+	}
+	fmt.Fprintf(w, "\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err = io.Copy(out, conn)
+	return err
+}
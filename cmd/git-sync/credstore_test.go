@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestMemoryCredentialStore(t *testing.T) {
+	s := &memoryCredentialStore{creds: map[string]storedCredential{}}
+	host := map[string]string{"protocol": "https", "host": "example.com"}
+	withPath := map[string]string{"protocol": "https", "host": "example.com", "path": "org/repo.git"}
+
+	if got := s.handle("get", host); got != "" {
+		t.Errorf("expected nothing before a store, got %q", got)
+	}
+	s.handle("store", map[string]string{"protocol": "https", "host": "example.com", "username": "bot", "password": "secret"})
+	expect := "username=bot\npassword=secret\n"
+	for _, req := range []map[string]string{host, withPath, {"protocol": "https", "host": "example.com", "username": "bot"}} {
+		if got := s.handle("get", req); got != expect {
+			t.Errorf("%v: expected %q, got %q", req, expect, got)
+		}
+	}
+	for _, req := range []map[string]string{
+		{"protocol": "http", "host": "example.com"},
+		{"protocol": "https", "host": "example.com:8443"},
+		{"protocol": "https", "host": "example.com", "username": "other"},
+	} {
+		if got := s.handle("get", req); got != "" {
+			t.Errorf("%v: expected nothing, got %q", req, got)
+		}
+	}
+
+	s.handle("erase", map[string]string{"protocol": "https", "host": "example.com", "username": "other"})
+	if got := s.handle("get", host); got != expect {
+		t.Errorf("expected an erase for another user to be ignored, got %q", got)
+	}
+	s.handle("erase", host)
+	if got := s.handle("get", host); got != "" {
+		t.Errorf("expected nothing after an erase, got %q", got)
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	defer func(store, config string) {
+		*flCredentialStore, *flGitConfigFile = store, config
+	}(*flCredentialStore, *flGitConfigFile)
+	defer func(exe, socket string) {
+		memoryCredentials.exe, memoryCredentials.socket = exe, socket
+	}(memoryCredentials.exe, memoryCredentials.socket)
+
+	*flCredentialStore, *flGitConfigFile = credentialStoreFile, ""
+	if got := credentialHelper(); got != "store" {
+		t.Errorf("expected %q, got %q", "store", got)
+	}
+	*flGitConfigFile = "/etc/git-sync/gitconfig"
+	if got, expect := credentialHelper(), "store --file=/etc/git-sync/.git-credentials"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+	*flCredentialStore = credentialStoreMemory
+	memoryCredentials.exe, memoryCredentials.socket = "/git sync", "/tmp/x/socket"
+	if got, expect := credentialHelper(), "!'/git sync' credential-memory '/tmp/x/socket'"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestMemoryCredentialSocket(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	s := &memoryCredentialStore{creds: map[string]storedCredential{}}
+	if err := s.start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(s.socket))
+
+	out := &bytes.Buffer{}
+	err := askMemoryCredentials(s.socket, "store", strings.NewReader("protocol=https\nhost=example.com\nusername=bot\npassword=secret\n\n"), out)
+	if err != nil || out.Len() != 0 {
+		t.Fatalf("unexpected store result: %q, %v", out.String(), err)
+	}
+	err = askMemoryCredentials(s.socket, "get", strings.NewReader("protocol=https\nhost=example.com\n"), out)
+	if expect := "username=bot\npassword=secret\n"; err != nil || out.String() != expect {
+		t.Errorf("expected %q, got %q, %v", expect, out.String(), err)
+	}
+	out.Reset()
+	if err := askMemoryCredentials(s.socket, "capability", strings.NewReader(""), out); err != nil || out.Len() != 0 {
+		t.Errorf("expected an unknown action to be ignored, got %q, %v", out.String(), err)
+	}
+	if err := askMemoryCredentials(filepath.Join(t.TempDir(), "missing"), "get", strings.NewReader("\n"), out); err == nil {
+		t.Errorf("expected an error without git-sync")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// webhookDeadLetterPath is where the --webhook-dead-letter-file is served.
+const webhookDeadLetterPath = "/api/v1/webhook/dead-letters"
+
+var webhookDeadLetterCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_webhook_dead_letters_total",
+	Help: "How many webhook notifications were given up on after --webhook-max-retries",
+})
+
+func init() {
+	prometheus.MustRegister(webhookDeadLetterCount)
+}
+
+// deadLetter is one line of the --webhook-dead-letter-file: a notification
+// which was never delivered.
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	Method   string    `json:"method"`
+	Hash     string    `json:"hash"`
+	Rollback bool      `json:"rollback,omitempty"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+}
+
+// deadLetters appends undelivered notifications to a file.
+type deadLetters struct {
+	mutex sync.Mutex
+	path  string
+}
+
+var webhookDeadLetters deadLetters
+
+// append writes one record to the file, if there is one.
+func (d *deadLetters) append(rec deadLetter) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// read returns the contents of the file.
+func (d *deadLetters) read() ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	data, err := ioutil.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// serveDeadLetters handles requests to webhookDeadLetterPath, which returns
+// the --webhook-dead-letter-file.
+func serveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := webhookDeadLetters.read()
+	if err != nil {
+		log.Error(err, "can't read webhook dead letters", "path", webhookDeadLetters.path)
+		http.Error(w, "can't read dead letters", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Write(data)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+)
+
+// debouncer tracks an upstream hash which has not yet been published, so
+// that a burst of upstream changes is published once, after it settles.
+type debouncer struct {
+	// hash is the most recently seen upstream hash, or "" if there is
+	// nothing pending.
+	hash string
+	// since is when hash was first seen.
+	since time.Time
+}
+
+// pending is the debounce state for the sync loop.  This is protected by
+// syncLock.
+var pending debouncer
+
+// settled returns true if hash has been the upstream hash for at least
+// quiet.  Seeing a different hash restarts the wait.
+func (d *debouncer) settled(hash string, now time.Time, quiet time.Duration) bool {
+	if hash != d.hash {
+		if d.hash == "" {
+			log.V(0).Info("upstream changed, waiting for it to settle", "hash", hash, "debounce", quiet.String())
+		} else {
+			log.V(0).Info("upstream changed again, restarting the wait", "hash", hash, "previous", d.hash, "debounce", quiet.String())
+		}
+		d.hash = hash
+		d.since = now
+	}
+	return now.Sub(d.since) >= quiet
+}
+
+// reset forgets any pending hash.
+func (d *debouncer) reset() {
+	d.hash = ""
+	d.since = time.Time{}
+}
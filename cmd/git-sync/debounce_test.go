@@ -0,0 +1,54 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestDebouncer(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	d := debouncer{}
+	now := time.Unix(1600000000, 0)
+	quiet := 10 * time.Second
+
+	if d.settled(hash1, now, quiet) {
+		t.Errorf("expected a new hash not to be settled")
+	}
+	if d.settled(hash1, now.Add(5*time.Second), quiet) {
+		t.Errorf("expected the hash not to be settled after 5s")
+	}
+	// A new hash restarts the wait.
+	if d.settled(hash2, now.Add(8*time.Second), quiet) {
+		t.Errorf("expected a changed hash not to be settled")
+	}
+	if d.settled(hash2, now.Add(15*time.Second), quiet) {
+		t.Errorf("expected the changed hash not to be settled after 7s")
+	}
+	if !d.settled(hash2, now.Add(18*time.Second), quiet) {
+		t.Errorf("expected the changed hash to be settled after 10s")
+	}
+
+	d.reset()
+	if d.settled(hash2, now.Add(20*time.Second), quiet) {
+		t.Errorf("expected the wait to restart after a reset")
+	}
+}
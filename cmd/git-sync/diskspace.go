@@ -0,0 +1,199 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var rootFreeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_root_free_bytes",
+	Help: "How many bytes were free on the filesystem of --root, as of the last check",
+})
+
+var rootPredictedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_root_predicted_bytes",
+	Help: "How many bytes the next new worktree was predicted to need, as of the last check",
+})
+
+var freeSpaceRefusals = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_free_space_refusals_total",
+	Help: "How many syncs were not started because they were predicted to leave less than --min-free-space",
+})
+
+func init() {
+	prometheus.MustRegister(rootFreeBytes)
+	prometheus.MustRegister(rootPredictedBytes)
+	prometheus.MustRegister(freeSpaceRefusals)
+}
+
+// errInsufficientSpace is returned when a sync is not started because it
+// would leave less than --min-free-space.
+var errInsufficientSpace = errors.New("not enough free space")
+
+// fsStats describes the filesystem which holds --root.
+type fsStats struct {
+	fsType string
+	// memory is true if the filesystem is backed by memory (e.g. an
+	// emptyDir with medium: Memory), so filling it counts against the
+	// pod's memory limit.
+	memory bool
+	free   uint64
+	total  uint64
+}
+
+// minFreeSpace is --min-free-space, in bytes.
+var minFreeSpace uint64
+
+// byteSuffixes are the units parseByteSize accepts, as Kubernetes quantities
+// do.
+var byteSuffixes = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// parseByteSize parses a size like "512Mi", "1G", or "1048576".
+func parseByteSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	mult := uint64(1)
+	for _, u := range byteSuffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// worktreeSizes caches the size of published worktrees, which never change.
+var worktreeSizes struct {
+	mutex sync.Mutex
+	hash  string
+	size  uint64
+}
+
+// nearestDir returns path, or its nearest parent which exists, since the
+// root may not have been made yet.
+func nearestDir(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// treeSize returns the total size of the regular files under dir.
+func treeSize(dir string) (uint64, error) {
+	var total uint64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += uint64(info.Size())
+		}
+		return nil
+	})
+	return total, err
+}
+
+// predictWorktreeSize predicts how much space the next worktree needs: about
+// as much as the published one.  It returns 0 if nothing is published.
+func predictWorktreeSize(gitRoot, dest string) (uint64, error) {
+	current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	hash := worktreeHash(current)
+	worktreeSizes.mutex.Lock()
+	defer worktreeSizes.mutex.Unlock()
+	if worktreeSizes.hash == hash {
+		return worktreeSizes.size, nil
+	}
+	size, err := treeSize(current)
+	if err != nil {
+		return 0, err
+	}
+	worktreeSizes.hash, worktreeSizes.size = hash, size
+	return size, nil
+}
+
+// checkFreeSpace returns an error wrapping errInsufficientSpace if making a
+// new worktree (and fetching for it) is predicted to leave less than
+// --min-free-space free under gitRoot.
+func checkFreeSpace(gitRoot, dest string) error {
+	if minFreeSpace == 0 {
+		return nil
+	}
+	stats, err := statFilesystem(nearestDir(gitRoot))
+	if err != nil {
+		return fmt.Errorf("can't check free space: %w", err)
+	}
+	rootFreeBytes.Set(float64(stats.free))
+	predicted, err := predictWorktreeSize(gitRoot, dest)
+	if err != nil {
+		log.Error(err, "can't predict the size of the next worktree")
+	}
+	rootPredictedBytes.Set(float64(predicted))
+	if stats.free < predicted+minFreeSpace {
+		freeSpaceRefusals.Inc()
+		return fmt.Errorf("%w under %s: %s free, a new worktree needs about %s, and --min-free-space is %s",
+			errInsufficientSpace, gitRoot, humanBytes(int64(stats.free)), humanBytes(int64(predicted)), humanBytes(int64(minFreeSpace)))
+	}
+	return nil
+}
+
+// logFilesystem describes the filesystem of gitRoot at startup, with a
+// warning if it is memory-backed and not guarded by --min-free-space.
+func logFilesystem(gitRoot string) {
+	stats, err := statFilesystem(nearestDir(gitRoot))
+	if err != nil {
+		log.V(1).Info("can't check the filesystem of the root", "root", gitRoot, "error", err.Error())
+		return
+	}
+	rootFreeBytes.Set(float64(stats.free))
+	log.V(0).Info("root filesystem", "root", gitRoot, "type", stats.fsType, "memory", stats.memory, "free", humanBytes(int64(stats.free)), "total", humanBytes(int64(stats.total)))
+	if stats.memory && minFreeSpace == 0 {
+		log.V(0).Info("WARNING: the root is memory-backed, and filling it counts against the memory limit; consider --min-free-space", "root", gitRoot)
+	}
+	if minFreeSpace > 0 && stats.free < minFreeSpace {
+		log.Error(fmt.Errorf("%w: %s free", errInsufficientSpace, humanBytes(int64(stats.free))), "less than --min-free-space is free under the root; syncs will not start until there is more", "root", gitRoot)
+	}
+}
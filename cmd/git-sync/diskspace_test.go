@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect uint64
+		ok     bool
+	}{
+		{"1024", 1024, true},
+		{"512Mi", 512 << 20, true},
+		{"2Gi", 2 << 30, true},
+		{"1G", 1000000000, true},
+		{"10k", 10000, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"1.5Gi", 0, false},
+		{"lots", 0, false},
+	}
+	for _, tc := range cases {
+		got, err := parseByteSize(tc.in)
+		if tc.ok != (err == nil) || got != tc.expect {
+			t.Errorf("%q: expected %d, %v, got %d, %v", tc.in, tc.expect, tc.ok, got, err)
+		}
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	if !canCheckFreeSpace {
+		t.Skipf("not supported on %s", runtime.GOOS)
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(min uint64) { minFreeSpace = min }(minFreeSpace)
+
+	root := t.TempDir()
+	worktree := filepath.Join(root, hash1)
+	if err := os.Mkdir(worktree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(worktree, "file"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(hash1, filepath.Join(root, "repo")); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := predictWorktreeSize(root, "repo"); err != nil || size != 1000 {
+		t.Errorf("expected a prediction of 1000 bytes, got %d, %v", size, err)
+	}
+	if size, err := predictWorktreeSize(root, "nothing"); err != nil || size != 0 {
+		t.Errorf("expected no prediction without a worktree, got %d, %v", size, err)
+	}
+
+	minFreeSpace = 0
+	if err := checkFreeSpace(root, "repo"); err != nil {
+		t.Errorf("expected no check, got %v", err)
+	}
+	minFreeSpace = 1
+	if err := checkFreeSpace(root, "repo"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// The root need not exist yet.
+	if err := checkFreeSpace(filepath.Join(root, "not", "yet"), "repo"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	minFreeSpace = 1 << 62
+	if err := checkFreeSpace(root, "repo"); !errors.Is(err, errInsufficientSpace) {
+		t.Errorf("expected errInsufficientSpace, got %v", err)
+	}
+}
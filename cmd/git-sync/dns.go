@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of --dns-policy.
+const (
+	// dnsPolicyDefault leaves name resolution to git.
+	dnsPolicyDefault = "default"
+	// dnsPolicyPerSync resolves the repo's host at the start of each sync,
+	// and uses those addresses for the whole sync.
+	dnsPolicyPerSync = "per-sync"
+	// dnsPolicyPin resolves the repo's host once, and uses those addresses
+	// for the life of the process.
+	dnsPolicyPin = "pin"
+)
+
+var dnsResolutionCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_dns_resolution_count_total",
+	Help: "How many times the repo's host has been resolved for --dns-policy, partitioned by state (success, error)",
+}, []string{"status"})
+
+func init() {
+	prometheus.MustRegister(dnsResolutionCount)
+}
+
+// lookupHost resolves a host name.  It is a variable for tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// upstreamHostPort returns the host and port which git connects to for repo.
+// Only HTTP(S) repos are supported, since git can only be told which
+// addresses to use for those.
+func upstreamHostPort(repo string) (string, string, error) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", "", fmt.Errorf("can't parse the repo URL")
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	default:
+		return "", "", fmt.Errorf("only http and https repos are supported")
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("the repo URL has no host")
+	}
+	return u.Hostname(), port, nil
+}
+
+// curlResolveEntry formats addrs as a libcurl CURLOPT_RESOLVE entry, which
+// git takes in http.curloptResolve.
+func curlResolveEntry(host, port string, addrs []string) string {
+	formatted := make([]string, len(addrs))
+	for i, a := range addrs {
+		if strings.Contains(a, ":") {
+			a = "[" + a + "]"
+		}
+		formatted[i] = a
+	}
+	return host + ":" + port + ":" + strings.Join(formatted, ",")
+}
+
+// dnsPinner resolves the repo's host according to --dns-policy, and tells
+// git which addresses to use.  It is only accessed under syncLock.
+type dnsPinner struct {
+	addrs []string
+}
+
+var dnsPins dnsPinner
+
+// resolve looks up the repo's host, unless it is pinned and has already been
+// looked up.  If the addresses change, git is told to use the new ones.
+func (p *dnsPinner) resolve(ctx context.Context, repo, policy string) error {
+	if policy == dnsPolicyDefault || (policy == dnsPolicyPin && p.addrs != nil) {
+		return nil
+	}
+	host, port, err := upstreamHostPort(repo)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		// Nothing to resolve.
+		p.addrs = []string{host}
+		return nil
+	}
+
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		dnsResolutionCount.WithLabelValues(metricKeyError).Inc()
+		return fmt.Errorf("can't resolve %s: %w", host, err)
+	}
+	dnsResolutionCount.WithLabelValues(metricKeySuccess).Inc()
+	sort.Strings(addrs)
+	if strings.Join(addrs, ",") == strings.Join(p.addrs, ",") {
+		return nil
+	}
+
+	entry := curlResolveEntry(host, port, addrs)
+	if _, err := runCommand(ctx, "", *flGitCmd, "config", "--global", "--replace-all", "http.curloptResolve", entry); err != nil {
+		return fmt.Errorf("can't configure resolved addresses: %w", err)
+	}
+	if p.addrs == nil {
+		log.V(0).Info("using resolved addresses for the repo", "host", host, "addrs", addrs, "policy", policy)
+	} else {
+		log.V(0).Info("resolved addresses for the repo have changed", "host", host, "from", p.addrs, "to", addrs)
+	}
+	p.addrs = addrs
+	return nil
+}
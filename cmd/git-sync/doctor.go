@@ -0,0 +1,319 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/glogr"
+)
+
+// doctorCmd is the subcommand which inspects an existing --root and reports
+// problems, with advice on fixing them.
+const doctorCmd = "doctor"
+
+// doctorLockAge is how old a git lock file must be before it is assumed to
+// have been left behind by a git process which died.
+const doctorLockAge = 10 * time.Minute
+
+// doctorTimeout bounds the git commands the doctor runs.
+const doctorTimeout = 5 * time.Minute
+
+// Levels of doctor findings.
+const (
+	doctorOK   = "ok"
+	doctorInfo = "info"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+// doctorFinding is the result of one check.
+type doctorFinding struct {
+	level   string
+	check   string
+	message string
+	// remedy is advice on how to fix the problem, if any.
+	remedy string
+	// fixed is true if --fix repaired the problem.
+	fixed bool
+}
+
+// doctorMain runs the doctor subcommand with args, which are git-sync flags
+// and --fix, and returns the exit code: 1 if any check failed.
+func doctorMain(args []string) int {
+	setFlagDefaults()
+	flags := flag.NewFlagSet(doctorCmd, flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "repair problems which can be repaired safely")
+	flag.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	log = &customLogger{glogr.New(), "", ""}
+	dest, err := resolveDest()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid --dest: %v\n", err)
+		return 2
+	}
+	*flDest = dest
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	findings := runDoctor(ctx, *flRoot, *flDest, *fix, time.Now())
+	writeDoctorFindings(os.Stdout, findings)
+	for _, f := range findings {
+		if f.level == doctorFail && !f.fixed {
+			return 1
+		}
+	}
+	return 0
+}
+
+// runDoctor checks the root at gitRoot, with the link dest.  If fix is true,
+// problems which can be repaired without risk to what is published are
+// repaired.
+func runDoctor(ctx context.Context, gitRoot, dest string, fix bool, now time.Time) []doctorFinding {
+	var findings []doctorFinding
+	add := func(f doctorFinding) { findings = append(findings, f) }
+
+	fi, err := os.Stat(gitRoot)
+	if err != nil || !fi.IsDir() {
+		add(doctorFinding{level: doctorFail, check: "root", message: fmt.Sprintf("%s is not a directory: %v", gitRoot, err),
+			remedy: "check --root, and that its volume is mounted"})
+		return findings
+	}
+	add(doctorFinding{level: doctorOK, check: "root", message: gitRoot})
+
+	if _, err := os.Stat(filepath.Join(gitRoot, ".git")); err != nil {
+		add(doctorFinding{level: doctorFail, check: "repo", message: fmt.Sprintf("no clone under %s: %v", gitRoot, err),
+			remedy: "git-sync clones on its next sync; if it fails to, empty --root and restart it"})
+		return findings
+	}
+	if _, err := runCommand(ctx, gitRoot, *flGitCmd, "fsck", "--connectivity-only", "--no-dangling"); err != nil {
+		add(doctorFinding{level: doctorFail, check: "repo", message: fmt.Sprintf("the clone is corrupt: %v", err),
+			remedy: "stop git-sync, empty --root, and restart it to clone again"})
+	} else {
+		add(doctorFinding{level: doctorOK, check: "repo", message: "the clone is consistent"})
+	}
+
+	if _, err := os.Stat(filepath.Join(gitRoot, ".git", "shallow")); err == nil {
+		add(doctorFinding{level: doctorInfo, check: "shallow", message: "the clone is shallow, so history rewrites can't be detected"})
+	}
+
+	findings = append(findings, doctorCheckLink(gitRoot, dest)...)
+	findings = append(findings, doctorCheckJournal(gitRoot)...)
+	findings = append(findings, doctorCheckWorktrees(ctx, gitRoot, fix)...)
+	findings = append(findings, doctorCheckLocks(gitRoot, fix, now)...)
+	findings = append(findings, doctorCheckDiskUsage(ctx, gitRoot)...)
+	return findings
+}
+
+// doctorCheckLink checks that the link points at a worktree under gitRoot.
+func doctorCheckLink(gitRoot, dest string) []doctorFinding {
+	link := filepath.Join(gitRoot, dest)
+	fi, err := os.Lstat(link)
+	if os.IsNotExist(err) {
+		return []doctorFinding{{level: doctorWarn, check: "link", message: fmt.Sprintf("%s does not exist, so nothing is published", link),
+			remedy: "check --dest; git-sync creates it on its first successful sync"}}
+	}
+	if err != nil {
+		return []doctorFinding{{level: doctorFail, check: "link", message: err.Error()}}
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return []doctorFinding{{level: doctorFail, check: "link", message: fmt.Sprintf("%s is not a symlink", link),
+			remedy: "remove it, and git-sync will publish again on its next sync"}}
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return []doctorFinding{{level: doctorFail, check: "link", message: fmt.Sprintf("%s is broken: %v", link, err),
+			remedy: "remove it, and git-sync will publish again on its next sync"}}
+	}
+	if filepath.Dir(target) != gitRoot || !isWorktreeName(filepath.Base(target)) {
+		return []doctorFinding{{level: doctorWarn, check: "link", message: fmt.Sprintf("%s points at %s, which is not a worktree made by git-sync", link, target)}}
+	}
+	return []doctorFinding{{level: doctorOK, check: "link", message: fmt.Sprintf("%s publishes %s", link, filepath.Base(target))}}
+}
+
+// doctorCheckJournal reports an operation which was interrupted by a crash.
+// Recovering from it is left to git-sync, which holds the root's lock while
+// it does.
+func doctorCheckJournal(gitRoot string) []doctorFinding {
+	e, err := readJournal(gitRoot)
+	if err != nil {
+		return []doctorFinding{{level: doctorWarn, check: "journal", message: err.Error(),
+			remedy: "git-sync ignores it, and removes it on its next sync"}}
+	}
+	if e == nil {
+		return nil
+	}
+	return []doctorFinding{{level: doctorWarn, check: "journal", message: fmt.Sprintf("a %s of %s was interrupted at %s", e.Op, e.Worktree, e.Time.Format(time.RFC3339)),
+		remedy: "git-sync finishes or undoes it on its next sync"}}
+}
+
+// doctorCheckWorktrees compares the worktree directories under gitRoot with
+// the ones git knows about.  Worktrees which git knows about but which are
+// gone are pruned by fix.
+func doctorCheckWorktrees(ctx context.Context, gitRoot string, fix bool) []doctorFinding {
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "worktree", "list", "--porcelain")
+	if err != nil {
+		return []doctorFinding{{level: doctorFail, check: "worktrees", message: err.Error()}}
+	}
+	registered := map[string]bool{}
+	prunable := 0
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			registered[filepath.Base(strings.TrimPrefix(line, "worktree "))] = true
+		case strings.HasPrefix(line, "prunable"):
+			prunable++
+		}
+	}
+
+	var findings []doctorFinding
+	if prunable > 0 {
+		f := doctorFinding{level: doctorWarn, check: "worktrees", message: fmt.Sprintf("git has %d worktree(s) whose directories are gone", prunable),
+			remedy: "run 'git worktree prune' in --root, or 'git-sync doctor --fix'"}
+		if fix {
+			if _, err := runCommand(ctx, gitRoot, *flGitCmd, "worktree", "prune"); err != nil {
+				f.message += fmt.Sprintf(" (can't prune: %v)", err)
+			} else {
+				f.fixed = true
+			}
+		}
+		findings = append(findings, f)
+	}
+
+	entries, err := ioutil.ReadDir(gitRoot)
+	if err != nil {
+		return append(findings, doctorFinding{level: doctorFail, check: "worktrees", message: err.Error()})
+	}
+	count := 0
+	for _, e := range entries {
+		if !e.IsDir() || !isWorktreeName(e.Name()) {
+			continue
+		}
+		count++
+		if !registered[e.Name()] && !*flPublishWithoutGitdir {
+			findings = append(findings, doctorFinding{level: doctorWarn, check: "worktrees",
+				message: fmt.Sprintf("%s is not a worktree git knows about", filepath.Join(gitRoot, e.Name())),
+				remedy:  "if it is not published, it is left over from a failed sync and can be removed"})
+		}
+	}
+	return append(findings, doctorFinding{level: doctorInfo, check: "worktrees", message: fmt.Sprintf("%d worktree(s) under %s", count, gitRoot)})
+}
+
+// doctorCheckLocks looks for git lock files, which make git commands fail
+// until they are removed.  Ones older than doctorLockAge are removed by fix.
+func doctorCheckLocks(gitRoot string, fix bool, now time.Time) []doctorFinding {
+	var findings []doctorFinding
+	gitDir := filepath.Join(gitRoot, ".git")
+	err := filepath.Walk(gitDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == filepath.Join(gitDir, "objects") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		age := now.Sub(info.ModTime()).Truncate(time.Second)
+		if age < doctorLockAge {
+			findings = append(findings, doctorFinding{level: doctorInfo, check: "locks", message: fmt.Sprintf("%s is %v old, and may be in use", path, age)})
+			return nil
+		}
+		f := doctorFinding{level: doctorWarn, check: "locks", message: fmt.Sprintf("%s is %v old, and was probably left by a git process which died", path, age),
+			remedy: "remove it, or run 'git-sync doctor --fix'"}
+		if fix {
+			if err := os.Remove(path); err != nil {
+				f.message += fmt.Sprintf(" (can't remove: %v)", err)
+			} else {
+				f.fixed = true
+			}
+		}
+		findings = append(findings, f)
+		return nil
+	})
+	if err != nil {
+		findings = append(findings, doctorFinding{level: doctorFail, check: "locks", message: err.Error()})
+	}
+	if len(findings) == 0 {
+		findings = append(findings, doctorFinding{level: doctorOK, check: "locks", message: "no lock files"})
+	}
+	return findings
+}
+
+// doctorCheckDiskUsage reports how much space the root and the clone's
+// objects use.
+func doctorCheckDiskUsage(ctx context.Context, gitRoot string) []doctorFinding {
+	var total int64
+	err := filepath.Walk(gitRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return []doctorFinding{{level: doctorWarn, check: "disk", message: fmt.Sprintf("can't measure %s: %v", gitRoot, err)}}
+	}
+	msg := fmt.Sprintf("%s uses %s", gitRoot, humanBytes(total))
+	if stats, err := countObjects(ctx, gitRoot); err == nil {
+		msg += fmt.Sprintf(", of which %d objects use %s", stats.objects, humanBytes(stats.bytes))
+	}
+	return []doctorFinding{{level: doctorInfo, check: "disk", message: msg}}
+}
+
+// humanBytes formats n bytes with a binary unit.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// writeDoctorFindings writes findings to w, one per line, with any remedy
+// below.
+func writeDoctorFindings(w io.Writer, findings []doctorFinding) {
+	for _, f := range findings {
+		level := f.level
+		if f.fixed {
+			level = "fixed"
+		}
+		fmt.Fprintf(w, "%-7s %-10s %s\n", "["+level+"]", f.check, f.message)
+		if f.remedy != "" && !f.fixed {
+			fmt.Fprintf(w, "%-18s -> %s\n", "", f.remedy)
+		}
+	}
+}
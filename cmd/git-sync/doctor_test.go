@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRunDoctor(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+
+	tmp := t.TempDir()
+	upstream := filepath.Join(tmp, "upstream")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(tmp, "init", "-q", "-b", "main", upstream)
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "one")
+	hash := git(upstream, "rev-parse", "HEAD")
+
+	root := filepath.Join(tmp, "root")
+	git(tmp, "clone", "-q", upstream, root)
+	git(root, "worktree", "add", "-q", filepath.Join(root, hash), hash)
+	if err := os.Symlink(hash, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	levels := func(findings []doctorFinding) map[string]string {
+		m := map[string]string{}
+		for _, f := range findings {
+			if f.level != doctorInfo {
+				m[f.check] = f.level
+				if f.fixed {
+					m[f.check] = "fixed"
+				}
+			}
+		}
+		return m
+	}
+
+	// A healthy root.
+	got := levels(runDoctor(ctx, root, "link", false, now))
+	for _, check := range []string{"root", "repo", "link", "locks"} {
+		if got[check] != doctorOK {
+			t.Errorf("healthy: expected %s to be ok, got %q", check, got[check])
+		}
+	}
+	if _, found := got["worktrees"]; found {
+		t.Errorf("healthy: expected no worktree problems, got %q", got["worktrees"])
+	}
+	if _, found := got["journal"]; found {
+		t.Errorf("healthy: expected no journal, got %q", got["journal"])
+	}
+
+	// Break it: a stale lock, a worktree which is gone, a broken link, and
+	// an interrupted checkout.
+	lock := filepath.Join(root, ".git", "index.lock")
+	if err := ioutil.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := now.Add(-time.Hour)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(root, hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := beginJournal(root, journalEntry{Op: journalCheckout, Worktree: hash, Time: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	got = levels(runDoctor(ctx, root, "link", false, now))
+	expect := map[string]string{"root": doctorOK, "repo": doctorOK, "link": doctorFail, "locks": doctorWarn, "worktrees": doctorWarn, "journal": doctorWarn}
+	for check, level := range expect {
+		if got[check] != level {
+			t.Errorf("broken: expected %s to be %s, got %q", check, level, got[check])
+		}
+	}
+
+	// Fix what can be fixed.
+	got = levels(runDoctor(ctx, root, "link", true, now))
+	expect = map[string]string{"link": doctorFail, "locks": "fixed", "worktrees": "fixed"}
+	for check, level := range expect {
+		if got[check] != level {
+			t.Errorf("fix: expected %s to be %s, got %q", check, level, got[check])
+		}
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be removed, got %v", err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	writeDoctorFindings(out, runDoctor(ctx, root, "link", false, now))
+	if !strings.Contains(out.String(), "[fail]  link") || !strings.Contains(out.String(), "-> remove it") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	testCases := []struct {
+		in     int64
+		expect string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+	for _, tc := range testCases {
+		if got := humanBytes(tc.in); got != tc.expect {
+			t.Errorf("%d: expected %q, got %q", tc.in, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// How a sync would get the upstream hash, for --dry-run.
+const (
+	// dryRunClone means there is no clone under --root yet.
+	dryRunClone = "clone"
+	// dryRunFetch means the hash is not in the clone, so would be fetched.
+	dryRunFetch = "fetch"
+	// dryRunNone means the hash is already in the clone.
+	dryRunNone = "none"
+)
+
+// dryRunPlan is what the next sync would do, as reported by --dry-run.
+type dryRunPlan struct {
+	// Repo is the repo URL, with any password redacted.
+	Repo string `json:"repo"`
+	// Ref is the upstream ref which was resolved, or "" if --rev is a hash.
+	Ref string `json:"ref,omitempty"`
+	// Current is the published hash, if any.
+	Current string `json:"current,omitempty"`
+	// Upstream is the hash which would be published.
+	Upstream  string `json:"upstream"`
+	WouldSync bool   `json:"wouldSync"`
+	// Fetch is one of dryRunClone, dryRunFetch, or dryRunNone.
+	Fetch string `json:"fetch"`
+	Depth int    `json:"depth,omitempty"`
+}
+
+// planSync works out what the next sync of repo into gitRoot would do,
+// without changing anything under gitRoot: only the upstream is asked, with
+// ls-remote, which checks that the repo can be reached and authenticated to,
+// and that the ref exists.
+func planSync(ctx context.Context, repo, branch, rev string, depth int, gitRoot, dest string) (dryRunPlan, error) {
+	plan := dryRunPlan{Repo: secrets.redact(repo), Depth: depth}
+
+	if isHashName(rev) {
+		plan.Upstream = rev
+	} else {
+		plan.Ref = refForRev(branch, rev)
+		err := runStage(ctx, stageLsRemote, *flLsRemoteTimeout, func(ctx context.Context) error {
+			output, err := runCommand(ctx, "", *flGitCmd, "ls-remote", "-q", repo, plan.Ref)
+			plan.Upstream = parseLsRemote(output, plan.Ref)
+			return err
+		})
+		if err != nil {
+			return plan, err
+		}
+		if plan.Upstream == "" {
+			return plan, fmt.Errorf("%w: %s", errRefMissing, plan.Ref)
+		}
+	}
+
+	if current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest)); err == nil {
+		plan.Current = worktreeHash(current)
+	} else if !os.IsNotExist(err) {
+		return plan, err
+	}
+	plan.WouldSync = plan.Current != plan.Upstream
+
+	switch _, err := os.Stat(filepath.Join(gitRoot, ".git")); {
+	case os.IsNotExist(err):
+		plan.Fetch = dryRunClone
+	case err != nil:
+		return plan, err
+	default:
+		plan.Fetch = dryRunFetch
+		if _, err := runCommand(ctx, gitRoot, *flGitCmd, "cat-file", "-e", plan.Upstream+"^{commit}"); err == nil {
+			plan.Fetch = dryRunNone
+		}
+	}
+	return plan, nil
+}
+
+// writeDryRunPlan writes plan to w as JSON.
+func writeDryRunPlan(w io.Writer, plan dryRunPlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
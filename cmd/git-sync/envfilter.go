@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envAlwaysPassed are the environment variables which are passed to
+// subprocesses even when they are not in an allowlist: git and most hooks
+// can't run without them, and git-sync sets the GIT_* ones itself to
+// configure git.
+var envAlwaysPassed = []string{"PATH", "HOME", "GIT_SSH_COMMAND", "GIT_CONFIG_GLOBAL", "GIT_CONFIG_PARAMETERS"}
+
+// envFilter selects which of git-sync's environment variables are passed to
+// a subprocess.  Both lists hold names or globs, e.g. "AWS_*".
+type envFilter struct {
+	// If not empty, only variables which match one of these (or are in
+	// envAlwaysPassed) are passed.
+	allow []string
+	// Variables which match one of these are not passed, unless they are in
+	// envAlwaysPassed.
+	block []string
+}
+
+// gitEnv and hookEnv are set from --git-env and --git-env-blocklist, and
+// --hook-env and --hook-env-blocklist.
+var gitEnv, hookEnv envFilter
+
+// validateEnvPatterns returns an error if any of patterns is not a valid glob.
+func validateEnvPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func matchesEnvPattern(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// passes returns true if the named variable is passed by f.
+func (f envFilter) passes(name string) bool {
+	for _, n := range envAlwaysPassed {
+		if n == name {
+			return true
+		}
+	}
+	if len(f.allow) > 0 && !matchesEnvPattern(name, f.allow) {
+		return false
+	}
+	return !matchesEnvPattern(name, f.block)
+}
+
+// environ returns git-sync's environment, as filtered by f, followed by
+// extra, which is not filtered.  This returns nil, which means the whole
+// environment, if there is nothing to filter or add.
+func (f envFilter) environ(extra []string) []string {
+	if len(f.allow) == 0 && len(f.block) == 0 {
+		if len(extra) == 0 {
+			return nil
+		}
+		return append(os.Environ(), extra...)
+	}
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if f.passes(name) {
+			env = append(env, kv)
+		}
+	}
+	return append(env, extra...)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEnvFilterPasses(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter envFilter
+		exp    map[string]bool
+	}{{
+		name:   "no filter",
+		filter: envFilter{},
+		exp:    map[string]bool{"AWS_SECRET": true, "PATH": true, "FOO": true},
+	}, {
+		name:   "allow",
+		filter: envFilter{allow: []string{"FOO", "APP_*"}},
+		exp:    map[string]bool{"AWS_SECRET": false, "PATH": true, "FOO": true, "APP_X": true, "FOO2": false},
+	}, {
+		name:   "block",
+		filter: envFilter{block: []string{"AWS_*", "PATH"}},
+		exp:    map[string]bool{"AWS_SECRET": false, "PATH": true, "FOO": true},
+	}, {
+		name:   "both",
+		filter: envFilter{allow: []string{"APP_*"}, block: []string{"APP_TOKEN"}},
+		exp:    map[string]bool{"APP_X": true, "APP_TOKEN": false, "GIT_SSH_COMMAND": true, "FOO": false},
+	}}
+	for _, tc := range cases {
+		for name, exp := range tc.exp {
+			if got := tc.filter.passes(name); got != exp {
+				t.Errorf("%s: %s: expected %v, got %v", tc.name, name, exp, got)
+			}
+		}
+	}
+}
+
+func TestEnvFilterEnviron(t *testing.T) {
+	defer os.Setenv("GIT_SYNC_TEST_SECRET", os.Getenv("GIT_SYNC_TEST_SECRET"))
+	os.Setenv("GIT_SYNC_TEST_SECRET", "x")
+	defer os.Setenv("GIT_SYNC_TEST_OK", os.Getenv("GIT_SYNC_TEST_OK"))
+	os.Setenv("GIT_SYNC_TEST_OK", "y")
+
+	if env := (envFilter{}).environ(nil); env != nil {
+		t.Errorf("expected nil with no filter, got %v", env)
+	}
+
+	env := envFilter{allow: []string{"GIT_SYNC_TEST_*"}, block: []string{"*SECRET"}}.environ([]string{"GIT_SYNC_HASH=abc"})
+	names := []string{}
+	for _, kv := range env {
+		switch kv {
+		case "GIT_SYNC_TEST_OK=y", "GIT_SYNC_HASH=abc":
+			names = append(names, kv)
+		case "GIT_SYNC_TEST_SECRET=x":
+			t.Errorf("blocked variable was passed")
+		}
+	}
+	sort.Strings(names)
+	if exp := []string{"GIT_SYNC_HASH=abc", "GIT_SYNC_TEST_OK=y"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("expected %v, got %v", exp, names)
+	}
+	if env[len(env)-1] != "GIT_SYNC_HASH=abc" {
+		t.Errorf("expected extra variables last, got %v", env)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sync lifecycle event types.
+const (
+	eventSyncStart = "sync-start"
+	eventFetched   = "fetched"
+	eventPublished = "published"
+	eventHookDone  = "hook-done"
+	eventError     = "error"
+	eventRejected  = "rejected"
+)
+
+// syncEvent is a notable step in the sync lifecycle.
+type syncEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Hash     string    `json:"hash,omitempty"`
+	Rollback bool      `json:"rollback,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// eventBroker fans events out to any number of subscribers.  Subscribers
+// which fall behind miss events rather than blocking the sync loop.
+type eventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[chan syncEvent]struct{}
+}
+
+var events = &eventBroker{
+	subscribers: map[chan syncEvent]struct{}{},
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind by before
+// events are dropped.
+const subscriberBuffer = 64
+
+func (b *eventBroker) subscribe() chan syncEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ch := make(chan syncEvent, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan syncEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *eventBroker) publish(ev syncEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.subscribers {
+		// Non-blocking write.
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// emitEvent publishes an event of the specified type.  The err may be nil.
+func emitEvent(typ, hash string, err error) {
+	events.publish(syncEvent{Type: typ, Hash: hash, Error: errorString(err)})
+}
+
+// serveEvents streams sync events as server-sent events.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Error(err, "can't encode event", "type", ev.Type)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
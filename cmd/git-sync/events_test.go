@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEventBroker(t *testing.T) {
+	t.Run("subscribers get events", func(t *testing.T) {
+		b := &eventBroker{subscribers: map[chan syncEvent]struct{}{}}
+		ch1 := b.subscribe()
+		ch2 := b.subscribe()
+
+		b.publish(syncEvent{Type: eventPublished, Hash: hash1})
+
+		for _, ch := range []chan syncEvent{ch1, ch2} {
+			ev := <-ch
+			if ev.Type != eventPublished || ev.Hash != hash1 {
+				t.Errorf("unexpected event: %+v", ev)
+			}
+			if ev.Time.IsZero() {
+				t.Errorf("expected event time to be set")
+			}
+		}
+	})
+
+	t.Run("unsubscribed channels get nothing", func(t *testing.T) {
+		b := &eventBroker{subscribers: map[chan syncEvent]struct{}{}}
+		ch := b.subscribe()
+		b.unsubscribe(ch)
+
+		b.publish(syncEvent{Type: eventError})
+
+		select {
+		case ev := <-ch:
+			t.Errorf("unexpected event: %+v", ev)
+		default:
+		}
+	})
+
+	t.Run("slow subscribers do not block", func(t *testing.T) {
+		b := &eventBroker{subscribers: map[chan syncEvent]struct{}{}}
+		ch := b.subscribe()
+
+		for i := 0; i < subscriberBuffer*2; i++ {
+			b.publish(syncEvent{Type: eventSyncStart})
+		}
+
+		if len(ch) != subscriberBuffer {
+			t.Errorf("expected %d buffered events, got %d", subscriberBuffer, len(ch))
+		}
+	})
+}
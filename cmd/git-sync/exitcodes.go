@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// Exit codes for a sync which fails for good (e.g. with --one-time), so that
+// Job controllers and scripts can tell failures apart.  These are part of
+// git-sync's interface; don't renumber them.
+const (
+	exitSyncError        = 1  // any failure not listed below
+	exitAuthFailure      = 10 // the upstream rejected the credentials
+	exitRefNotFound      = 11 // the branch, tag, or rev doesn't exist upstream
+	exitTimeout          = 12 // --timeout, or a stage's own timeout, expired
+	exitDiskFull         = 13 // no space was left on the device
+	exitHookFailure      = 14 // --sync-hook-command failed
+	exitFirstSyncTimeout = 15 // the first sync didn't succeed within --wait-for-first-sync
+)
+
+// refNotFoundPatterns are substrings of git error output which indicate that
+// the requested ref doesn't exist upstream.
+var refNotFoundPatterns = []string{
+	"not found in upstream origin",
+	"couldn't find remote ref",
+	"unknown revision",
+}
+
+// hookError is returned when --sync-hook-command fails.
+type hookError struct {
+	err error
+}
+
+func (e hookError) Error() string {
+	return "sync hook failed: " + e.err.Error()
+}
+
+func (e hookError) Unwrap() error {
+	return e.err
+}
+
+// exitCodeFor returns the exit code for a sync which failed with err.  If err
+// could be more than one class of failure (e.g. a hook which timed out), the
+// one which is most specific about what to fix wins.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return 0
+	}
+	msg := strings.ToLower(err.Error())
+	if errors.As(err, &hookError{}) {
+		return exitHookFailure
+	}
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, errInsufficientSpace) || strings.Contains(msg, "no space left on device") {
+		return exitDiskFull
+	}
+	if isAuthError(err) {
+		return exitAuthFailure
+	}
+	if errors.Is(err, errRefMissing) {
+		return exitRefNotFound
+	}
+	for _, pat := range refNotFoundPatterns {
+		if strings.Contains(msg, pat) {
+			return exitRefNotFound
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return exitTimeout
+	}
+	return exitSyncError
+}
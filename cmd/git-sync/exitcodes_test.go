@@ -0,0 +1,53 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		expect int
+	}{
+		{"nil", nil, 0},
+		{"generic", errors.New("something broke"), exitSyncError},
+		{"auth", fmt.Errorf("Run(git fetch): exit status 128: { stdout: \"\", stderr: \"fatal: Authentication failed for 'https://example.com/repo'\\n\" }"), exitAuthFailure},
+		{"missing branch", fmt.Errorf("Run(git clone): exit status 128: { stdout: \"\", stderr: \"fatal: Remote branch nope not found in upstream origin\\n\" }"), exitRefNotFound},
+		{"missing rev", fmt.Errorf("Run(git rev-parse nope): exit status 128: { stdout: \"nope\\n\", stderr: \"fatal: ambiguous argument 'nope': unknown revision or path not in the working tree.\" }"), exitRefNotFound},
+		{"ref missing policy", fmt.Errorf("%w: refs/heads/nope", errRefMissing), exitRefNotFound},
+		{"timeout", fmt.Errorf("fetch timed out after 1s: %w", context.DeadlineExceeded), exitTimeout},
+		{"disk full", &os.PathError{Op: "write", Path: "/git/x", Err: syscall.ENOSPC}, exitDiskFull},
+		{"git disk full", errors.New("fatal: write error: No space left on device"), exitDiskFull},
+		{"min free space", fmt.Errorf("%w under /git", errInsufficientSpace), exitDiskFull},
+		{"hook", hookError{errors.New("Run(hook): exit status 2")}, exitHookFailure},
+		{"hook timeout", fmt.Errorf("sync: %w", hookError{fmt.Errorf("sync hook timed out after 1s: %w", context.DeadlineExceeded)}), exitHookFailure},
+	}
+
+	for _, tc := range cases {
+		if got := exitCodeFor(tc.err); got != tc.expect {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	fetchObjects = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name: "git_sync_fetch_objects",
+		Help: "Summary of the number of objects received by each fetch or clone",
+	})
+
+	fetchBytes = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name: "git_sync_fetch_bytes",
+		Help: "Summary of the number of bytes of objects received by each fetch or clone",
+	})
+
+	fetchDeltaSeconds = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name: "git_sync_fetch_delta_seconds",
+		Help: "Summary of the time spent resolving deltas in the objects received by each fetch or clone",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(fetchObjects)
+	prometheus.MustRegister(fetchBytes)
+	prometheus.MustRegister(fetchDeltaSeconds)
+}
+
+// objectStats describes the objects in a repo's object store.
+type objectStats struct {
+	// The number of objects, loose and packed.
+	objects int64
+	// The on-disk size of those objects, in bytes.
+	bytes int64
+}
+
+// parseCountObjects parses the output of `git count-objects -v`.
+func parseCountObjects(output string) (objectStats, error) {
+	stats := objectStats{}
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		switch key {
+		case "count", "in-pack", "size", "size-pack":
+		default:
+			continue
+		}
+		val, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil {
+			return objectStats{}, fmt.Errorf("can't parse %q: %v", line, err)
+		}
+		switch key {
+		case "count", "in-pack":
+			stats.objects += val
+		case "size", "size-pack":
+			// git reports sizes in KiB.
+			stats.bytes += val * 1024
+		}
+	}
+	return stats, nil
+}
+
+// countObjects returns the object stats for the repo at gitRoot.
+func countObjects(ctx context.Context, gitRoot string) (objectStats, error) {
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "count-objects", "-v")
+	if err != nil {
+		return objectStats{}, err
+	}
+	return parseCountObjects(output)
+}
+
+// recordFetchStats logs and exports the difference between the object stats
+// before and after a fetch or clone.
+func recordFetchStats(ctx context.Context, before, after objectStats) {
+	objects := after.objects - before.objects
+	if objects < 0 {
+		objects = 0
+	}
+	bytes := after.bytes - before.bytes
+	if bytes < 0 {
+		bytes = 0
+	}
+	log.V(0).Info("fetched objects", "objects", objects, "bytes", bytes)
+	summaryFrom(ctx).addFetch(objects, bytes)
+	fetchObjects.Observe(float64(objects))
+	fetchBytes.Observe(float64(bytes))
+}
+
+// withProgress returns args, a git fetch or clone command, with --progress
+// after the subcommand.  git only traces its progress regions, which is how
+// the time spent resolving deltas is found, when it reports its progress.
+func withProgress(args []string) []string {
+	for i, arg := range args {
+		if arg == "fetch" || arg == "clone" {
+			out := append([]string{}, args[:i+1]...)
+			out = append(out, "--progress")
+			return append(out, args[i+1:]...)
+		}
+	}
+	return args
+}
+
+// deltaTimeFromTrace returns the time spent resolving deltas according to a
+// GIT_TRACE2_EVENT trace, which covers git and its children (e.g. index-pack).
+func deltaTimeFromTrace(trace string) (time.Duration, bool) {
+	var total float64
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(trace))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev struct {
+			Event    string  `json:"event"`
+			Category string  `json:"category"`
+			Label    string  `json:"label"`
+			TRel     float64 `json:"t_rel"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if ev.Event == "region_leave" && ev.Category == "progress" && ev.Label == "Resolving deltas" {
+			total += ev.TRel
+			found = true
+		}
+	}
+	return time.Duration(total * float64(time.Second)), found
+}
+
+// runReceiveCommand runs args, a git fetch or clone, with runRemoteCommand,
+// and records how long git spent resolving deltas in what it received.
+func runReceiveCommand(ctx context.Context, cwd string, args ...string) (string, error) {
+	f, err := ioutil.TempFile("", "git-sync-trace2-")
+	if err != nil {
+		log.V(2).Info("can't trace delta resolution", "error", err.Error())
+		return runRemoteCommand(ctx, cwd, args...)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	out, err := runRemoteCommandWithEnv(ctx, cwd, []string{"GIT_TRACE2_EVENT=" + f.Name()}, withProgress(args)...)
+	if err == nil {
+		trace, _ := ioutil.ReadFile(f.Name())
+		if d, ok := deltaTimeFromTrace(string(trace)); ok {
+			log.V(1).Info("resolved deltas", "duration", d.Round(time.Millisecond).String())
+			fetchDeltaSeconds.Observe(d.Seconds())
+		}
+	}
+	return out, err
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCountObjects(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		expect objectStats
+		fail   bool
+	}{{
+		name:   "empty",
+		input:  ``,
+		expect: objectStats{},
+	}, {
+		name:   "loose",
+		input:  "count: 3\nsize: 12\nin-pack: 0\npacks: 0\nsize-pack: 0\nprune-packable: 0\ngarbage: 0\nsize-garbage: 0\n",
+		expect: objectStats{objects: 3, bytes: 12 * 1024},
+	}, {
+		name:   "loose-and-packed",
+		input:  "count: 3\nsize: 12\nin-pack: 100\npacks: 2\nsize-pack: 40\nprune-packable: 0\ngarbage: 1\nsize-garbage: 8\n",
+		expect: objectStats{objects: 103, bytes: 52 * 1024},
+	}, {
+		name:  "bad-value",
+		input: "count: three\n",
+		fail:  true,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats, err := parseCountObjects(tc.input)
+			if err != nil && !tc.fail {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tc.fail {
+				t.Errorf("unexpected success")
+			}
+			if stats != tc.expect {
+				t.Errorf("bad result: expected %+v, got %+v", tc.expect, stats)
+			}
+		})
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"fetch", "-f", "origin", "main"}, []string{"fetch", "--progress", "-f", "origin", "main"}},
+		{[]string{"-c", "fetch.negotiationAlgorithm=skipping", "fetch", "origin"}, []string{"-c", "fetch.negotiationAlgorithm=skipping", "fetch", "--progress", "origin"}},
+		{[]string{"clone", "--no-checkout", "repo", "dir"}, []string{"clone", "--progress", "--no-checkout", "repo", "dir"}},
+		{[]string{"ls-remote", "origin"}, []string{"ls-remote", "origin"}},
+	}
+	for _, tc := range cases {
+		if got := withProgress(tc.args); !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%v: expected %v, got %v", tc.args, tc.expect, got)
+		}
+	}
+}
+
+func TestDeltaTimeFromTrace(t *testing.T) {
+	trace := `{"event":"region_enter","sid":"a/b","category":"progress","label":"Receiving objects"}
+{"event":"region_leave","sid":"a/b","t_rel":0.012144,"category":"progress","label":"Receiving objects"}
+{"event":"region_enter","sid":"a/b","category":"progress","label":"Resolving deltas"}
+{"event":"data","sid":"a/b","t_rel":0.002563,"category":"progress","key":"total_objects","value":"160"}
+{"event":"region_leave","sid":"a/b","t_rel":0.25,"category":"progress","label":"Resolving deltas"}
+not json
+{"event":"region_leave","sid":"a/c","t_rel":0.5,"category":"progress","label":"Resolving deltas"}
+`
+	d, ok := deltaTimeFromTrace(trace)
+	if !ok || d != 750*time.Millisecond {
+		t.Errorf("expected 750ms, got %v (%v)", d, ok)
+	}
+	if _, ok := deltaTimeFromTrace(`{"event":"region_leave","t_rel":1,"category":"progress","label":"Receiving objects"}`); ok {
+		t.Errorf("expected no delta time without deltas")
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// firstSyncWaiter ends git-sync if the first sync doesn't succeed within
+// --wait-for-first-sync, and creates --first-sync-file once it does.
+type firstSyncWaiter struct {
+	mutex    sync.Mutex
+	finished bool
+	timer    *time.Timer
+	file     string
+	// exit is called if the first sync takes too long; it is
+	// abortFirstSync outside of tests.
+	exit func(timeout time.Duration)
+}
+
+var firstSyncWait *firstSyncWaiter
+
+// newFirstSyncWaiter starts waiting for the first sync.  A timeout of 0
+// waits forever.
+func newFirstSyncWaiter(timeout time.Duration, file string, exit func(time.Duration)) *firstSyncWaiter {
+	f := &firstSyncWaiter{file: file, exit: exit}
+	if timeout > 0 {
+		f.timer = time.AfterFunc(timeout, func() {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			if !f.finished {
+				f.finished = true
+				f.exit(timeout)
+			}
+		})
+	}
+	return f
+}
+
+// done records that the first sync succeeded.  Only the first call does
+// anything.
+func (f *firstSyncWaiter) done(now time.Time) {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.finished {
+		return
+	}
+	f.finished = true
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	if f.file != "" {
+		if err := writeFirstSyncFile(f.file, now); err != nil {
+			log.Error(err, "can't write first sync file", "path", f.file)
+		}
+	}
+}
+
+// writeFirstSyncFile atomically writes the time of the first sync to path.
+func writeFirstSyncFile(path string, now time.Time) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(tmp, now.UTC().Format(time.RFC3339))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// abortFirstSync ends git-sync because the first sync didn't succeed within
+// timeout.
+func abortFirstSync(timeout time.Duration) {
+	log.Error(fmt.Errorf("no successful sync after %v", timeout), "first sync did not complete in time, exiting", "exitCode", exitFirstSyncTimeout)
+	exportFinalMetrics()
+	os.Exit(exitFirstSyncTimeout)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFirstSyncWaiter(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	file := filepath.Join(t.TempDir(), "synced")
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	exited := make(chan time.Duration, 1)
+	exit := func(d time.Duration) { exited <- d }
+
+	f := newFirstSyncWaiter(time.Hour, file, exit)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("expected no file before the first sync, got %v", err)
+	}
+	f.done(now)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("expected the file after the first sync: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "2021-01-01T00:00:00Z" {
+		t.Errorf("unexpected file contents: %q", got)
+	}
+	// Later syncs leave it alone.
+	f.done(now.Add(time.Hour))
+	if data2, _ := ioutil.ReadFile(file); string(data2) != string(data) {
+		t.Errorf("expected the file not to change, got %q", data2)
+	}
+
+	f = newFirstSyncWaiter(10*time.Millisecond, "", exit)
+	select {
+	case d := <-exited:
+		if d != 10*time.Millisecond {
+			t.Errorf("unexpected timeout: %v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected git-sync to exit")
+	}
+	f.done(now)
+
+	// A nil waiter does nothing.
+	var none *firstSyncWaiter
+	none.done(now)
+}
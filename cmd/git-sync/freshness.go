@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var upstreamAgeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_upstream_commit_age_seconds",
+	Help: "How long ago the upstream commit was committed, by its committer date",
+})
+
+var upstreamStaleGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_upstream_stale",
+	Help: "Whether the upstream commit is older than --max-ref-age (1) or not (0)",
+})
+
+func init() {
+	prometheus.MustRegister(upstreamAgeGauge)
+	prometheus.MustRegister(upstreamStaleGauge)
+}
+
+// upstreamHash is the hash that the upstream rev resolved to on the most
+// recent sync.  This is protected by syncLock.
+var upstreamHash string
+
+// staleLock protects upstreamStale.
+var staleLock sync.Mutex
+
+// upstreamStale indicates that the upstream commit is older than
+// --max-ref-age.
+var upstreamStale = false
+
+func getUpstreamStale() bool {
+	staleLock.Lock()
+	defer staleLock.Unlock()
+	return upstreamStale
+}
+
+// setUpstreamStale records whether the upstream is stale, and returns the
+// previous value.
+func setUpstreamStale(stale bool) bool {
+	staleLock.Lock()
+	defer staleLock.Unlock()
+	was := upstreamStale
+	upstreamStale = stale
+	return was
+}
+
+// commitTime returns the committer date of hash in the clone at gitRoot.
+func commitTime(ctx context.Context, gitRoot, hash string) (time.Time, error) {
+	out, err := runCommand(ctx, gitRoot, *flGitCmd, "show", "-s", "--format=%ct", hash, "--")
+	if err != nil {
+		return time.Time{}, err
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't parse commit time %q: %v", out, err)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// checkUpstreamAge compares the committer date of hash against maxAge, and
+// updates the metrics and the staleness state.  Failures are logged, and
+// leave the previous state in place.
+func checkUpstreamAge(ctx context.Context, gitRoot, hash string, maxAge time.Duration, now time.Time) {
+	if hash == "" {
+		return
+	}
+	when, err := commitTime(ctx, gitRoot, hash)
+	if err != nil {
+		log.Error(err, "can't get upstream commit time", "hash", hash)
+		return
+	}
+	age := now.Sub(when)
+	upstreamAgeGauge.Set(age.Seconds())
+
+	stale := age > maxAge
+	if stale {
+		upstreamStaleGauge.Set(1)
+	} else {
+		upstreamStaleGauge.Set(0)
+	}
+	switch was := setUpstreamStale(stale); {
+	case stale && !was:
+		log.Error(fmt.Errorf("upstream commit is older than %v", maxAge), "upstream is stale",
+			"hash", hash, "committed", when.UTC().Format(time.RFC3339), "age", age.Round(time.Second).String())
+	case !stale && was:
+		log.V(0).Info("upstream is fresh again", "hash", hash, "age", age.Round(time.Second).String())
+	}
+}
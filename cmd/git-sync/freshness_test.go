@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestCheckUpstreamAge(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_COMMITTER_DATE=@1600000000 +0000")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "test")
+	hash := git("rev-parse", "HEAD")
+
+	ctx := context.Background()
+	committed := time.Unix(1600000000, 0)
+	if got, err := commitTime(ctx, dir, hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !got.Equal(committed) {
+		t.Fatalf("expected %v, got %v", committed, got)
+	}
+
+	setUpstreamStale(false)
+	checkUpstreamAge(ctx, dir, hash, time.Hour, committed.Add(30*time.Minute))
+	if getUpstreamStale() {
+		t.Errorf("expected a 30m old commit to be fresh")
+	}
+	checkUpstreamAge(ctx, dir, hash, time.Hour, committed.Add(2*time.Hour))
+	if !getUpstreamStale() {
+		t.Errorf("expected a 2h old commit to be stale")
+	}
+	// An unknown hash leaves the state alone.
+	checkUpstreamAge(ctx, dir, strings.Repeat("0", 40), time.Hour, committed)
+	if !getUpstreamStale() {
+		t.Errorf("expected the state to be unchanged")
+	}
+	setUpstreamStale(false)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fsckDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	Name: "git_sync_fsck_duration_seconds",
+	Help: "Summary of background integrity check durations",
+}, []string{"status"})
+
+var fsckCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_fsck_count_total",
+	Help: "How many background integrity checks have run, partitioned by state (success, error)",
+}, []string{"status"})
+
+var fsckLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_fsck_last_success_timestamp_seconds",
+	Help: "When the last background integrity check passed, in seconds since the epoch",
+})
+
+func init() {
+	prometheus.MustRegister(fsckDuration)
+	prometheus.MustRegister(fsckCount)
+	prometheus.MustRegister(fsckLastSuccess)
+}
+
+// objectsBusy is held while something reads or removes objects in bulk:
+// an integrity check, which waits for it, or git gc, which is put off until
+// a later sync if it is taken.  A fetch only adds objects, so it doesn't
+// need it.
+var objectsBusy = make(chan struct{}, 1)
+
+// tryLockObjects takes objectsBusy, if it is free, and returns a func which
+// releases it, or nil.
+func tryLockObjects() func() {
+	select {
+	case objectsBusy <- struct{}{}:
+		return func() { <-objectsBusy }
+	default:
+		return nil
+	}
+}
+
+// rootClears counts how many times clearRoot has removed the clone, so that
+// a check which overlapped with that isn't reported as a failure.
+var rootClears int32
+
+// errFsckInterrupted is returned when the clone was removed while it was
+// being checked.
+var errFsckInterrupted = errors.New("the clone was removed during the check")
+
+// fsckRepo checks the integrity of the clone at gitRoot.  This doesn't hold
+// syncLock, since a check can take up to --fsck-timeout, so syncs carry on
+// alongside it: fetches only add objects, gc waits for the check, and a
+// re-clone makes the check inconclusive.
+func fsckRepo(ctx context.Context, gitRoot string) error {
+	if _, err := os.Stat(filepath.Join(gitRoot, ".git")); os.IsNotExist(err) {
+		// Not cloned yet.
+		return nil
+	}
+
+	objectsBusy <- struct{}{}
+	defer func() { <-objectsBusy }()
+	clears := atomic.LoadInt32(&rootClears)
+
+	ctx, cancel := context.WithTimeout(ctx, *flFsckTimeout)
+	defer cancel()
+	ctx, span := startSpan(ctx, "fsck")
+	_, err := runCommand(ctx, gitRoot, *flGitCmd, "fsck", "--no-progress", "--no-dangling")
+	span.finish(err)
+	if err != nil && atomic.LoadInt32(&rootClears) != clears {
+		return errFsckInterrupted
+	}
+	return err
+}
+
+// runFsck checks the clone every --fsck-interval, forever.  Failures are
+// reported, but do not stop syncing.
+func runFsck(gitRoot string) {
+	for {
+		time.Sleep(*flFsckInterval)
+
+		start := time.Now()
+		err := fsckRepo(context.Background(), gitRoot)
+		if err == errFsckInterrupted {
+			log.V(0).Info("integrity check was inconclusive, will retry", "path", gitRoot, "reason", err.Error())
+			continue
+		}
+		if err != nil {
+			fsckDuration.WithLabelValues(metricKeyError).Observe(time.Since(start).Seconds())
+			fsckCount.WithLabelValues(metricKeyError).Inc()
+			log.Error(err, "integrity check failed", "path", gitRoot)
+			recordKubeEvent(kubeEventWarning, kubeReasonFsckFailed, "integrity check of %s failed: %v", gitRoot, err)
+			continue
+		}
+		fsckDuration.WithLabelValues(metricKeySuccess).Observe(time.Since(start).Seconds())
+		fsckCount.WithLabelValues(metricKeySuccess).Inc()
+		fsckLastSuccess.SetToCurrentTime()
+		log.V(1).Info("integrity check passed", "path", gitRoot, "duration", time.Since(start).String())
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFsckRepo(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(d time.Duration) { *flFsckTimeout = d }(*flFsckTimeout)
+	*flFsckTimeout = time.Minute
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := fsckRepo(ctx, dir); err != nil {
+		t.Errorf("expected no error before the clone exists, got %v", err)
+	}
+
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "file")
+	git("commit", "-q", "-m", "test")
+	// A check doesn't wait for syncs.
+	syncLock.Lock()
+	err := fsckRepo(ctx, dir)
+	syncLock.Unlock()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Corrupt the blob.
+	blob := git("rev-parse", "HEAD:file")
+	path := filepath.Join(dir, ".git", "objects", blob[:2], blob[2:])
+	os.Chmod(path, 0644)
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsckRepo(ctx, dir); err == nil || err == errFsckInterrupted {
+		t.Errorf("expected an error for a corrupted object, got %v", err)
+	}
+}
+
+func TestGCRepoWaitsForFsck(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(cmd string, last time.Time) { *flGitCmd, lastGC = cmd, last }(*flGitCmd, lastGC)
+	*flGitCmd = "/does/not/exist"
+	lastGC = time.Time{}
+
+	unlock := tryLockObjects()
+	if unlock == nil {
+		t.Fatalf("expected objectsBusy to be free")
+	}
+	if err := gcRepo(context.Background(), t.TempDir()); err != nil {
+		t.Errorf("expected gc to be put off, got %v", err)
+	}
+	if !lastGC.IsZero() {
+		t.Errorf("expected gc to stay due")
+	}
+	unlock()
+	if err := gcRepo(context.Background(), t.TempDir()); err == nil {
+		t.Errorf("expected gc to run, and fail")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Commands which run git gc at a lower priority.
+const (
+	niceCmd   = "nice"
+	ioniceCmd = "ionice"
+)
+
+// lastGC is when git gc last succeeded, for --gc-interval.  It is only
+// accessed under syncLock.
+var lastGC time.Time
+
+// gcDue returns true if git gc should run now, i.e. --gc-interval has passed
+// since it last succeeded.
+func gcDue(now time.Time) bool {
+	return lastGC.IsZero() || now.Sub(lastGC) >= *flGCInterval
+}
+
+// gcCommand returns the command and args which run git gc, wrapped in nice
+// and ionice if --gc-nice or --gc-io-idle are set.
+func gcCommand() (string, []string) {
+	args := []string{}
+	if *flGCThreads != 0 {
+		// git sizes its thread pool by the CPUs on the node, not the
+		// container's CPU limit.
+		args = append(args, "-c", "pack.threads="+strconv.Itoa(*flGCThreads))
+	}
+	args = append(args, "gc", "--prune=all")
+	command := *flGitCmd
+	if *flGCIOIdle {
+		args = append([]string{"-c", "3", command}, args...)
+		command = ioniceCmd
+	}
+	if *flGCNice != 0 {
+		args = append([]string{"-n", strconv.Itoa(*flGCNice), command}, args...)
+		command = niceCmd
+	}
+	return command, args
+}
+
+// gcRepo runs git gc on the clone at gitRoot, unless it ran less than
+// --gc-interval ago, or an integrity check is running, since gc removes the
+// packs which the check may be reading.
+func gcRepo(ctx context.Context, gitRoot string) error {
+	now := time.Now()
+	if !gcDue(now) {
+		log.V(3).Info("skipping git gc", "last", lastGC.Format(time.RFC3339), "interval", flGCInterval.String())
+		return nil
+	}
+	unlockObjects := tryLockObjects()
+	if unlockObjects == nil {
+		log.V(1).Info("putting off git gc until the integrity check is done")
+		return nil
+	}
+	defer unlockObjects()
+	command, args := gcCommand()
+	if _, err := runCommand(ctx, gitRoot, command, args...); err != nil {
+		return err
+	}
+	lastGC = now
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGCDue(t *testing.T) {
+	defer func(interval time.Duration, last time.Time) {
+		*flGCInterval = interval
+		lastGC = last
+	}(*flGCInterval, lastGC)
+
+	now := time.Now()
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		last     time.Time
+		expect   bool
+	}{
+		{"never run", time.Hour, time.Time{}, true},
+		{"no interval", 0, now, true},
+		{"too soon", time.Hour, now.Add(-time.Minute), false},
+		{"interval passed", time.Hour, now.Add(-time.Hour), true},
+	}
+	for _, tc := range testCases {
+		*flGCInterval = tc.interval
+		lastGC = tc.last
+		if got := gcDue(now); got != tc.expect {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+}
+
+func TestGCCommand(t *testing.T) {
+	defer func(nice int, ioIdle bool, threads int) {
+		*flGCNice = nice
+		*flGCIOIdle = ioIdle
+		*flGCThreads = threads
+	}(*flGCNice, *flGCIOIdle, *flGCThreads)
+
+	testCases := []struct {
+		name       string
+		nice       int
+		ioIdle     bool
+		threads    int
+		expectCmd  string
+		expectArgs []string
+	}{
+		{"default", 0, false, 0, *flGitCmd, []string{"gc", "--prune=all"}},
+		{"threads", 0, false, 2, *flGitCmd, []string{"-c", "pack.threads=2", "gc", "--prune=all"}},
+		{"nice", 10, false, 0, niceCmd, []string{"-n", "10", *flGitCmd, "gc", "--prune=all"}},
+		{"io idle", 0, true, 0, ioniceCmd, []string{"-c", "3", *flGitCmd, "gc", "--prune=all"}},
+		{"all", 19, true, 1, niceCmd, []string{"-n", "19", ioniceCmd, "-c", "3", *flGitCmd, "-c", "pack.threads=1", "gc", "--prune=all"}},
+	}
+	for _, tc := range testCases {
+		*flGCNice = tc.nice
+		*flGCIOIdle = tc.ioIdle
+		*flGCThreads = tc.threads
+		cmd, args := gcCommand()
+		if cmd != tc.expectCmd || !reflect.DeepEqual(args, tc.expectArgs) {
+			t.Errorf("%s: expected %s %q, got %s %q", tc.name, tc.expectCmd, tc.expectArgs, cmd, args)
+		}
+	}
+}
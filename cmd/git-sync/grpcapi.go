@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	apiv1 "k8s.io/git-sync/pkg/api/v1"
+)
+
+// serveGRPCEndpoint serves the GitSync gRPC service on ln until it fails.
+func serveGRPCEndpoint(ln net.Listener) error {
+	return newGRPCServer().Serve(ln)
+}
+
+// newGRPCServer returns a server for the GitSync gRPC service.  Like the HTTP
+// endpoint, it uses TLS if --http-tls-cert is set, and requires
+// --http-token-file's token if that is set.
+func newGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkGRPCToken(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkGRPCToken(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+		// No connection age limit: Watch streams are long-lived.
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: *flHTTPIdleTimeout}),
+	}
+	if *flHTTPTLSCert != "" {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: httpCert.getCertificate})))
+	}
+	srv := grpc.NewServer(opts...)
+	apiv1.RegisterGitSyncServer(srv, gitSyncServer{})
+	return srv
+}
+
+// checkGRPCToken returns an Unauthenticated error if --http-token-file is
+// set and the call doesn't carry its token as a bearer token.
+func checkGRPCToken(ctx context.Context) error {
+	token := apiToken.get()
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, got := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// gitSyncServer implements the GitSync service.
+type gitSyncServer struct {
+	apiv1.UnimplementedGitSyncServer
+}
+
+func (gitSyncServer) Sync(context.Context, *apiv1.SyncRequest) (*apiv1.SyncResponse, error) {
+	return &apiv1.SyncResponse{Triggered: triggerSync(triggerGRPC)}, nil
+}
+
+func (gitSyncServer) Status(context.Context, *apiv1.StatusRequest) (*apiv1.StatusResponse, error) {
+	report, _ := currentStatus()
+	resp := &apiv1.StatusResponse{
+		Repo:      report.Repo,
+		Branch:    report.Branch,
+		Rev:       report.Rev,
+		Hash:      report.Hash,
+		Fetched:   report.Fetched,
+		Ready:     report.Ready,
+		Paused:    string(report.Paused),
+		LastError: report.LastError,
+	}
+	if report.LastSync != nil {
+		resp.LastSync = report.LastSync.UTC().Format(time.RFC3339Nano)
+	}
+	if report.LastChange != nil {
+		resp.LastChange = report.LastChange.UTC().Format(time.RFC3339Nano)
+	}
+	return resp, nil
+}
+
+func (gitSyncServer) Pause(_ context.Context, req *apiv1.PauseRequest) (*apiv1.PauseResponse, error) {
+	if !*flHTTPAdmin {
+		return nil, status.Error(codes.PermissionDenied, "Pause requires --http-admin")
+	}
+	mode, err := parsePauseMode(req.Mode)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	pauses.set(mode)
+	return &apiv1.PauseResponse{Paused: string(pauses.mode(*flRoot))}, nil
+}
+
+func (gitSyncServer) Resume(context.Context, *apiv1.ResumeRequest) (*apiv1.ResumeResponse, error) {
+	if !*flHTTPAdmin {
+		return nil, status.Error(codes.PermissionDenied, "Resume requires --http-admin")
+	}
+	pauses.set(pauseNone)
+	mode := pauses.mode(*flRoot)
+	if mode == pauseNone {
+		triggerSync(triggerGRPC)
+	}
+	return &apiv1.ResumeResponse{Paused: string(mode)}, nil
+}
+
+// Watch streams sync events until the client goes away.
+func (gitSyncServer) Watch(_ *apiv1.WatchRequest, stream apiv1.GitSync_WatchServer) error {
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	// Send the headers now, so the client knows it is subscribed.
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-ch:
+			msg := &apiv1.Event{
+				Type:     ev.Type,
+				Time:     ev.Time.UTC().Format(time.RFC3339Nano),
+				Hash:     ev.Hash,
+				Rollback: ev.Rollback,
+				Error:    ev.Error,
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
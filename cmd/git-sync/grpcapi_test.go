@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	apiv1 "k8s.io/git-sync/pkg/api/v1"
+)
+
+// startGRPCServer serves the GitSync service on a local port, and returns a
+// client for it.
+func startGRPCServer(t *testing.T) apiv1.GitSyncClient {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newGRPCServer()
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return apiv1.NewGitSyncClient(conn)
+}
+
+func TestGRPCService(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(root, dest string, admin bool) {
+		*flRoot, *flDest, *flHTTPAdmin = root, dest, admin
+		pauses.set(pauseNone)
+	}(*flRoot, *flDest, *flHTTPAdmin)
+	*flRoot = t.TempDir()
+	*flDest = "link"
+	*flHTTPAdmin = false
+
+	client := startGRPCServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status, err := client.Status(ctx, &apiv1.StatusRequest{})
+	if err != nil {
+		t.Fatalf("Status: unexpected error: %v", err)
+	}
+	if status.Rev != *flRev || status.Hash != "" {
+		t.Errorf("Status: unexpected response: %v", status)
+	}
+
+	// Drain any trigger left over from other tests.
+	select {
+	case <-syncNow:
+	default:
+	}
+	if resp, err := client.Sync(ctx, &apiv1.SyncRequest{}); err != nil || !resp.Triggered {
+		t.Errorf("Sync: expected a trigger, got %v, %v", resp, err)
+	}
+	if resp, err := client.Sync(ctx, &apiv1.SyncRequest{}); err != nil || resp.Triggered {
+		t.Errorf("Sync: expected the trigger to be pending, got %v, %v", resp, err)
+	}
+	<-syncNow
+
+	if _, err := client.Pause(ctx, &apiv1.PauseRequest{Mode: "all"}); !hasGRPCCode(err, codes.PermissionDenied) {
+		t.Errorf("Pause: expected PermissionDenied without --http-admin, got %v", err)
+	}
+	*flHTTPAdmin = true
+	if _, err := client.Pause(ctx, &apiv1.PauseRequest{Mode: "sideways"}); !hasGRPCCode(err, codes.InvalidArgument) {
+		t.Errorf("Pause: expected InvalidArgument, got %v", err)
+	}
+	if resp, err := client.Pause(ctx, &apiv1.PauseRequest{Mode: "all"}); err != nil || resp.Paused != string(pauseAll) {
+		t.Errorf("Pause: unexpected response %v, %v", resp, err)
+	}
+	if resp, err := client.Resume(ctx, &apiv1.ResumeRequest{}); err != nil || resp.Paused != "" {
+		t.Errorf("Resume: unexpected response %v, %v", resp, err)
+	}
+	select {
+	case <-syncNow:
+	default:
+		t.Errorf("Resume: expected a sync to be requested")
+	}
+
+	stream, err := client.Watch(ctx, &apiv1.WatchRequest{})
+	if err != nil {
+		t.Fatalf("Watch: unexpected error: %v", err)
+	}
+	// The subscription is made before the headers are sent, so this is seen.
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Watch: unexpected error: %v", err)
+	}
+	emitEvent(eventPublished, "abc123", nil)
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Watch: unexpected error: %v", err)
+	}
+	if ev.Type != eventPublished || ev.Hash != "abc123" {
+		t.Errorf("Watch: unexpected event %v", ev)
+	}
+}
+
+func TestGRPCToken(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(token string) {
+		apiToken.mutex.Lock()
+		apiToken.token = token
+		apiToken.mutex.Unlock()
+	}(apiToken.get())
+	apiToken.mutex.Lock()
+	apiToken.token = "sekrit"
+	apiToken.mutex.Unlock()
+
+	client := startGRPCServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.Status(ctx, &apiv1.StatusRequest{}); !hasGRPCCode(err, codes.Unauthenticated) {
+		t.Errorf("expected Unauthenticated without the token, got %v", err)
+	}
+	stream, err := client.Watch(ctx, &apiv1.WatchRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if !hasGRPCCode(err, codes.Unauthenticated) {
+		t.Errorf("expected Unauthenticated for Watch without the token, got %v", err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer sekrit")
+	if _, err := client.Status(authed, &apiv1.StatusRequest{}); err != nil {
+		t.Errorf("unexpected error with the token: %v", err)
+	}
+}
+
+func hasGRPCCode(err error, code codes.Code) bool {
+	return err != nil && status.Code(err) == code
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var healthCheckCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_health_checks_total",
+	Help: "How many --health-exec-command runs have happened, partitioned by state (success, error)",
+}, []string{"status"})
+
+var healthCheckFailures = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_health_check_consecutive_failures",
+	Help: "How many --health-exec-command runs in a row have failed",
+})
+
+func init() {
+	prometheus.MustRegister(healthCheckCount)
+	prometheus.MustRegister(healthCheckFailures)
+}
+
+// healthChecker runs --health-exec-command against the published worktree,
+// and tracks whether it has failed too many times in a row.
+type healthChecker struct {
+	mutex    sync.Mutex
+	failures int
+}
+
+var health healthChecker
+
+// healthy returns false if the check has failed at least
+// --health-exec-failure-threshold times in a row.
+func (h *healthChecker) healthy() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.failures < *flHealthExecFailureThreshold
+}
+
+// record notes the result of a check, logging when the health changes.
+func (h *healthChecker) record(hash string, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if err == nil {
+		healthCheckCount.WithLabelValues(metricKeySuccess).Inc()
+		if h.failures >= *flHealthExecFailureThreshold {
+			log.V(0).Info("health check is passing again", "hash", hash)
+		}
+		h.failures = 0
+		healthCheckFailures.Set(0)
+		return
+	}
+
+	healthCheckCount.WithLabelValues(metricKeyError).Inc()
+	h.failures++
+	healthCheckFailures.Set(float64(h.failures))
+	if h.failures == *flHealthExecFailureThreshold {
+		log.Error(err, "health check failed too many times, marking not ready", "hash", hash, "failures", h.failures)
+		recordKubeEvent(kubeEventWarning, kubeReasonHealthCheckFailing, "%d consecutive health check failures on %s, last error: %v", h.failures, hash, err)
+	} else {
+		log.V(1).Info("health check failed", "hash", hash, "failures", h.failures, "error", err.Error())
+	}
+}
+
+// check runs the command once, in the worktree that the link under gitRoot
+// points to.  Nothing is run until something has been published.
+func (h *healthChecker) check(ctx context.Context, gitRoot, dest string) {
+	worktree, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) && !getRepoReady() {
+		return
+	}
+	hash := ""
+	if err == nil {
+		hash = worktreeHash(worktree)
+		ctx, cancel := context.WithTimeout(ctx, *flHealthExecTimeout)
+		defer cancel()
+		env := []string{"GIT_SYNC_HASH=" + hash}
+		err = runHook(ctx, "health-check", hash, worktree, env, *flHealthExecCommand)
+	}
+	h.record(hash, err)
+}
+
+// run checks every --health-exec-interval, forever.
+func (h *healthChecker) run(gitRoot, dest string) {
+	for {
+		h.check(context.Background(), gitRoot, dest)
+		time.Sleep(*flHealthExecInterval)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestHealthChecker(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	truePath, err1 := exec.LookPath("true")
+	falsePath, err2 := exec.LookPath("false")
+	if err1 != nil || err2 != nil {
+		t.Skip("true or false not found")
+	}
+	defer func(cmd string, threshold int, timeout time.Duration) {
+		*flHealthExecCommand, *flHealthExecFailureThreshold, *flHealthExecTimeout = cmd, threshold, timeout
+	}(*flHealthExecCommand, *flHealthExecFailureThreshold, *flHealthExecTimeout)
+	*flHealthExecFailureThreshold = 2
+	*flHealthExecTimeout = 10 * time.Second
+
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, hash1), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(hash1, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	h := &healthChecker{}
+	*flHealthExecCommand = falsePath
+	h.check(ctx, root, "link")
+	if !h.healthy() {
+		t.Errorf("expected one failure to be tolerated")
+	}
+	h.check(ctx, root, "link")
+	if h.healthy() {
+		t.Errorf("expected two failures to be unhealthy")
+	}
+	*flHealthExecCommand = truePath
+	h.check(ctx, root, "link")
+	if !h.healthy() {
+		t.Errorf("expected a success to be healthy again")
+	}
+
+	// A missing worktree, once something was published, is a failure.
+	setRepoReady()
+	os.Remove(filepath.Join(root, hash1))
+	h.check(ctx, root, "link")
+	h.check(ctx, root, "link")
+	if h.healthy() {
+		t.Errorf("expected a missing worktree to be unhealthy")
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerVCS("hg", hgBackend{})
+}
+
+// hgBackend syncs Mercurial repositories.  This is experimental.  The clone
+// in --root has no working copy, and worktrees are made with `hg share`, so
+// they share the clone's store the same way git worktrees do.
+type hgBackend struct{}
+
+// hg runs a Mercurial command, with user configuration which could change
+// its output disabled.
+func (hgBackend) hg(ctx context.Context, cwd string, args ...string) (string, error) {
+	args = append([]string{"--noninteractive"}, args...)
+	return runCommandWithEnv(ctx, cwd, []string{"HGPLAIN=1"}, *flHgCmd, args...)
+}
+
+// hgRevset returns the Mercurial revset for rev.  "HEAD" means the tip of
+// branch, the same as it does for git.
+func hgRevset(branch, rev string) string {
+	if rev == "HEAD" {
+		return fmt.Sprintf("max(branch(%q))", branch)
+	}
+	return rev
+}
+
+// hgRemoteRev returns the revision to look up upstream for rev.  Remote
+// lookups only accept symbolic names, not revsets.
+func hgRemoteRev(branch, rev string) string {
+	if rev == "HEAD" {
+		return branch
+	}
+	return rev
+}
+
+func (hgBackend) command() string {
+	return *flHgCmd
+}
+
+func (hgBackend) metaDir() string {
+	return ".hg"
+}
+
+func (h hgBackend) clone(ctx context.Context, repo, branch, rev string, depth int, gitRoot string) error {
+	args := []string{"clone", "--noupdate", "--branch", branch, repo, gitRoot}
+	log.V(0).Info("cloning repo", "origin", secrets.redact(repo), "path", gitRoot)
+
+	_, err := h.hg(ctx, "", args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "is not empty") {
+			// Maybe a previous run crashed?  Mercurial won't use this dir.
+			log.V(0).Info("hg root exists and is not empty (previous crash?), cleaning up", "path", gitRoot)
+			recordKubeEvent(kubeEventWarning, kubeReasonRepoReinitialized, "hg root %s exists and is not empty (previous crash?), re-cloning", gitRoot)
+			if err := os.RemoveAll(gitRoot); err != nil {
+				return err
+			}
+			if _, err := h.hg(ctx, "", args...); err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h hgBackend) localHash(ctx context.Context, branch, rev, gitRoot string) (string, error) {
+	output, err := h.hg(ctx, gitRoot, "log", "--rev", hgRevset(branch, rev), "--template", "{node}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func (h hgBackend) revs(ctx context.Context, gitRoot, target, branch, rev string) (string, string, error) {
+	// Ask hg what is checked out in the worktree.
+	local, err := h.hg(ctx, target, "log", "--rev", ".", "--template", "{node}")
+	if err != nil {
+		return "", "", err
+	}
+
+	// Shares point their default path at the clone, not upstream, so ask
+	// the clone.
+	output, err := h.hg(ctx, gitRoot, "identify", "--debug", "--id", "--rev", hgRemoteRev(branch, rev), "default")
+	if err != nil {
+		return "", "", err
+	}
+	// --debug (for the full hash) can log other lines first; the id is
+	// last.
+	remote := ""
+	if fields := strings.Fields(output); len(fields) > 0 {
+		remote = fields[len(fields)-1]
+	}
+	return strings.TrimSpace(local), remote, nil
+}
+
+func (h hgBackend) fetch(ctx context.Context, gitRoot, branch string, depth int) error {
+	_, err := h.hg(ctx, gitRoot, "pull", "--branch", branch)
+	return err
+}
+
+func (h hgBackend) hasCommit(ctx context.Context, gitRoot, hash string) error {
+	_, err := h.hg(ctx, gitRoot, "log", "--rev", hash, "--template", "{node}")
+	return err
+}
+
+func (h hgBackend) revIsHash(ctx context.Context, rev, gitRoot string) (bool, error) {
+	if rev == "HEAD" {
+		return false, nil
+	}
+	output, err := h.hg(ctx, gitRoot, "log", "--rev", rev, "--template", "{node}")
+	if err != nil {
+		return false, err
+	}
+	// As with git, a hash prefix resolves to the full hash.
+	return strings.HasPrefix(strings.TrimSpace(output), rev), nil
+}
+
+func (h hgBackend) checkout(ctx context.Context, gitRoot, branch, hash string, depth int, submoduleMode, worktreePath string) error {
+	// Clean up anything left behind by a previous failure.
+	if err := h.removeWorktree(ctx, gitRoot, worktreePath); err != nil {
+		return err
+	}
+
+	// --relative makes the share refer to the store with a relative path,
+	// so other containers can mount the volume at a different path.
+	log.V(0).Info("adding worktree", "path", worktreePath, "branch", branch)
+	if _, err := h.hg(ctx, "", "--config", "extensions.share=", "share", "--noupdate", "--relative", gitRoot, worktreePath); err != nil {
+		return err
+	}
+	if _, err := h.hg(ctx, worktreePath, "update", "--clean", "--rev", hash); err != nil {
+		return err
+	}
+	log.V(0).Info("updated worktree to hash", "path", worktreePath, "hash", hash)
+	return nil
+}
+
+func (hgBackend) removeWorktree(ctx context.Context, gitRoot, worktreePath string) error {
+	if err := os.RemoveAll(worktreePath); err != nil {
+		return fmt.Errorf("error removing directory: %v", err)
+	}
+	return nil
+}
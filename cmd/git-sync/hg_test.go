@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestHgBackend(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flHgCmd); err != nil {
+		t.Skipf("%s not found", *flHgCmd)
+	}
+
+	tmp := t.TempDir()
+	upstream := filepath.Join(tmp, "upstream")
+	hg := func(dir string, args ...string) string {
+		cmd := exec.Command(*flHgCmd, append([]string{"--config", "ui.username=test <test@example.com>"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "HGPLAIN=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("hg %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(content string) string {
+		if err := ioutil.WriteFile(filepath.Join(upstream, "file"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hg(upstream, "commit", "--addremove", "-m", content)
+		return hg(upstream, "log", "--rev", "tip", "--template", "{node}")
+	}
+	hg(tmp, "init", upstream)
+	first := commit("one")
+
+	h := hgBackend{}
+	ctx := context.Background()
+	root := filepath.Join(tmp, "root")
+	if err := h.clone(ctx, upstream, "default", "HEAD", 0, root); err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	if hash, err := h.localHash(ctx, "default", "HEAD", root); err != nil || hash != first {
+		t.Fatalf("expected local hash %s, got %q, %v", first, hash, err)
+	}
+	if err := h.hasCommit(ctx, root, first); err != nil {
+		t.Errorf("expected %s to be in the clone: %v", first, err)
+	}
+	if isHash, err := h.revIsHash(ctx, first[:12], root); err != nil || !isHash {
+		t.Errorf("expected a hash prefix to be a hash, got %v, %v", isHash, err)
+	}
+	if isHash, err := h.revIsHash(ctx, "HEAD", root); err != nil || isHash {
+		t.Errorf("expected HEAD not to be a hash, got %v, %v", isHash, err)
+	}
+
+	worktree := filepath.Join(root, first)
+	if err := h.checkout(ctx, root, "default", first, 0, "", worktree); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(worktree, "file")); err != nil || string(data) != "one" {
+		t.Errorf("expected the worktree to have the file, got %q, %v", data, err)
+	}
+
+	// The link is not directly under the root, so the clone can't be found
+	// from it.
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "sub", "link")
+	if err := os.Symlink(worktree, link); err != nil {
+		t.Fatal(err)
+	}
+	second := commit("two")
+	local, remote, err := h.revs(ctx, root, link, "default", "HEAD")
+	if err != nil {
+		t.Fatalf("revs: %v", err)
+	}
+	if local != first || remote != second {
+		t.Errorf("expected revs %s, %s, got %s, %s", first, second, local, remote)
+	}
+
+	if err := h.fetch(ctx, root, "default", 0); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if err := h.hasCommit(ctx, root, second); err != nil {
+		t.Errorf("expected %s to be in the clone after a fetch: %v", second, err)
+	}
+
+	if err := h.removeWorktree(ctx, root, worktree); err != nil {
+		t.Fatalf("removeWorktree: %v", err)
+	}
+	if _, err := os.Stat(worktree); !os.IsNotExist(err) {
+		t.Errorf("expected the worktree to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --sync-hook-policy.
+const (
+	// hookPolicyInline runs the hook as part of the sync, which waits for it.
+	hookPolicyInline = "inline"
+	// hookPolicyLatestWins runs hooks in the background.  While they are
+	// busy, only the newest hash waits; older ones are skipped.
+	hookPolicyLatestWins = "latest-wins"
+	// hookPolicyQueueAll runs hooks in the background, for every hash, in
+	// order.
+	hookPolicyQueueAll = "queue-all"
+	// hookPolicyRejectIfBusy runs hooks in the background, and skips any hash
+	// which arrives while they are busy.
+	hookPolicyRejectIfBusy = "reject-if-busy"
+)
+
+var hookQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_hook_queue_depth",
+	Help: "How many hashes are waiting for --sync-hook-command to run",
+})
+
+var hooksSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_hooks_skipped_total",
+	Help: "How many hashes --sync-hook-command was not run for, because of --sync-hook-policy",
+})
+
+func init() {
+	prometheus.MustRegister(hookQueueDepth)
+	prometheus.MustRegister(hooksSkipped)
+}
+
+// hookJob is one pending run of the sync hook.
+type hookJob struct {
+	worktreePath string
+	rollback     bool
+}
+
+// hookQueue runs sync hooks in the background, at most max at once, with
+// the delivery semantics of policy.
+type hookQueue struct {
+	mutex   sync.Mutex
+	policy  string
+	max     int
+	running int
+	pending []hookJob
+	// run is called for each job; it is runSyncHook outside of tests.
+	run func(job hookJob)
+}
+
+var hooks *hookQueue
+
+func newHookQueue(policy string, max int) *hookQueue {
+	q := &hookQueue{policy: policy, max: max}
+	q.run = func(job hookJob) {
+		if _, err := os.Stat(job.worktreePath); os.IsNotExist(err) {
+			// It was replaced and cleaned up while it waited.
+			log.V(0).Info("worktree is gone, skipping sync hook", "hash", worktreeHash(job.worktreePath))
+			hooksSkipped.Inc()
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*flSyncTimeout))
+		defer cancel()
+		if err := runSyncHook(ctx, job.worktreePath, job.rollback); err != nil {
+			log.Error(err, "sync hook failed", "hash", worktreeHash(job.worktreePath), "rollback", job.rollback)
+		}
+	}
+	return q
+}
+
+// submit starts job if there is room, or else handles it according to the
+// policy.
+func (q *hookQueue) submit(job hookJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.running < q.max {
+		q.running++
+		go q.work(job)
+		return
+	}
+	switch q.policy {
+	case hookPolicyQueueAll:
+		q.pending = append(q.pending, job)
+	case hookPolicyLatestWins:
+		if len(q.pending) > 0 {
+			log.V(1).Info("sync hook is busy, replacing pending hash", "old", worktreeHash(q.pending[0].worktreePath), "new", worktreeHash(job.worktreePath))
+			hooksSkipped.Inc()
+		}
+		q.pending = []hookJob{job}
+	case hookPolicyRejectIfBusy:
+		log.V(0).Info("sync hook is busy, skipping hash", "hash", worktreeHash(job.worktreePath))
+		hooksSkipped.Inc()
+	}
+	hookQueueDepth.Set(float64(len(q.pending)))
+}
+
+// work runs job, then any jobs which are pending, until there are none.
+func (q *hookQueue) work(job hookJob) {
+	for {
+		q.run(job)
+
+		q.mutex.Lock()
+		if len(q.pending) == 0 {
+			q.running--
+			q.mutex.Unlock()
+			return
+		}
+		job = q.pending[0]
+		q.pending = q.pending[1:]
+		hookQueueDepth.Set(float64(len(q.pending)))
+		q.mutex.Unlock()
+	}
+}
+
+// dispatchSyncHook runs the --sync-hook-command for worktreePath, either now
+// or in the background, according to --sync-hook-policy.  Errors are only
+// returned for hooks which run inline.
+func dispatchSyncHook(ctx context.Context, worktreePath string, rollback bool) error {
+	if *flSyncHookCommand == "" {
+		return nil
+	}
+	if hooks == nil {
+		return runSyncHook(ctx, worktreePath, rollback)
+	}
+	hooks.submit(hookJob{worktreePath: worktreePath, rollback: rollback})
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// blockingHooks returns a hookQueue whose jobs wait for release, and records
+// the order in which they ran.
+func blockingHooks(policy string, max int) (*hookQueue, chan struct{}, func() []string) {
+	var (
+		mutex sync.Mutex
+		ran   []string
+	)
+	release := make(chan struct{})
+	q := &hookQueue{policy: policy, max: max}
+	q.run = func(job hookJob) {
+		<-release
+		mutex.Lock()
+		ran = append(ran, job.worktreePath)
+		mutex.Unlock()
+	}
+	return q, release, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), ran...)
+	}
+}
+
+// drain releases jobs until the queue is idle.
+func drain(t *testing.T, q *hookQueue, release chan struct{}) {
+	deadline := time.After(5 * time.Second)
+	for {
+		q.mutex.Lock()
+		idle := q.running == 0
+		q.mutex.Unlock()
+		if idle {
+			return
+		}
+		select {
+		case release <- struct{}{}:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("hook queue did not drain")
+		}
+	}
+}
+
+func TestHookQueuePolicies(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	testCases := []struct {
+		policy string
+		max    int
+		expect []string
+	}{{
+		policy: hookPolicyQueueAll,
+		max:    1,
+		expect: []string{"a", "b", "c", "d"},
+	}, {
+		policy: hookPolicyLatestWins,
+		max:    1,
+		expect: []string{"a", "d"},
+	}, {
+		policy: hookPolicyRejectIfBusy,
+		max:    1,
+		expect: []string{"a"},
+	}, {
+		policy: hookPolicyRejectIfBusy,
+		max:    2,
+		expect: []string{"a", "b"},
+	}}
+
+	for _, tc := range testCases {
+		q, release, ran := blockingHooks(tc.policy, tc.max)
+		for _, name := range []string{"a", "b", "c", "d"} {
+			q.submit(hookJob{worktreePath: name})
+		}
+		drain(t, q, release)
+		got := ran()
+		if tc.max > 1 {
+			// Concurrent jobs finish in any order.
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%s/%d: expected %v, got %v", tc.policy, tc.max, tc.expect, got)
+		}
+	}
+}
+
+func TestHookQueueParallelism(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	q, release, _ := blockingHooks(hookPolicyQueueAll, 2)
+	for _, name := range []string{"a", "b", "c"} {
+		q.submit(hookJob{worktreePath: name})
+	}
+	q.mutex.Lock()
+	running, pending := q.running, len(q.pending)
+	q.mutex.Unlock()
+	if running != 2 || pending != 1 {
+		t.Errorf("expected 2 running and 1 pending, got %d and %d", running, pending)
+	}
+	drain(t, q, release)
+}
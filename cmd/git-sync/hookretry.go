@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for the "reason" label of git_sync_sync_hook_retries_total.
+const (
+	hookRetryFailed     = "failed"
+	hookRetryRetryLater = "retry-later"
+)
+
+var syncHookRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_sync_hook_retries_total",
+	Help: "How many times --sync-hook-command was run again for the same hash, partitioned by why (failed, retry-later)",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(syncHookRetries)
+}
+
+// hookExitCode returns the exit code of a hook which failed with err, or -1
+// if it didn't exit (e.g. it couldn't be started, or was killed).
+func hookExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// hookRetryPolicy says how a failed hook is run again.
+type hookRetryPolicy struct {
+	// retries is how many times a failed hook is run again.
+	retries int
+	// backoff is the wait before the first retry, doubled after each one.
+	backoff time.Duration
+	// retryCode is the exit code with which a hook asks to be run again
+	// later.  These retries don't count against retries, and go on until
+	// ctx ends.  -1 means none.
+	retryCode int
+	// permanentCode is the exit code with which a hook says its failure is
+	// permanent, so it is not run again.  -1 means none.
+	permanentCode int
+}
+
+// syncHookRetryPolicy returns the policy given by the --sync-hook-* flags.
+func syncHookRetryPolicy() hookRetryPolicy {
+	return hookRetryPolicy{
+		retries:       *flSyncHookMaxRetries,
+		backoff:       *flSyncHookBackoff,
+		retryCode:     *flSyncHookRetryExitCode,
+		permanentCode: *flSyncHookPermanentExitCode,
+	}
+}
+
+// run calls fn, which runs a hook, and runs it again according to p until
+// it succeeds, fails for good, or ctx ends.
+func (p hookRetryPolicy) run(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	backoff := p.backoff
+	failures := 0
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		code := hookExitCode(err)
+		reason := hookRetryFailed
+		switch {
+		case code != -1 && code == p.permanentCode:
+			log.V(0).Info("hook failed permanently, not retrying", "name", name, "exitCode", code)
+			return err
+		case code != -1 && code == p.retryCode:
+			reason = hookRetryRetryLater
+		case failures >= p.retries:
+			return err
+		default:
+			failures++
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		log.V(0).Info("hook failed, retrying", "name", name, "exitCode", code, "reason", reason, "backoff", backoff.String(), "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		syncHookRetries.WithLabelValues(reason).Inc()
+		backoff = nextBackoff(backoff, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestHookRetryPolicy(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	exit := func(code int) error {
+		if code == 0 {
+			return nil
+		}
+		return fmt.Errorf("Run(hook): %w", exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run())
+	}
+	if got := hookExitCode(exit(3)); got != 3 {
+		t.Errorf("expected exit code 3, got %d", got)
+	}
+	if got := hookExitCode(errors.New("not started")); got != -1 {
+		t.Errorf("expected exit code -1, got %d", got)
+	}
+
+	policy := hookRetryPolicy{retries: 2, backoff: time.Millisecond, retryCode: 75, permanentCode: 78}
+	cases := []struct {
+		name  string
+		codes []int
+		runs  int
+		ok    bool
+	}{
+		{name: "success", codes: []int{0}, runs: 1, ok: true},
+		{name: "recovers", codes: []int{1, 1, 0}, runs: 3, ok: true},
+		{name: "out of retries", codes: []int{1, 1, 1, 0}, runs: 3},
+		{name: "permanent", codes: []int{78, 0}, runs: 1},
+		{name: "retry later", codes: []int{75, 75, 75, 1, 0}, runs: 5, ok: true},
+	}
+	for _, tc := range cases {
+		runs := 0
+		err := policy.run(context.Background(), "test", func(ctx context.Context) error {
+			code := tc.codes[runs]
+			runs++
+			return exit(code)
+		})
+		if runs != tc.runs {
+			t.Errorf("%s: expected %d runs, got %d", tc.name, tc.runs, runs)
+		}
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+
+	// Retries end with the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := policy.run(ctx, "test", func(ctx context.Context) error { return exit(75) })
+	if hookExitCode(err) != 75 {
+		t.Errorf("expected the last failure, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hookOutputRetain is how many runs' worth of output files are kept in
+// --hook-output-dir.  Older ones are removed.
+const hookOutputRetain = 50
+
+// cappedWriter passes the first max bytes written to it on to w, and
+// silently drops the rest.  A max of 0 means no limit.
+type cappedWriter struct {
+	w         io.Writer
+	max       int
+	written   int
+	truncated bool
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.max > 0 {
+		room := c.max - c.written
+		if room < len(p) {
+			c.truncated = true
+			if room <= 0 {
+				return n, nil
+			}
+			p = p[:room]
+		}
+	}
+	written, err := c.w.Write(p)
+	c.written += written
+	if err != nil {
+		return written, err
+	}
+	return n, nil
+}
+
+// hookOutput captures one stream of a hook's output.
+type hookOutput struct {
+	buf bytes.Buffer
+	cappedWriter
+}
+
+// newHookOutput returns a hookOutput which keeps at most max bytes, and also
+// copies them to file, if it is not nil.
+func newHookOutput(max int, file *os.File) *hookOutput {
+	h := &hookOutput{}
+	h.cappedWriter = cappedWriter{w: &h.buf, max: max}
+	if file != nil {
+		h.cappedWriter.w = io.MultiWriter(&h.buf, file)
+	}
+	return h
+}
+
+func (h *hookOutput) String() string {
+	if h.truncated {
+		return h.buf.String() + "...(truncated)"
+	}
+	return h.buf.String()
+}
+
+// hookOutputFiles creates the files which capture one run of a hook, under
+// --hook-output-dir.  It returns nils if the dir is not set.
+func hookOutputFiles(name, hash string, now time.Time) (stdout, stderr *os.File, err error) {
+	if *flHookOutputDir == "" {
+		return nil, nil, nil
+	}
+	if err := os.MkdirAll(*flHookOutputDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	base := filepath.Join(*flHookOutputDir, fmt.Sprintf("%s-%s-%s", now.UTC().Format("20060102T150405.000000000Z"), name, hash))
+	if stdout, err = os.Create(base + ".stdout"); err != nil {
+		return nil, nil, err
+	}
+	if stderr, err = os.Create(base + ".stderr"); err != nil {
+		stdout.Close()
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+// pruneHookOutput removes all but the newest hookOutputRetain runs from dir.
+// Files are named with a timestamp prefix, so they sort by age.
+func pruneHookOutput(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	runs := []string{}
+	for _, e := range entries {
+		if name := e.Name(); strings.HasSuffix(name, ".stdout") {
+			runs = append(runs, strings.TrimSuffix(name, ".stdout"))
+		}
+	}
+	if len(runs) <= hookOutputRetain {
+		return nil
+	}
+	sort.Strings(runs)
+	for _, run := range runs[:len(runs)-hookOutputRetain] {
+		for _, ext := range []string{".stdout", ".stderr"} {
+			if err := os.Remove(filepath.Join(dir, run+ext)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runHook runs a user-provided hook command in cwd.  Unlike runCommand, the
+// hook gets its own process group, which is killed as a whole when ctx ends,
+// so children which the hook forked can't outlive it.  At most
+// --hook-max-output bytes of each of stdout and stderr are kept in memory,
+// and if --hook-output-dir is set, the same is written to per-run files.
+func runHook(ctx context.Context, name, hash, cwd string, env []string, command string) error {
+	cmdStr := cmdForLog(command)
+	log.V(5).Info("running hook", "name", name, "cwd", cwd, "cmd", cmdStr)
+
+	cmd := exec.Command(command)
+	cmd.Dir = cwd
+	cmd.Env = hookEnv.environ(env)
+	setProcessGroup(cmd)
+
+	outfile, errfile, err := hookOutputFiles(name, hash, time.Now())
+	if err != nil {
+		log.Error(err, "can't create hook output files", "dir", *flHookOutputDir)
+	} else if outfile != nil {
+		defer func() {
+			outfile.Close()
+			errfile.Close()
+			if err := pruneHookOutput(*flHookOutputDir); err != nil {
+				log.Error(err, "can't prune hook output", "dir", *flHookOutputDir)
+			}
+		}()
+	}
+	outbuf := newHookOutput(*flHookMaxOutput, outfile)
+	errbuf := newHookOutput(*flHookMaxOutput, errfile)
+	cmd.Stdout = outbuf
+	cmd.Stderr = errbuf
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		recordResourceUsage(name, time.Since(start), nil)
+		return fmt.Errorf("Run(%s): %w", cmdStr, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if kerr := killProcessGroup(cmd); kerr != nil {
+			log.Error(kerr, "can't kill hook", "name", name, "pid", cmd.Process.Pid)
+		}
+		<-done
+		err = ctx.Err()
+	}
+	recordResourceUsage(name, time.Since(start), cmd.ProcessState)
+	if err != nil {
+		return fmt.Errorf("Run(%s): %w: { stdout: %q, stderr: %q }", cmdStr, err, outbuf, errbuf)
+	}
+	log.V(6).Info("hook result", "name", name, "stdout", outbuf.String(), "stderr", errbuf.String())
+	return nil
+}
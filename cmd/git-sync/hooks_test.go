@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestCappedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &cappedWriter{w: &buf, max: 5}
+	for _, s := range []string{"abc", "def", "ghi"} {
+		if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if buf.String() != "abcde" || !w.truncated {
+		t.Errorf("expected truncated \"abcde\", got %q (truncated=%v)", buf.String(), w.truncated)
+	}
+
+	buf.Reset()
+	w = &cappedWriter{w: &buf}
+	w.Write([]byte("unlimited"))
+	if buf.String() != "unlimited" || w.truncated {
+		t.Errorf("expected \"unlimited\", got %q (truncated=%v)", buf.String(), w.truncated)
+	}
+}
+
+func TestPruneHookOutput(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < hookOutputRetain+3; i++ {
+		for _, ext := range []string{".stdout", ".stderr"} {
+			name := filepath.Join(dir, fmt.Sprintf("%04d-hook-%s%s", i, hash1, ext))
+			if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := pruneHookOutput(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2*hookOutputRetain {
+		t.Errorf("expected %d files, got %d", 2*hookOutputRetain, len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("0000-hook-%s.stdout", hash1))); !os.IsNotExist(err) {
+		t.Errorf("expected the oldest run to be removed")
+	}
+}
+
+func writeHook(t *testing.T, dir, script string) string {
+	path := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunHookOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(max int, dir string) {
+		*flHookMaxOutput, *flHookOutputDir = max, dir
+	}(*flHookMaxOutput, *flHookOutputDir)
+
+	dir := t.TempDir()
+	*flHookMaxOutput = 4
+	*flHookOutputDir = filepath.Join(dir, "out")
+	hook := writeHook(t, dir, "echo stdout-$GIT_SYNC_TEST\necho stderr >&2\nexit 1\n")
+
+	err := runHook(context.Background(), "sync-hook", hash1, dir, []string{"GIT_SYNC_TEST=x"}, hook)
+	if err == nil {
+		t.Fatal("expected the hook to fail")
+	}
+	if !strings.Contains(err.Error(), `stdout: "stdo...(truncated)"`) {
+		t.Errorf("expected truncated stdout in the error, got %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(*flHookOutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 output files, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if !strings.Contains(e.Name(), "-sync-hook-"+hash1+".") {
+			t.Errorf("unexpected output file name %q", e.Name())
+		}
+		data, err := ioutil.ReadFile(filepath.Join(*flHookOutputDir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{".stdout": "stdo", ".stderr": "stde"}[filepath.Ext(e.Name())]; string(data) != want {
+			t.Errorf("expected %s to hold %q, got %q", e.Name(), want, data)
+		}
+	}
+}
+
+func TestRunHookKillsChildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on Windows")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(dir string) { *flHookOutputDir = dir }(*flHookOutputDir)
+	*flHookOutputDir = ""
+
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "survived")
+	// The child would outlive a hook which was killed on its own.
+	hook := writeHook(t, dir, fmt.Sprintf("(sleep 1; touch %s) &\nsleep 10\n", marker))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := runHook(ctx, "sync-hook", hash1, dir, nil, hook)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("hook was not killed promptly: %v", d)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the hook's child to be killed")
+	}
+}
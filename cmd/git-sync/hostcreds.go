@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// entryList is a flag.Value like stringList, for flags whose values contain
+// commas.  Each value is one entry, but several entries may be given in one
+// value, separated by semicolons, which is how they are given in
+// environment variables and the config file.
+type entryList struct {
+	items []string
+	set   bool
+}
+
+func (l *entryList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.items, ";")
+}
+
+func (l *entryList) Set(value string) error {
+	if !l.set {
+		l.items = nil
+		l.set = true
+	}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			l.items = append(l.items, item)
+		}
+	}
+	return nil
+}
+
+// entryListFlag defines a repeatable flag, with a semicolon-separated
+// default.
+func entryListFlag(name, def, usage string) *entryList {
+	l := &entryList{}
+	l.Set(def)
+	l.set = false
+	flag.Var(l, name, usage)
+	return l
+}
+
+// hostCredential is one --credential entry: a username and password for a
+// host other than --repo's, e.g. one which serves submodules.
+type hostCredential struct {
+	// url is the protocol and host, without a path, since git doesn't send
+	// paths to credential helpers by default.
+	url          string
+	username     string
+	password     string
+	passwordFile string
+}
+
+// parseHostCredential parses a --credential entry, which is comma-separated
+// key=value fields: "host" (for https) or "url", "username", and one of
+// "password" or "password-file".
+func parseHostCredential(entry string) (hostCredential, error) {
+	c := hostCredential{}
+	for i, field := range strings.Split(entry, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return c, fmt.Errorf("field %d is not in key=value form", i+1)
+		}
+		switch kv[0] {
+		case "host":
+			c.url = "https://" + kv[1]
+		case "url":
+			u, err := url.Parse(kv[1])
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return c, fmt.Errorf("invalid url %q", kv[1])
+			}
+			c.url = u.Scheme + "://" + u.Host
+		case "username":
+			c.username = kv[1]
+		case "password":
+			c.password = kv[1]
+		case "password-file":
+			c.passwordFile = kv[1]
+		default:
+			return c, fmt.Errorf("unknown field %q", kv[0])
+		}
+	}
+	if c.url == "" || c.username == "" {
+		return c, fmt.Errorf("host or url, and username must be set")
+	}
+	if (c.password == "") == (c.passwordFile == "") {
+		return c, fmt.Errorf("exactly one of password and password-file must be set")
+	}
+	return c, nil
+}
+
+// hostCredentials are the parsed --credential entries.
+var hostCredentials []hostCredential
+
+// parseHostCredentials parses all of the --credential entries.
+func parseHostCredentials(entries []string) ([]hostCredential, error) {
+	creds := []hostCredential{}
+	for _, e := range entries {
+		c, err := parseHostCredential(e)
+		if err != nil {
+			// The entry may hold a password, so it is not repeated.
+			return nil, fmt.Errorf("entry %d: %v", len(creds)+1, err)
+		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
+
+// storeHostCredential stores c for git to use, reading its password file if
+// it has one.
+func storeHostCredential(ctx context.Context, c hostCredential) error {
+	password := c.password
+	if c.passwordFile != "" {
+		data, err := ioutil.ReadFile(c.passwordFile)
+		if err != nil {
+			return fmt.Errorf("can't read password file for %s: %w", c.url, err)
+		}
+		password = string(data)
+	}
+	return setupGitAuth(ctx, c.username, password, c.url)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHostCredential(t *testing.T) {
+	cases := []struct {
+		entry string
+		exp   hostCredential
+		err   bool
+	}{
+		{entry: "host=github.com,username=u,password-file=/f", exp: hostCredential{url: "https://github.com", username: "u", passwordFile: "/f"}},
+		{entry: "url=http://git.local:8080/org/repo, username=u, password=p", exp: hostCredential{url: "http://git.local:8080", username: "u", password: "p"}},
+		{entry: "username=u,password=p", err: true},
+		{entry: "host=github.com,password=p", err: true},
+		{entry: "host=github.com,username=u", err: true},
+		{entry: "host=github.com,username=u,password=p,password-file=/f", err: true},
+		{entry: "host=github.com,username=u,token=p", err: true},
+		{entry: "url=github.com,username=u,password=p", err: true},
+		{entry: "host=github.com,username=u,secret", err: true},
+	}
+	for _, tc := range cases {
+		c, err := parseHostCredential(tc.entry)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.entry, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.entry, err)
+		} else if c != tc.exp {
+			t.Errorf("%q: expected %+v, got %+v", tc.entry, tc.exp, c)
+		}
+	}
+}
+
+func TestParseHostCredentialsHidesEntries(t *testing.T) {
+	_, err := parseHostCredentials([]string{"host=a.com,username=u,password=ok", "host=b.com,password=hunter2"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "entry 2") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEntryList(t *testing.T) {
+	l := &entryList{}
+	l.Set("host=a,username=u,password=p; host=b,username=v,password=q")
+	l.set = false
+	if exp := []string{"host=a,username=u,password=p", "host=b,username=v,password=q"}; !reflect.DeepEqual(l.items, exp) {
+		t.Errorf("expected %q, got %q", exp, l.items)
+	}
+	// The first value which is set replaces the default.
+	l.Set("host=c,username=w,password=r")
+	l.Set("host=d,username=x,password=s")
+	if exp := []string{"host=c,username=w,password=r", "host=d,username=x,password=s"}; !reflect.DeepEqual(l.items, exp) {
+		t.Errorf("expected %q, got %q", exp, l.items)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// httpServer returns the server for git-sync's HTTP endpoint, with the
+// --http-*-timeout flags applied.
+func httpServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		ReadTimeout:  *flHTTPReadTimeout,
+		WriteTimeout: *flHTTPWriteTimeout,
+		IdleTimeout:  *flHTTPIdleTimeout,
+	}
+}
+
+// httpToken is the bearer token from --http-token-file, which every request
+// to git-sync's HTTP endpoint, except the health check, must carry.
+type httpToken struct {
+	mutex sync.Mutex
+	token string
+}
+
+// apiToken is the current --http-token-file token, if any.
+var apiToken httpToken
+
+// load reads the token from path.  Surrounding whitespace is ignored.
+func (t *httpToken) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("%s is empty", path)
+	}
+	secrets.register(token)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.token = token
+	return nil
+}
+
+// get returns the current token, or "" if there is none.
+func (t *httpToken) get() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.token
+}
+
+// requireToken wraps next so that requests without the token in an
+// "Authorization: Bearer" header are rejected.  The health check at "/" is
+// left open, for kubelet probes.  If there is no token, next is returned as
+// it is.
+func (t *httpToken) requireToken(next http.Handler) http.Handler {
+	if t.get() == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The health check is for probes, and SCM webhooks carry their own
+		// signatures instead.
+		if r.URL.Path != "/" && r.URL.Path != scmWebhookPath {
+			got := r.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+t.get())) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="git-sync"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize adds the token, if there is one, to req, which is for another
+// git-sync's HTTP endpoint (e.g. a peer's).
+func (t *httpToken) authorize(req *http.Request) {
+	if token := t.get(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// tlsCert is the certificate from --http-tls-cert and --http-tls-key.  It
+// can be replaced while the server runs, e.g. when cert-manager renews it.
+type tlsCert struct {
+	mutex sync.Mutex
+	cert  *tls.Certificate
+}
+
+// httpCert is the current certificate for git-sync's HTTP endpoint, if it
+// serves TLS.
+var httpCert tlsCert
+
+// load reads the certificate and its key.
+func (c *tlsCert) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// getCertificate is for tls.Config.GetCertificate.
+func (c *tlsCert) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cert == nil {
+		return nil, fmt.Errorf("no certificate is loaded")
+	}
+	return c.cert, nil
+}
+
+// reloadHTTPCert is a fileReloader handler for the certificate and key.
+func reloadHTTPCert(ctx context.Context) error {
+	return httpCert.load(*flHTTPTLSCert, *flHTTPTLSKey)
+}
+
+// reloadHTTPToken is a fileReloader handler for --http-token-file.
+func reloadHTTPToken(ctx context.Context) error {
+	return apiToken.load(*flHTTPTokenFile)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var none httpToken
+	rec := httptest.NewRecorder()
+	none.requireToken(ok).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("without a token: expected 200, got %d", rec.Code)
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var tok httpToken
+	if err := tok.load(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := tok.requireToken(ok)
+
+	cases := []struct {
+		path   string
+		auth   string
+		expect int
+	}{
+		{"/", "", http.StatusOK},
+		{scmWebhookPath, "", http.StatusOK},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/api/v1/status", "Bearer wrong", http.StatusUnauthorized},
+		{"/api/v1/status", "s3cret", http.StatusUnauthorized},
+		{"/api/v1/status", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.expect {
+			t.Errorf("%s with %q: expected %d, got %d", tc.path, tc.auth, tc.expect, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/status", nil)
+	tok.authorize(req)
+	if got := req.Header.Get("Authorization"); got != "Bearer s3cret" {
+		t.Errorf("expected the token to be added, got %q", got)
+	}
+}
+
+func TestHTTPTokenLoadEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var tok httpToken
+	if err := tok.load(path); err == nil {
+		t.Errorf("expected an error for an empty token file")
+	}
+}
+
+func TestTLSCertNotLoaded(t *testing.T) {
+	var c tlsCert
+	if _, err := c.getCertificate(nil); err == nil {
+		t.Errorf("expected an error with no certificate")
+	}
+	if err := c.load("/does/not/exist.crt", "/does/not/exist.key"); err == nil {
+		t.Errorf("expected an error for missing files")
+	}
+}
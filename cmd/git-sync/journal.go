@@ -0,0 +1,206 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rootJournalFile is the name of the journal under --root.  It only exists
+// while an operation is in progress.
+const rootJournalFile = ".git-sync.journal"
+
+// Journaled operations.
+const (
+	// journalCheckout is the creation of a new worktree.
+	journalCheckout = "checkout"
+	// journalPublish is the flip of the link to a new worktree, and the
+	// cleanup of the one it replaced.
+	journalPublish = "publish"
+)
+
+// Ways to recover from an interrupted operation.
+const (
+	journalRollBack    = "roll-back"
+	journalRollForward = "roll-forward"
+)
+
+var journalRecoveryCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_journal_recovery_count_total",
+	Help: "How many interrupted operations have been recovered from the journal, partitioned by action (roll-back, roll-forward)",
+}, []string{"action"})
+
+func init() {
+	prometheus.MustRegister(journalRecoveryCount)
+}
+
+// journalEntry is the operation which is in progress.  Worktrees are given
+// by their names under --root, which are their hashes unless
+// --worktree-names says otherwise.
+type journalEntry struct {
+	Op       string    `json:"op"`
+	Worktree string    `json:"worktree"`
+	Previous string    `json:"previous,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// beginJournal records that e is in progress.  The journal is replaced
+// atomically, so after a crash it holds either the old entry or the new one.
+func beginJournal(gitRoot string, e journalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(gitRoot, rootJournalFile)
+	tmp, err := ioutil.TempFile(gitRoot, rootJournalFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("can't write journal: %w", err)
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("can't write journal: %w", err)
+	}
+	syncDir(gitRoot)
+	return nil
+}
+
+// endJournal records that nothing is in progress.
+func endJournal(gitRoot string) {
+	err := os.Remove(filepath.Join(gitRoot, rootJournalFile))
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(err, "can't remove journal", "path", gitRoot)
+		return
+	}
+	syncDir(gitRoot)
+}
+
+// syncDir flushes dir, so that renames and removals in it survive a crash.
+// Not every platform can do this, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// readJournal returns the operation which was in progress, or nil if there
+// was none.
+func readJournal(gitRoot string) (*journalEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(gitRoot, rootJournalFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := &journalEntry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("can't parse journal: %w", err)
+	}
+	if !isWorktreeName(e.Worktree) || (e.Previous != "" && !isWorktreeName(e.Previous)) {
+		return nil, fmt.Errorf("journal names an invalid worktree")
+	}
+	return e, nil
+}
+
+// publishedWorktreeName returns the name of the worktree that the link at
+// dest points to, or "" if there is none.  This is its name under --root, to
+// compare with journal entries, not its hash (see worktreeHash).
+func publishedWorktreeName(gitRoot, dest string) (string, error) {
+	current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error accessing current worktree: %v", err)
+	}
+	return filepath.Base(current), nil
+}
+
+// recoveryAction decides how to finish e, given the name of the worktree
+// which is published now.  An interrupted checkout is undone.  An
+// interrupted publish is finished if the link was flipped, and undone if
+// not.
+func recoveryAction(e journalEntry, current string) string {
+	if e.Op == journalPublish && current == e.Worktree {
+		return journalRollForward
+	}
+	return journalRollBack
+}
+
+// recoverJournal finishes or undoes an operation which was interrupted by a
+// crash, so that the root is as if the operation had completed or had never
+// started.  It must be called under syncLock, with the root locked.
+func recoverJournal(ctx context.Context, gitRoot, dest string) error {
+	e, err := readJournal(gitRoot)
+	if err != nil {
+		// There is nothing to act on, and sanity checks during the sync
+		// will clean up whatever was left behind.
+		log.Error(err, "ignoring unreadable journal", "path", gitRoot)
+		endJournal(gitRoot)
+		return nil
+	}
+	if e == nil {
+		return nil
+	}
+	current, err := publishedWorktreeName(gitRoot, dest)
+	if err != nil {
+		return err
+	}
+
+	action := recoveryAction(*e, current)
+	log.V(0).Info("recovering interrupted operation", "op", e.Op, "worktree", e.Worktree, "since", e.Time.Format(time.RFC3339), "action", action)
+	switch {
+	case action == journalRollBack && e.Worktree != current:
+		if err := cleanupWorkTree(ctx, gitRoot, filepath.Join(gitRoot, e.Worktree)); err != nil {
+			return err
+		}
+	case action == journalRollForward && e.Previous != "" && e.Previous != current:
+		previous := filepath.Join(gitRoot, e.Previous)
+		if _, err := os.Stat(previous); err == nil {
+			if retainingWorktrees() {
+				err = retireWorktree(previous)
+			} else {
+				err = cleanupWorkTree(ctx, gitRoot, previous)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	journalRecoveryCount.WithLabelValues(action).Inc()
+	endJournal(gitRoot)
+	return nil
+}
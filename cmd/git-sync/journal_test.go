@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if e, err := readJournal(dir); e != nil || err != nil {
+		t.Fatalf("expected no journal, got %+v, %v", e, err)
+	}
+
+	want := journalEntry{Op: journalPublish, Worktree: hash2, Previous: hash1, Time: time.Unix(1600000000, 0).UTC()}
+	if err := beginJournal(dir, journalEntry{Op: journalCheckout, Worktree: hash2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := beginJournal(dir, want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := readJournal(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *got != want {
+		t.Errorf("expected %+v, got %+v", want, *got)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the journal, got %d files", len(entries))
+	}
+
+	endJournal(dir)
+	if e, err := readJournal(dir); e != nil || err != nil {
+		t.Errorf("expected no journal after it ended, got %+v, %v", e, err)
+	}
+}
+
+func TestReadJournalInvalid(t *testing.T) {
+	for _, data := range []string{
+		"garbage",
+		`{"op":"checkout","worktree":"../../etc"}`,
+		`{"op":"publish","worktree":"` + hash1 + `","previous":"link"}`,
+	} {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, rootJournalFile), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readJournal(dir); err == nil {
+			t.Errorf("%s: expected an error", data)
+		}
+	}
+}
+
+func TestRecoveryAction(t *testing.T) {
+	testCases := []struct {
+		name    string
+		entry   journalEntry
+		current string
+		want    string
+	}{
+		{"checkout", journalEntry{Op: journalCheckout, Worktree: hash2}, hash1, journalRollBack},
+		{"first checkout", journalEntry{Op: journalCheckout, Worktree: hash2}, "", journalRollBack},
+		{"publish not flipped", journalEntry{Op: journalPublish, Worktree: hash2, Previous: hash1}, hash1, journalRollBack},
+		{"publish flipped", journalEntry{Op: journalPublish, Worktree: hash2, Previous: hash1}, hash2, journalRollForward},
+		{"first publish flipped", journalEntry{Op: journalPublish, Worktree: hash2}, hash2, journalRollForward},
+	}
+	for _, tc := range testCases {
+		if got := recoveryAction(tc.entry, tc.current); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestRecoverJournal(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+
+	testCases := []struct {
+		name   string
+		entry  journalEntry
+		remain []string
+	}{
+		{"interrupted checkout", journalEntry{Op: journalCheckout, Worktree: hash1}, []string{hash2}},
+		{"interrupted checkout of the published worktree", journalEntry{Op: journalCheckout, Worktree: hash2}, []string{hash1, hash2}},
+		{"publish not flipped", journalEntry{Op: journalPublish, Worktree: hash1, Previous: hash2}, []string{hash2}},
+		{"publish flipped", journalEntry{Op: journalPublish, Worktree: hash2, Previous: hash1}, []string{hash2}},
+	}
+	for _, tc := range testCases {
+		dir := t.TempDir()
+		if out, err := exec.Command(*flGitCmd, "init", "-q", dir).CombinedOutput(); err != nil {
+			t.Fatalf("git init: %v: %s", err, out)
+		}
+		for _, name := range []string{hash1, hash2} {
+			if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(hash2, filepath.Join(dir, "link")); err != nil {
+			t.Fatal(err)
+		}
+		if err := beginJournal(dir, tc.entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := recoverJournal(context.Background(), dir, "link"); err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		for _, name := range []string{hash1, hash2} {
+			_, err := os.Stat(filepath.Join(dir, name))
+			want := false
+			for _, r := range tc.remain {
+				want = want || r == name
+			}
+			if (err == nil) != want {
+				t.Errorf("%s: expected %s to exist: %v, got %v", tc.name, name, want, err == nil)
+			}
+		}
+		if e, _ := readJournal(dir); e != nil {
+			t.Errorf("%s: expected the journal to be removed", tc.name)
+		}
+	}
+}
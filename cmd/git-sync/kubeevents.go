@@ -0,0 +1,284 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The in-cluster service account files, which are mounted into every pod
+// (unless disabled).
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubernetes Event types.
+const (
+	kubeEventNormal  = "Normal"
+	kubeEventWarning = "Warning"
+)
+
+// Kubernetes Event reasons.
+const (
+	kubeReasonFirstSync          = "FirstSyncSucceeded"
+	kubeReasonSyncFailing        = "SyncFailing"
+	kubeReasonRepoReinitialized  = "RepoReinitialized"
+	kubeReasonHashRejected       = "HashRejected"
+	kubeReasonHealthCheckFailing = "HealthCheckFailing"
+	kubeReasonFsckFailed         = "IntegrityCheckFailed"
+	kubeReasonHistoryRewritten   = "HistoryRewritten"
+	kubeReasonLeaderElected      = "LeaderElected"
+	kubeReasonTamperSuspected    = "TamperSuspected"
+	kubeReasonContentCorrupted   = "ContentCorrupted"
+)
+
+// kubeClient calls the Kubernetes API server from inside the pod in which
+// git-sync is running, with the pod's service account.
+type kubeClient struct {
+	apiURL    string
+	token     string
+	client    *http.Client
+	podName   string
+	namespace string
+}
+
+// kubeEventRecorder posts Kubernetes Events about the pod in which git-sync
+// is running, so they show up in `kubectl describe pod`.
+type kubeEventRecorder struct {
+	*kubeClient
+	podUID string
+}
+
+// kubeEvents is the process-wide recorder, or nil if --kube-events is not set.
+var kubeEvents *kubeEventRecorder
+
+// newInClusterClient builds a kubeClient from the in-cluster environment.
+// The pod is identified by the $POD_NAME and $POD_NAMESPACE env vars (e.g.
+// from the downward API), falling back to the hostname and the service
+// account's namespace respectively.
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: $KUBERNETES_SERVICE_HOST and $KUBERNETES_SERVICE_PORT must be set")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("can't read service account token: %w", err)
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("can't read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("can't parse service account CA")
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("can't determine namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	podName := os.Getenv("POD_NAME")
+	if podName == "" {
+		if podName, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("can't determine pod name: %w", err)
+		}
+	}
+
+	return &kubeClient{
+		apiURL: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		podName:   podName,
+		namespace: namespace,
+	}, nil
+}
+
+// newInClusterEventRecorder builds a kubeEventRecorder from the in-cluster
+// environment.  The pod's UID comes from the $POD_UID env var, falling back
+// to an API lookup.
+func newInClusterEventRecorder(ctx context.Context) (*kubeEventRecorder, error) {
+	c, err := newInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	r := &kubeEventRecorder{kubeClient: c, podUID: os.Getenv("POD_UID")}
+	if r.podUID == "" {
+		// Without the UID, `kubectl describe` won't show our events.
+		if r.podUID, err = r.lookupPodUID(ctx); err != nil {
+			return nil, fmt.Errorf("can't determine pod UID (set $POD_UID or allow 'get' on pods): %w", err)
+		}
+	}
+	return r, nil
+}
+
+// kubeStatusError is a response from the API server with a non-2xx status.
+type kubeStatusError struct {
+	status int
+	body   string
+}
+
+func (e *kubeStatusError) Error() string {
+	return fmt.Sprintf("API server returned status %d: %q", e.status, e.body)
+}
+
+func (c *kubeClient) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &kubeStatusError{status: resp.StatusCode, body: string(body)}
+	}
+	return body, nil
+}
+
+func (r *kubeEventRecorder) lookupPodUID(ctx context.Context) (string, error) {
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s", r.apiURL, r.namespace, r.podName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	body, err := r.do(req)
+	if err != nil {
+		return "", err
+	}
+	pod := struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(body, &pod); err != nil {
+		return "", err
+	}
+	return pod.Metadata.UID, nil
+}
+
+type kubeObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type kubeEvent struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		GenerateName string `json:"generateName"`
+		Namespace    string `json:"namespace"`
+	} `json:"metadata"`
+	InvolvedObject kubeObjectRef `json:"involvedObject"`
+	Reason         string        `json:"reason"`
+	Message        string        `json:"message"`
+	Type           string        `json:"type"`
+	Source         struct {
+		Component string `json:"component"`
+	} `json:"source"`
+	FirstTimestamp     time.Time `json:"firstTimestamp"`
+	LastTimestamp      time.Time `json:"lastTimestamp"`
+	Count              int       `json:"count"`
+	ReportingComponent string    `json:"reportingComponent"`
+	ReportingInstance  string    `json:"reportingInstance"`
+}
+
+func (r *kubeEventRecorder) newEvent(eventType, reason, message string) kubeEvent {
+	now := time.Now().UTC()
+	ev := kubeEvent{
+		APIVersion: "v1",
+		Kind:       "Event",
+		InvolvedObject: kubeObjectRef{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       r.podName,
+			Namespace:  r.namespace,
+			UID:        r.podUID,
+		},
+		Reason:             reason,
+		Message:            message,
+		Type:               eventType,
+		FirstTimestamp:     now,
+		LastTimestamp:      now,
+		Count:              1,
+		ReportingComponent: "git-sync",
+		ReportingInstance:  r.podName,
+	}
+	ev.Metadata.GenerateName = r.podName + "."
+	ev.Metadata.Namespace = r.namespace
+	ev.Source.Component = "git-sync"
+	return ev
+}
+
+// post creates an Event in the API server.
+func (r *kubeEventRecorder) post(ctx context.Context, ev kubeEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/events", r.apiURL, r.namespace)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = r.do(req)
+	return err
+}
+
+// kubeEventTimeout bounds how long posting an Event can delay the sync loop.
+const kubeEventTimeout = 5 * time.Second
+
+// recordKubeEvent posts an Event, if --kube-events is enabled.  This is
+// synchronous, so that events are not lost when git-sync exits right after.
+// Failures are logged, but otherwise ignored.
+func recordKubeEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	r := kubeEvents
+	if r == nil {
+		return
+	}
+	ev := r.newEvent(eventType, reason, fmt.Sprintf(messageFmt, args...))
+	ctx, cancel := context.WithTimeout(context.Background(), kubeEventTimeout)
+	defer cancel()
+	if err := r.post(ctx, ev); err != nil {
+		log.Error(err, "can't post Kubernetes event", "reason", ev.Reason)
+	}
+}
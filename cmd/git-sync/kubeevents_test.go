@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKubeEventPost(t *testing.T) {
+	var got kubeEvent
+	var gotPath, gotAuth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("can't decode event: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	r := &kubeEventRecorder{
+		kubeClient: &kubeClient{
+			apiURL:    srv.URL,
+			token:     "sekrit",
+			client:    srv.Client(),
+			podName:   "my-pod",
+			namespace: "my-ns",
+		},
+		podUID: "1234",
+	}
+	ev := r.newEvent(kubeEventWarning, kubeReasonSyncFailing, "it broke")
+	if err := r.post(context.Background(), ev); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotPath != "/api/v1/namespaces/my-ns/events" {
+		t.Errorf("unexpected path: %q", gotPath)
+	}
+	if gotAuth != "Bearer sekrit" {
+		t.Errorf("unexpected auth header: %q", gotAuth)
+	}
+	if got.InvolvedObject.Kind != "Pod" || got.InvolvedObject.Name != "my-pod" || got.InvolvedObject.UID != "1234" {
+		t.Errorf("unexpected involved object: %+v", got.InvolvedObject)
+	}
+	if got.Type != kubeEventWarning || got.Reason != kubeReasonSyncFailing || got.Message != "it broke" {
+		t.Errorf("unexpected event: %+v", got)
+	}
+	if got.Metadata.GenerateName != "my-pod." {
+		t.Errorf("unexpected generateName: %q", got.Metadata.GenerateName)
+	}
+}
+
+func TestKubeEventPostError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	r := &kubeEventRecorder{
+		kubeClient: &kubeClient{
+			apiURL:    srv.URL,
+			client:    srv.Client(),
+			podName:   "my-pod",
+			namespace: "my-ns",
+		},
+	}
+	if err := r.post(context.Background(), r.newEvent(kubeEventNormal, kubeReasonFirstSync, "ok")); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,393 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --leader-election.
+const (
+	leaderElectionFile  = "file"
+	leaderElectionLease = "lease"
+)
+
+// rootLeaderFile is the name of the file which is locked by the leader, under
+// --root, with --leader-election=file.
+const rootLeaderFile = ".git-sync.leader"
+
+// Values for the "role" field of /api/v1/status.
+const (
+	roleLeader   = "leader"
+	roleFollower = "follower"
+)
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_leader",
+	Help: "Whether this git-sync is the elected leader (1) or a follower (0), with --leader-election",
+})
+
+func init() {
+	prometheus.MustRegister(leaderGauge)
+}
+
+// leaderElector is one way of deciding which git-sync is the leader.
+type leaderElector interface {
+	// tryAcquire tries to become the leader, or to stay the leader if it
+	// already is.  It returns true if this process is the leader, and who
+	// the leader is, if known.
+	tryAcquire(ctx context.Context, now time.Time) (bool, string, error)
+}
+
+// leadership tracks whether this process is the leader.
+type leadership struct {
+	elector  leaderElector
+	duration time.Duration
+	// renewDeadline is how long a leader which can't renew its leadership
+	// keeps it.  This is shorter than duration, so that the leader has
+	// stopped before anyone else can take over.
+	renewDeadline time.Duration
+
+	mutex sync.Mutex
+	// leader is true while this process is the leader.
+	leader bool
+	// holder is the last known leader.
+	holder string
+	// renewed is when leadership was last confirmed.
+	renewed time.Time
+	// cancelSync cancels the sync in progress, if any.
+	cancelSync context.CancelFunc
+}
+
+// leader is the process-wide leadership, or nil if --leader-election is not
+// set, in which case this process is always the leader.
+var leader *leadership
+
+func newLeadership(elector leaderElector, duration time.Duration) *leadership {
+	return &leadership{elector: elector, duration: duration, renewDeadline: duration * 2 / 3}
+}
+
+// isLeader returns true if this process should sync.
+func (l *leadership) isLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.leader
+}
+
+// role returns the role of this process for /api/v1/status, or "" if
+// --leader-election is not set.
+func (l *leadership) role() string {
+	if l == nil {
+		return ""
+	}
+	if l.isLeader() {
+		return roleLeader
+	}
+	return roleFollower
+}
+
+// syncContext returns a context for one sync, which times out after timeout,
+// and which is canceled if this process stops being the leader, so that a
+// former leader doesn't keep writing to --root alongside the new one.
+func (l *leadership) syncContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if l == nil {
+		return ctx, cancel
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.leader {
+		cancel()
+		return ctx, cancel
+	}
+	l.cancelSync = cancel
+	return ctx, func() {
+		l.mutex.Lock()
+		l.cancelSync = nil
+		l.mutex.Unlock()
+		cancel()
+	}
+}
+
+// check tries once to acquire or renew leadership, and logs any change.  If
+// the elector fails, a leader keeps its role until the renew deadline, so
+// that a brief outage doesn't stop syncing.  Losing leadership cancels the
+// sync in progress.
+func (l *leadership) check(ctx context.Context, now time.Time) {
+	ok, holder, err := l.elector.tryAcquire(ctx, now)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	was := l.leader
+	if err != nil {
+		log.Error(err, "leader election failed")
+		if l.leader && now.Sub(l.renewed) >= l.renewDeadline {
+			l.leader = false
+		}
+	} else {
+		l.leader = ok
+		l.holder = holder
+		if ok {
+			l.renewed = now
+		}
+	}
+
+	switch {
+	case l.leader && !was:
+		log.V(0).Info("became the leader, syncing")
+		recordKubeEvent(kubeEventNormal, kubeReasonLeaderElected, "became the leader")
+	case !l.leader && was:
+		log.V(0).Info("lost leadership, no longer syncing", "leader", l.holder)
+		if l.cancelSync != nil {
+			l.cancelSync()
+			l.cancelSync = nil
+		}
+	case !l.leader && err == nil && holder != "":
+		log.V(1).Info("another git-sync is the leader", "leader", holder)
+	}
+	if l.leader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
+
+// run tries to acquire or renew leadership several times per lease duration,
+// forever.
+func (l *leadership) run() {
+	for {
+		time.Sleep(l.duration / 3)
+		ctx, cancel := context.WithTimeout(context.Background(), l.duration/3)
+		l.check(ctx, time.Now())
+		cancel()
+	}
+}
+
+// fileElector elects the leader by holding an advisory lock on a file under
+// --root.  The lock is dropped when the process exits, for any reason.
+type fileElector struct {
+	path string
+	// f is the open lock file, once it has been locked.
+	f *os.File
+}
+
+func newFileElector(gitRoot string) *fileElector {
+	return &fileElector{path: filepath.Join(gitRoot, rootLeaderFile)}
+}
+
+func (e *fileElector) tryAcquire(ctx context.Context, now time.Time) (bool, string, error) {
+	if e.f != nil {
+		// Nobody can take a held lock away.
+		return true, lockOwner(), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return false, "", fmt.Errorf("can't create root: %w", err)
+	}
+	f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, "", fmt.Errorf("can't open leader file: %w", err)
+	}
+	locked, err := tryLockFile(f)
+	if err != nil {
+		f.Close()
+		return false, "", fmt.Errorf("can't lock %s: %w", e.path, err)
+	}
+	if !locked {
+		holder := lockHolder(f)
+		f.Close()
+		return false, holder, nil
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(lockOwner()+"\n"), 0)
+	}
+	e.f = f
+	return true, lockOwner(), nil
+}
+
+// kubeMicroTime is a timestamp in the format of the Kubernetes MicroTime type.
+type kubeMicroTime struct {
+	time.Time
+}
+
+const kubeMicroTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+func (t kubeMicroTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(kubeMicroTimeFormat))
+}
+
+func (t *kubeMicroTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	s := ""
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+type kubeLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string         `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int            `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          *kubeMicroTime `json:"acquireTime,omitempty"`
+		RenewTime            *kubeMicroTime `json:"renewTime,omitempty"`
+		LeaseTransitions     int            `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// leaseElector elects the leader with a coordination.k8s.io/v1 Lease, which
+// the leader renews.  If the leader stops renewing it, another git-sync takes
+// it over once it expires.
+type leaseElector struct {
+	*kubeClient
+	name     string
+	duration time.Duration
+}
+
+func newLeaseElector(c *kubeClient, name string, duration time.Duration) *leaseElector {
+	return &leaseElector{kubeClient: c, name: name, duration: duration}
+}
+
+func (e *leaseElector) url(name string) string {
+	url := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.apiURL, e.namespace)
+	if name != "" {
+		url += "/" + name
+	}
+	return url
+}
+
+// send makes a request with a Lease as its body, if lease is not nil, and
+// decodes the Lease in the response.
+func (e *leaseElector) send(ctx context.Context, method, url string, lease *kubeLease) (*kubeLease, error) {
+	var body io.Reader
+	if lease != nil {
+		b, err := json.Marshal(lease)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if lease != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := e.do(req)
+	if err != nil {
+		return nil, err
+	}
+	result := &kubeLease{}
+	if err := json.Unmarshal(resp, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// isKubeStatus returns true if err is a response from the API server with
+// the HTTP status code.
+func isKubeStatus(err error, code int) bool {
+	var serr *kubeStatusError
+	return errors.As(err, &serr) && serr.status == code
+}
+
+func (e *leaseElector) tryAcquire(ctx context.Context, now time.Time) (bool, string, error) {
+	identity := e.podName
+	renew := &kubeMicroTime{now}
+
+	lease, err := e.send(ctx, http.MethodGet, e.url(e.name), nil)
+	if isKubeStatus(err, http.StatusNotFound) {
+		lease = &kubeLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		lease.Metadata.Name = e.name
+		lease.Metadata.Namespace = e.namespace
+		lease.Spec.HolderIdentity = identity
+		lease.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+		lease.Spec.AcquireTime = renew
+		lease.Spec.RenewTime = renew
+		_, err := e.send(ctx, http.MethodPost, e.url(""), lease)
+		if isKubeStatus(err, http.StatusConflict) {
+			// Someone else created it first.
+			return false, "", nil
+		}
+		if err != nil {
+			return false, "", fmt.Errorf("can't create lease %s: %w", e.name, err)
+		}
+		return true, identity, nil
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("can't get lease %s: %w", e.name, err)
+	}
+
+	holder := lease.Spec.HolderIdentity
+	if holder != "" && holder != identity {
+		duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+		if lease.Spec.RenewTime != nil && now.Before(lease.Spec.RenewTime.Add(duration)) {
+			return false, holder, nil
+		}
+		log.V(0).Info("taking over an expired lease", "lease", e.name, "holder", holder)
+	}
+	if holder != identity {
+		lease.Spec.HolderIdentity = identity
+		lease.Spec.AcquireTime = renew
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	lease.Spec.RenewTime = renew
+	// The resourceVersion makes this fail if anyone else changed it since
+	// the GET.
+	_, err = e.send(ctx, http.MethodPut, e.url(e.name), lease)
+	if isKubeStatus(err, http.StatusConflict) {
+		return false, holder, nil
+	}
+	if err != nil {
+		return false, holder, fmt.Errorf("can't update lease %s: %w", e.name, err)
+	}
+	return true, identity, nil
+}
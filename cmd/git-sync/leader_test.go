@@ -0,0 +1,194 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// fakeLeaseServer is a minimal API server which stores one Lease.
+type fakeLeaseServer struct {
+	mutex   sync.Mutex
+	lease   *kubeLease
+	version int
+}
+
+func (s *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	const base = "/apis/coordination.k8s.io/v1/namespaces/my-ns/leases"
+
+	write := func(code int) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(s.lease)
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == base+"/git-sync":
+		if s.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		write(http.StatusOK)
+	case r.Method == http.MethodPost && r.URL.Path == base:
+		if s.lease != nil {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		s.lease = &kubeLease{}
+		json.NewDecoder(r.Body).Decode(s.lease)
+		s.version++
+		s.lease.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		write(http.StatusCreated)
+	case r.Method == http.MethodPut && r.URL.Path == base+"/git-sync":
+		lease := &kubeLease{}
+		json.NewDecoder(r.Body).Decode(lease)
+		if s.lease == nil || lease.Metadata.ResourceVersion != s.lease.Metadata.ResourceVersion {
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		s.lease = lease
+		s.version++
+		s.lease.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		write(http.StatusOK)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestLeaseElector(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+
+	newElector := func(pod string) *leaseElector {
+		c := &kubeClient{apiURL: srv.URL, client: srv.Client(), podName: pod, namespace: "my-ns"}
+		return newLeaseElector(c, "git-sync", 15*time.Second)
+	}
+	a, b := newElector("pod-a"), newElector("pod-b")
+	ctx := context.Background()
+	now := time.Now()
+
+	if ok, _, err := a.tryAcquire(ctx, now); err != nil || !ok {
+		t.Fatalf("expected pod-a to create the lease, got %v, %v", ok, err)
+	}
+	if ok, holder, err := b.tryAcquire(ctx, now); err != nil || ok || holder != "pod-a" {
+		t.Fatalf("expected pod-b to follow pod-a, got %v, %q, %v", ok, holder, err)
+	}
+	if ok, _, err := a.tryAcquire(ctx, now.Add(10*time.Second)); err != nil || !ok {
+		t.Fatalf("expected pod-a to renew the lease, got %v, %v", ok, err)
+	}
+	if ok, _, err := b.tryAcquire(ctx, now.Add(20*time.Second)); err != nil || ok {
+		t.Fatalf("expected the renewed lease to hold, got %v, %v", ok, err)
+	}
+
+	// pod-a stops renewing, so pod-b takes over.
+	if ok, _, err := b.tryAcquire(ctx, now.Add(30*time.Second)); err != nil || !ok {
+		t.Fatalf("expected pod-b to take over the expired lease, got %v, %v", ok, err)
+	}
+	if fake.lease.Spec.HolderIdentity != "pod-b" || fake.lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("unexpected lease: %+v", fake.lease.Spec)
+	}
+	if ok, holder, err := a.tryAcquire(ctx, now.Add(31*time.Second)); err != nil || ok || holder != "pod-b" {
+		t.Errorf("expected pod-a to follow pod-b, got %v, %q, %v", ok, holder, err)
+	}
+}
+
+// fakeElector returns canned results.
+type fakeElector struct {
+	ok  bool
+	err error
+}
+
+func (e *fakeElector) tryAcquire(ctx context.Context, now time.Time) (bool, string, error) {
+	return e.ok, "someone", e.err
+}
+
+func TestLeadershipCheck(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	e := &fakeElector{ok: true}
+	l := newLeadership(e, 15*time.Second)
+	now := time.Now()
+
+	if l.isLeader() {
+		t.Fatalf("expected to start as a follower")
+	}
+	l.check(context.Background(), now)
+	if !l.isLeader() || l.role() != roleLeader {
+		t.Fatalf("expected to be the leader")
+	}
+
+	// A brief failure doesn't lose leadership...
+	e.ok, e.err = false, errors.New("API server is down")
+	l.check(context.Background(), now.Add(5*time.Second))
+	if !l.isLeader() {
+		t.Errorf("expected to stay the leader within the lease duration")
+	}
+	// ...but one which reaches the renew deadline, before the lease
+	// expires, does.
+	l.check(context.Background(), now.Add(10*time.Second))
+	if l.isLeader() || l.role() != roleFollower {
+		t.Errorf("expected to lose leadership at the renew deadline")
+	}
+
+	var none *leadership
+	if !none.isLeader() || none.role() != "" {
+		t.Errorf("expected to always lead without --leader-election")
+	}
+}
+
+func TestLeadershipSyncContext(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	e := &fakeElector{ok: true}
+	l := newLeadership(e, 15*time.Second)
+	now := time.Now()
+
+	ctx, cancel := l.syncContext(time.Minute)
+	if ctx.Err() == nil {
+		t.Errorf("expected a follower's sync to be canceled")
+	}
+	cancel()
+
+	l.check(context.Background(), now)
+	ctx, cancel = l.syncContext(time.Minute)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatalf("expected the leader's sync to run, got %v", ctx.Err())
+	}
+	e.ok = false
+	l.check(context.Background(), now.Add(5*time.Second))
+	if ctx.Err() != context.Canceled {
+		t.Errorf("expected losing leadership to cancel the sync, got %v", ctx.Err())
+	}
+
+	var none *leadership
+	ctx, cancel = none.syncContext(time.Minute)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Errorf("expected to always sync without --leader-election, got %v", ctx.Err())
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// destVars are the variables which --dest may use, as a Go template.
+type destVars struct {
+	// Repo is --repo (or --oci-ref).
+	Repo string
+	// RepoName is the last part of Repo, e.g. "git-sync" for
+	// "https://github.com/kubernetes/git-sync".
+	RepoName string
+	Branch   string
+	Rev      string
+	// Ref is Rev, or Branch if Rev is HEAD.
+	Ref string
+	// RefSlug is Ref, made safe for a file name, e.g. "release-1.2" for
+	// "release/1.2".
+	RefSlug string
+}
+
+func newDestVars(repo, branch, rev string) destVars {
+	parts := strings.Split(strings.TrimSuffix(strings.Trim(repo, "/"), ".git"), "/")
+	ref := rev
+	if ref == "" || ref == "HEAD" {
+		ref = branch
+	}
+	return destVars{
+		Repo:     repo,
+		RepoName: parts[len(parts)-1],
+		Branch:   branch,
+		Rev:      rev,
+		Ref:      ref,
+		RefSlug:  refSlug(ref),
+	}
+}
+
+// refSlug lower-cases ref and replaces everything but letters, digits, '.',
+// '_', and '-' with '-'.
+func refSlug(ref string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, ref)
+	return strings.Trim(slug, "-.")
+}
+
+// expandDest expands the template variables in dest.  Names without any are
+// returned as they are.
+func expandDest(dest string, vars destVars) (string, error) {
+	if !strings.Contains(dest, "{{") {
+		return dest, nil
+	}
+	tmpl, err := template.New("dest").Option("missingkey=error").Parse(dest)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("%q expands to nothing", dest)
+	}
+	return buf.String(), nil
+}
+
+// resolveDest returns the name of the link from --dest, which defaults to the
+// last part of the repo's path, with its template expanded.
+func resolveDest() (string, error) {
+	dest := *flDest
+	if dest == "" {
+		name := *flRepo
+		if *flSource == sourceOCI {
+			ref, _ := parseOCIRef(*flOCIRef)
+			name = ref.repository
+		}
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		dest = parts[len(parts)-1]
+	}
+	repo := *flRepo
+	if *flSource == sourceOCI {
+		repo = *flOCIRef
+	}
+	return expandDest(dest, newDestVars(repo, *flBranch, *flRev))
+}
+
+// byHashStep returns a step which makes the --by-hash-dir link for worktree,
+// if there isn't one already, and which removes it again on undo.
+func byHashStep(ctx context.Context, gitRoot, dir, worktree string) publishStep {
+	hash := worktreeHash(worktree)
+	linkDir := filepath.Join(gitRoot, dir)
+	_, err := os.Lstat(filepath.Join(linkDir, hash))
+	hadLink := err == nil
+	return publishStep{
+		name: filepath.Join(dir, hash),
+		do: func() error {
+			if hadLink {
+				return nil
+			}
+			if err := os.MkdirAll(linkDir, 0755); err != nil {
+				return err
+			}
+			// Relative, like the main link.
+			return replaceSymlink(ctx, linkDir, filepath.Join("..", hash), hash)
+		},
+		undo: func() error {
+			if hadLink {
+				return nil
+			}
+			return os.Remove(filepath.Join(linkDir, hash))
+		},
+	}
+}
+
+// removeByHashLink removes the --by-hash-dir link for worktree, if there is
+// one.
+func removeByHashLink(gitRoot, dir, worktree string) error {
+	err := os.Remove(filepath.Join(gitRoot, dir, filepath.Base(worktree)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
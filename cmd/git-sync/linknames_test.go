@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRefSlug(t *testing.T) {
+	cases := map[string]string{
+		"main":          "main",
+		"release/1.2":   "release-1.2",
+		"Feature/Foo_X": "feature-foo_x",
+		"v1.0.0":        "v1.0.0",
+		"/weird/":       "weird",
+	}
+	for in, expect := range cases {
+		if got := refSlug(in); got != expect {
+			t.Errorf("%q: expected %q, got %q", in, expect, got)
+		}
+	}
+}
+
+func TestExpandDest(t *testing.T) {
+	vars := newDestVars("https://github.com/kubernetes/git-sync.git", "release/1.2", "HEAD")
+	cases := []struct {
+		dest   string
+		expect string
+		err    bool
+	}{
+		{"plain", "plain", false},
+		{"current-{{.RefSlug}}", "current-release-1.2", false},
+		{"{{.RepoName}}-{{.Ref}}", "git-sync-release/1.2", false},
+		{"{{.Nope}}", "", true},
+		{"{{.Ref", "", true},
+		{"{{if false}}x{{end}}", "", true},
+	}
+	for _, tc := range cases {
+		got, err := expandDest(tc.dest, vars)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.dest)
+			}
+			continue
+		}
+		if err != nil || got != tc.expect {
+			t.Errorf("%q: expected %q, got %q, %v", tc.dest, tc.expect, got, err)
+		}
+	}
+
+	if got := newDestVars("repo", "main", "v1.0").Ref; got != "v1.0" {
+		t.Errorf("expected --rev to be the ref, got %q", got)
+	}
+}
+
+func TestResolveDest(t *testing.T) {
+	defer func(dest, repo, branch, rev, source string) {
+		*flDest, *flRepo, *flBranch, *flRev, *flSource = dest, repo, branch, rev, source
+	}(*flDest, *flRepo, *flBranch, *flRev, *flSource)
+
+	*flRepo = "https://github.com/kubernetes/git-sync.git"
+	*flBranch = "main"
+	*flRev = "HEAD"
+	*flSource = sourceRepo
+	for dest, want := range map[string]string{
+		"":                     "git-sync.git",
+		"plain":                "plain",
+		"current-{{.RefSlug}}": "current-main",
+	} {
+		*flDest = dest
+		if got, err := resolveDest(); err != nil || got != want {
+			t.Errorf("%q: expected %q, got %q, %v", dest, want, got, err)
+		}
+	}
+	*flDest = "{{.Nope}}"
+	if _, err := resolveDest(); err == nil {
+		t.Errorf("expected an error for a bad template")
+	}
+}
+
+func TestByHashStep(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	root := t.TempDir()
+	worktree := filepath.Join(root, hash1)
+	if err := os.Mkdir(worktree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "by-hash", hash1)
+
+	step := byHashStep(context.Background(), root, "by-hash", worktree)
+	if err := step.do(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if target, err := filepath.EvalSymlinks(link); err != nil || target != worktree {
+		t.Errorf("expected a link to %s, got %q, %v", worktree, target, err)
+	}
+	if err := step.undo(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("expected the link to be removed, got %v", err)
+	}
+
+	// A link which was already there stays, even on undo.
+	if err := step.do(); err != nil {
+		t.Fatal(err)
+	}
+	again := byHashStep(context.Background(), root, "by-hash", worktree)
+	if err := again.do(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := again.undo(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(link); err != nil {
+		t.Errorf("expected the link to stay, got %v", err)
+	}
+
+	if err := removeByHashLink(root, "by-hash", worktree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("expected the link to be removed, got %v", err)
+	}
+	if err := removeByHashLink(root, "by-hash", worktree); err != nil {
+		t.Errorf("expected no error for a missing link, got %v", err)
+	}
+}
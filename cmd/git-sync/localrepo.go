@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// localRepoPath returns the path of --repo if it is a local path or a
+// file:// URL, or "" if it is not.
+func localRepoPath() string {
+	if repoInfo.kind != repoKindLocal {
+		return ""
+	}
+	return repoInfo.path
+}
+
+// localCloneArgs returns the extra "git clone" options for repo, and what to
+// clone it from.  A local repo is cloned the way a remote one is, copying
+// only the objects which are reachable and honoring --depth, unless
+// --local-hardlinks is set.
+func localCloneArgs(repo string) ([]string, string) {
+	path := localRepoPath()
+	if path == "" {
+		return nil, repo
+	}
+	if *flLocalHardlinks {
+		// git only links objects when it is given a path, not a URL.
+		return []string{"--local"}, path
+	}
+	return []string{"--no-local"}, repo
+}
+
+// localRepoCredentialFlags returns the flags which are set, but which only
+// apply to a repo which is reached over the network.
+func localRepoCredentialFlags() []string {
+	flags := []string{}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"username", *flUsername != ""},
+		{"ssh", *flSSH},
+		{"askpass-url", *flAskPassURL != ""},
+		{"cookie-file", *flCookieFile},
+		{"auth-provider", *flAuthProvider != ""},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// absLocalRepo makes a relative --repo path absolute, so that it means the
+// same thing when git runs in --root.
+func absLocalRepo() error {
+	if repoInfo.kind != repoKindLocal || repoInfo.scheme == "file" || filepath.IsAbs(repoInfo.path) {
+		return nil
+	}
+	abs, err := filepath.Abs(repoInfo.path)
+	if err != nil {
+		return err
+	}
+	repoInfo.path = abs
+	*flRepo = abs
+	return nil
+}
+
+// checkLocalRepo returns an error if path is not a git repo, bare or not.
+func checkLocalRepo(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	for _, dir := range []string{path, filepath.Join(path, ".git")} {
+		if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "objects")); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a git repo", path)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestLocalCloneArgs(t *testing.T) {
+	defer func(info repoURL, hardlinks bool) {
+		repoInfo, *flLocalHardlinks = info, hardlinks
+	}(repoInfo, *flLocalHardlinks)
+
+	cases := []struct {
+		repo      string
+		hardlinks bool
+		args      []string
+		source    string
+	}{
+		{repo: "https://example.com/repo", source: "https://example.com/repo"},
+		{repo: "https://example.com/repo", hardlinks: true, source: "https://example.com/repo"},
+		{repo: "/srv/repo", args: []string{"--no-local"}, source: "/srv/repo"},
+		{repo: "/srv/repo", hardlinks: true, args: []string{"--local"}, source: "/srv/repo"},
+		{repo: "file:///srv/repo", args: []string{"--no-local"}, source: "file:///srv/repo"},
+		{repo: "file:///srv/repo", hardlinks: true, args: []string{"--local"}, source: "/srv/repo"},
+	}
+	for _, tc := range cases {
+		u, err := parseRepoURL(tc.repo)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.repo, err)
+		}
+		repoInfo, *flLocalHardlinks = u, tc.hardlinks
+		args, source := localCloneArgs(tc.repo)
+		if !reflect.DeepEqual(args, tc.args) || source != tc.source {
+			t.Errorf("%s (hardlinks=%v): expected %v %q, got %v %q", tc.repo, tc.hardlinks, tc.args, tc.source, args, source)
+		}
+	}
+}
+
+func TestAbsLocalRepo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("paths without a drive letter are relative on Windows")
+	}
+	defer func(info repoURL, repo string) {
+		repoInfo, *flRepo = info, repo
+	}(repoInfo, *flRepo)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for repo, expect := range map[string]string{
+		"repo":                     filepath.Join(cwd, "repo"),
+		"/srv/repo":                "/srv/repo",
+		"file:///srv/repo":         "file:///srv/repo",
+		"https://example.com/repo": "https://example.com/repo",
+	} {
+		*flRepo = repo
+		if repoInfo, err = parseRepoURL(repo); err != nil {
+			t.Fatalf("%s: %v", repo, err)
+		}
+		if err := absLocalRepo(); err != nil {
+			t.Errorf("%s: unexpected error: %v", repo, err)
+		}
+		if *flRepo != expect {
+			t.Errorf("%s: expected %q, got %q", repo, expect, *flRepo)
+		}
+	}
+}
+
+func TestCheckLocalRepo(t *testing.T) {
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "bare.git")
+	work := filepath.Join(dir, "work")
+	for _, args := range [][]string{{"init", "-q", "--bare", bare}, {"init", "-q", work}} {
+		if out, err := exec.Command(*flGitCmd, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+	for _, path := range []string{bare, work} {
+		if err := checkLocalRepo(path); err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+		}
+	}
+	for _, path := range []string{dir, filepath.Join(dir, "missing"), filepath.Join(bare, "HEAD")} {
+		if err := checkLocalRepo(path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
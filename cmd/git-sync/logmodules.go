@@ -0,0 +1,216 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// logModules maps the subsystems which --vmodule can set the verbosity of to
+// the source files and functions which log for them.  Functions are named as
+// "package.function" and take precedence over files.
+var logModules = map[string][]string{
+	"auth": {
+		"credentials.go", "credhelper.go",
+		"main.fetchAskPassCreds", "main.setupGitAuth", "main.setupGitCookieFile",
+		"main.setupGitSSH", "main.setupGitConfigFile", "main.setupExtraGitConfigs",
+		"main.setupGitTransportConfigs",
+	},
+	"command": {
+		"main.runCommandWithEnvAndStdin",
+	},
+	"fetch": {
+		"bundle.go", "fetchstats.go", "gc.go", "lsremote.go", "reference.go", "retry.go", "urlrewrite.go",
+		"main.cloneRepo",
+	},
+	"hook": {
+		"health.go", "hookqueue.go", "hooks.go", "notify.go", "webhook.go",
+		"main.runSyncHook",
+	},
+	"worktree": {
+		"bluegreen.go", "publish.go", "rollback.go",
+		"main.addWorktreeAndSwap", "main.checkoutWorktree", "main.cleanupWorkTree", "main.createWorktree",
+		"main.matchRootGroup",
+	},
+}
+
+// vmoduleRule is one "pattern=level" entry of --vmodule.
+type vmoduleRule struct {
+	pattern string
+	level   int
+}
+
+// parseVModule parses a --vmodule value, which is a comma-separated list of
+// "pattern=level", where pattern is a subsystem in logModules or a glob
+// matched against source file names, without the ".go" suffix.
+func parseVModule(spec string) ([]vmoduleRule, error) {
+	rules := []vmoduleRule{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected pattern=level", item)
+		}
+		if _, err := filepath.Match(parts[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", parts[0], err)
+		}
+		level, err := strconv.Atoi(parts[1])
+		if err != nil || level < 0 {
+			return nil, fmt.Errorf("invalid level %q for %q", parts[1], parts[0])
+		}
+		rules = append(rules, vmoduleRule{pattern: parts[0], level: level})
+	}
+	return rules, nil
+}
+
+// logModuleOf returns the subsystem which the named function, in the named
+// source file, logs for, or "" if it is not part of one.
+func logModuleOf(file, function string) string {
+	base := filepath.Base(file)
+	byFile := ""
+	for module, sources := range logModules {
+		for _, s := range sources {
+			if s == function {
+				return module
+			}
+			if s == base {
+				byFile = module
+			}
+		}
+	}
+	return byFile
+}
+
+// matchVModule returns the level set by the first of rules which matches the
+// subsystem or the source file of the named function.
+func matchVModule(rules []vmoduleRule, file, function string) (int, bool) {
+	module := logModuleOf(file, function)
+	name := strings.TrimSuffix(filepath.Base(file), ".go")
+	for _, r := range rules {
+		if r.pattern == module {
+			return r.level, true
+		}
+		if ok, _ := filepath.Match(r.pattern, name); ok {
+			return r.level, true
+		}
+	}
+	return 0, false
+}
+
+// logLevels holds the parsed --vmodule rules, and caches the level which
+// applies to each call site.
+type logLevels struct {
+	rules []vmoduleRule
+	cache sync.Map // uintptr -> *int, nil if no rule matches
+}
+
+// vmodule is set by setupVModule, and is nil if --vmodule is not set.
+var vmodule *logLevels
+
+// vmoduleFlag wraps glog's --vmodule flag to keep the value as it was set:
+// glog drops entries with level 0, which are needed to quiet a subsystem.
+type vmoduleFlag struct {
+	flag.Value
+	raw string
+}
+
+func (f *vmoduleFlag) Set(value string) error {
+	if err := f.Value.Set(value); err != nil {
+		return err
+	}
+	f.raw = value
+	return nil
+}
+
+func (f *vmoduleFlag) String() string {
+	return f.raw
+}
+
+func init() {
+	if f := flag.Lookup("vmodule"); f != nil {
+		f.Value = &vmoduleFlag{Value: f.Value}
+	}
+}
+
+// setupVModule parses the --vmodule flag, which is registered by glog.
+func setupVModule() error {
+	f := flag.Lookup("vmodule")
+	if f == nil || f.Value.String() == "" {
+		return nil
+	}
+	rules, err := parseVModule(f.Value.String())
+	if err != nil {
+		return err
+	}
+	vmodule = &logLevels{rules: rules}
+	return nil
+}
+
+// levelAt returns the level which applies to the function at pc, if any.
+func (l *logLevels) levelAt(pc uintptr) (int, bool) {
+	if v, found := l.cache.Load(pc); found {
+		if v.(*int) == nil {
+			return 0, false
+		}
+		return *v.(*int), true
+	}
+	var level *int
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if v, ok := matchVModule(l.rules, frame.File, frame.Function); ok {
+		level = &v
+	}
+	l.cache.Store(pc, level)
+	if level == nil {
+		return 0, false
+	}
+	return *level, true
+}
+
+// V returns a logger for the verbosity level.  If --vmodule sets a level for
+// the caller's subsystem, that is used instead of -v.  glog can't be asked to
+// log above -v, so lines which only --vmodule enables are logged at level 0.
+func (l customLogger) V(level int) logr.Logger {
+	if vmodule == nil {
+		return l.Logger.V(level)
+	}
+	pcs := [1]uintptr{}
+	if runtime.Callers(2, pcs[:]) == 0 {
+		return l.Logger.V(level)
+	}
+	max, found := vmodule.levelAt(pcs[0])
+	if !found {
+		return l.Logger.V(level)
+	}
+	if level > max {
+		return logr.Discard()
+	}
+	if v := l.Logger.V(level); v.Enabled() {
+		return v
+	}
+	return l.Logger
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVModule(t *testing.T) {
+	cases := []struct {
+		spec string
+		exp  []vmoduleRule
+		err  bool
+	}{
+		{spec: "", exp: []vmoduleRule{}},
+		{spec: "hook=5", exp: []vmoduleRule{{"hook", 5}}},
+		{spec: "fetch=5, hook=2,", exp: []vmoduleRule{{"fetch", 5}, {"hook", 2}}},
+		{spec: "peer*=3", exp: []vmoduleRule{{"peer*", 3}}},
+		{spec: "hook", err: true},
+		{spec: "=5", err: true},
+		{spec: "hook=high", err: true},
+		{spec: "hook=-1", err: true},
+		{spec: "[=1", err: true},
+	}
+	for _, tc := range cases {
+		rules, err := parseVModule(tc.spec)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tc.spec, rules)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.spec, err)
+		} else if !reflect.DeepEqual(rules, tc.exp) {
+			t.Errorf("%q: expected %v, got %v", tc.spec, tc.exp, rules)
+		}
+	}
+}
+
+func TestMatchVModule(t *testing.T) {
+	rules := []vmoduleRule{{"command", 0}, {"hook", 5}, {"peer*", 3}, {"main", 1}}
+	cases := []struct {
+		file     string
+		function string
+		level    int
+		found    bool
+	}{
+		{"/src/cmd/git-sync/main.go", "main.runCommandWithEnvAndStdin", 0, true},
+		{"/src/cmd/git-sync/main.go", "main.runSyncHook", 5, true},
+		{"/src/cmd/git-sync/hooks.go", "main.runHook", 5, true},
+		{"/src/cmd/git-sync/peers.go", "main.waitForPeers", 3, true},
+		{"/src/cmd/git-sync/main.go", "main.syncRepo", 1, true},
+		{"/src/cmd/git-sync/gc.go", "main.gcRepo", 0, false},
+	}
+	for _, tc := range cases {
+		level, found := matchVModule(rules, tc.file, tc.function)
+		if level != tc.level || found != tc.found {
+			t.Errorf("%s %s: expected %d %v, got %d %v", tc.file, tc.function, tc.level, tc.found, level, found)
+		}
+	}
+}
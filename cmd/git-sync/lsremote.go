@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var upstreamRoundTrips = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_upstream_round_trips_total",
+	Help: "How many requests were made to the upstream repo, partitioned by operation (ls-remote, fetch) and state (success, error)",
+}, []string{"op", "status"})
+
+var lsRemoteCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_ls_remote_cache_hits_total",
+	Help: "How many upstream lookups were answered from the --ls-remote-cache-ttl cache",
+})
+
+func init() {
+	prometheus.MustRegister(upstreamRoundTrips)
+	prometheus.MustRegister(lsRemoteCacheHits)
+}
+
+// countRoundTrip records one request to the upstream repo.
+func countRoundTrip(op string, err error) {
+	status := metricKeySuccess
+	if err != nil {
+		status = metricKeyError
+	}
+	upstreamRoundTrips.WithLabelValues(op, status).Inc()
+}
+
+// lsRemoteCache holds the last answer from the upstream, so that it can be
+// reused for --ls-remote-cache-ttl.
+type lsRemoteCache struct {
+	mutex sync.Mutex
+	ref   string
+	hash  string
+	when  time.Time
+}
+
+var remoteHashes lsRemoteCache
+
+// get returns the cached hash for ref, if it is younger than ttl.
+func (c *lsRemoteCache) get(ref string, ttl time.Duration, now time.Time) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if ttl <= 0 || c.ref != ref || now.Sub(c.when) >= ttl {
+		return "", false
+	}
+	return c.hash, true
+}
+
+// invalidate forgets the cached hash, e.g. when the upstream is known to
+// have changed.
+func (c *lsRemoteCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ref = ""
+	c.hash = ""
+	c.when = time.Time{}
+}
+
+func (c *lsRemoteCache) set(ref, hash string, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ref = ref
+	c.hash = hash
+	c.when = now
+}
+
+// parseLsRemote finds the hash for exactly ref in the output of `git
+// ls-remote`.  It returns "" if ref is not listed.
+func parseLsRemote(output, ref string) string {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// remoteHashForRef returns the upstream hash for a given ref.  Unless
+// --git-protocol-version says otherwise, this uses protocol v2, so that the
+// upstream only sends the ref that was asked for rather than advertising all
+// of them.
+func remoteHashForRef(ctx context.Context, ref, gitRoot string) (string, error) {
+	if hash, ok := remoteHashes.get(ref, *flLsRemoteCacheTTL, time.Now()); ok {
+		lsRemoteCacheHits.Inc()
+		return hash, nil
+	}
+
+	args := []string{}
+	if *flGitProtocolVersion == "" {
+		args = append(args, "-c", "protocol.version=2")
+	}
+	args = append(args, "ls-remote", "-q", "origin", ref)
+	var output string
+	err := withRetries(ctx, "ls-remote", *flLsRemoteRetries, *flRetryBackoff, func() error {
+		return runStage(ctx, stageLsRemote, *flLsRemoteTimeout, func(ctx context.Context) error {
+			var err error
+			output, err = runRemoteCommand(ctx, gitRoot, args...)
+			countRoundTrip("ls-remote", err)
+			return err
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := parseLsRemote(output, ref)
+	remoteHashes.set(ref, hash, time.Now())
+	return hash, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLsRemote(t *testing.T) {
+	output := hash1 + "\trefs/heads/feature/main\n" +
+		hash2 + "\trefs/heads/main\n"
+	if got := parseLsRemote(output, "refs/heads/main"); got != hash2 {
+		t.Errorf("expected %s, got %q", hash2, got)
+	}
+	if got := parseLsRemote(output, "refs/heads/other"); got != "" {
+		t.Errorf("expected no match, got %q", got)
+	}
+	if got := parseLsRemote("", "refs/heads/main"); got != "" {
+		t.Errorf("expected no match, got %q", got)
+	}
+}
+
+func TestLsRemoteCache(t *testing.T) {
+	c := lsRemoteCache{}
+	now := time.Unix(1600000000, 0)
+	ttl := 10 * time.Second
+
+	if _, ok := c.get("refs/heads/main", ttl, now); ok {
+		t.Errorf("expected a miss on an empty cache")
+	}
+	c.set("refs/heads/main", hash1, now)
+	if got, ok := c.get("refs/heads/main", ttl, now.Add(5*time.Second)); !ok || got != hash1 {
+		t.Errorf("expected a hit, got %q, %v", got, ok)
+	}
+	if _, ok := c.get("refs/heads/main", ttl, now.Add(ttl)); ok {
+		t.Errorf("expected a miss after the TTL")
+	}
+	if _, ok := c.get("refs/heads/other", ttl, now); ok {
+		t.Errorf("expected a miss for a different ref")
+	}
+	if _, ok := c.get("refs/heads/main", 0, now); ok {
+		t.Errorf("expected a miss with caching disabled")
+	}
+}
@@ -21,10 +21,11 @@ package main // import "k8s.io/git-sync/cmd/git-sync"
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -33,54 +34,243 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/glogr"
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/spf13/pflag"
 	"k8s.io/git-sync/pkg/pid1"
 	"k8s.io/git-sync/pkg/version"
 )
 
 var flVer = flag.Bool("version", false, "print the version and exit")
+var flMan = flag.Bool("man", false, "print the reference for all flags, in --man-format, and exit")
+var flManFormat = flag.String("man-format", manFormatMarkdown, "the format of --man: 'md' (a markdown table) or 'json'")
+var flConfig = flag.String("config", envString("GIT_SYNC_CONFIG", ""),
+	"the path to a YAML file of flag names and values (flags and env vars take precedence over the file)")
 
+var flSource = flag.String("source", envString("GIT_SYNC_SOURCE", sourceRepo),
+	"where to sync from: 'repo' (--repo) or 'oci' (--oci-ref)")
 var flRepo = flag.String("repo", envString("GIT_SYNC_REPO", ""),
 	"the git repository to clone")
+var flOCIRef = flag.String("oci-ref", envString("GIT_SYNC_OCI_REF", ""),
+	"the OCI artifact to pull when --source=oci, e.g. 'ghcr.io/org/manifests:v1'")
 var flBranch = flag.String("branch", envString("GIT_SYNC_BRANCH", "master"),
 	"the git branch to check out")
 var flRev = flag.String("rev", envString("GIT_SYNC_REV", "HEAD"),
 	"the git revision (tag or hash) to check out")
+var flRefFallbacks = stringListFlag("ref-fallbacks", envString("GIT_SYNC_REF_FALLBACKS", ""),
+	"branches in order of priority, e.g. 'release-1.29,release-1.28,main': the first which exists upstream is tracked, re-evaluated before each sync (instead of --branch)")
 var flDepth = flag.Int("depth", envInt("GIT_SYNC_DEPTH", 0),
 	"use a shallow clone with a history truncated to the specified number of commits")
+var flOnHistoryRewrite = flag.String("on-history-rewrite", envString("GIT_SYNC_ON_HISTORY_REWRITE", rewritePolicyForceSync),
+	"what to do when the upstream is force-pushed or rebased: force-sync (publish it), fail (don't publish it), or reclone (publish it from a fresh clone)")
+var flOnRefMissing = flag.String("on-ref-missing", envString("GIT_SYNC_ON_REF_MISSING", refMissingFail),
+	"what to do when the tracked branch or tag is deleted upstream: fail, keep-last (keep the published hash), or fallback-ref=<branch> (sync that branch instead)")
+var flShallowSince = flag.String("shallow-since", envString("GIT_SYNC_SHALLOW_SINCE", ""),
+	"create a shallow clone with history after this date, in any format git accepts (e.g. '2021-06-01' or '30 days ago'); mutually exclusive with --depth")
+var flShallowExclude = stringListFlag("shallow-exclude", envString("GIT_SYNC_SHALLOW_EXCLUDE", ""),
+	"create a shallow clone without history reachable from this remote branch or tag (may be repeated); mutually exclusive with --depth")
 var flSubmodules = flag.String("submodules", envString("GIT_SYNC_SUBMODULES", "recursive"),
 	"git submodule behavior: one of 'recursive', 'shallow', or 'off'")
 
 var flRoot = flag.String("root", envString("GIT_SYNC_ROOT", envString("HOME", "")+"/git"),
 	"the root directory for git-sync operations, under which --dest will be created")
 var flDest = flag.String("dest", envString("GIT_SYNC_DEST", ""),
-	"the name of (a symlink to) a directory in which to check-out files under --root (defaults to the leaf dir of --repo)")
+	"the name of (a symlink to) a directory in which to check-out files under --root (defaults to the leaf dir of --repo), which may use Go template variables, e.g. 'current-{{.RefSlug}}'")
+var flPreviousLink = flag.String("previous-link", envString("GIT_SYNC_PREVIOUS_LINK", ""),
+	"the name of a symlink under --root to the previously published worktree, which flips together with --dest (the previous worktree is kept while it is linked)")
+var flByHashDir = flag.String("by-hash-dir", envString("GIT_SYNC_BY_HASH_DIR", ""),
+	"the name of a directory under --root in which a symlink named for each retained worktree's hash points at it, e.g. 'by-hash'")
+var flWorktreeNames = flag.String("worktree-names", envString("GIT_SYNC_WORKTREE_NAMES", worktreeNamesHash),
+	"how to name worktrees under --root: 'hash' (the commit hash), 'sequence' (wt-000001, wt-000002, ...), or 'hmac' (an HMAC of the hash, keyed by --worktree-name-key-file), which keep the hash out of the published path (the hash of each is recorded in "+worktreeNamesFile+" under --root)")
+var flWorktreeNameKeyFile = flag.String("worktree-name-key-file", envString("GIT_SYNC_WORKTREE_NAME_KEY_FILE", ""),
+	"the file holding the key for --worktree-names=hmac, of at least 16 bytes")
 var flErrorFile = flag.String("error-file", envString("GIT_SYNC_ERROR_FILE", ""),
 	"the name of a file into which errors will be written under --root (defaults to \"\", disabling error reporting)")
 var flWait = flag.Float64("wait", envFloat("GIT_SYNC_WAIT", 1),
 	"the number of seconds between syncs")
+var flSchedule = flag.String("schedule", envString("GIT_SYNC_SCHEDULE", ""),
+	"a cron expression (e.g. \"*/15 9-17 * * mon-fri\") for when to sync, instead of every --wait seconds")
+var flScheduleJitter = flag.Duration("schedule-jitter", envDuration("GIT_SYNC_SCHEDULE_JITTER", 0),
+	"the size of the window after each --schedule time in which this instance syncs, at a stable offset derived from its hostname")
 var flSyncTimeout = flag.Int("timeout", envInt("GIT_SYNC_TIMEOUT", 120),
 	"the max number of seconds allowed for a complete sync")
+var flLsRemoteTimeout = flag.Duration("ls-remote-timeout", envDuration("GIT_SYNC_LS_REMOTE_TIMEOUT", 0),
+	"the max time allowed for asking the upstream for its hash (0 is only bounded by --timeout)")
+var flFetchTimeout = flag.Duration("fetch-timeout", envDuration("GIT_SYNC_FETCH_TIMEOUT", 0),
+	"the max time allowed for one fetch from the upstream (0 is only bounded by --timeout)")
+var flCheckoutTimeout = flag.Duration("checkout-timeout", envDuration("GIT_SYNC_CHECKOUT_TIMEOUT", 0),
+	"the max time allowed for checking out a new worktree, not counting submodules (0 is only bounded by --timeout)")
+var flSubmoduleTimeout = flag.Duration("submodule-timeout", envDuration("GIT_SYNC_SUBMODULE_TIMEOUT", 0),
+	"the max time allowed for updating the submodules of a new worktree (0 is only bounded by --timeout)")
+var flSyncHookTimeout = flag.Duration("sync-hook-timeout", envDuration("GIT_SYNC_HOOK_TIMEOUT", 0),
+	"the max time allowed for one run of --sync-hook-command (0 is only bounded by --timeout)")
+var flLsRemoteRetries = flag.Int("ls-remote-retries", envInt("GIT_SYNC_LS_REMOTE_RETRIES", 0),
+	"how many times to retry asking the upstream for its hash, within one sync, before failing the sync")
+var flFetchRetries = flag.Int("fetch-retries", envInt("GIT_SYNC_FETCH_RETRIES", 0),
+	"how many times to retry a fetch from the upstream, within one sync, before failing the sync")
+var flRetryBackoff = flag.Duration("retry-backoff", envDuration("GIT_SYNC_RETRY_BACKOFF", time.Second),
+	"how long to wait before the first retry of --ls-remote-retries or --fetch-retries, doubling for each retry after that")
+var flMaxBackpressureWait = flag.Duration("max-backpressure-wait", envDuration("GIT_SYNC_MAX_BACKPRESSURE_WAIT", 10*time.Minute),
+	"the longest to wait between syncs while the upstream is throttling git-sync (HTTP 429 or 503), doubling from --period; a longer Retry-After from the upstream is still honored")
 var flOneTime = flag.Bool("one-time", envBool("GIT_SYNC_ONE_TIME", false),
 	"exit after the first sync")
+var flMaxRuntime = flag.Duration("max-runtime", envDuration("GIT_SYNC_MAX_RUNTIME", 0),
+	"how long to run before exiting cleanly, between syncs, so that git-sync is restarted (0 runs forever)")
+var flWaitForFirstSync = flag.Duration("wait-for-first-sync", envDuration("GIT_SYNC_WAIT_FOR_FIRST_SYNC", 0),
+	"the max time for the first sync to succeed, after which git-sync exits with code 15 (0 waits forever)")
+var flFirstSyncFile = flag.String("first-sync-file", envString("GIT_SYNC_FIRST_SYNC_FILE", ""),
+	"the path of a file to create once the first sync succeeds, e.g. for an exec startupProbe")
+var flMinFreeSpace = flag.String("min-free-space", envString("GIT_SYNC_MIN_FREE_SPACE", ""),
+	"don't start a sync which is predicted to leave less than this much space free under --root, e.g. '512Mi' (empty to not check)")
+var flMaxRuntimeAction = flag.String("max-runtime-action", envString("GIT_SYNC_MAX_RUNTIME_ACTION", maxRuntimeExit),
+	"what to do when --max-runtime is reached: exit (for Kubernetes to restart the container), or re-exec (restart in place, in the same process)")
+var flVerify = flag.Bool("verify", envBool("GIT_SYNC_VERIFY", false),
+	"never change --root, but check every --wait whether the published hash matches the upstream, and report drift as metrics")
+var flDryRun = flag.Bool("dry-run", envBool("GIT_SYNC_DRY_RUN", false),
+	"print what the next sync would do (e.g. which hash it would publish) as JSON, and exit, without changing anything under --root; the size of a fetch is not estimated")
+var flBlueGreen = flag.Bool("blue-green", envBool("GIT_SYNC_BLUE_GREEN", false),
+	"also publish into two stable directories under --root, <dest>-a and <dest>-b, with a pointer file, <dest>.json, which names the active one, for consumers which cache symlinks")
+var flManifest = flag.String("manifest", envString("GIT_SYNC_MANIFEST", ""),
+	"write a manifest of the sha256 of each published file, sorted by path: at the root of the published tree (tree), or in the --metadata-link directory (metadata)")
+var flManifestName = flag.String("manifest-name", envString("GIT_SYNC_MANIFEST_NAME", "MANIFEST"),
+	"the file name of the --manifest")
+var flMetadataLink = flag.String("metadata-link", envString("GIT_SYNC_METADATA_LINK", ""),
+	"the name of a symlink under --root to a directory of metadata (hash, time) about the published worktree, which flips together with --dest")
+var flRenderFiles = stringListFlag("render-files", envString("GIT_SYNC_RENDER_FILES", ""),
+	"globs of files (e.g. *.tmpl or config/*.yaml) to render as templates into the --render-link tree")
+var flRenderLink = flag.String("render-link", envString("GIT_SYNC_RENDER_LINK", ""),
+	"the name of a symlink under --root to a copy of the published worktree, with the --render-files rendered, which flips together with --dest")
+var flRenderEngine = flag.String("render-engine", envString("GIT_SYNC_RENDER_ENGINE", renderEngineTemplate),
+	"how --render-files are rendered: template (Go templates) or envsubst (only $VAR and ${VAR} are replaced)")
+var flRenderEnv = stringListFlag("render-env", envString("GIT_SYNC_RENDER_ENV", ""),
+	"names or globs of the environment variables which --render-files may use (default is all but GIT_SYNC_*)")
 var flMaxSyncFailures = flag.Int("max-sync-failures", envInt("GIT_SYNC_MAX_SYNC_FAILURES", 0),
 	"the number of consecutive failures allowed before aborting (the first sync must succeed, -1 will retry forever after the initial sync)")
 var flChmod = flag.Int("change-permissions", envInt("GIT_SYNC_PERMISSIONS", 0),
 	"the file permissions to apply to the checked-out files (0 will not change permissions at all)")
+var flMatchRootGroup = flag.Bool("match-root-group", envBool("GIT_SYNC_MATCH_ROOT_GROUP", false),
+	"make each new worktree owned by, and readable by, the group which owns --root (e.g. a pod's fsGroup)")
+var flLockTimeout = flag.Duration("lock-timeout", envDuration("GIT_SYNC_LOCK_TIMEOUT", 0),
+	"lock --root while changing it, so that other git-sync processes sharing it wait, and give up on a held lock after this long (0 disables locking)")
+var flRejectHashesFile = flag.String("reject-hashes-file", envString("GIT_SYNC_REJECT_HASHES_FILE", ""),
+	"the path to a file of commit hashes or refs (one per line) which will never be published")
+var flSkipTrailers = stringListFlag("skip-trailer", envString("GIT_SYNC_SKIP_TRAILER", ""),
+	"don't publish commits with this trailer, as 'Key' or 'Key=value', e.g. 'Deploy-Freeze=true' (may be repeated)")
+var flMetricTrailers = stringListFlag("metric-trailer", envString("GIT_SYNC_METRIC_TRAILER", ""),
+	"report whether the upstream commit has this trailer, as 'Key' or 'Key=value', in the git_sync_commit_trailer metric (may be repeated)")
+var flTamperCheck = flag.Bool("tamper-check", envBool("GIT_SYNC_TAMPER_CHECK", false),
+	"after each sync, check that the published worktree is checked out at the hash it is named for, which is the upstream hash or one of its ancestors, and fail readiness if not")
+var flTagRequireAnnotated = flag.Bool("tag-require-annotated", envBool("GIT_SYNC_TAG_REQUIRE_ANNOTATED", false),
+	"don't publish the tag given by --rev unless it is an annotated tag")
+var flTagRequireSignature = flag.Bool("tag-require-signature", envBool("GIT_SYNC_TAG_REQUIRE_SIGNATURE", false),
+	"don't publish the tag given by --rev unless it is signed, and 'git verify-tag' accepts the signature")
+var flTagTaggers = stringListFlag("tag-taggers", envString("GIT_SYNC_TAG_TAGGERS", ""),
+	"don't publish the tag given by --rev unless its tagger's email matches one of these globs, e.g. '*@example.com' (may be repeated)")
 var flSyncHookCommand = flag.String("sync-hook-command", envString("GIT_SYNC_HOOK_COMMAND", ""),
 	"the command executed with the syncing repository as its working directory after syncing a new hash of the remote repository. "+
 		"it is subject to the sync time out and will extend period between syncs. (doesn't support the command arguments)")
+var flSyncHookPolicy = flag.String("sync-hook-policy", envString("GIT_SYNC_HOOK_POLICY", hookPolicyInline),
+	"how --sync-hook-command is run: inline (the sync waits for it), or in the background with latest-wins, queue-all, or reject-if-busy")
+var flSyncHookMaxRetries = flag.Int("sync-hook-max-retries", envInt("GIT_SYNC_HOOK_MAX_RETRIES", 0),
+	"how many times to run --sync-hook-command again for the same hash, within --timeout, if it fails")
+var flSyncHookBackoff = flag.Duration("sync-hook-backoff", envDuration("GIT_SYNC_HOOK_BACKOFF", time.Second*3),
+	"the time to wait before running a failed --sync-hook-command again, doubled after each retry")
+var flSyncHookRetryExitCode = flag.Int("sync-hook-retry-exit-code", envInt("GIT_SYNC_HOOK_RETRY_EXIT_CODE", -1),
+	"an exit code with which --sync-hook-command asks to be run again later; these retries don't count against --sync-hook-max-retries, and go on until --timeout (-1 for none)")
+var flSyncHookPermanentExitCode = flag.Int("sync-hook-permanent-exit-code", envInt("GIT_SYNC_HOOK_PERMANENT_EXIT_CODE", -1),
+	"an exit code with which --sync-hook-command says its failure is permanent, so it is not run again (-1 for none)")
+var flSyncHookParallelism = flag.Int("sync-hook-parallelism", envInt("GIT_SYNC_HOOK_PARALLELISM", 1),
+	"the max number of background --sync-hook-command runs at once, each for a different hash")
+var flNotifyPid = flag.Int("notify-pid", envInt("GIT_SYNC_NOTIFY_PID", 0),
+	"the PID of a process to send --notify-signal to when a new hash is published")
+var flNotifyPidFile = flag.String("notify-pid-file", envString("GIT_SYNC_NOTIFY_PID_FILE", ""),
+	"the path to a file holding the PID of a process to send --notify-signal to when a new hash is published, read on each publish")
+var flNotifySignal = flag.String("notify-signal", envString("GIT_SYNC_NOTIFY_SIGNAL", "HUP"),
+	"the signal sent to --notify-pid or --notify-pid-file (HUP, INT, QUIT, TERM, USR1, USR2, or WINCH)")
+var flNotifyFifo = flag.String("notify-fifo", envString("GIT_SYNC_NOTIFY_FIFO", ""),
+	"the path to a named pipe to which the hash is written when a new hash is published, if something is reading it")
+var flHookMaxOutput = flag.Int("hook-max-output", envInt("GIT_SYNC_HOOK_MAX_OUTPUT", 1024*1024),
+	"the max number of bytes of each of stdout and stderr kept from one run of --sync-hook-command or --health-exec-command (0 keeps everything)")
+var flHookOutputDir = flag.String("hook-output-dir", envString("GIT_SYNC_HOOK_OUTPUT_DIR", ""),
+	"a directory in which to save the stdout and stderr of each run of --sync-hook-command or --health-exec-command")
+var flHookEnv = stringListFlag("hook-env", envString("GIT_SYNC_HOOK_ENV", ""),
+	"an environment variable (or a glob, e.g. 'AWS_*') to pass to --sync-hook-command and --health-exec-command; if set, others are not passed, except PATH, HOME, and the GIT_* variables git-sync sets (may be repeated)")
+var flHookEnvBlocklist = stringListFlag("hook-env-blocklist", envString("GIT_SYNC_HOOK_ENV_BLOCKLIST", ""),
+	"an environment variable (or a glob) not to pass to --sync-hook-command and --health-exec-command (may be repeated)")
+var flLsRemoteCacheTTL = flag.Duration("ls-remote-cache-ttl", envDuration("GIT_SYNC_LS_REMOTE_CACHE_TTL", 0),
+	"how long to reuse the upstream hash before asking the upstream again, to reduce load on it (0 asks on every sync)")
+var flAuditLogFile = flag.String("audit-log-file", envString("GIT_SYNC_AUDIT_LOG_FILE", ""),
+	"the path to an append-only, hash-chained log of every change to the published hash")
+var flHealthExecCommand = flag.String("health-exec-command", envString("GIT_SYNC_HEALTH_EXEC_COMMAND", ""),
+	"a command which is run periodically in the published worktree to check that its content is intact; repeated failures fail the readiness check (doesn't support the command arguments)")
+var flHealthExecInterval = flag.Duration("health-exec-interval", envDuration("GIT_SYNC_HEALTH_EXEC_INTERVAL", 30*time.Second),
+	"how often to run --health-exec-command")
+var flHealthExecTimeout = flag.Duration("health-exec-timeout", envDuration("GIT_SYNC_HEALTH_EXEC_TIMEOUT", 10*time.Second),
+	"the max time allowed for one run of --health-exec-command")
+var flHealthExecFailureThreshold = flag.Int("health-exec-failure-threshold", envInt("GIT_SYNC_HEALTH_EXEC_FAILURE_THRESHOLD", 3),
+	"the number of consecutive --health-exec-command failures after which the readiness check fails")
 var flSparseCheckoutFile = flag.String("sparse-checkout-file", envString("GIT_SYNC_SPARSE_CHECKOUT_FILE", ""),
 	"the path to a sparse-checkout file.")
+var flCaseCollisions = flag.String("case-collisions", envString("GIT_SYNC_CASE_COLLISIONS", casePolicyWarn),
+	"what to do, on a case-insensitive filesystem, about paths which differ only by case and would overwrite each other: warn (log and publish anyway), fail (don't publish), or off (don't check)")
+var flRepoConfigPolicy = flag.String("repo-config-policy", envString("GIT_SYNC_REPO_CONFIG_POLICY", repoPolicyFail),
+	"what to do when the clone's git config or a .gitmodules file could make git run a command, or a submodule's URL is not allowed by --submodule-host-allowlist: fail (don't publish), warn (log and publish anyway), or off (don't check, and don't force core.fsmonitor off)")
+var flSubmoduleHostAllowlist = stringListFlag("submodule-host-allowlist", envString("GIT_SYNC_SUBMODULE_HOST_ALLOWLIST", ""),
+	"a host (or a glob, e.g. '*.example.com') which submodules may be fetched from; if set, submodules on other hosts violate --repo-config-policy, except those on --repo's host and those with relative URLs (may be repeated)")
+var flExcludePaths = stringListFlag("exclude-paths", envString("GIT_SYNC_EXCLUDE_PATHS", ""),
+	"a pattern, in .gitignore syntax (e.g. '/docs/' or '*.md'), of paths to leave out of the checkout, by way of sparse-checkout rules (may be repeated)")
+var flPublishWithoutGitdir = flag.Bool("publish-without-gitdir", envBool("GIT_SYNC_PUBLISH_WITHOUT_GITDIR", false),
+	"remove git metadata (.git files and directories) from each worktree before it is published")
+var flStaleWorktreeTimeout = flag.Duration("stale-worktree-timeout", envDuration("GIT_SYNC_STALE_WORKTREE_TIMEOUT", 0),
+	"how long to retain non-current worktrees (0 removes them as soon as they are replaced)")
+var flStaleWorktreeMaxCount = flag.Int("stale-worktree-max-count", envInt("GIT_SYNC_STALE_WORKTREE_MAX_COUNT", 0),
+	"how many non-current worktrees to retain, regardless of age (0 retains them according to --stale-worktree-timeout)")
+var flCheckoutWorkers = flag.Int("checkout-workers", envInt("GIT_SYNC_CHECKOUT_WORKERS", 0),
+	"the number of parallel workers git uses to check out files (0 uses one per CPU, 1 checks out sequentially)")
+var flCheckoutParallelThreshold = flag.Int("checkout-parallel-threshold", envInt("GIT_SYNC_CHECKOUT_PARALLEL_THRESHOLD", 100),
+	"the minimum number of files to check out before git uses parallel workers")
+var flReferenceRepo = flag.String("reference-repo", envString("GIT_SYNC_REFERENCE_REPO", ""),
+	"the absolute path to a local git repo (e.g. a cache shared by other instances on the node) from which to borrow objects rather than fetching and storing them again")
+var flLocalHardlinks = flag.Bool("local-hardlinks", envBool("GIT_SYNC_LOCAL_HARDLINKS", false),
+	"when --repo is a local path or file:// URL on the same filesystem as --root, hard-link its objects rather than copying them (can't be used with --depth)")
+var flFromBundle = flag.String("from-bundle", envString("GIT_SYNC_FROM_BUNDLE", ""),
+	"the path to a git bundle (e.g. baked into the image) from which to seed the initial clone, so that only newer objects are fetched")
+var flGitMaintenance = flag.Bool("git-maintenance", envBool("GIT_SYNC_GIT_MAINTENANCE", false),
+	"write a commit-graph and multi-pack-index after each fetch, and use skipping fetch negotiation, to speed up fetches and checkouts of large repos")
+var flGCInterval = flag.Duration("gc-interval", envDuration("GIT_SYNC_GC_INTERVAL", 0),
+	"the minimum time between runs of git gc, which otherwise runs after every fetch (0 runs it every time)")
+var flGCNice = flag.Int("gc-nice", envInt("GIT_SYNC_GC_NICE", 0),
+	"the niceness (1-19) at which to run git gc, to leave CPU for other containers (0 does not change it)")
+var flGCIOIdle = flag.Bool("gc-io-idle", envBool("GIT_SYNC_GC_IO_IDLE", false),
+	"run git gc in the idle I/O scheduling class, so it only uses the disk when nothing else does")
+var flGCThreads = flag.Int("gc-threads", envInt("GIT_SYNC_GC_THREADS", 0),
+	"the number of threads git gc uses to pack objects (0 uses one per CPU on the node)")
+var flFsckInterval = flag.Duration("fsck-interval", envDuration("GIT_SYNC_FSCK_INTERVAL", 0),
+	"how often to check the integrity of the local clone (git fsck) in the background (0 disables)")
+var flFsckTimeout = flag.Duration("fsck-timeout", envDuration("GIT_SYNC_FSCK_TIMEOUT", 10*time.Minute),
+	"the max time allowed for one background integrity check")
+var flSpotCheckInterval = flag.Duration("spot-check-interval", envDuration("GIT_SYNC_SPOT_CHECK_INTERVAL", 0),
+	"how often to compare files in the published worktree with the published commit, to catch damage on disk (0 disables)")
+var flSpotCheckFiles = flag.Int("spot-check-files", envInt("GIT_SYNC_SPOT_CHECK_FILES", 100),
+	"how many files to compare in each spot check, continuing from where the last one stopped (0 compares all of them)")
+var flSpotCheckRepair = flag.Bool("spot-check-repair", envBool("GIT_SYNC_SPOT_CHECK_REPAIR", false),
+	"check out damaged files again when a spot check finds them")
+var flRollbackHold = flag.Bool("rollback-hold", envBool("GIT_SYNC_ROLLBACK_HOLD", false),
+	"hold rollbacks from /admin/rollback until /admin/release is called, rather than until the upstream moves")
+var flDebounce = flag.Duration("debounce", envDuration("GIT_SYNC_DEBOUNCE", 0),
+	"how long the upstream hash must be unchanged before it is published, to collapse bursts of pushes (0 publishes immediately)")
+var flMaxRefAge = flag.Duration("max-ref-age", envDuration("GIT_SYNC_MAX_REF_AGE", 0),
+	"the maximum age, by committer date, of the upstream commit before it is reported as stale (0 disables)")
+var flMaxRefAgeUnready = flag.Bool("max-ref-age-unready", envBool("GIT_SYNC_MAX_REF_AGE_UNREADY", false),
+	"fail the readiness check while the upstream commit is older than --max-ref-age")
 
 var flWebhookURL = flag.String("webhook-url", envString("GIT_SYNC_WEBHOOK_URL", ""),
 	"the URL for a webook notification when syncs complete (default is no webook)")
@@ -90,15 +280,29 @@ var flWebhookStatusSuccess = flag.Int("webhook-success-status", envInt("GIT_SYNC
 	"the HTTP status code indicating a successful webhook (-1 disables success checks to make webhooks fire-and-forget)")
 var flWebhookTimeout = flag.Duration("webhook-timeout", envDuration("GIT_SYNC_WEBHOOK_TIMEOUT", time.Second),
 	"the timeout for the webhook")
+var flWebhookTemplate = flag.String("webhook-template", envString("GIT_SYNC_WEBHOOK_TEMPLATE", ""),
+	"a Go template for the webhook request body, with the new commit's .Hash, .Author, .AuthorEmail, .Subject, .Committed, and .Tag, and .Rollback (the json function quotes values)")
+var flWebhookContentType = flag.String("webhook-content-type", envString("GIT_SYNC_WEBHOOK_CONTENT_TYPE", "application/json"),
+	"the Content-Type of the webhook request body, with --webhook-template")
 var flWebhookBackoff = flag.Duration("webhook-backoff", envDuration("GIT_SYNC_WEBHOOK_BACKOFF", time.Second*3),
-	"the time to wait before retrying a failed webhook")
+	"the time to wait before retrying a failed webhook, doubled after each failure up to --webhook-backoff-max")
+var flWebhookBackoffMax = flag.Duration("webhook-backoff-max", envDuration("GIT_SYNC_WEBHOOK_BACKOFF_MAX", time.Minute),
+	"the longest time to wait before retrying a failed webhook")
+var flWebhookMaxRetries = flag.Int("webhook-max-retries", envInt("GIT_SYNC_WEBHOOK_MAX_RETRIES", -1),
+	"how many times to retry a failed webhook before giving up on that hash (-1 retries forever)")
+var flWebhookDeadLetterFile = flag.String("webhook-dead-letter-file", envString("GIT_SYNC_WEBHOOK_DEAD_LETTER_FILE", ""),
+	"the path to a file which webhooks that were given up on after --webhook-max-retries are appended to, as JSON lines; also served at "+webhookDeadLetterPath+" on --http-bind")
 
 var flUsername = flag.String("username", envString("GIT_SYNC_USERNAME", ""),
 	"the username to use for git auth")
 var flPassword = flag.String("password", envString("GIT_SYNC_PASSWORD", ""),
 	"the password to use for git auth (prefer --password-file or this env var)")
-var flPasswordFile = pflag.String("password-file", envString("GIT_SYNC_PASSWORD_FILE", ""),
+var flPasswordFile = flag.String("password-file", envString("GIT_SYNC_PASSWORD_FILE", ""),
 	"the file from which the password or personal access token for git auth will be sourced")
+var flCredentials = entryListFlag("credential", envString("GIT_SYNC_CREDENTIAL", ""),
+	"credentials for another host, e.g. one which serves submodules, as 'host=H,username=U,password-file=F' ('url' may be given instead of 'host', and 'password' instead of 'password-file'; may be repeated, or separated by ';')")
+var flCredentialStore = flag.String("credential-store", envString("GIT_SYNC_CREDENTIAL_STORE", credentialStoreFile),
+	"where git-sync keeps credentials for git: 'file' (git's store helper, which writes them to a file) or 'memory' (only in git-sync, which git asks through a socket)")
 
 var flSSH = flag.Bool("ssh", envBool("GIT_SYNC_SSH", false),
 	"use SSH for git operations")
@@ -108,26 +312,127 @@ var flSSHKnownHosts = flag.Bool("ssh-known-hosts", envBool("GIT_KNOWN_HOSTS", tr
 	"enable SSH known_hosts verification")
 var flSSHKnownHostsFile = flag.String("ssh-known-hosts-file", envString("GIT_SSH_KNOWN_HOSTS_FILE", "/etc/git-secret/known_hosts"),
 	"the known_hosts file to use")
+var flSSHControlPersist = flag.Duration("ssh-control-persist", envDuration("GIT_SYNC_SSH_CONTROL_PERSIST", 0),
+	"share one SSH connection to the upstream between git commands, through an SSH ControlMaster, and keep it open this long after the last one (0 connects for each command)")
 var flAddUser = flag.Bool("add-user", envBool("GIT_SYNC_ADD_USER", false),
-	"add a record to /etc/passwd for the current UID/GID (needed to use SSH with a different UID)")
+	"add a record to --passwd-file for the current UID/GID (needed to use SSH with a different UID)")
+var flPasswdFile = flag.String("passwd-file", envString("GIT_SYNC_PASSWD_FILE", "/etc/passwd"),
+	"the passwd file to which --add-user adds a record (e.g. a writable copy for nss_wrapper, when the root filesystem is read-only)")
+var flNSSWrapperLib = flag.String("nss-wrapper-lib", envString("GIT_SYNC_NSS_WRAPPER_LIB", ""),
+	"the path to the nss_wrapper library (e.g. /usr/lib/libnss_wrapper.so), with which git and ssh look users up in --passwd-file, seeded from /etc/passwd, so that --add-user works with a read-only /etc/passwd")
 
 var flCookieFile = flag.Bool("cookie-file", envBool("GIT_COOKIE_FILE", false),
 	"use git cookiefile")
+var flCookieFilePath = flag.String("cookie-file-path", envString("GIT_SYNC_COOKIE_FILE_PATH", "/etc/git-secret/cookie_file"),
+	"the git cookiefile to use with --cookie-file")
 
 var flAskPassURL = flag.String("askpass-url", envString("GIT_ASKPASS_URL", ""),
 	"the URL for GIT_ASKPASS callback")
+var flAuthProvider = flag.String("auth-provider", envString("GIT_SYNC_AUTH_PROVIDER", ""),
+	"generate short-lived git credentials for a platform before each sync, from the identity git-sync runs as: one of 'azure-devops', 'codecommit-sigv4', or 'gcp-source-repos'")
 
+var flSubreaper = flag.Bool("subreaper", envBool("GIT_SYNC_SUBREAPER", false),
+	"when not running as pid 1 (e.g. the pod shares its process namespace), run git-sync under a small init which reaps orphaned processes, such as those left behind by hooks (Linux only)")
+
+var flVCS = flag.String("vcs", envString("GIT_SYNC_VCS", "git"),
+	"the version control system of the repo: one of 'git' or 'hg' (experimental)")
 var flGitCmd = flag.String("git", envString("GIT_SYNC_GIT", "git"),
 	"the git command to run (subject to PATH search, mostly for testing)")
+var flHgCmd = flag.String("hg", envString("GIT_SYNC_HG", "hg"),
+	"the hg command to run when --vcs=hg (subject to PATH search, mostly for testing)")
 var flGitConfig = flag.String("git-config", envString("GIT_SYNC_GIT_CONFIG", ""),
 	"additional git config options in 'key1:val1,key2:val2' format")
+var flGitConfigFile = flag.String("git-config-file", envString("GIT_SYNC_GIT_CONFIG_FILE", ""),
+	"the absolute path of the global git config file which git-sync writes, and the credentials file beside it (defaults to $HOME/.gitconfig and $HOME/.git-credentials)")
+var flURLRewrites = stringListFlag("url-rewrite", envString("GIT_SYNC_URL_REWRITE", ""),
+	"a rule in 'from=to' form to fetch URLs starting with 'from' (including submodules) from 'to' instead, via url.<to>.insteadOf (may be repeated)")
+var flGitEnv = stringListFlag("git-env", envString("GIT_SYNC_GIT_ENV", ""),
+	"an environment variable (or a glob, e.g. 'HTTPS_PROXY') to pass to git and the other commands git-sync runs; if set, others are not passed, except PATH, HOME, and the GIT_* variables git-sync sets (may be repeated)")
+var flGitEnvBlocklist = stringListFlag("git-env-blocklist", envString("GIT_SYNC_GIT_ENV_BLOCKLIST", ""),
+	"an environment variable (or a glob) not to pass to git and the other commands git-sync runs (may be repeated)")
+var flGitSandbox = flag.Bool("git-sandbox", envBool("GIT_SYNC_GIT_SANDBOX", false),
+	"run git and the other commands git-sync runs with only the environment variables git needs (and those in --git-env), and on Linux, without the ability to gain privileges or most capabilities")
+var flGitHooksPath = flag.String("git-hooks-path", envString("GIT_SYNC_GIT_HOOKS_PATH", os.DevNull),
+	"the directory git runs its hooks from (core.hooksPath); the default runs none, so that a repo can't run code in git-sync through git hooks ('' to use git's default)")
+var flGitProtocolVersion = flag.String("git-protocol-version", envString("GIT_SYNC_GIT_PROTOCOL_VERSION", ""),
+	"the git wire protocol version to use: one of '0', '1', or '2' (defaults to git's default)")
+var flHTTPLowSpeedLimit = flag.Int("http-low-speed-limit", envInt("GIT_SYNC_HTTP_LOW_SPEED_LIMIT", 0),
+	"abort HTTP(S) git transfers which are slower than this many bytes per second for --http-low-speed-time (0 disables)")
+var flHTTPLowSpeedTime = flag.Duration("http-low-speed-time", envDuration("GIT_SYNC_HTTP_LOW_SPEED_TIME", 0),
+	"how long an HTTP(S) git transfer may be slower than --http-low-speed-limit before it is aborted")
+var flGitCompression = flag.Int("git-compression", envInt("GIT_SYNC_GIT_COMPRESSION", -1),
+	"the zlib compression level (0-9) git uses for objects and packs (-1 uses git's default)")
+var flDNSPolicy = flag.String("dns-policy", envString("GIT_SYNC_DNS_POLICY", dnsPolicyDefault),
+	"how the HTTP(S) repo's host is resolved: 'default' (by git, for each connection), 'per-sync' (once at the start of each sync), or 'pin' (once, for the life of the process)")
+
+var flReloadFiles = flag.Bool("reload-files", envBool("GIT_SYNC_RELOAD_FILES", true),
+	"watch --config (for --wait, --timeout, --max-sync-failures, --username, and --password), --password-file, the --credential password files, --ssh-key-file, --ssh-known-hosts-file, --http-tls-cert, --http-tls-key, --http-token-file, --scm-webhook-secret-file, and --sparse-checkout-file, and apply changes to them")
 
 var flHTTPBind = flag.String("http-bind", envString("GIT_SYNC_HTTP_BIND", ""),
 	"the bind address (including port) for git-sync's HTTP endpoint")
+var flHTTPReadTimeout = flag.Duration("http-read-timeout", envDuration("GIT_SYNC_HTTP_READ_TIMEOUT", 30*time.Second),
+	"the max time allowed for reading a request to git-sync's HTTP endpoint (0 means no limit)")
+var flHTTPWriteTimeout = flag.Duration("http-write-timeout", envDuration("GIT_SYNC_HTTP_WRITE_TIMEOUT", 0),
+	"the max time allowed for writing a response from git-sync's HTTP endpoint, which also bounds /api/v1/events streams (0 means no limit)")
+var flHTTPIdleTimeout = flag.Duration("http-idle-timeout", envDuration("GIT_SYNC_HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	"how long an idle keep-alive connection to git-sync's HTTP endpoint is kept open (0 uses --http-read-timeout)")
+var flHTTPTLSCert = flag.String("http-tls-cert", envString("GIT_SYNC_HTTP_TLS_CERT", ""),
+	"the path to a PEM certificate (chain) with which git-sync's HTTP endpoint serves TLS (requires --http-tls-key)")
+var flHTTPTLSKey = flag.String("http-tls-key", envString("GIT_SYNC_HTTP_TLS_KEY", ""),
+	"the path to the PEM private key for --http-tls-cert")
+var flHTTPTokenFile = flag.String("http-token-file", envString("GIT_SYNC_HTTP_TOKEN_FILE", ""),
+	"the path to a file holding a bearer token which all requests to git-sync's HTTP endpoint, except the health check at /, must carry")
+var flSCMWebhookSecretFile = flag.String("scm-webhook-secret-file", envString("GIT_SYNC_SCM_WEBHOOK_SECRET_FILE", ""),
+	"the path to a file holding the secret of a GitHub or GitLab webhook; pushes to the synced ref which are received at "+scmWebhookPath+" on --http-bind start a sync immediately")
 var flHTTPMetrics = flag.Bool("http-metrics", envBool("GIT_SYNC_HTTP_METRICS", true),
 	"enable metrics on git-sync's HTTP endpoint")
+var flMetricLabels = stringListFlag("metric-labels", envString("GIT_SYNC_METRIC_LABELS", ""),
+	"a label in 'key=value' form to add to every exported metric, e.g. 'team=payments' (may be repeated)")
+var flMetricRefLabel = flag.Bool("metric-ref-label", envBool("GIT_SYNC_METRIC_REF_LABEL", false),
+	"add the --rev, or --branch if --rev is HEAD, as a 'ref' label to every exported metric")
+var flMetricsTextfile = flag.String("metrics-textfile", envString("GIT_SYNC_METRICS_TEXTFILE", ""),
+	"the path of a file to write the final metrics to, in the Prometheus text format, when git-sync exits (e.g. after --one-time), for node-exporter's textfile collector")
+var flMetricsPushgateway = flag.String("metrics-pushgateway", envString("GIT_SYNC_METRICS_PUSHGATEWAY", ""),
+	"the URL of a Prometheus Pushgateway to push the final metrics to when git-sync exits (e.g. after --one-time)")
+var flMetricsPushgatewayJob = flag.String("metrics-pushgateway-job", envString("GIT_SYNC_METRICS_PUSHGATEWAY_JOB", "git-sync"),
+	"the job name for --metrics-pushgateway")
 var flHTTPprof = flag.Bool("http-pprof", envBool("GIT_SYNC_HTTP_PPROF", false),
 	"enable the pprof debug endpoints on git-sync's HTTP endpoint")
+var flHTTPFiles = flag.Bool("http-files", envBool("GIT_SYNC_HTTP_FILES", false),
+	"serve the published tree, read-only, under /content/ on git-sync's HTTP endpoint, for debugging")
+var flHTTPFilesListing = flag.Bool("http-files-listing", envBool("GIT_SYNC_HTTP_FILES_LISTING", false),
+	"allow directory listings under /content/ (requires --http-files)")
+var flGRPCBind = flag.String("grpc-bind", envString("GIT_SYNC_GRPC_BIND", ""),
+	"the bind address (including port) for git-sync's gRPC control API (see pkg/api/v1/gitsync.proto)")
+var flOTelExporterEndpoint = flag.String("otel-exporter-endpoint",
+	envString("GIT_SYNC_OTEL_EXPORTER_ENDPOINT", envString("OTEL_EXPORTER_OTLP_ENDPOINT", "")),
+	"the OTLP/HTTP endpoint (e.g. http://localhost:4318) to which traces of each sync are exported (default is no tracing)")
+
+var flKubeEvents = flag.Bool("kube-events", envBool("GIT_SYNC_KUBE_EVENTS", false),
+	"post Kubernetes Events about notable conditions on the pod in which git-sync runs (requires in-cluster credentials which can create events)")
+var flKubeEventsFailureThreshold = flag.Int("kube-events-failure-threshold", envInt("GIT_SYNC_KUBE_EVENTS_FAILURE_THRESHOLD", 3),
+	"the number of consecutive sync failures after which a Kubernetes Event is posted")
+
+var flLeaderElection = flag.String("leader-election", envString("GIT_SYNC_LEADER_ELECTION", ""),
+	"elect one git-sync to sync --root when several share it (e.g. on a RWX volume), while the rest only serve status: file (a lock under --root) or lease (a Kubernetes Lease, in-cluster)")
+var flLeaderElectionLeaseName = flag.String("leader-election-lease-name", envString("GIT_SYNC_LEADER_ELECTION_LEASE_NAME", "git-sync"),
+	"the name of the Lease, in the pod's namespace, with --leader-election=lease")
+var flLeaderElectionLeaseDuration = flag.Duration("leader-election-lease-duration", envDuration("GIT_SYNC_LEADER_ELECTION_LEASE_DURATION", 15*time.Second),
+	"how long a leader which stops renewing its leadership keeps it, before another git-sync takes over")
+
+var flPeerURLs = flag.String("peer-urls", envString("GIT_SYNC_PEER_URLS", ""),
+	"a comma-separated list of the --http-bind URLs of all replicas; a new hash is only published once a quorum of them has fetched it")
+var flPeerDNS = flag.String("peer-dns", envString("GIT_SYNC_PEER_DNS", ""),
+	"a host:port (e.g. a headless Service) which resolves to the --http-bind addresses of all replicas, as an alternative to --peer-urls")
+var flPeerQuorum = flag.Int("peer-quorum", envInt("GIT_SYNC_PEER_QUORUM", 0),
+	"how many peers must have fetched a hash before it is published (0 means a majority)")
+var flPeerCAFile = flag.String("peer-ca-file", envString("GIT_SYNC_PEER_CA_FILE", ""),
+	"the path to a PEM bundle of CA certificates with which to verify peers which serve TLS (defaults to the system's roots)")
+var flPeerTimeout = flag.Duration("peer-timeout", envDuration("GIT_SYNC_PEER_TIMEOUT", 30*time.Second),
+	"how long to wait for a peer quorum before giving up until the next sync")
+
+var flHTTPAdmin = flag.Bool("http-admin", envBool("GIT_SYNC_HTTP_ADMIN", false),
+	"enable the admin endpoints (e.g. /admin/rollback and /api/v1/pause) on git-sync's HTTP endpoint")
 
 var log *customLogger
 
@@ -344,23 +649,174 @@ func main() {
 		os.Exit(127)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == credentialHelperCmd {
+		os.Exit(credentialHelperMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == credentialMemoryCmd {
+		os.Exit(credentialMemoryMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == completionCmd {
+		os.Exit(completionMain(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == doctorCmd {
+		os.Exit(doctorMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == migrateFlagsCmd {
+		os.Exit(migrateFlagsMain(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == validateCmd {
+		validating = true
+		args = args[1:]
+	}
+
 	setFlagDefaults()
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	log = &customLogger{glogr.New(), *flRoot, *flErrorFile}
 
+	if *flConfig != "" {
+		if err := loadConfigFile(*flConfig); err != nil {
+			handleError(false, "ERROR: can't load --config file %q: %v", *flConfig, err)
+		}
+		// The config file might have changed these.
+		log = &customLogger{glogr.New(), *flRoot, *flErrorFile}
+	}
+
 	if *flVer {
 		fmt.Println(version.VERSION)
 		os.Exit(0)
 	}
+	if *flMan {
+		if err := writeManual(os.Stdout, *flManFormat); err != nil {
+			handleError(true, "ERROR: --man-format must be one of %q or %q", manFormatMarkdown, manFormatJSON)
+		}
+		os.Exit(0)
+	}
+
+	// As above, but as a subreaper, which has to wait for the flags.
+	if *flSubreaper && os.Getpid() != 1 && !pid1.IsSubreaperChild() && !validating {
+		log.V(0).Info("running init handler as a subreaper")
+		code, err := pid1.ReRunAsSubreaper()
+		if err == nil {
+			os.Exit(code)
+		}
+		handleError(false, "ERROR: can't run as a subreaper: %v", err)
+	}
+
+	if err := setupVModule(); err != nil {
+		handleError(true, "ERROR: invalid --vmodule: %v", err)
+	}
+	for _, f := range []struct {
+		name  string
+		items []string
+	}{
+		{"git-env", flGitEnv.items},
+		{"git-env-blocklist", flGitEnvBlocklist.items},
+		{"hook-env", flHookEnv.items},
+		{"hook-env-blocklist", flHookEnvBlocklist.items},
+	} {
+		if err := validateEnvPatterns(f.items); err != nil {
+			handleError(true, "ERROR: invalid --%s: %v", f.name, err)
+		}
+	}
+	gitEnv = envFilter{allow: flGitEnv.items, block: flGitEnvBlocklist.items}
+	if *flGitSandbox {
+		gitEnv = sandboxGitEnv(flGitEnv.items, flGitEnvBlocklist.items)
+	}
+	hookEnv = envFilter{allow: flHookEnv.items, block: flHookEnvBlocklist.items}
 
-	if *flRepo == "" {
-		handleError(true, "ERROR: --repo must be specified")
+	switch *flSource {
+	case sourceRepo:
+		if *flRepo == "" {
+			handleError(true, "ERROR: --repo must be specified")
+		}
+		if *flVCS == "git" {
+			u, err := parseRepoURL(*flRepo)
+			if err != nil {
+				handleError(true, "ERROR: invalid --repo: %v", err)
+			}
+			repoInfo = u
+			if err := absLocalRepo(); err != nil {
+				handleError(true, "ERROR: invalid --repo: %v", err)
+			}
+		}
+		if *flOCIRef != "" {
+			handleError(true, "ERROR: --oci-ref requires --source=%s", sourceOCI)
+		}
+	case sourceOCI:
+		if *flOCIRef == "" {
+			handleError(true, "ERROR: --oci-ref must be specified when --source=%s", sourceOCI)
+		}
+		if _, err := parseOCIRef(*flOCIRef); err != nil {
+			handleError(true, "ERROR: invalid --oci-ref: %v", err)
+		}
+		if *flRepo != "" {
+			handleError(true, "ERROR: only one of --repo and --oci-ref may be specified")
+		}
+	default:
+		handleError(true, "ERROR: --source must be one of %q or %q", sourceRepo, sourceOCI)
 	}
 
+	if len(flRefFallbacks.items) != 0 {
+		branchSet := flagSetFromEnv("branch")
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "branch" {
+				branchSet = true
+			}
+		})
+		if branchSet {
+			handleError(true, "ERROR: only one of --branch and --ref-fallbacks may be specified")
+		}
+		if *flRev != "HEAD" {
+			handleError(true, "ERROR: --rev can't be used with --ref-fallbacks, which tracks branches")
+		}
+		if *flVerify {
+			handleError(true, "ERROR: --verify can't be used with --ref-fallbacks")
+		}
+	}
 	if *flDepth < 0 { // 0 means "no limit"
 		handleError(true, "ERROR: --depth must be greater than or equal to 0")
 	}
+	if _, _, err := parseRefMissingPolicy(*flOnRefMissing); err != nil {
+		handleError(true, "ERROR: invalid --on-ref-missing: %v", err)
+	}
+	switch *flOnHistoryRewrite {
+	case rewritePolicyForceSync, rewritePolicyFail, rewritePolicyReclone:
+	default:
+		handleError(true, "ERROR: --on-history-rewrite must be one of %q, %q, or %q", rewritePolicyForceSync, rewritePolicyFail, rewritePolicyReclone)
+	}
+	switch *flRepoConfigPolicy {
+	case repoPolicyFail, repoPolicyWarn, repoPolicyOff:
+	default:
+		handleError(true, "ERROR: --repo-config-policy must be one of %q, %q, or %q", repoPolicyFail, repoPolicyWarn, repoPolicyOff)
+	}
+	if len(flSubmoduleHostAllowlist.items) != 0 {
+		if *flSubmodules == submodulesOff {
+			handleError(true, "ERROR: --submodule-host-allowlist can't be used with --submodules=%s", submodulesOff)
+		}
+		if *flRepoConfigPolicy == repoPolicyOff {
+			handleError(true, "ERROR: --submodule-host-allowlist can't be used with --repo-config-policy=%s", repoPolicyOff)
+		}
+		if err := validateEnvPatterns(flSubmoduleHostAllowlist.items); err != nil {
+			handleError(true, "ERROR: invalid --submodule-host-allowlist: %v", err)
+		}
+	}
+
+	switch *flCaseCollisions {
+	case casePolicyWarn, casePolicyFail, casePolicyOff:
+	default:
+		handleError(true, "ERROR: --case-collisions must be one of %q, %q, or %q", casePolicyWarn, casePolicyFail, casePolicyOff)
+	}
+	for _, pattern := range flExcludePaths.items {
+		if err := validateExcludePath(pattern); err != nil {
+			handleError(true, "ERROR: invalid --exclude-paths: %v", err)
+		}
+	}
+	if *flDepth != 0 && (*flShallowSince != "" || len(flShallowExclude.items) != 0) {
+		handleError(true, "ERROR: --depth can't be combined with --shallow-since or --shallow-exclude")
+	}
 
 	switch *flSubmodules {
 	case submodulesRecursive, submodulesShallow, submodulesOff:
@@ -372,23 +828,263 @@ func main() {
 		handleError(true, "ERROR: --root must be specified")
 	}
 
-	if *flDest == "" {
-		parts := strings.Split(strings.Trim(*flRepo, "/"), "/")
-		*flDest = parts[len(parts)-1]
+	dest, err := resolveDest()
+	if err != nil {
+		handleError(true, "ERROR: invalid --dest: %v", err)
 	}
+	*flDest = dest
 
 	if strings.Contains(*flDest, "/") {
 		handleError(true, "ERROR: --dest must be a leaf name, not a path")
 	}
+	if *flPreviousLink != "" {
+		if strings.Contains(*flPreviousLink, "/") {
+			handleError(true, "ERROR: --previous-link must be a leaf name, not a path")
+		}
+		if *flPreviousLink == *flDest || *flPreviousLink == *flMetadataLink || *flPreviousLink == *flRenderLink {
+			handleError(true, "ERROR: --previous-link must be different from --dest, --metadata-link, and --render-link")
+		}
+	}
+	if *flByHashDir != "" {
+		if strings.Contains(*flByHashDir, "/") {
+			handleError(true, "ERROR: --by-hash-dir must be a leaf name, not a path")
+		}
+		if *flByHashDir == *flDest || isWorktreeName(*flByHashDir) || strings.HasPrefix(*flByHashDir, ".") {
+			handleError(true, "ERROR: --by-hash-dir must be different from --dest, and not a worktree name or a hidden name")
+		}
+	}
+	switch *flWorktreeNames {
+	case worktreeNamesHash, worktreeNamesSequence, worktreeNamesHMAC:
+	default:
+		handleError(true, "ERROR: --worktree-names must be one of %q, %q, or %q", worktreeNamesHash, worktreeNamesSequence, worktreeNamesHMAC)
+	}
+	if *flWorktreeNames != worktreeNamesHash {
+		if *flSource != sourceRepo {
+			handleError(true, "ERROR: --worktree-names=%s requires --source=%s", *flWorktreeNames, sourceRepo)
+		}
+		if *flByHashDir != "" {
+			handleError(true, "ERROR: --by-hash-dir can't be used with --worktree-names=%s, since its links are named for hashes", *flWorktreeNames)
+		}
+	}
+	if (*flWorktreeNames == worktreeNamesHMAC) != (*flWorktreeNameKeyFile != "") {
+		handleError(true, "ERROR: --worktree-name-key-file is required with, and only valid with, --worktree-names=%s", worktreeNamesHMAC)
+	}
+	if *flWorktreeNameKeyFile != "" {
+		key, err := readWorktreeNameKey(*flWorktreeNameKeyFile)
+		if err != nil {
+			handleError(false, "ERROR: can't read --worktree-name-key-file: %v", err)
+		}
+		worktreeNameKey = key
+	}
+	switch *flManifest {
+	case "", manifestTree:
+	case manifestMetadata:
+		if *flMetadataLink == "" {
+			handleError(true, "ERROR: --manifest=%s requires --metadata-link", manifestMetadata)
+		}
+	default:
+		handleError(true, "ERROR: --manifest must be %q or %q", manifestTree, manifestMetadata)
+	}
+	if *flManifest != "" && (*flManifestName == "" || strings.ContainsAny(*flManifestName, `/\`) || *flManifestName == "." || *flManifestName == "..") {
+		handleError(true, "ERROR: --manifest-name must be a file name, not a path")
+	}
+	if strings.Contains(*flMetadataLink, "/") {
+		handleError(true, "ERROR: --metadata-link must be a leaf name, not a path")
+	}
+	if *flMetadataLink != "" && *flMetadataLink == *flDest {
+		handleError(true, "ERROR: --metadata-link must be different from --dest")
+	}
+	if (len(flRenderFiles.items) == 0) != (*flRenderLink == "") {
+		handleError(true, "ERROR: --render-files and --render-link must be specified together")
+	}
+	if *flRenderLink != "" {
+		if strings.Contains(*flRenderLink, "/") {
+			handleError(true, "ERROR: --render-link must be a leaf name, not a path")
+		}
+		if *flRenderLink == *flDest || *flRenderLink == *flMetadataLink {
+			handleError(true, "ERROR: --render-link must be different from --dest and --metadata-link")
+		}
+		switch *flRenderEngine {
+		case renderEngineTemplate, renderEngineEnvsubst:
+		default:
+			handleError(true, "ERROR: --render-engine must be one of %q or %q", renderEngineTemplate, renderEngineEnvsubst)
+		}
+		for _, f := range []struct {
+			name  string
+			items []string
+		}{
+			{"render-files", flRenderFiles.items},
+			{"render-env", flRenderEnv.items},
+		} {
+			if err := validateEnvPatterns(f.items); err != nil {
+				handleError(true, "ERROR: invalid --%s: %v", f.name, err)
+			}
+		}
+		fileRenderer = &renderer{
+			patterns: flRenderFiles.items,
+			engine:   *flRenderEngine,
+			env:      envFilter{allow: flRenderEnv.items},
+		}
+	}
 
 	if *flWait < 0 {
 		handleError(true, "ERROR: --wait must be greater than or equal to 0")
 	}
 
+	if *flSchedule != "" {
+		sched, err := parseCron(*flSchedule)
+		if err != nil {
+			handleError(true, "ERROR: invalid --schedule: %v", err)
+		} else if sched.next(time.Now()).IsZero() {
+			handleError(true, "ERROR: --schedule %q never matches", *flSchedule)
+		}
+		syncSchedule = sched
+	}
+	if *flScheduleJitter < 0 {
+		handleError(true, "ERROR: --schedule-jitter must be at least 0")
+	}
+	if *flScheduleJitter > 0 && *flSchedule == "" {
+		handleError(true, "ERROR: --schedule-jitter requires --schedule")
+	}
+	scheduleJitter = scheduleOffset(*flScheduleJitter)
+
 	if *flSyncTimeout < 0 {
 		handleError(true, "ERROR: --timeout must be greater than 0")
 	}
 
+	if *flMaxRuntime < 0 {
+		handleError(true, "ERROR: --max-runtime must be at least 0")
+	}
+	switch *flMaxRuntimeAction {
+	case maxRuntimeExit:
+	case maxRuntimeReExec:
+		if !canReExec {
+			handleError(false, "ERROR: --max-runtime-action=%s is not supported on %s", maxRuntimeReExec, runtime.GOOS)
+		}
+	default:
+		handleError(true, "ERROR: --max-runtime-action must be one of %q or %q", maxRuntimeExit, maxRuntimeReExec)
+	}
+	if *flMinFreeSpace != "" {
+		if !canCheckFreeSpace {
+			handleError(false, "ERROR: --min-free-space is not supported on %s", runtime.GOOS)
+		}
+		n, err := parseByteSize(*flMinFreeSpace)
+		if err != nil {
+			handleError(true, "ERROR: invalid --min-free-space: %v", err)
+		}
+		minFreeSpace = n
+	}
+	if *flWaitForFirstSync < 0 {
+		handleError(true, "ERROR: --wait-for-first-sync must be at least 0")
+	}
+
+	if *flMaxRuntime > 0 && *flOneTime {
+		handleError(true, "ERROR: --max-runtime can't be used with --one-time")
+	}
+	for _, f := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"ls-remote-timeout", *flLsRemoteTimeout},
+		{"fetch-timeout", *flFetchTimeout},
+		{"checkout-timeout", *flCheckoutTimeout},
+		{"submodule-timeout", *flSubmoduleTimeout},
+		{"sync-hook-timeout", *flSyncHookTimeout},
+	} {
+		if f.value < 0 {
+			handleError(true, "ERROR: --%s must be at least 0", f.name)
+		}
+	}
+	if *flLsRemoteRetries < 0 {
+		handleError(true, "ERROR: --ls-remote-retries must be at least 0")
+	}
+	if *flFetchRetries < 0 {
+		handleError(true, "ERROR: --fetch-retries must be at least 0")
+	}
+	if *flRetryBackoff < 0 {
+		handleError(true, "ERROR: --retry-backoff must be at least 0")
+	}
+	if *flMaxBackpressureWait < time.Second {
+		handleError(true, "ERROR: --max-backpressure-wait must be at least 1s")
+	}
+	if *flGCInterval < 0 {
+		handleError(true, "ERROR: --gc-interval must be at least 0")
+	}
+	if *flGCNice < 0 || *flGCNice > 19 {
+		handleError(true, "ERROR: --gc-nice must be between 0 and 19")
+	}
+	if *flGCThreads < 0 {
+		handleError(true, "ERROR: --gc-threads must be at least 0")
+	}
+	if *flGCNice != 0 {
+		if _, err := exec.LookPath(niceCmd); err != nil {
+			handleError(false, "ERROR: --gc-nice needs %s: %v", niceCmd, err)
+		}
+	}
+	if *flGCIOIdle {
+		if _, err := exec.LookPath(ioniceCmd); err != nil {
+			handleError(false, "ERROR: --gc-io-idle needs %s: %v", ioniceCmd, err)
+		}
+	}
+	if *flHookMaxOutput < 0 {
+		handleError(true, "ERROR: --hook-max-output must be at least 0")
+	}
+
+	if *flOTelExporterEndpoint != "" {
+		t, err := newTracer(*flOTelExporterEndpoint)
+		if err != nil {
+			handleError(true, "ERROR: invalid --otel-exporter-endpoint: %v", err)
+		}
+		tracing = t
+	}
+
+	if *flKubeEvents && *flKubeEventsFailureThreshold < 1 {
+		handleError(true, "ERROR: --kube-events-failure-threshold must be at least 1")
+	}
+
+	switch *flLeaderElection {
+	case "":
+	case leaderElectionFile:
+		if !canLockRoot {
+			handleError(false, "ERROR: --leader-election=%s is not supported on %s", leaderElectionFile, runtime.GOOS)
+		}
+	case leaderElectionLease:
+		if *flLeaderElectionLeaseName == "" {
+			handleError(true, "ERROR: --leader-election-lease-name must be specified")
+		}
+	default:
+		handleError(true, "ERROR: --leader-election must be one of %q or %q", leaderElectionFile, leaderElectionLease)
+	}
+	if *flLeaderElection != "" {
+		if *flLeaderElectionLeaseDuration < time.Second {
+			handleError(true, "ERROR: --leader-election-lease-duration must be at least 1s")
+		}
+		if *flOneTime {
+			handleError(true, "ERROR: --leader-election can't be used with --one-time")
+		}
+	}
+
+	if err := validateGitTransportFlags(); err != nil {
+		handleError(true, "ERROR: %v", err)
+	}
+
+	switch *flDNSPolicy {
+	case dnsPolicyDefault:
+	case dnsPolicyPerSync, dnsPolicyPin:
+		if _, _, err := upstreamHostPort(*flRepo); err != nil {
+			handleError(false, "ERROR: --dns-policy=%s can't be used with this --repo: %v", *flDNSPolicy, err)
+		}
+	default:
+		handleError(true, "ERROR: --dns-policy must be one of %q, %q, or %q", dnsPolicyDefault, dnsPolicyPerSync, dnsPolicyPin)
+	}
+
+	if *flStaleWorktreeTimeout < 0 {
+		handleError(true, "ERROR: --stale-worktree-timeout must be greater than or equal to 0")
+	}
+	if *flStaleWorktreeMaxCount < 0 {
+		handleError(true, "ERROR: --stale-worktree-max-count must be greater than or equal to 0")
+	}
+
 	if *flWebhookURL != "" {
 		if *flWebhookStatusSuccess < -1 {
 			handleError(true, "ERROR: --webhook-success-status must be a valid HTTP code or -1")
@@ -399,15 +1095,167 @@ func main() {
 		if *flWebhookBackoff < time.Second {
 			handleError(true, "ERROR: --webhook-backoff must be at least 1s")
 		}
+		if *flWebhookBackoffMax < *flWebhookBackoff {
+			handleError(true, "ERROR: --webhook-backoff-max must be at least --webhook-backoff")
+		}
+		if *flWebhookMaxRetries < -1 {
+			handleError(true, "ERROR: --webhook-max-retries must be at least -1")
+		}
+	}
+	var webhookTemplate *template.Template
+	if *flWebhookTemplate != "" {
+		tmpl, err := parseWebhookTemplate(*flWebhookTemplate)
+		if err != nil {
+			handleError(true, "ERROR: can't parse --webhook-template: %v", err)
+		}
+		webhookTemplate = tmpl
+	}
+	if *flWebhookDeadLetterFile != "" {
+		if *flWebhookURL == "" {
+			handleError(true, "ERROR: --webhook-dead-letter-file requires --webhook-url")
+		}
+		if *flWebhookMaxRetries < 0 {
+			handleError(true, "ERROR: --webhook-dead-letter-file requires --webhook-max-retries")
+		}
+		webhookDeadLetters.path = *flWebhookDeadLetterFile
+	}
+
+	notGit := ""
+	if b, found := vcsBackends[*flVCS]; !found {
+		handleError(true, "ERROR: --vcs must be one of %s", strings.Join(vcsNames(), ", "))
+	} else if *flSource == sourceOCI {
+		if *flVCS != "git" {
+			handleError(true, "ERROR: --vcs can't be used with --source=%s", sourceOCI)
+		}
+		backend = ociBackend{}
+		notGit = "--source=" + sourceOCI
+	} else {
+		backend = b
+		if *flVCS != "git" {
+			notGit = "--vcs=" + *flVCS
+		}
+	}
+	if notGit != "" {
+		gitOnly := []struct {
+			name string
+			set  bool
+		}{
+			{"depth", *flDepth != 0},
+			{"shallow-since", *flShallowSince != ""},
+			{"on-history-rewrite", *flOnHistoryRewrite != rewritePolicyForceSync},
+			{"ls-remote-timeout", *flLsRemoteTimeout != 0},
+			{"fetch-timeout", *flFetchTimeout != 0},
+			{"checkout-timeout", *flCheckoutTimeout != 0},
+			{"submodule-timeout", *flSubmoduleTimeout != 0},
+			{"ls-remote-retries", *flLsRemoteRetries != 0},
+			{"fetch-retries", *flFetchRetries != 0},
+			{"max-backpressure-wait", *flMaxBackpressureWait != 10*time.Minute},
+			{"shallow-exclude", len(flShallowExclude.items) != 0},
+			{"sparse-checkout-file", *flSparseCheckoutFile != ""},
+			{"exclude-paths", len(flExcludePaths.items) != 0},
+			{"case-collisions", *flCaseCollisions != casePolicyWarn},
+			{"repo-config-policy", *flRepoConfigPolicy != repoPolicyFail},
+			{"submodule-host-allowlist", len(flSubmoduleHostAllowlist.items) != 0},
+			{"username", *flUsername != "" && *flSource != sourceOCI},
+			{"ssh", *flSSH},
+			{"ssh-control-persist", *flSSHControlPersist != 0},
+			{"cookie-file", *flCookieFile},
+			{"askpass-url", *flAskPassURL != ""},
+			{"ref-fallbacks", len(flRefFallbacks.items) != 0},
+			{"credential", len(flCredentials.items) != 0},
+			{"credential-store", *flCredentialStore != credentialStoreFile},
+			{"auth-provider", *flAuthProvider != ""},
+			{"git-config", *flGitConfig != ""},
+			{"url-rewrite", len(flURLRewrites.items) != 0},
+			{"git-protocol-version", *flGitProtocolVersion != ""},
+			{"http-low-speed-limit", *flHTTPLowSpeedLimit != 0},
+			{"git-compression", *flGitCompression != -1},
+			{"dns-policy", *flDNSPolicy != dnsPolicyDefault},
+			{"publish-without-gitdir", *flPublishWithoutGitdir && *flSource == sourceRepo},
+			{"max-ref-age", *flMaxRefAge != 0},
+			{"fsck-interval", *flFsckInterval != 0},
+			{"spot-check-interval", *flSpotCheckInterval != 0},
+			{"ls-remote-cache-ttl", *flLsRemoteCacheTTL != 0},
+			{"git-maintenance", *flGitMaintenance},
+			{"dry-run", *flDryRun},
+			{"verify", *flVerify},
+			{"gc-interval", *flGCInterval != 0},
+			{"gc-nice", *flGCNice != 0},
+			{"gc-io-idle", *flGCIOIdle},
+			{"gc-threads", *flGCThreads != 0},
+			{"checkout-workers", *flCheckoutWorkers != 0},
+			{"reference-repo", *flReferenceRepo != ""},
+			{"local-hardlinks", *flLocalHardlinks},
+			{"from-bundle", *flFromBundle != ""},
+			{"lock-timeout", *flLockTimeout != 0},
+			{"skip-trailer", len(flSkipTrailers.items) != 0},
+			{"metric-trailer", len(flMetricTrailers.items) != 0},
+			{"tamper-check", *flTamperCheck},
+			{"tag-require-annotated", *flTagRequireAnnotated},
+			{"tag-require-signature", *flTagRequireSignature},
+			{"tag-taggers", len(flTagTaggers.items) != 0},
+		}
+		for _, f := range gitOnly {
+			if f.set {
+				handleError(false, "ERROR: --%s is not supported with %s", f.name, notGit)
+			}
+		}
+	}
+
+	if *flRev == "HEAD" && (*flTagRequireAnnotated || *flTagRequireSignature || len(flTagTaggers.items) != 0) {
+		handleError(true, "ERROR: --tag-require-annotated, --tag-require-signature, and --tag-taggers require --rev to be a tag")
+	}
+	if err := validateEnvPatterns(flTagTaggers.items); err != nil {
+		handleError(true, "ERROR: invalid --tag-taggers: %v", err)
+	}
+
+	if *flVerify {
+		if *flDryRun {
+			handleError(true, "ERROR: only one of --verify and --dry-run may be specified")
+		}
+		if *flErrorFile != "" {
+			handleError(true, "ERROR: --error-file can't be used with --verify, which never writes under --root")
+		}
 	}
 
-	if _, err := exec.LookPath(*flGitCmd); err != nil {
-		handleError(false, "ERROR: git executable %q not found: %v", *flGitCmd, err)
+	if cmd := backend.command(); cmd != "" {
+		if _, err := exec.LookPath(cmd); err != nil {
+			handleError(false, "ERROR: %s executable %q not found: %v", *flVCS, cmd, err)
+		}
 	}
 
 	if *flPassword != "" && *flPasswordFile != "" {
 		handleError(false, "ERROR: only one of --password and --password-file may be specified")
 	}
+	switch *flCredentialStore {
+	case credentialStoreFile, credentialStoreMemory:
+	default:
+		handleError(true, "ERROR: --credential-store must be one of %q or %q", credentialStoreFile, credentialStoreMemory)
+	}
+	if creds, err := parseHostCredentials(flCredentials.items); err != nil {
+		handleError(true, "ERROR: invalid --credential: %v", err)
+	} else {
+		hostCredentials = creds
+		for _, c := range creds {
+			secrets.register(c.password)
+		}
+	}
+	if *flAuthProvider != "" {
+		if p, found := authProviders[*flAuthProvider]; !found {
+			handleError(true, "ERROR: --auth-provider must be one of %s", strings.Join(authProviderNames(), ", "))
+		} else if err := p.validate(*flRepo); err != nil {
+			handleError(false, "ERROR: can't use --auth-provider=%s: %v", *flAuthProvider, err)
+		}
+		if *flUsername != "" {
+			handleError(false, "ERROR: only one of --auth-provider and --username may be specified")
+		}
+		if *flAskPassURL != "" {
+			handleError(false, "ERROR: only one of --auth-provider and --askpass-url may be specified")
+		}
+		if *flSSH {
+			handleError(false, "ERROR: only one of --auth-provider and --ssh may be specified")
+		}
+	}
 	if *flUsername != "" {
 		if *flPassword == "" && *flPasswordFile == "" {
 			handleError(true, "ERROR: --password or --password-file must be set when --username is specified")
@@ -440,127 +1288,768 @@ func main() {
 		}
 	}
 
-	if *flAddUser {
-		if err := addUser(); err != nil {
-			handleError(false, "ERROR: can't write to /etc/passwd: %v", err)
+	if *flLocalHardlinks {
+		if localRepoPath() == "" {
+			handleError(true, "ERROR: --local-hardlinks requires --repo to be a local path or file:// URL")
+		}
+		if len(shallowArgs(*flDepth)) != 0 {
+			handleError(true, "ERROR: --local-hardlinks can't be used with --depth or --shallow-since, since a shallow clone can't share objects")
 		}
 	}
 
-	// This context is used only for git credentials initialization. There are no long-running operations like
-	// `git clone`, so initTimeout set to 30 seconds should be enough.
-	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	if *flSSHControlPersist < 0 {
+		handleError(true, "ERROR: --ssh-control-persist must be at least 0")
+	}
+	if *flSSHControlPersist > 0 {
+		if !canMultiplexSSH {
+			handleError(false, "ERROR: --ssh-control-persist is not supported on %s", runtime.GOOS)
+		}
+		if !isSSHRepo(repoInfo) {
+			handleError(true, "ERROR: --ssh-control-persist requires --repo to be reached over SSH")
+		}
+		if *flSSHControlPersist%time.Second != 0 {
+			handleError(true, "ERROR: --ssh-control-persist must be a whole number of seconds")
+		}
+	}
 
-	if *flUsername != "" {
-		if *flPasswordFile != "" {
-			passwordFileBytes, err := ioutil.ReadFile(*flPasswordFile)
-			if err != nil {
-				log.Error(err, "ERROR: can't read password file")
-				os.Exit(1)
+	if *flChmod != 0 && !canChangePermissions {
+		handleError(false, "ERROR: --change-permissions is not supported on %s", runtime.GOOS)
+	}
+
+	if *flDebounce < 0 {
+		handleError(true, "ERROR: --debounce must be at least 0")
+	}
+
+	if *flPeerURLs != "" && *flPeerDNS != "" {
+		handleError(true, "ERROR: only one of --peer-urls and --peer-dns may be specified")
+	}
+	if peerGateEnabled() {
+		if *flHTTPBind == "" {
+			handleError(true, "ERROR: --peer-urls and --peer-dns require --http-bind, so that peers can see this instance's status")
+		}
+		if *flPeerDNS != "" {
+			if _, _, err := net.SplitHostPort(*flPeerDNS); err != nil {
+				handleError(true, "ERROR: --peer-dns must be a host:port: %v", err)
 			}
-			*flPassword = string(passwordFileBytes)
 		}
-		if err := setupGitAuth(ctx, *flUsername, *flPassword, *flRepo); err != nil {
-			handleError(false, "ERROR: can't create .netrc file: %v", err)
+		if *flPeerQuorum < 0 {
+			handleError(true, "ERROR: --peer-quorum must be at least 0")
+		}
+		if *flPeerTimeout <= 0 {
+			handleError(true, "ERROR: --peer-timeout must be greater than 0")
 		}
+	} else if *flPeerCAFile != "" {
+		handleError(true, "ERROR: --peer-ca-file requires --peer-urls or --peer-dns")
 	}
 
-	if *flSSH {
-		if err := setupGitSSH(*flSSHKnownHosts); err != nil {
-			handleError(false, "ERROR: can't configure SSH: %v", err)
+	for _, rule := range flURLRewrites.items {
+		if _, err := parseURLRewrite(rule); err != nil {
+			handleError(true, "ERROR: invalid --url-rewrite: %v", err)
 		}
 	}
 
-	if *flCookieFile {
-		if err := setupGitCookieFile(ctx); err != nil {
-			handleError(false, "ERROR: can't set git cookie file: %v", err)
+	if *flNotifyPid < 0 {
+		handleError(true, "ERROR: --notify-pid must be at least 0")
+	}
+	if *flNotifyPid != 0 && *flNotifyPidFile != "" {
+		handleError(true, "ERROR: only one of --notify-pid and --notify-pid-file may be specified")
+	}
+	if *flNotifyPid != 0 || *flNotifyPidFile != "" {
+		if _, err := parseSignal(*flNotifySignal); err != nil {
+			handleError(true, "ERROR: invalid --notify-signal: %v", err)
 		}
 	}
 
-	if *flAskPassURL != "" {
-		if err := callGitAskPassURL(ctx, *flAskPassURL); err != nil {
-			askpassCount.WithLabelValues(metricKeyError).Inc()
-			handleError(false, "ERROR: failed to call ASKPASS callback URL: %v", err)
+	if *flSyncHookMaxRetries < 0 {
+		handleError(true, "ERROR: --sync-hook-max-retries must be at least 0")
+	}
+	if *flSyncHookBackoff < 0 {
+		handleError(true, "ERROR: --sync-hook-backoff must be at least 0")
+	}
+	for _, code := range []struct {
+		name  string
+		value int
+	}{
+		{"sync-hook-retry-exit-code", *flSyncHookRetryExitCode},
+		{"sync-hook-permanent-exit-code", *flSyncHookPermanentExitCode},
+	} {
+		if code.value != -1 && (code.value < 1 || code.value > 255) {
+			handleError(true, "ERROR: --%s must be between 1 and 255, or -1", code.name)
 		}
-		askpassCount.WithLabelValues(metricKeySuccess).Inc()
+	}
+	if *flSyncHookRetryExitCode != -1 && *flSyncHookRetryExitCode == *flSyncHookPermanentExitCode {
+		handleError(true, "ERROR: --sync-hook-retry-exit-code and --sync-hook-permanent-exit-code must be different")
 	}
 
-	// This needs to be after all other git-related config flags.
-	if *flGitConfig != "" {
-		if err := setupExtraGitConfigs(ctx, *flGitConfig); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: can't set additional git configs: %v\n", err)
-			os.Exit(1)
+	switch *flSyncHookPolicy {
+	case hookPolicyInline:
+	case hookPolicyLatestWins, hookPolicyQueueAll, hookPolicyRejectIfBusy:
+		if *flSyncHookParallelism < 1 {
+			handleError(true, "ERROR: --sync-hook-parallelism must be at least 1")
 		}
+	default:
+		handleError(true, "ERROR: --sync-hook-policy must be one of %q, %q, %q, or %q", hookPolicyInline, hookPolicyLatestWins, hookPolicyQueueAll, hookPolicyRejectIfBusy)
 	}
 
-	// The scope of the initialization context ends here, so we call cancel to release resources associated with it.
-	cancel()
+	if *flHealthExecCommand != "" {
+		if *flHealthExecInterval <= 0 {
+			handleError(true, "ERROR: --health-exec-interval must be greater than 0")
+		}
+		if *flHealthExecTimeout <= 0 {
+			handleError(true, "ERROR: --health-exec-timeout must be greater than 0")
+		}
+		if *flHealthExecFailureThreshold < 1 {
+			handleError(true, "ERROR: --health-exec-failure-threshold must be at least 1")
+		}
+	}
 
-	if *flHTTPBind != "" {
-		ln, err := net.Listen("tcp", *flHTTPBind)
-		if err != nil {
-			handleError(false, "ERROR: unable to bind HTTP endpoint: %v", err)
+	if *flFsckInterval < 0 {
+		handleError(true, "ERROR: --fsck-interval must be at least 0")
+	}
+	if *flFsckInterval > 0 && *flFsckTimeout <= 0 {
+		handleError(true, "ERROR: --fsck-timeout must be greater than 0")
+	}
+
+	if *flSpotCheckInterval < 0 {
+		handleError(true, "ERROR: --spot-check-interval must be at least 0")
+	}
+	if *flSpotCheckInterval > 0 {
+		if *flSpotCheckFiles < 0 {
+			handleError(true, "ERROR: --spot-check-files must be at least 0")
 		}
-		mux := http.NewServeMux()
-		go func() {
-			if *flHTTPMetrics {
-				mux.Handle("/metrics", promhttp.Handler())
-			}
+		if *flBlueGreen {
+			handleError(false, "ERROR: --spot-check-interval can't be used with --blue-green")
+		}
+		if *flSpotCheckRepair && *flPublishWithoutGitdir {
+			handleError(false, "ERROR: --spot-check-repair can't be used with --publish-without-gitdir, which leaves nothing to check out with")
+		}
+	}
 
-			if *flHTTPprof {
-				mux.HandleFunc("/debug/pprof/", pprof.Index)
-				mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-				mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-				mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-				mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	if labels, err := parseMetricLabels(flMetricLabels.items); err != nil {
+		handleError(true, "ERROR: invalid --metric-labels: %v", err)
+	} else {
+		if *flMetricRefLabel {
+			if *flSource != sourceRepo || len(flRefFallbacks.items) != 0 {
+				handleError(false, "ERROR: --metric-ref-label requires a fixed --branch or --rev")
+			}
+			if _, found := labels[metricRefLabel]; found {
+				handleError(true, "ERROR: only one of --metric-ref-label and --metric-labels=%s=... may be specified", metricRefLabel)
 			}
+			labels[metricRefLabel] = *flRev
+			if *flRev == "HEAD" {
+				labels[metricRefLabel] = *flBranch
+			}
+		}
+		metricsGatherer = newLabeledGatherer(prometheus.DefaultGatherer, labels)
+	}
 
-			// This is a dumb liveliness check endpoint. Currently this checks
+	if *flMetricsTextfile != "" {
+		if !filepath.IsAbs(*flMetricsTextfile) {
+			handleError(true, "ERROR: --metrics-textfile must be an absolute path")
+		}
+		if fi, err := os.Stat(filepath.Dir(*flMetricsTextfile)); err != nil || !fi.IsDir() {
+			handleError(false, "ERROR: the directory of --metrics-textfile must exist")
+		}
+	}
+	if *flMetricsPushgateway != "" {
+		if !isHTTPURL(*flMetricsPushgateway) {
+			handleError(true, "ERROR: --metrics-pushgateway must be an http or https URL")
+		}
+		if *flMetricsPushgatewayJob == "" {
+			handleError(true, "ERROR: --metrics-pushgateway-job must be specified")
+		}
+	}
+
+	if *flLsRemoteCacheTTL < 0 {
+		handleError(true, "ERROR: --ls-remote-cache-ttl must be at least 0")
+	}
+
+	if *flReferenceRepo != "" && !filepath.IsAbs(*flReferenceRepo) {
+		handleError(true, "ERROR: --reference-repo must be an absolute path")
+	}
+
+	if *flGitConfigFile != "" {
+		if !filepath.IsAbs(*flGitConfigFile) {
+			handleError(true, "ERROR: --git-config-file must be an absolute path")
+		}
+		// Under --root, it must stay out of the way of the repo and the
+		// worktrees, which clearRoot removes around it.
+		if rel, err := relToRoot(*flRoot, *flGitConfigFile); err == nil && !strings.HasPrefix(rel, "..") {
+			switch top := strings.SplitN(rel, string(filepath.Separator), 2)[0]; {
+			case rel == ".", top == ".git", top == *flDest, top == rootCloneDir, top == rootLockFile, top == rootLeaderFile, isWorktreeName(top):
+				handleError(true, "ERROR: --git-config-file must not be --root, or take the place of the repo, --dest, or a worktree under it")
+			}
+		}
+	}
+
+	if *flNSSWrapperLib != "" {
+		if !*flAddUser {
+			handleError(true, "ERROR: --nss-wrapper-lib requires --add-user")
+		}
+		if *flPasswdFile == systemPasswdFile {
+			handleError(true, "ERROR: --nss-wrapper-lib requires --passwd-file to be a writable copy of %s", systemPasswdFile)
+		}
+		if !filepath.IsAbs(*flNSSWrapperLib) || !filepath.IsAbs(*flPasswdFile) {
+			handleError(true, "ERROR: --nss-wrapper-lib and --passwd-file must be absolute paths")
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"http-read-timeout", *flHTTPReadTimeout},
+		{"http-write-timeout", *flHTTPWriteTimeout},
+		{"http-idle-timeout", *flHTTPIdleTimeout},
+	} {
+		if f.value < 0 {
+			handleError(true, "ERROR: --%s must be at least 0", f.name)
+		}
+	}
+	if (*flHTTPTLSCert == "") != (*flHTTPTLSKey == "") {
+		handleError(true, "ERROR: --http-tls-cert and --http-tls-key must be specified together")
+	}
+	if *flHTTPTLSCert != "" {
+		if *flHTTPBind == "" && *flGRPCBind == "" {
+			handleError(true, "ERROR: --http-tls-cert requires --http-bind or --grpc-bind")
+		}
+		if err := httpCert.load(*flHTTPTLSCert, *flHTTPTLSKey); err != nil {
+			handleError(false, "ERROR: can't load --http-tls-cert and --http-tls-key: %v", err)
+		}
+	}
+	if *flHTTPTokenFile != "" {
+		if *flHTTPBind == "" && *flGRPCBind == "" {
+			handleError(true, "ERROR: --http-token-file requires --http-bind or --grpc-bind")
+		}
+		if err := apiToken.load(*flHTTPTokenFile); err != nil {
+			handleError(false, "ERROR: can't read --http-token-file: %v", err)
+		}
+	}
+	if *flSCMWebhookSecretFile != "" {
+		if *flHTTPBind == "" {
+			handleError(true, "ERROR: --scm-webhook-secret-file requires --http-bind")
+		}
+		if err := scmSecret.load(*flSCMWebhookSecretFile); err != nil {
+			handleError(false, "ERROR: can't read --scm-webhook-secret-file: %v", err)
+		}
+	}
+	if *flHTTPFiles && *flHTTPBind == "" {
+		handleError(true, "ERROR: --http-files requires --http-bind")
+	}
+	if *flHTTPFilesListing && !*flHTTPFiles {
+		handleError(true, "ERROR: --http-files-listing requires --http-files")
+	}
+
+	if *flCheckoutWorkers < 0 {
+		handleError(true, "ERROR: --checkout-workers must be at least 0")
+	}
+	if *flCheckoutParallelThreshold < 0 {
+		handleError(true, "ERROR: --checkout-parallel-threshold must be at least 0")
+	}
+
+	if *flMaxRefAge < 0 {
+		handleError(true, "ERROR: --max-ref-age must be at least 0")
+	}
+	if *flMaxRefAgeUnready && *flMaxRefAge == 0 {
+		handleError(true, "ERROR: --max-ref-age-unready requires --max-ref-age")
+	}
+
+	for _, specs := range [][]string{flSkipTrailers.items, flMetricTrailers.items} {
+		if _, err := parseTrailerMatches(specs); err != nil {
+			handleError(true, "ERROR: invalid --skip-trailer or --metric-trailer: %v", err)
+		}
+	}
+	if *flRejectHashesFile != "" {
+		if _, err := os.Stat(*flRejectHashesFile); err != nil {
+			handleError(false, "ERROR: can't access --reject-hashes-file: %v", err)
+		}
+	}
+
+	if *flMatchRootGroup && !canMatchRootGroup {
+		handleError(false, "ERROR: --match-root-group is not supported on %s", runtime.GOOS)
+	}
+
+	if *flLockTimeout < 0 {
+		handleError(true, "ERROR: --lock-timeout must be greater than or equal to 0")
+	}
+	if *flLockTimeout > 0 && !canLockRoot {
+		handleError(false, "ERROR: --lock-timeout is not supported on %s", runtime.GOOS)
+	}
+
+	if *flGitHooksPath != "" && gitConfigSetsHooksPath(*flGitConfig) {
+		handleError(true, "ERROR: --git-hooks-path overrides core.hooksPath in --git-config; set --git-hooks-path instead")
+	}
+
+	if validating {
+		finishValidation()
+	}
+
+	if *flNSSWrapperLib != "" {
+		if err := setupNSSWrapper(*flNSSWrapperLib, *flPasswdFile); err != nil {
+			handleError(false, "ERROR: can't set up nss_wrapper: %v", err)
+		}
+	}
+	if *flAddUser {
+		if err := addUser(*flPasswdFile); err != nil {
+			handleError(false, "ERROR: can't write to %s: %v", *flPasswdFile, err)
+		}
+	}
+
+	if *flGitConfigFile != "" {
+		if err := setupGitConfigFile(*flGitConfigFile); err != nil {
+			handleError(false, "ERROR: can't use --git-config-file: %v", err)
+		}
+	}
+
+	if err := setupGitConfigParameters(containmentConfigs()); err != nil {
+		handleError(false, "ERROR: can't configure git: %v", err)
+	}
+
+	if *flGitSandbox {
+		if canSandboxProcess {
+			if err := sandboxProcess(); err != nil {
+				handleError(false, "ERROR: can't sandbox subprocesses: %v", err)
+			}
+		} else {
+			log.V(0).Info("--git-sandbox only restricts the environment on this OS", "os", runtime.GOOS)
+		}
+	}
+
+	// The first sync's window includes setting up credentials.
+	if *flWaitForFirstSync > 0 || *flFirstSyncFile != "" {
+		firstSyncWait = newFirstSyncWaiter(*flWaitForFirstSync, *flFirstSyncFile, abortFirstSync)
+	}
+
+	// This context is used only for git credentials initialization. There are no long-running operations like
+	// `git clone`, so initTimeout set to 30 seconds should be enough.
+	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+
+	if *flCredentialStore == credentialStoreMemory {
+		if err := memoryCredentials.start(); err != nil {
+			handleError(false, "ERROR: can't set up the credential store: %v", err)
+		}
+	}
+
+	if *flUsername != "" {
+		if *flPasswordFile != "" {
+			passwordFileBytes, err := ioutil.ReadFile(*flPasswordFile)
+			if err != nil {
+				log.Error(err, "ERROR: can't read password file")
+				os.Exit(1)
+			}
+			*flPassword = string(passwordFileBytes)
+		}
+		// Registry credentials are sent by git-sync itself.
+		if *flSource == sourceRepo {
+			if err := setupGitAuth(ctx, *flUsername, *flPassword, *flRepo); err != nil {
+				handleError(false, "ERROR: can't create .netrc file: %v", err)
+			}
+		}
+	}
+
+	for _, c := range hostCredentials {
+		if err := storeHostCredential(ctx, c); err != nil {
+			handleError(false, "ERROR: can't store --credential for %s: %v", c.url, err)
+		}
+	}
+
+	if *flSSH {
+		if err := setupGitSSH(*flSSHKnownHosts); err != nil {
+			handleError(false, "ERROR: can't configure SSH: %v", err)
+		}
+	}
+
+	if *flSSHControlPersist > 0 {
+		if err := setupSSHMultiplexing(*flSSHControlPersist); err != nil {
+			handleError(false, "ERROR: can't set up SSH connection sharing: %v", err)
+		}
+	}
+
+	if *flCookieFile {
+		if err := setupGitCookieFile(ctx); err != nil {
+			handleError(false, "ERROR: can't set git cookie file: %v", err)
+		}
+	}
+
+	if *flAskPassURL != "" {
+		if err := callGitAskPassURL(ctx, *flAskPassURL); err != nil {
+			askpassCount.WithLabelValues(metricKeyError).Inc()
+			handleError(false, "ERROR: failed to call ASKPASS callback URL: %v", err)
+		}
+		askpassCount.WithLabelValues(metricKeySuccess).Inc()
+	}
+
+	if *flAuthProvider != "" {
+		if err := callAuthProvider(ctx); err != nil {
+			handleError(false, "ERROR: %v", err)
+		}
+	}
+
+	if *flKubeEvents {
+		r, err := newInClusterEventRecorder(ctx)
+		if err != nil {
+			handleError(false, "ERROR: can't set up Kubernetes events: %v", err)
+		}
+		kubeEvents = r
+	}
+
+	switch *flLeaderElection {
+	case leaderElectionFile:
+		leader = newLeadership(newFileElector(*flRoot), *flLeaderElectionLeaseDuration)
+	case leaderElectionLease:
+		c, err := newInClusterClient()
+		if err != nil {
+			handleError(false, "ERROR: can't set up leader election: %v", err)
+		}
+		leader = newLeadership(newLeaseElector(c, *flLeaderElectionLeaseName, *flLeaderElectionLeaseDuration), *flLeaderElectionLeaseDuration)
+	}
+
+	if err := setupGitTransportConfigs(ctx); err != nil {
+		handleError(false, "ERROR: can't set git transport configs: %v", err)
+	}
+
+	if err := setupURLRewrites(ctx, flURLRewrites.items); err != nil {
+		handleError(false, "ERROR: can't set URL rewrites: %v", err)
+	}
+
+	// This needs to be after all other git-related config flags.
+	if *flGitConfig != "" {
+		if err := setupExtraGitConfigs(ctx, *flGitConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: can't set additional git configs: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The scope of the initialization context ends here, so we call cancel to release resources associated with it.
+	cancel()
+
+	if *flDryRun {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*flSyncTimeout))
+		var plan dryRunPlan
+		err := dnsPins.resolve(ctx, *flRepo, *flDNSPolicy)
+		if err == nil {
+			// The same credentials as a sync, so that they are checked too.
+			err = setupSyncCreds(ctx, *flAskPassURL)
+		}
+		if err == nil && len(flRefFallbacks.items) != 0 {
+			err = selectRefFallback(ctx)
+		}
+		if err == nil {
+			plan, err = planSync(ctx, *flRepo, *flBranch, *flRev, *flDepth, *flRoot, *flDest)
+		}
+		cancel()
+		if err != nil {
+			handleError(false, "ERROR: dry run failed: %v", err)
+		}
+		if err := writeDryRunPlan(os.Stdout, plan); err != nil {
+			handleError(false, "ERROR: can't write dry run: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	// Startup webhooks goroutine
+	var webhook *Webhook
+	if *flWebhookURL != "" {
+		webhook = &Webhook{
+			URL:         *flWebhookURL,
+			Method:      *flWebhookMethod,
+			Success:     *flWebhookStatusSuccess,
+			Timeout:     *flWebhookTimeout,
+			Backoff:     *flWebhookBackoff,
+			MaxBackoff:  *flWebhookBackoffMax,
+			MaxRetries:  *flWebhookMaxRetries,
+			Template:    webhookTemplate,
+			ContentType: *flWebhookContentType,
+			Data:        NewWebhookData(),
+		}
+		go webhook.run()
+	}
+
+	if *flHTTPBind != "" {
+		ln, err := net.Listen("tcp", *flHTTPBind)
+		if err != nil {
+			handleError(false, "ERROR: unable to bind HTTP endpoint: %v", err)
+		}
+		mux := http.NewServeMux()
+		go func() {
+			if *flHTTPMetrics {
+				mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+					promhttp.HandlerFor(metricsGatherer, promhttp.HandlerOpts{})))
+			}
+
+			if *flHTTPprof {
+				mux.HandleFunc("/debug/pprof/", pprof.Index)
+				mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+				mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+				mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+				mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+			}
+
+			mux.HandleFunc("/api/v1/events", serveEvents)
+			mux.HandleFunc("/api/v1/status", serveStatus)
+			mux.HandleFunc("/api/v1/version", serveVersion)
+
+			if *flAuditLogFile != "" {
+				mux.HandleFunc("/api/v1/audit", serveAudit)
+			}
+			if *flWebhookDeadLetterFile != "" {
+				mux.HandleFunc(webhookDeadLetterPath, serveDeadLetters)
+			}
+			if *flSCMWebhookSecretFile != "" {
+				mux.HandleFunc(scmWebhookPath, serveSCMWebhook)
+			}
+			if *flHTTPFiles {
+				mux.HandleFunc(contentPrefix, serveContent)
+			}
+
+			if *flHTTPAdmin {
+				mux.HandleFunc("/admin/rollback", func(w http.ResponseWriter, r *http.Request) {
+					serveRollback(w, r, webhook)
+				})
+				mux.HandleFunc("/admin/release", serveRelease)
+				mux.HandleFunc("/api/v1/pause", servePause)
+				mux.HandleFunc("/api/v1/resume", serveResume)
+			}
+
+			// This is a dumb liveliness check endpoint. Currently this checks
 			// nothing and will always return 200 if the process is live.
 			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 				if !getRepoReady() {
 					http.Error(w, "repo is not ready", http.StatusServiceUnavailable)
+					return
+				}
+				if *flMaxRefAgeUnready && getUpstreamStale() {
+					http.Error(w, "upstream is stale", http.StatusServiceUnavailable)
+					return
+				}
+				if *flHealthExecCommand != "" && !health.healthy() {
+					http.Error(w, "health check is failing", http.StatusServiceUnavailable)
+					return
+				}
+				if *flTamperCheck && getTamperSuspected() {
+					http.Error(w, "published worktree may have been tampered with", http.StatusServiceUnavailable)
+					return
 				}
 				// Otherwise success
 			})
-			http.Serve(ln, mux)
+			srv := httpServer(apiToken.requireToken(mux))
+			var err error
+			if *flHTTPTLSCert != "" {
+				srv.TLSConfig = &tls.Config{GetCertificate: httpCert.getCertificate}
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			log.Error(err, "HTTP endpoint stopped")
 		}()
 	}
 
-	// From here on, output goes through logging.
-	log.V(0).Info("starting up", "pid", os.Getpid(), "args", os.Args)
+	if *flGRPCBind != "" {
+		ln, err := net.Listen("tcp", *flGRPCBind)
+		if err != nil {
+			handleError(false, "ERROR: unable to bind gRPC endpoint: %v", err)
+		}
+		go func() {
+			err := serveGRPCEndpoint(ln)
+			log.Error(err, "gRPC endpoint stopped")
+		}()
+	}
 
-	// Startup webhooks goroutine
-	var webhook *Webhook
-	if *flWebhookURL != "" {
-		webhook = &Webhook{
-			URL:     *flWebhookURL,
-			Method:  *flWebhookMethod,
-			Success: *flWebhookStatusSuccess,
-			Timeout: *flWebhookTimeout,
-			Backoff: *flWebhookBackoff,
-			Data:    NewWebhookData(),
+	// From here on, output goes through logging.
+	secrets.register(*flPassword)
+	log.V(0).Info("starting up", "pid", os.Getpid(), "args", secrets.redactAll(os.Args))
+	if repoInfo.kind != "" {
+		log.V(0).Info("parsed repo", "url", repoInfo.String(), "kind", repoInfo.kind)
+		if path := localRepoPath(); path != "" {
+			if err := checkLocalRepo(path); err != nil {
+				log.V(0).Info("WARNING: the local repo can't be synced yet", "path", path, "error", err.Error())
+			}
+			if flags := localRepoCredentialFlags(); len(flags) != 0 {
+				log.V(0).Info("WARNING: git doesn't use credentials for a local repo, so these flags only matter to a --git wrapper", "flags", flags)
+			}
 		}
-		go webhook.run()
+		for _, w := range repoInfo.warnings() {
+			log.V(0).Info("--repo may not be what was meant", "reason", w)
+		}
+	}
+
+	if *flVerify {
+		runVerify(*flRepo, *flBranch, *flRev, *flDepth, *flRoot, *flDest)
+	}
+
+	var reloader *fileReloader
+	if *flReloadFiles {
+		reloader = newFileReloader()
+		go reloader.run(context.Background())
 	}
 
+	// source is what is being synced, for logs and events.
+	source := *flRepo
+	if *flSource == sourceOCI {
+		source = *flOCIRef
+	}
+
+	if *flSyncHookCommand != "" && *flSyncHookPolicy != hookPolicyInline {
+		hooks = newHookQueue(*flSyncHookPolicy, *flSyncHookParallelism)
+	}
+	if *flHealthExecCommand != "" {
+		go health.run(*flRoot, *flDest)
+	}
+	if *flFsckInterval > 0 {
+		go runFsck(*flRoot)
+	}
+	if *flSpotCheckInterval > 0 {
+		go runSpotChecks(*flRoot, *flDest)
+	}
+	if canCheckFreeSpace {
+		logFilesystem(*flRoot)
+	}
+
+	if leader != nil {
+		// Try once before the first sync, so a lone git-sync doesn't wait.
+		ctx, cancel := context.WithTimeout(context.Background(), *flLeaderElectionLeaseDuration)
+		leader.check(ctx, time.Now())
+		cancel()
+		go leader.run()
+	}
+
+	go triggerOnSignal()
+
 	initialSync := true
 	failCount := 0
+	// trigger is what started the next sync.
+	trigger := triggerStartup
 	for {
+		if maxRuntimeReached(*flMaxRuntime, time.Now()) {
+			endRuntime()
+		}
+
+		if reloader != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+			reloader.check(ctx)
+			cancel()
+		}
+
+		if !leader.isLeader() {
+			// Another git-sync is syncing this root; serve what it
+			// publishes.
+			loopState.enter(stateIdle)
+			if !getRepoReady() {
+				if _, err := os.Stat(filepath.Join(*flRoot, *flDest)); err == nil {
+					setRepoReady()
+					firstSyncWait.done(time.Now())
+				}
+			}
+			trigger = waitForSync(waitTime(*flWait))
+			continue
+		}
+
+		if pauses.mode(*flRoot) == pauseAll {
+			loopState.enter(stateIdle)
+			log.V(1).Info("syncing is paused", "wait_time", waitTime(*flWait))
+			trigger = waitForSync(waitTime(*flWait))
+			continue
+		}
+
 		start := time.Now()
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*flSyncTimeout))
-		if changed, hash, err := syncRepo(ctx, *flRepo, *flBranch, *flRev, *flDepth, *flRoot, *flDest, *flAskPassURL, *flSubmodules); err != nil {
+		ctx, cancel := leader.syncContext(time.Second * time.Duration(*flSyncTimeout))
+		syncLock.Lock()
+		loopState.enter(stateResolving)
+		emitEvent(eventSyncStart, "", nil)
+		spanCtx, span := startSpan(ctx, "sync", "repo", source, "branch", *flBranch, "rev", *flRev)
+		spanCtx, summary := withSyncSummary(spanCtx)
+		spanCtx = withSyncState(spanCtx, loopState)
+		spanCtx = withSyncTrigger(spanCtx, trigger)
+		changed, hash := false, ""
+		unlockRoot, err := lockRoot(*flRoot, *flLockTimeout)
+		if err == nil {
+			err = recoverJournal(spanCtx, *flRoot, *flDest)
+		}
+		if err == nil {
+			err = dnsPins.resolve(spanCtx, *flRepo, *flDNSPolicy)
+		}
+		if err == nil && len(flRefFallbacks.items) != 0 {
+			err = selectRefFallback(spanCtx)
+		}
+		if err == nil {
+			changed, hash, err = syncRepo(spanCtx, *flRepo, *flBranch, *flRev, *flDepth, *flRoot, *flDest, *flAskPassURL, *flSubmodules)
+		}
+		if isAuthError(err) && canRefreshCreds() {
+			// The credentials may have expired, so get new ones and try
+			// once more, rather than waiting for the next sync.
+			log.V(0).Info("authentication failed, refreshing credentials and retrying", "error", err.Error())
+			if rerr := refreshCreds(spanCtx); rerr != nil {
+				credentialRefreshCount.WithLabelValues(metricKeyError).Inc()
+				log.Error(rerr, "can't refresh credentials")
+			} else {
+				credentialRefreshCount.WithLabelValues(metricKeySuccess).Inc()
+				changed, hash, err = syncRepo(spanCtx, *flRepo, *flBranch, *flRev, *flDepth, *flRoot, *flDest, *flAskPassURL, *flSubmodules)
+			}
+		}
+		if err == nil && *flBlueGreen {
+			err = reconcileBlueGreen(*flRoot, *flDest)
+		}
+		span.setAttr("changed", changed)
+		span.setAttr("hash", hash)
+		span.finish(err)
+		syncStatus.record(changed, err, time.Now())
+		result := metricKeyNoOp
+		switch {
+		case err != nil:
+			result = metricKeyError
+		case changed:
+			result = metricKeySuccess
+		}
+		summaryHash := hash
+		if summaryHash == "" {
+			summaryHash = upstreamHash
+		}
+		summary.log(spanCtx, *flRoot, result, summaryHash, time.Since(start), err)
+		if err == nil && *flMaxRefAge > 0 {
+			checkUpstreamAge(spanCtx, *flRoot, upstreamHash, *flMaxRefAge, time.Now())
+		}
+		if err == nil && *flTamperCheck {
+			checkTamper(spanCtx, *flRoot, *flDest, upstreamHash)
+		}
+		unlockRoot()
+		syncLock.Unlock()
+		if err != nil && !leader.isLeader() {
+			// The sync was canceled because another git-sync took over,
+			// which is not a failure.
+			log.V(0).Info("lost leadership during a sync, abandoning it", "error", err.Error())
+			loopState.enter(stateIdle)
+			cancel()
+			continue
+		}
+		if err != nil {
+			loopState.enter(stateBackoff)
+			emitEvent(eventError, "", err)
 			updateSyncMetrics(metricKeyError, start)
+			if wait, ok := backpressure.next(err, waitTime(*flWait), *flMaxBackpressureWait); ok {
+				// This doesn't count towards --max-sync-failures, and a
+				// trigger can't cut it short.
+				log.V(0).Info("upstream is throttling, backing off", "error", err.Error(), "waitTime", wait)
+				cancel()
+				time.Sleep(wait)
+				trigger = triggerPoll
+				continue
+			}
 			if *flMaxSyncFailures != -1 && failCount >= *flMaxSyncFailures {
 				// Exit after too many retries, maybe the error is not recoverable.
-				log.Error(err, "too many failures, aborting", "failCount", failCount)
-				os.Exit(1)
+				code := exitCodeFor(err)
+				log.Error(err, "too many failures, aborting", "failCount", failCount, "exitCode", code)
+				exportFinalMetrics()
+				os.Exit(code)
 			}
 
 			failCount++
+			if failCount == *flKubeEventsFailureThreshold {
+				recordKubeEvent(kubeEventWarning, kubeReasonSyncFailing, "%d consecutive sync failures, last error: %v", failCount, err)
+			}
 			log.Error(err, "unexpected error syncing repo, will retry")
 			log.V(0).Info("waiting before retrying", "waitTime", waitTime(*flWait))
 			cancel()
-			time.Sleep(waitTime(*flWait))
+			trigger = waitForSync(waitTime(*flWait))
 			continue
 		} else if changed {
 			if webhook != nil {
@@ -572,26 +2061,45 @@ func main() {
 		}
 
 		if initialSync {
+			recordKubeEvent(kubeEventNormal, kubeReasonFirstSync, "first sync of %s succeeded", source)
+			firstSyncWait.done(time.Now())
 			if *flOneTime {
 				log.deleteErrorFile()
+				exportFinalMetrics()
 				os.Exit(0)
 			}
-			if isHash, err := revIsHash(ctx, *flRev, *flRoot); err != nil {
-				log.Error(err, "can't tell if rev is a git hash, exiting", "rev", *flRev)
+			if isHash, err := backend.revIsHash(ctx, *flRev, *flRoot); err != nil {
+				log.Error(err, "can't tell if rev is a hash, exiting", "rev", *flRev)
 				os.Exit(1)
 			} else if isHash {
-				log.V(0).Info("rev appears to be a git hash, no further sync needed", "rev", *flRev)
+				log.V(0).Info("rev appears to be a hash, no further sync needed", "rev", *flRev)
 				log.deleteErrorFile()
+				loopState.enter(stateIdle)
 				sleepForever()
 			}
 			initialSync = false
 		}
 
+		if retainingWorktrees() {
+			loopState.enter(stateCleaning)
+			if unlockRoot, err := lockRoot(*flRoot, *flLockTimeout); err != nil {
+				log.Error(err, "can't clean up stale worktrees")
+			} else {
+				if err := cleanupStaleWorktrees(ctx, *flRoot, *flDest, *flStaleWorktreeTimeout, *flStaleWorktreeMaxCount); err != nil {
+					log.Error(err, "can't clean up stale worktrees")
+				}
+				unlockRoot()
+			}
+		}
+
 		failCount = 0
+		backpressure.reset()
 		log.deleteErrorFile()
-		log.V(1).Info("next sync", "wait_time", waitTime(*flWait))
+		loopState.enter(stateIdle)
+		wait := nextSyncWait(time.Now())
+		log.V(1).Info("next sync", "wait_time", wait)
 		cancel()
-		time.Sleep(waitTime(*flWait))
+		trigger = waitForSync(wait)
 	}
 }
 
@@ -617,6 +2125,10 @@ func sleepForever() {
 // exports the error to the error file and exits the process with the exit code.
 func handleError(printUsage bool, format string, a ...interface{}) {
 	s := fmt.Sprintf(format, a...)
+	if validating {
+		validationErrors = append(validationErrors, s)
+		return
+	}
 	fmt.Fprintln(os.Stderr, s)
 	if printUsage {
 		flag.Usage()
@@ -625,60 +2137,8 @@ func handleError(printUsage bool, format string, a ...interface{}) {
 	os.Exit(1)
 }
 
-// Put the current UID/GID into /etc/passwd so SSH can look it up.  This
-// assumes that we have the permissions to write to it.
-func addUser() error {
-	home := os.Getenv("HOME")
-	if home == "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("can't get working directory and $HOME is not set: %w", err)
-		}
-		home = cwd
-	}
-
-	f, err := os.OpenFile("/etc/passwd", os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	str := fmt.Sprintf("git-sync:x:%d:%d::%s:/sbin/nologin\n", os.Getuid(), os.Getgid(), home)
-	_, err = f.WriteString(str)
-	return err
-}
-
-// updateSymlink atomically swaps the symlink to point at the specified
-// directory and cleans up the previous worktree.  If there was a previous
-// worktree, this returns the path to it.
-func updateSymlink(ctx context.Context, gitRoot, link, newDir string) (string, error) {
-	// Get currently-linked repo directory (to be removed), unless it doesn't exist
-	linkPath := filepath.Join(gitRoot, link)
-	oldWorktreePath, err := filepath.EvalSymlinks(linkPath)
-	if err != nil && !os.IsNotExist(err) {
-		return "", fmt.Errorf("error accessing current worktree: %v", err)
-	}
-
-	// newDir is absolute, so we need to change it to a relative path.  This is
-	// so it can be volume-mounted at another path and the symlink still works.
-	newDirRelative, err := filepath.Rel(gitRoot, newDir)
-	if err != nil {
-		return "", fmt.Errorf("error converting to relative path: %v", err)
-	}
-
-	const tmplink = "tmp-link"
-	log.V(1).Info("creating tmp symlink", "root", gitRoot, "dst", newDirRelative, "src", tmplink)
-	if _, err := runCommand(ctx, gitRoot, "ln", "-snf", newDirRelative, tmplink); err != nil {
-		return "", fmt.Errorf("error creating symlink: %v", err)
-	}
-
-	log.V(1).Info("renaming symlink", "root", gitRoot, "old_name", tmplink, "new_name", link)
-	if _, err := runCommand(ctx, gitRoot, "mv", "-T", tmplink, link); err != nil {
-		return "", fmt.Errorf("error replacing symlink: %v", err)
-	}
-
-	return oldWorktreePath, nil
-}
+// syncLock serializes changes to the root, such as syncs and rollbacks.
+var syncLock sync.Mutex
 
 // repoReady indicates that the repo has been cloned and synced.
 var readyLock sync.Mutex
@@ -700,45 +2160,211 @@ func setRepoReady() {
 func cleanupWorkTree(ctx context.Context, gitRoot, worktree string) error {
 	// Clean up worktree(s)
 	log.V(1).Info("removing worktree", "path", worktree)
-	if err := os.RemoveAll(worktree); err != nil {
-		return fmt.Errorf("error removing directory: %v", err)
-	} else if _, err := runCommand(ctx, gitRoot, *flGitCmd, "worktree", "prune"); err != nil {
+	standby.forget(worktree)
+	if *flByHashDir != "" {
+		if err := removeByHashLink(gitRoot, *flByHashDir, worktree); err != nil {
+			log.Error(err, "can't remove by-hash link", "path", worktree)
+		}
+	}
+	if *flPreviousLink != "" {
+		if err := removePreviousLink(gitRoot, *flPreviousLink, worktree); err != nil {
+			log.Error(err, "can't remove previous link", "path", worktree)
+		}
+	}
+	return backend.removeWorktree(ctx, gitRoot, worktree)
+}
+
+// addWorktreeAndSwap creates a new worktree and calls publishWorktree to swap the symlink to point to the new worktree
+func addWorktreeAndSwap(ctx context.Context, gitRoot, dest, branch, rev string, depth int, hash string, submoduleMode string) error {
+	log.V(0).Info("syncing repo", "vcs", *flVCS, "rev", rev, "hash", hash)
+
+	if err := checkFreeSpace(gitRoot, dest); err != nil {
+		return err
+	}
+
+	// Update from the remote.
+	start := time.Now()
+	err := backend.fetch(ctx, gitRoot, branch, depth)
+	observeDuration(fetchDuration, start, err)
+	if err != nil {
+		return err
+	}
+	emitEvent(eventFetched, hash, nil)
+
+	// With shallow fetches, it's possible to race with the upstream repo and
+	// end up NOT fetching the hash we wanted. If we can't resolve that hash
+	// to a commit we can just end early and leave it for the next sync period.
+	if err := backend.hasCommit(ctx, gitRoot, hash); err != nil {
+		log.Error(err, "can't resolve commit, will retry", "rev", rev, "hash", hash)
+		return nil
+	}
+
+	if err := checkHistoryRewrite(ctx, gitRoot, dest, hash); err != nil {
+		return err
+	}
+	if err := checkRejected(ctx, gitRoot, hash); err != nil {
+		return err
+	}
+	if err := checkTrailers(ctx, gitRoot, hash); err != nil {
+		return err
+	}
+	if *flVCS == "git" && *flSource == sourceRepo {
+		if err := checkTag(ctx, gitRoot, rev, hash); err != nil {
+			return err
+		}
+		if err := checkCaseCollisions(ctx, gitRoot, hash); err != nil {
+			return err
+		}
+	}
+	syncStatus.setFetched(hash)
+
+	// Once something is published, wait for the other replicas to catch up,
+	// so that they all flip at about the same time.
+	if peerGateEnabled() {
+		if _, err := os.Lstat(filepath.Join(gitRoot, dest)); err == nil {
+			if err := waitForPeers(ctx, hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Journal what is being done, so that a crash part way through can be
+	// recovered from (see recoverJournal).
+	name, err := worktreeNameFor(gitRoot, hash)
+	if err != nil {
+		return err
+	}
+	if err := beginJournal(gitRoot, journalEntry{Op: journalCheckout, Worktree: name, Time: time.Now()}); err != nil {
+		return err
+	}
+	defer endJournal(gitRoot)
+
+	start = time.Now()
+	worktreePath, err := createWorktree(ctx, gitRoot, branch, hash, depth, submoduleMode)
+	observeDuration(checkoutDuration, start, err)
+	if err != nil {
+		return err
+	}
+
+	previous, err := publishedWorktreeName(gitRoot, dest)
+	if err != nil {
+		return err
+	}
+	if !isWorktreeName(previous) {
+		previous = ""
+	}
+	if err := beginJournal(gitRoot, journalEntry{Op: journalPublish, Worktree: filepath.Base(worktreePath), Previous: previous, Time: time.Now()}); err != nil {
+		return err
+	}
+
+	// Flip the symlink.
+	stateFrom(ctx).enter(statePublishing)
+	publishCtx, span := startSpan(ctx, "publish", "hash", hash)
+	oldWorktree, err := publishWorktree(publishCtx, gitRoot, dest, worktreePath)
+	span.finish(err)
+	if err != nil {
 		return err
 	}
-	return nil
-}
+	auditFlip(auditActionSync, syncTrigger(ctx), oldWorktree, worktreePath)
+	recordPublished(ctx, gitRoot, hash)
+	setRepoReady()
+	standby.setRolledBackFrom("")
+	emitEvent(eventPublished, hash, nil)
+	notifyConsumers(hash)
 
-// addWorktreeAndSwap creates a new worktree and calls updateSymlink to swap the symlink to point to the new worktree
-func addWorktreeAndSwap(ctx context.Context, gitRoot, dest, branch, rev string, depth int, hash string, submoduleMode string) error {
-	log.V(0).Info("syncing git", "rev", rev, "hash", hash)
+	// From here on we have to save errors until the end.
 
-	args := []string{"fetch", "-f", "--tags"}
-	if depth != 0 {
-		args = append(args, "--depth", strconv.Itoa(depth))
+	// Execute the hook command, if requested, or queue it according to
+	// --sync-hook-policy.  Save any error until after cleanup runs.
+	execErr := dispatchSyncHook(ctx, worktreePath, false)
+
+	// Clean up previous worktree(s), or keep the previous one as a standby
+	// if stale worktrees are being retained.
+	var cleanupErr error
+	if oldWorktree != "" {
+		stateFrom(ctx).enter(stateCleaning)
+		if retainingWorktrees() {
+			cleanupErr = retireWorktree(oldWorktree)
+		} else {
+			cleanupErr = cleanupWorkTree(ctx, gitRoot, oldWorktree)
+		}
 	}
-	args = append(args, "origin", branch)
 
-	// Update from the remote.
-	if _, err := runCommand(ctx, gitRoot, *flGitCmd, args...); err != nil {
-		return err
+	if cleanupErr != nil {
+		return cleanupErr
+	}
+	if execErr != nil {
+		return execErr
 	}
+	return nil
+}
 
-	// With shallow fetches, it's possible to race with the upstream repo and
-	// end up NOT fetching the hash we wanted. If we can't resolve that hash
-	// to a commit we can just end early and leave it for the next sync period.
-	if _, err := revIsHash(ctx, hash, gitRoot); err != nil {
-		log.Error(err, "can't resolve commit, will retry", "rev", rev, "hash", hash)
-		return nil
+// createWorktree creates a new worktree for hash, and checks it out.  This
+// returns the path to the new worktree.
+func createWorktree(ctx context.Context, gitRoot, branch, hash string, depth int, submoduleMode string) (string, error) {
+	stateFrom(ctx).enter(stateCheckingOut)
+	ctx, span := startSpan(ctx, "worktree", "hash", hash)
+	name, err := worktreeNameFor(gitRoot, hash)
+	if err != nil {
+		span.finish(err)
+		return "", err
+	}
+	worktreePath := filepath.Join(gitRoot, name)
+	err = backend.checkout(ctx, gitRoot, branch, hash, depth, submoduleMode, worktreePath)
+	if err == nil && *flChmod != 0 {
+		// Change the file permissions, if requested.
+		log.V(0).Info("changing file permissions", "mode", fmt.Sprintf("%#o", *flChmod))
+		err = changePermissions(ctx, worktreePath, *flChmod)
+	}
+	if err == nil && *flMatchRootGroup {
+		err = matchRootGroup(gitRoot, worktreePath)
+	}
+	if err == nil && *flPublishWithoutGitdir {
+		log.V(1).Info("removing git metadata from worktree", "path", worktreePath)
+		err = removeGitMetadata(worktreePath)
 	}
+	span.finish(err)
+	return worktreePath, err
+}
 
-	// GC clone
-	if _, err := runCommand(ctx, gitRoot, *flGitCmd, "gc", "--prune=all"); err != nil {
-		return err
+// matchRootGroup makes worktreePath owned by, and readable by, the group
+// which owns gitRoot.  With a pod fsGroup, that is the group that other
+// containers sharing the volume are in.
+func matchRootGroup(gitRoot, worktreePath string) error {
+	gid, err := pathGroup(gitRoot)
+	if err != nil {
+		return fmt.Errorf("can't get the group of the root: %v", err)
+	}
+	log.V(1).Info("matching worktree group to root", "path", worktreePath, "gid", gid)
+	if err := matchGroup(worktreePath, gid); err != nil {
+		return fmt.Errorf("can't change the group of the worktree: %v", err)
 	}
+	return nil
+}
 
-	// Make a worktree for this exact git hash.
-	worktreePath := filepath.Join(gitRoot, hash)
+// removeGitMetadata removes all .git files and directories (the worktree's own
+// and any submodules') under dir.  Git never tracks paths named .git, so
+// these are all metadata.  Once they are gone, git no longer knows dir is a
+// worktree, and `git worktree prune` forgets it.
+func removeGitMetadata(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() != ".git" {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
 
+func checkoutWorktree(ctx context.Context, gitRoot, branch, hash string, depth int, submoduleMode, worktreePath string) error {
 	// Avoid wedge cases where the worktree was created but this function error'd without cleaning the worktree.
 	// Next timearound, the sync loop fails to create the worktree and bails out.
 	// Error observed:
@@ -749,10 +2375,15 @@ func addWorktreeAndSwap(ctx context.Context, gitRoot, dest, branch, rev string,
 		return err
 	}
 
-	_, err := runCommand(ctx, gitRoot, *flGitCmd, "worktree", "add", worktreePath, "origin/"+branch, "--no-checkout")
+	// Everything up to the submodules counts towards --checkout-timeout.
+	checkoutCtx, cancel := stageContext(ctx, *flCheckoutTimeout)
+	defer cancel()
+	checkoutStart := time.Now()
+
+	_, err := runCommand(checkoutCtx, gitRoot, *flGitCmd, "worktree", "add", worktreePath, "origin/"+branch, "--no-checkout")
 	log.V(0).Info("adding worktree", "path", worktreePath, "branch", fmt.Sprintf("origin/%s", branch))
 	if err != nil {
-		return err
+		return stageError(ctx, checkoutCtx, stageCheckout, *flCheckoutTimeout, err)
 	}
 
 	// The .git file in the worktree directory holds a reference to
@@ -763,135 +2394,191 @@ func addWorktreeAndSwap(ctx context.Context, gitRoot, dest, branch, rev string,
 	if err != nil {
 		return err
 	}
-	gitDirRef := []byte(filepath.Join("gitdir: ../.git/worktrees", worktreePathRelative) + "\n")
+	gitDirRef := []byte(filepath.ToSlash(filepath.Join("gitdir: ../.git/worktrees", worktreePathRelative)) + "\n")
 	if err = ioutil.WriteFile(filepath.Join(worktreePath, ".git"), gitDirRef, 0644); err != nil {
 		return err
 	}
 
-	if *flSparseCheckoutFile != "" {
+	if usingSparseCheckout() {
 		// This is required due to the undocumented behavior outlined here: https://public-inbox.org/git/CAPig+cSP0UiEBXSCi7Ua099eOdpMk8R=JtAjPuUavRF4z0R0Vg@mail.gmail.com/t/
 		log.V(0).Info("configuring worktree sparse checkout")
-		checkoutFile := *flSparseCheckoutFile
-
-		gitInfoPath := filepath.Join(gitRoot, fmt.Sprintf(".git/worktrees/%s/info", hash))
-		gitSparseConfigPath := filepath.Join(gitInfoPath, "sparse-checkout")
-
-		source, err := os.Open(checkoutFile)
-		if err != nil {
-			return err
-		}
-		defer source.Close()
-
-		if _, err := os.Stat(gitInfoPath); os.IsNotExist(err) {
-			fileMode := os.FileMode(int(0755))
-			err := os.Mkdir(gitInfoPath, fileMode)
-			if err != nil {
-				return err
-			}
-		}
-
-		destination, err := os.Create(gitSparseConfigPath)
-		if err != nil {
-			return err
-		}
-		defer destination.Close()
-		_, err = io.Copy(destination, source)
-		if err != nil {
+		gitInfoPath := filepath.Join(worktreeAdminDir(gitRoot, worktreePath), "info")
+		if err := writeSparseCheckout(gitInfoPath); err != nil {
 			return err
 		}
 
 		args := []string{"sparse-checkout", "init"}
-		_, err = runCommand(ctx, worktreePath, *flGitCmd, args...)
+		_, err = runCommand(checkoutCtx, worktreePath, *flGitCmd, args...)
 		if err != nil {
-			return err
+			return stageError(ctx, checkoutCtx, stageCheckout, *flCheckoutTimeout, err)
 		}
 	}
 
-	_, err = runCommand(ctx, worktreePath, *flGitCmd, "reset", "--hard", hash)
+	args := append(parallelCheckoutArgs(), "reset", "--hard", hash)
+	_, err = runCommand(checkoutCtx, worktreePath, *flGitCmd, args...)
 	if err != nil {
-		return err
+		return stageError(ctx, checkoutCtx, stageCheckout, *flCheckoutTimeout, err)
 	}
 	log.V(0).Info("reset worktree to hash", "path", worktreePath, "hash", hash)
 
+	if err := checkRepoConfig(checkoutCtx, gitRoot, worktreePath); err != nil {
+		return err
+	}
+	summaryFrom(ctx).addStage(stageCheckout, time.Since(checkoutStart))
+
 	// Update submodules
 	// NOTE: this works for repo with or without submodules.
 	if submoduleMode != submodulesOff {
 		log.V(0).Info("updating submodules")
-		submodulesArgs := []string{"submodule", "update", "--init"}
-		if submoduleMode == submodulesRecursive {
-			submodulesArgs = append(submodulesArgs, "--recursive")
-		}
-		if depth != 0 {
-			submodulesArgs = append(submodulesArgs, "--depth", strconv.Itoa(depth))
-		}
-		_, err = runCommand(ctx, worktreePath, *flGitCmd, submodulesArgs...)
+		err = runStage(ctx, stageSubmodule, *flSubmoduleTimeout, func(ctx context.Context) error {
+			return updateSubmodules(ctx, worktreePath, submoduleMode, depth)
+		})
 		if err != nil {
 			return err
 		}
 	}
 
-	// Change the file permissions, if requested.
-	if *flChmod != 0 {
-		mode := fmt.Sprintf("%#o", *flChmod)
-		log.V(0).Info("changing file permissions", "mode", mode)
-		_, err = runCommand(ctx, "", "chmod", "-R", mode, worktreePath)
-		if err != nil {
-			return err
-		}
+	return nil
+}
+
+// parallelCheckoutArgs returns the git options which set how many workers
+// check out files in parallel.
+func parallelCheckoutArgs() []string {
+	workers := *flCheckoutWorkers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return []string{
+		"-c", "checkout.workers=" + strconv.Itoa(workers),
+		"-c", "checkout.thresholdForParallelism=" + strconv.Itoa(*flCheckoutParallelThreshold),
 	}
+}
 
-	// Flip the symlink.
-	oldWorktree, err := updateSymlink(ctx, gitRoot, dest, worktreePath)
+// fetch runs `git fetch` with the specified args and records stats about
+// what was fetched.
+func fetch(ctx context.Context, gitRoot string, args []string) (err error) {
+	ctx, span := startSpan(ctx, "fetch")
+	defer func() { span.finish(err) }()
+
+	// The stats only feed metrics, so failing to count isn't fatal.
+	before, countErr := countObjects(ctx, gitRoot)
+	if countErr != nil {
+		log.Error(countErr, "can't count objects before fetching")
+	}
+	err = withRetries(ctx, "fetch", *flFetchRetries, *flRetryBackoff, func() error {
+		return runStage(ctx, stageFetch, *flFetchTimeout, func(ctx context.Context) error {
+			_, err := runReceiveCommand(ctx, gitRoot, args...)
+			countRoundTrip("fetch", err)
+			return err
+		})
+	})
 	if err != nil {
 		return err
 	}
-	setRepoReady()
-
-	// From here on we have to save errors until the end.
-
-	// Execute the hook command, if requested.
-	var execErr error
-	if *flSyncHookCommand != "" {
-		log.V(1).Info("executing command for git sync hooks", "command", *flSyncHookCommand)
-		if _, err := runCommand(ctx, worktreePath, *flSyncHookCommand); err != nil {
-			// Save it until after cleanup runs.
-			execErr = err
-		}
+	if countErr != nil {
+		return nil
+	}
+	after, countErr := countObjects(ctx, gitRoot)
+	if countErr != nil {
+		log.Error(countErr, "can't count objects after fetching")
+		return nil
 	}
+	recordFetchStats(ctx, before, after)
+	span.setAttr("objects", after.objects-before.objects)
+	return nil
+}
 
-	// Clean up previous worktree(s).
-	var cleanupErr error
-	if oldWorktree != "" {
-		cleanupErr = cleanupWorkTree(ctx, gitRoot, oldWorktree)
+// runSyncHook executes the --sync-hook-command, if one was specified, in the
+// specified worktree.  The hook can tell if this was a rollback from the
+// GIT_SYNC_ROLLBACK environment variable.
+func runSyncHook(ctx context.Context, worktreePath string, rollback bool) error {
+	if *flSyncHookCommand == "" {
+		return nil
+	}
+	log.V(1).Info("executing command for git sync hooks", "command", *flSyncHookCommand, "rollback", rollback)
+	ctx, span := startSpan(ctx, "sync-hook", "rollback", rollback)
+	env := []string{"GIT_SYNC_ROLLBACK=" + strconv.FormatBool(rollback)}
+	hash := worktreeHash(worktreePath)
+	info, err := getCommitInfo(ctx, filepath.Dir(worktreePath), hash)
+	if err != nil {
+		log.Error(err, "can't get commit info for sync hook", "hash", hash)
+	}
+	env = append(env, info.env()...)
+	err = syncHookRetryPolicy().run(ctx, "sync-hook", func(ctx context.Context) error {
+		return runStage(ctx, stageSyncHook, *flSyncHookTimeout, func(ctx context.Context) error {
+			return runHook(ctx, "sync-hook", hash, worktreePath, env, *flSyncHookCommand)
+		})
+	})
+	if err != nil {
+		err = hookError{err}
 	}
+	span.finish(err)
+	events.publish(syncEvent{Type: eventHookDone, Hash: hash, Rollback: rollback, Error: errorString(err)})
+	return err
+}
 
-	if cleanupErr != nil {
-		return cleanupErr
+// shallowArgs returns the flags which limit how much history clone and fetch
+// bring in: either depth commits, or --shallow-since and --shallow-exclude.
+func shallowArgs(depth int) []string {
+	args := []string{}
+	if depth != 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
 	}
-	if execErr != nil {
-		return execErr
+	if *flShallowSince != "" {
+		args = append(args, "--shallow-since", *flShallowSince)
 	}
-	return nil
+	for _, ref := range flShallowExclude.items {
+		args = append(args, "--shallow-exclude", ref)
+	}
+	return args
 }
 
 func cloneRepo(ctx context.Context, repo, branch, rev string, depth int, gitRoot string) error {
 	args := []string{"clone", "--no-checkout", "-b", branch}
-	if depth != 0 {
-		args = append(args, "--depth", strconv.Itoa(depth))
+	args = append(args, shallowArgs(depth)...)
+	if *flReferenceRepo != "" {
+		// If the reference isn't there, clone without it.
+		args = append(args, "--reference-if-able", *flReferenceRepo)
+	}
+
+	// A locked root, or one which holds --git-config-file, is never empty,
+	// and git won't clone into it, so clone next to those files and move the
+	// repo into place.
+	cloneDir := gitRoot
+	if hasRootControlFiles(gitRoot) {
+		clear, err := isClearRoot(gitRoot)
+		if err != nil {
+			return err
+		}
+		if !clear {
+			log.V(0).Info("git root exists and is not empty (previous crash?), cleaning up", "path", gitRoot)
+			recordKubeEvent(kubeEventWarning, kubeReasonRepoReinitialized, "git root %s exists and is not empty (previous crash?), re-cloning", gitRoot)
+			if err := clearRoot(gitRoot); err != nil {
+				return err
+			}
+		}
+		cloneDir = filepath.Join(gitRoot, rootCloneDir)
 	}
-	args = append(args, repo, gitRoot)
-	log.V(0).Info("cloning repo", "origin", repo, "path", gitRoot)
+	localArgs, source := localCloneArgs(repo)
+	args = append(args, localArgs...)
+	args = append(args, source, cloneDir)
 
-	_, err := runCommand(ctx, "", *flGitCmd, args...)
+	var err error
+	bundled := *flFromBundle != "" && cloneFromBundle(ctx, repo, branch, depth, cloneDir)
+	if !bundled {
+		log.V(0).Info("cloning repo", "origin", secrets.redact(repo), "path", gitRoot)
+		_, err = runReceiveCommand(ctx, "", args...)
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "already exists and is not an empty directory") {
 			// Maybe a previous run crashed?  Git won't use this dir.
 			log.V(0).Info("git root exists and is not empty (previous crash?), cleaning up", "path", gitRoot)
-			err := os.RemoveAll(gitRoot)
+			recordKubeEvent(kubeEventWarning, kubeReasonRepoReinitialized, "git root %s exists and is not empty (previous crash?), re-cloning", gitRoot)
+			err := os.RemoveAll(cloneDir)
 			if err != nil {
 				return err
 			}
-			_, err = runCommand(ctx, "", *flGitCmd, args...)
+			_, err = runReceiveCommand(ctx, "", args...)
 			if err != nil {
 				return err
 			}
@@ -899,36 +2586,26 @@ func cloneRepo(ctx context.Context, repo, branch, rev string, depth int, gitRoot
 			return err
 		}
 	}
-
-	if *flSparseCheckoutFile != "" {
-		log.V(0).Info("configuring sparse checkout")
-		checkoutFile := *flSparseCheckoutFile
-
-		gitRepoPath := filepath.Join(gitRoot, ".git")
-		gitInfoPath := filepath.Join(gitRepoPath, "info")
-		gitSparseConfigPath := filepath.Join(gitInfoPath, "sparse-checkout")
-
-		source, err := os.Open(checkoutFile)
-		if err != nil {
+	if cloneDir != gitRoot {
+		if err := os.Rename(filepath.Join(cloneDir, ".git"), filepath.Join(gitRoot, ".git")); err != nil {
 			return err
 		}
-		defer source.Close()
-
-		if _, err := os.Stat(gitInfoPath); os.IsNotExist(err) {
-			fileMode := os.FileMode(int(0755))
-			err := os.Mkdir(gitInfoPath, fileMode)
-			if err != nil {
-				return err
-			}
+		if err := os.RemoveAll(cloneDir); err != nil {
+			return err
 		}
+	}
 
-		destination, err := os.Create(gitSparseConfigPath)
-		if err != nil {
-			return err
+	if !bundled {
+		if stats, err := countObjects(ctx, gitRoot); err != nil {
+			log.Error(err, "can't count objects after cloning")
+		} else {
+			recordFetchStats(ctx, objectStats{}, stats)
 		}
-		defer destination.Close()
-		_, err = io.Copy(destination, source)
-		if err != nil {
+	}
+
+	if usingSparseCheckout() {
+		log.V(0).Info("configuring sparse checkout")
+		if err := writeSparseCheckout(filepath.Join(gitRoot, ".git", "info")); err != nil {
 			return err
 		}
 
@@ -951,16 +2628,6 @@ func localHashForRev(ctx context.Context, rev, gitRoot string) (string, error) {
 	return strings.Trim(string(output), "\n"), nil
 }
 
-// remoteHashForRef returns the upstream hash for a given ref.
-func remoteHashForRef(ctx context.Context, ref, gitRoot string) (string, error) {
-	output, err := runCommand(ctx, gitRoot, *flGitCmd, "ls-remote", "-q", "origin", ref)
-	if err != nil {
-		return "", err
-	}
-	parts := strings.Split(string(output), "\t")
-	return parts[0], nil
-}
-
 func revIsHash(ctx context.Context, rev, gitRoot string) (bool, error) {
 	// If git doesn't identify rev as a commit, we're done.
 	output, err := runCommand(ctx, gitRoot, *flGitCmd, "cat-file", "-t", rev)
@@ -986,48 +2653,122 @@ func revIsHash(ctx context.Context, rev, gitRoot string) (bool, error) {
 // syncRepo syncs the branch of a given repository to the destination at the given rev.
 // returns (1) whether a change occured, (2) the new hash, and (3) an error if one happened
 func syncRepo(ctx context.Context, repo, branch, rev string, depth int, gitRoot, dest string, authURL string, submoduleMode string) (bool, string, error) {
-	if authURL != "" {
-		// For ASKPASS Callback URL, the credentials behind is dynamic, it needs to be
-		// re-fetched each time.
-		if err := callGitAskPassURL(ctx, authURL); err != nil {
-			askpassCount.WithLabelValues(metricKeyError).Inc()
-			return false, "", fmt.Errorf("failed to call GIT_ASKPASS_URL: %v", err)
+	if err := setupSyncCreds(ctx, authURL); err != nil {
+		return false, "", err
+	}
+
+	if *flReferenceRepo != "" {
+		if err := checkReferenceRepo(ctx, gitRoot); err != nil {
+			return false, "", err
 		}
-		askpassCount.WithLabelValues(metricKeySuccess).Inc()
 	}
 
 	target := filepath.Join(gitRoot, dest)
-	gitRepoPath := filepath.Join(target, ".git")
-	var hash string
+	marker := backend.metaDir()
+	if *flPublishWithoutGitdir {
+		// Published worktrees have no metadata, so the link existing means
+		// a sync has happened.
+		marker = ""
+	}
+	gitRepoPath := filepath.Join(target, marker)
+	var hash, previous string
+	firstSync := false
 	_, err := os.Stat(gitRepoPath)
 	switch {
 	case os.IsNotExist(err):
 		// First time. Just clone it and get the hash.
-		err = cloneRepo(ctx, repo, branch, rev, depth, gitRoot)
+		firstSync = true
+		if err := checkFreeSpace(gitRoot, dest); err != nil {
+			return false, "", err
+		}
+		stateFrom(ctx).enter(stateFetching)
+		err = backend.clone(ctx, repo, branch, rev, depth, gitRoot)
 		if err != nil {
 			return false, "", err
 		}
-		hash, err = localHashForRev(ctx, rev, gitRoot)
+		hash, err = backend.localHash(ctx, branch, rev, gitRoot)
 		if err != nil {
 			return false, "", err
 		}
+		upstreamHash = hash
 	case err != nil:
 		return false, "", fmt.Errorf("error checking if repo exists %q: %v", gitRepoPath, err)
 	default:
 		// Not the first time. Figure out if the ref has changed.
-		local, remote, err := getRevs(ctx, target, branch, rev)
+		local, remote, err := backend.revs(ctx, gitRoot, target, branch, rev)
 		if err != nil {
 			return false, "", err
 		}
+		if remote == "" {
+			fallback, fallbackRemote, err := handleMissingRef(ctx, target, branch, rev)
+			if err != nil || fallback == "" {
+				return false, "", err
+			}
+			branch, rev, remote = fallback, "HEAD", fallbackRemote
+		} else {
+			setRefMissing(false, refForRev(branch, rev))
+		}
+		upstreamHash = remote
 		if local == remote {
+			pending.reset()
+			rejectedGauge.Set(0)
 			log.V(1).Info("no update required", "rev", rev, "local", local, "remote", remote)
 			return false, "", nil
 		}
+		if standby.isHeld() {
+			// Keep the clone up to date, but leave the link alone.
+			log.V(1).Info("rollback is being held, fetching only", "rev", rev, "local", local, "remote", remote)
+			return false, "", backend.fetch(ctx, gitRoot, branch, depth)
+		}
+		if remote == standby.rolledBackFrom() {
+			log.V(1).Info("remote hash was rolled back, not re-publishing", "rev", rev, "local", local, "remote", remote)
+			return false, "", nil
+		}
+		if pauses.mode(gitRoot) == pausePublish {
+			// Keep the clone up to date, but leave the link alone.
+			log.V(1).Info("publishing is paused, fetching only", "rev", rev, "local", local, "remote", remote)
+			return false, "", backend.fetch(ctx, gitRoot, branch, depth)
+		}
+		// With --one-time there is no later sync to publish a pending
+		// change, so it is published straight away.
+		if *flDebounce > 0 && !*flOneTime && !pending.settled(remote, time.Now(), *flDebounce) {
+			log.V(1).Info("waiting for upstream to settle", "rev", rev, "local", local, "remote", remote)
+			return false, "", nil
+		}
 		log.V(0).Info("update required", "rev", rev, "local", local, "remote", remote)
 		hash = remote
+		previous = local
 	}
 
-	return true, hash, addWorktreeAndSwap(ctx, gitRoot, dest, branch, rev, depth, hash, submoduleMode)
+	err = addWorktreeAndSwap(ctx, gitRoot, dest, branch, rev, depth, hash, submoduleMode)
+	if errors.Is(err, errHistoryRewritten) && *flOnHistoryRewrite == rewritePolicyReclone {
+		if err = recloneRepo(ctx, repo, branch, rev, depth, gitRoot); err == nil {
+			err = addWorktreeAndSwap(ctx, gitRoot, dest, branch, rev, depth, hash, submoduleMode)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, errNoQuorum) {
+			log.V(0).Info("peer quorum not reached, will retry", "hash", hash, "timeout", flPeerTimeout.String())
+			return false, "", nil
+		}
+		if errors.Is(err, errHashRejected) {
+			if firstSync {
+				return false, "", fmt.Errorf("nothing to publish: the upstream hash %s is rejected", hash)
+			}
+			return false, "", nil
+		}
+		if errors.Is(err, errTrailerSkip) {
+			if firstSync {
+				return false, "", fmt.Errorf("nothing to publish: the upstream hash %s has a --skip-trailer", hash)
+			}
+			return false, "", nil
+		}
+		return false, "", err
+	}
+	pending.reset()
+	refSwitched = false
+	summaryFrom(ctx).setPublished(previous, hash)
+	return true, hash, nil
 }
 
 // getRevs returns the local and upstream hashes for rev.
@@ -1038,16 +2779,8 @@ func getRevs(ctx context.Context, localDir, branch, rev string) (string, string,
 		return "", "", err
 	}
 
-	// Build a ref string, depending on whether the user asked to track HEAD or a tag.
-	ref := ""
-	if rev == "HEAD" {
-		ref = "refs/heads/" + branch
-	} else {
-		ref = "refs/tags/" + rev
-	}
-
 	// Figure out what hash the remote resolves ref to.
-	remote, err := remoteHashForRef(ctx, ref, localDir)
+	remote, err := remoteHashForRef(ctx, refForRev(branch, rev), localDir)
 	if err != nil {
 		return "", "", err
 	}
@@ -1055,6 +2788,23 @@ func getRevs(ctx context.Context, localDir, branch, rev string) (string, string,
 	return local, remote, nil
 }
 
+// refForRev builds a ref string, depending on whether the user asked to track
+// HEAD or a tag.
+func refForRev(branch, rev string) string {
+	if rev == "HEAD" {
+		return "refs/heads/" + branch
+	}
+	return "refs/tags/" + rev
+}
+
+// errorString returns the message of err, or "" if err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
 func cmdForLog(command string, args ...string) string {
 	if strings.ContainsAny(command, " \t\n") {
 		command = fmt.Sprintf("%q", command)
@@ -1074,37 +2824,76 @@ func runCommand(ctx context.Context, cwd, command string, args ...string) (strin
 }
 
 func runCommandWithStdin(ctx context.Context, cwd, stdin, command string, args ...string) (string, error) {
-	cmdStr := cmdForLog(command, args...)
+	return runCommandWithEnvAndStdin(ctx, cwd, nil, stdin, command, args...)
+}
+
+// runCommandWithEnv is like runCommand, but adds env (in "key=value" form) to
+// the environment of the command.
+func runCommandWithEnv(ctx context.Context, cwd string, env []string, command string, args ...string) (string, error) {
+	return runCommandWithEnvAndStdin(ctx, cwd, env, "", command, args...)
+}
+
+func runCommandWithEnvAndStdin(ctx context.Context, cwd string, env []string, stdin, command string, args ...string) (string, error) {
+	cmdStr := secrets.redact(cmdForLog(command, args...))
 	log.V(5).Info("running command", "cwd", cwd, "cmd", cmdStr)
 
+	spanName := filepath.Base(command)
+	if len(args) > 0 {
+		spanName += " " + args[0]
+	}
+	_, span := startChildSpan(ctx, spanName, "cwd", cwd)
+
 	cmd := exec.CommandContext(ctx, command, args...)
 	if cwd != "" {
 		cmd.Dir = cwd
 	}
+	cmd.Env = gitEnv.environ(env)
 	outbuf := bytes.NewBuffer(nil)
 	errbuf := bytes.NewBuffer(nil)
 	cmd.Stdout = outbuf
 	cmd.Stderr = errbuf
 	cmd.Stdin = bytes.NewBufferString(stdin)
 
+	start := time.Now()
 	err := cmd.Run()
+	recordResourceUsage(commandLabel(command, args), time.Since(start), cmd.ProcessState)
 	stdout := outbuf.String()
-	stderr := errbuf.String()
+	stderr := collapseProgress(errbuf.String())
 	if ctx.Err() == context.DeadlineExceeded {
-		return "", fmt.Errorf("Run(%s): %w: { stdout: %q, stderr: %q }", cmdStr, ctx.Err(), stdout, stderr)
+		span.finish(ctx.Err())
+		return "", fmt.Errorf("Run(%s): %w: { stdout: %q, stderr: %q }", cmdStr, ctx.Err(), secrets.redact(stdout), secrets.redact(stderr))
 	}
 	if err != nil {
-		return "", fmt.Errorf("Run(%s): %w: { stdout: %q, stderr: %q }", cmdStr, err, stdout, stderr)
+		span.finish(err)
+		return "", fmt.Errorf("Run(%s): %w: { stdout: %q, stderr: %q }", cmdStr, err, secrets.redact(stdout), secrets.redact(stderr))
 	}
-	log.V(6).Info("command result", "stdout", stdout, "stderr", stderr)
+	span.finish(nil)
+	log.V(6).Info("command result", "stdout", secrets.redact(stdout), "stderr", secrets.redact(stderr))
 
 	return stdout, nil
 }
 
+// collapseProgress keeps only the last of each line's carriage-return
+// separated updates, as a terminal would show it, so that git's progress
+// reports don't swamp its errors.
+func collapseProgress(output string) string {
+	if !strings.Contains(output, "\r") {
+		return output
+	}
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if j := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); j >= 0 {
+			lines[i] = line[j+1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 func setupGitAuth(ctx context.Context, username, password, gitURL string) error {
 	log.V(1).Info("setting up git credential store")
+	secrets.register(password)
 
-	_, err := runCommand(ctx, "", *flGitCmd, "config", "--global", "credential.helper", "store")
+	_, err := runCommand(ctx, "", *flGitCmd, "config", "--global", "credential.helper", credentialHelper())
 	if err != nil {
 		return fmt.Errorf("can't configure git credential helper: %w", err)
 	}
@@ -1147,10 +2936,32 @@ func setupGitSSH(setupKnownHosts bool) error {
 	return nil
 }
 
+// setupGitConfigFile makes git read and write path as the global config file
+// rather than $HOME/.gitconfig, e.g. because $HOME is on a read-only
+// filesystem.  This needs git 2.32 or newer.
+func setupGitConfigFile(path string) error {
+	log.V(1).Info("setting up global git config file", "path", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Setenv("GIT_CONFIG_GLOBAL", path); err != nil {
+		return fmt.Errorf("can't set $GIT_CONFIG_GLOBAL: %w", err)
+	}
+	return nil
+}
+
 func setupGitCookieFile(ctx context.Context) error {
 	log.V(1).Info("configuring git cookie file")
 
-	var pathToCookieFile = "/etc/git-secret/cookie_file"
+	var pathToCookieFile = *flCookieFilePath
 
 	_, err := os.Stat(pathToCookieFile)
 	if err != nil {
@@ -1164,11 +2975,40 @@ func setupGitCookieFile(ctx context.Context) error {
 	return nil
 }
 
+func callGitAskPassURL(ctx context.Context, url string) error {
+	username, password, err := fetchAskPassCreds(ctx, url)
+	if err != nil {
+		return err
+	}
+	return setupGitAuth(ctx, username, password, *flRepo)
+}
+
+// setupSyncCreds gets the credentials which are fetched again for every sync:
+// from authURL (--askpass-url), if set, and from --auth-provider.
+func setupSyncCreds(ctx context.Context, authURL string) error {
+	if authURL != "" {
+		// For ASKPASS Callback URL, the credentials behind is dynamic, it needs to be
+		// re-fetched each time.
+		if err := callGitAskPassURL(ctx, authURL); err != nil {
+			askpassCount.WithLabelValues(metricKeyError).Inc()
+			return fmt.Errorf("failed to call GIT_ASKPASS_URL: %v", err)
+		}
+		askpassCount.WithLabelValues(metricKeySuccess).Inc()
+	}
+	if *flAuthProvider != "" {
+		// Likewise, these credentials are short-lived.
+		if err := callAuthProvider(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // The expected ASKPASS callback output are below,
 // see https://git-scm.com/docs/gitcredentials for more examples:
 // username=xxx@example.com
 // password=xxxyyyzzz
-func callGitAskPassURL(ctx context.Context, url string) error {
+func fetchAskPassCreds(ctx context.Context, url string) (string, string, error) {
 	log.V(1).Info("calling GIT_ASKPASS URL to get credentials")
 
 	var netClient = &http.Client{
@@ -1179,11 +3019,11 @@ func callGitAskPassURL(ctx context.Context, url string) error {
 	}
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("can't create auth request: %w", err)
+		return "", "", fmt.Errorf("can't create auth request: %w", err)
 	}
 	resp, err := netClient.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("can't access auth URL: %w", err)
+		return "", "", fmt.Errorf("can't access auth URL: %w", err)
 	}
 	defer func() {
 		_ = resp.Body.Close()
@@ -1191,13 +3031,13 @@ func callGitAskPassURL(ctx context.Context, url string) error {
 	if resp.StatusCode != 200 {
 		errMessage, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return fmt.Errorf("auth URL returned status %d, failed to read body: %w", resp.StatusCode, err)
+			return "", "", fmt.Errorf("auth URL returned status %d, failed to read body: %w", resp.StatusCode, err)
 		}
-		return fmt.Errorf("auth URL returned status %d, body: %q", resp.StatusCode, string(errMessage))
+		return "", "", fmt.Errorf("auth URL returned status %d, body: %q", resp.StatusCode, string(errMessage))
 	}
 	authData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("can't read auth response: %w", err)
+		return "", "", fmt.Errorf("can't read auth response: %w", err)
 	}
 
 	username := ""
@@ -1215,11 +3055,8 @@ func callGitAskPassURL(ctx context.Context, url string) error {
 		}
 	}
 
-	if err := setupGitAuth(ctx, username, password, *flRepo); err != nil {
-		return err
-	}
-
-	return nil
+	secrets.register(password)
+	return username, password, nil
 }
 
 func setupExtraGitConfigs(ctx context.Context, configsFlag string) error {
@@ -1238,6 +3075,67 @@ func setupExtraGitConfigs(ctx context.Context, configsFlag string) error {
 	return nil
 }
 
+// validateGitTransportFlags returns an error if the flags which tune git's
+// transport are invalid, alone or together.
+func validateGitTransportFlags() error {
+	switch *flGitProtocolVersion {
+	case "", "0", "1", "2":
+	default:
+		return fmt.Errorf("--git-protocol-version must be one of '0', '1', or '2'")
+	}
+	if *flHTTPLowSpeedLimit < 0 {
+		return fmt.Errorf("--http-low-speed-limit must be greater than or equal to 0")
+	}
+	if *flHTTPLowSpeedLimit > 0 {
+		if *flHTTPLowSpeedTime < time.Second {
+			return fmt.Errorf("--http-low-speed-time must be at least 1s when --http-low-speed-limit is specified")
+		}
+		if *flHTTPLowSpeedTime%time.Second != 0 {
+			return fmt.Errorf("--http-low-speed-time must be a whole number of seconds")
+		}
+	} else if *flHTTPLowSpeedTime != 0 {
+		return fmt.Errorf("--http-low-speed-time requires --http-low-speed-limit")
+	}
+	if *flGitCompression < -1 || *flGitCompression > 9 {
+		return fmt.Errorf("--git-compression must be between -1 and 9")
+	}
+	return nil
+}
+
+// gitTransportConfigs returns the git configs which the flags that tune
+// git's transport call for.
+func gitTransportConfigs() []keyVal {
+	configs := []keyVal{}
+	if *flGitProtocolVersion != "" {
+		configs = append(configs, keyVal{"protocol.version", *flGitProtocolVersion})
+	}
+	if *flHTTPLowSpeedLimit > 0 {
+		configs = append(configs,
+			keyVal{"http.lowSpeedLimit", strconv.Itoa(*flHTTPLowSpeedLimit)},
+			keyVal{"http.lowSpeedTime", strconv.Itoa(int(*flHTTPLowSpeedTime / time.Second))})
+	}
+	if *flGitCompression != -1 {
+		configs = append(configs, keyVal{"core.compression", strconv.Itoa(*flGitCompression)})
+	}
+	return configs
+}
+
+// setupGitTransportConfigs applies the flags which tune git's transport.
+func setupGitTransportConfigs(ctx context.Context) error {
+	configs := gitTransportConfigs()
+	if len(configs) == 0 {
+		return nil
+	}
+
+	log.V(1).Info("setting git transport configs")
+	for _, kv := range configs {
+		if _, err := runCommand(ctx, "", *flGitCmd, "config", "--global", kv.key, kv.val); err != nil {
+			return fmt.Errorf("error configuring %q %q: %v", kv.key, kv.val, err)
+		}
+	}
+	return nil
+}
+
 type keyVal struct {
 	key string
 	val string
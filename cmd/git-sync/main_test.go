@@ -17,9 +17,19 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
 )
 
 const (
@@ -187,3 +197,231 @@ func TestParseGitConfigs(t *testing.T) {
 		})
 	}
 }
+
+type gitTransportFlags struct {
+	protocol     string
+	lowSpeed     int
+	lowSpeedTime time.Duration
+	compression  int
+}
+
+func setGitTransportFlags(f gitTransportFlags) {
+	*flGitProtocolVersion = f.protocol
+	*flHTTPLowSpeedLimit = f.lowSpeed
+	*flHTTPLowSpeedTime = f.lowSpeedTime
+	*flGitCompression = f.compression
+}
+
+func TestGitTransportConfigs(t *testing.T) {
+	defer setGitTransportFlags(gitTransportFlags{*flGitProtocolVersion, *flHTTPLowSpeedLimit, *flHTTPLowSpeedTime, *flGitCompression})
+
+	cases := []struct {
+		name  string
+		flags gitTransportFlags
+		want  []keyVal
+	}{{
+		name:  "defaults",
+		flags: gitTransportFlags{compression: -1},
+		want:  []keyVal{},
+	}, {
+		name:  "protocol",
+		flags: gitTransportFlags{protocol: "2", compression: -1},
+		want:  []keyVal{{"protocol.version", "2"}},
+	}, {
+		name:  "low-speed",
+		flags: gitTransportFlags{lowSpeed: 1000, lowSpeedTime: time.Minute, compression: -1},
+		want:  []keyVal{{"http.lowSpeedLimit", "1000"}, {"http.lowSpeedTime", "60"}},
+	}, {
+		name:  "compression",
+		flags: gitTransportFlags{compression: 0},
+		want:  []keyVal{{"core.compression", "0"}},
+	}, {
+		name:  "all",
+		flags: gitTransportFlags{protocol: "1", lowSpeed: 1, lowSpeedTime: 30 * time.Second, compression: 9},
+		want: []keyVal{
+			{"protocol.version", "1"},
+			{"http.lowSpeedLimit", "1"},
+			{"http.lowSpeedTime", "30"},
+			{"core.compression", "9"},
+		},
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setGitTransportFlags(tc.flags)
+			if err := validateGitTransportFlags(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := gitTransportConfigs(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateGitTransportFlags(t *testing.T) {
+	defer setGitTransportFlags(gitTransportFlags{*flGitProtocolVersion, *flHTTPLowSpeedLimit, *flHTTPLowSpeedTime, *flGitCompression})
+
+	cases := []struct {
+		name  string
+		flags gitTransportFlags
+		err   string
+	}{{
+		name:  "bad-protocol",
+		flags: gitTransportFlags{protocol: "3", compression: -1},
+		err:   "--git-protocol-version",
+	}, {
+		name:  "negative-low-speed",
+		flags: gitTransportFlags{lowSpeed: -1, compression: -1},
+		err:   "--http-low-speed-limit",
+	}, {
+		name:  "low-speed-without-time",
+		flags: gitTransportFlags{lowSpeed: 1000, compression: -1},
+		err:   "at least 1s",
+	}, {
+		name:  "low-speed-short-time",
+		flags: gitTransportFlags{lowSpeed: 1000, lowSpeedTime: 500 * time.Millisecond, compression: -1},
+		err:   "at least 1s",
+	}, {
+		name:  "low-speed-fractional-time",
+		flags: gitTransportFlags{lowSpeed: 1000, lowSpeedTime: 1500 * time.Millisecond, compression: -1},
+		err:   "whole number of seconds",
+	}, {
+		name:  "time-without-low-speed",
+		flags: gitTransportFlags{lowSpeedTime: time.Minute, compression: -1},
+		err:   "requires --http-low-speed-limit",
+	}, {
+		name:  "compression-too-low",
+		flags: gitTransportFlags{compression: -2},
+		err:   "--git-compression",
+	}, {
+		name:  "compression-too-high",
+		flags: gitTransportFlags{compression: 10},
+		err:   "--git-compression",
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setGitTransportFlags(tc.flags)
+			err := validateGitTransportFlags()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected %q in %q", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRemoveGitMetadata(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"a/b", "sub/.git/objects", "sub/inner"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{".git", "a/b/file", "sub/inner/.git", "sub/.gitignore"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := removeGitMetadata(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, gone := range []string{".git", "sub/.git", "sub/inner/.git"} {
+		if _, err := os.Lstat(filepath.Join(dir, gone)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", gone, err)
+		}
+	}
+	for _, kept := range []string{"a/b/file", "sub/inner", "sub/.gitignore"} {
+		if _, err := os.Lstat(filepath.Join(dir, kept)); err != nil {
+			t.Errorf("expected %s to be kept, got %v", kept, err)
+		}
+	}
+}
+
+func TestParallelCheckoutArgs(t *testing.T) {
+	defer func(workers, threshold int) {
+		*flCheckoutWorkers, *flCheckoutParallelThreshold = workers, threshold
+	}(*flCheckoutWorkers, *flCheckoutParallelThreshold)
+
+	*flCheckoutWorkers = 4
+	*flCheckoutParallelThreshold = 50
+	want := []string{"-c", "checkout.workers=4", "-c", "checkout.thresholdForParallelism=50"}
+	if got := parallelCheckoutArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	*flCheckoutWorkers = 0
+	want[1] = "checkout.workers=" + strconv.Itoa(runtime.GOMAXPROCS(0))
+	if got := parallelCheckoutArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestShallowArgs(t *testing.T) {
+	defer func(since string, exclude stringList) {
+		*flShallowSince, *flShallowExclude = since, exclude
+	}(*flShallowSince, *flShallowExclude)
+
+	*flShallowSince = ""
+	*flShallowExclude = stringList{}
+	if got := shallowArgs(0); len(got) != 0 {
+		t.Errorf("expected no args, got %v", got)
+	}
+	if got, want := shallowArgs(3), []string{"--depth", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	*flShallowSince = "30 days ago"
+	flShallowExclude.Set("v1.0,release-1")
+	want := []string{"--shallow-since", "30 days ago", "--shallow-exclude", "v1.0", "--shallow-exclude", "release-1"}
+	if got := shallowArgs(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSetupGitConfigFile(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(v string, set bool) {
+		if set {
+			os.Setenv("GIT_CONFIG_GLOBAL", v)
+		} else {
+			os.Unsetenv("GIT_CONFIG_GLOBAL")
+		}
+	}(os.LookupEnv("GIT_CONFIG_GLOBAL"))
+
+	path := filepath.Join(t.TempDir(), "config", "gitconfig")
+	if err := setupGitConfigFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := runCommand(context.Background(), "", *flGitCmd, "config", "--global", "test.key", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "key = value"; !strings.Contains(string(data), want) {
+		t.Errorf("expected %q in %q", want, string(data))
+	}
+}
+
+func TestCollapseProgress(t *testing.T) {
+	cases := map[string]string{
+		"":                   "",
+		"fatal: not found\n": "fatal: not found\n",
+		"Receiving: 1%\rReceiving: 100%, done.\nfatal: oops\n": "Receiving: 100%, done.\nfatal: oops\n",
+		"Counting: 5\rCounting: 10\r\n":                        "Counting: 10\r\n",
+	}
+	for input, expect := range cases {
+		if got := collapseProgress(input); got != expect {
+			t.Errorf("%q: expected %q, got %q", input, expect, got)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fetchDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	Name: "git_sync_fetch_duration_seconds",
+	Help: "Summary of the time taken to fetch from the upstream",
+}, []string{"status"})
+
+var checkoutDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	Name: "git_sync_checkout_duration_seconds",
+	Help: "Summary of the time taken to create and check out a new worktree",
+}, []string{"status"})
+
+var maintenanceDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	Name: "git_sync_maintenance_duration_seconds",
+	Help: "Summary of the time taken to write the commit-graph and multi-pack-index",
+}, []string{"status"})
+
+func init() {
+	prometheus.MustRegister(fetchDuration)
+	prometheus.MustRegister(checkoutDuration)
+	prometheus.MustRegister(maintenanceDuration)
+}
+
+// observeDuration records the time since start in s, labelled by whether
+// err is nil.
+func observeDuration(s *prometheus.SummaryVec, start time.Time, err error) {
+	status := metricKeySuccess
+	if err != nil {
+		status = metricKeyError
+	}
+	s.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
+
+// fetchNegotiationArgs returns the git options which make fetches from large
+// repos cheaper to negotiate, if --git-maintenance is set.
+func fetchNegotiationArgs() []string {
+	if !*flGitMaintenance {
+		return nil
+	}
+	// Skip over ancestors when telling the upstream what we have, rather
+	// than walking every commit.
+	return []string{"-c", "fetch.negotiationAlgorithm=skipping"}
+}
+
+// packCount returns how many packfiles the clone at gitRoot has.
+func packCount(gitRoot string) (int, error) {
+	packs, err := filepath.Glob(filepath.Join(gitRoot, ".git", "objects", "pack", "*.pack"))
+	return len(packs), err
+}
+
+// maintainRepo writes a commit-graph and a multi-pack-index for the clone at
+// gitRoot, which make later fetches and checkouts faster on large repos.  The
+// commit-graph is written incrementally, after each fetch.  The
+// multi-pack-index only helps when fetches have left several packs, so it is
+// skipped when there is one, e.g. just after git gc, which repacks everything.
+// Either can fail without stopping the other.
+func maintainRepo(ctx context.Context, gitRoot string) (err error) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "maintenance")
+	defer func() {
+		span.finish(err)
+		observeDuration(maintenanceDuration, start, err)
+	}()
+
+	if _, cgErr := runCommand(ctx, gitRoot, *flGitCmd, "commit-graph", "write", "--reachable", "--split"); cgErr != nil {
+		err = fmt.Errorf("can't write commit-graph: %w", cgErr)
+	}
+	n, countErr := packCount(gitRoot)
+	if countErr != nil || n < 2 {
+		return err
+	}
+	if _, midxErr := runCommand(ctx, gitRoot, *flGitCmd, "multi-pack-index", "write"); midxErr != nil && err == nil {
+		err = fmt.Errorf("can't write multi-pack-index: %w", midxErr)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestMaintainRepo(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command(*flGitCmd, args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	commit := func() {
+		git("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "test")
+	}
+	exists := func(f string) bool {
+		_, err := os.Stat(filepath.Join(dir, ".git", "objects", f))
+		return err == nil
+	}
+	git("init", "-q")
+	commit()
+	git("gc", "-q")
+
+	// After gc there is one pack, which a multi-pack-index doesn't help.
+	if err := maintainRepo(context.Background(), dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists("info/commit-graph") && !exists("info/commit-graphs/commit-graph-chain") {
+		t.Errorf("expected a commit-graph to be written")
+	}
+	if exists("pack/multi-pack-index") {
+		t.Errorf("expected no multi-pack-index for one pack")
+	}
+
+	// A second pack, as a fetch would leave.
+	commit()
+	git("repack", "-q", "-d")
+	if err := maintainRepo(context.Background(), dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists("pack/multi-pack-index") {
+		t.Errorf("expected a multi-pack-index for several packs")
+	}
+	if !exists("info/commit-graphs/commit-graph-chain") {
+		t.Errorf("expected the commit-graph to be extended")
+	}
+}
+
+func TestMaintainRepoFailures(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	// Not a repo, so the commit-graph can't be written.
+	err := maintainRepo(context.Background(), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "commit-graph") {
+		t.Errorf("expected a commit-graph error, got %v", err)
+	}
+}
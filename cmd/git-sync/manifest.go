@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Values for --manifest.
+const (
+	// manifestTree writes the manifest at the root of the published tree.
+	manifestTree = "tree"
+	// manifestMetadata writes the manifest in the --metadata-link directory.
+	manifestMetadata = "metadata"
+)
+
+// formatManifest formats the regular files in entries, sorted by path, in
+// the format of sha256sum, so that "sha256sum -c" can check the tree they
+// came from.  Symlinks are left out, and so is a previous manifest named
+// name at the top of the tree.
+func formatManifest(entries []manifestEntry, name string) []byte {
+	files := []manifestEntry{}
+	for _, e := range entries {
+		if e.Link == "" && e.Path != name {
+			files = append(files, e)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	var buf bytes.Buffer
+	for _, e := range files {
+		fmt.Fprintf(&buf, "%s  %s\n", e.SHA256, e.Path)
+	}
+	return buf.Bytes()
+}
+
+// writeManifest writes the manifest of worktree to path, replacing it
+// atomically.
+func writeManifest(worktree, path, name string) error {
+	entries, err := buildManifest(worktree, backend.metaDir())
+	if err != nil {
+		return err
+	}
+	data := formatManifest(entries, name)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
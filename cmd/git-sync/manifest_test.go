@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"b.txt":       "bee\n",
+		"a/z.txt":     "zed\n",
+		".git":        "gitdir: ../.git/worktrees/x\n",
+		".github/c":   "",
+		"MANIFEST":    "stale\n",
+		"a/.git/HEAD": "not metadata below the root\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink("b.txt", filepath.Join(dir, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writeManifest(dir, filepath.Join(dir, "MANIFEST"), "MANIFEST"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "MANIFEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  .github/c\n" +
+		"a905fcdb8911b4e7db652a9a4dbec3ecb940af95d55b1027aa925dc3fcd0d927  a/.git/HEAD\n" +
+		"e4c81d6e661b430d874616bb2f2bbf7d5546cfd34097840a4a077991e80ef0dc  a/z.txt\n" +
+		"c150e5a8a604acebd8d15bd7bf8ea96b2874bdcc91dee6319977d353251283b0  b.txt\n"
+	if string(got) != expect {
+		t.Errorf("unexpected manifest:\n%s", got)
+	}
+}
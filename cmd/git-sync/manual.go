@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// The values of --man-format.
+const (
+	manFormatMarkdown = "md"
+	manFormatJSON     = "json"
+)
+
+// manOption is one flag in the option reference.
+type manOption struct {
+	Name string `json:"name"`
+	// EnvVars are the env vars which can set the flag, in order of
+	// precedence.
+	EnvVars []string `json:"envVars"`
+	Type    string   `json:"type"`
+	Default string   `json:"default"`
+	Help    string   `json:"help"`
+}
+
+// flagType returns the type of f's value, e.g. "string" or "duration".
+func flagType(f *flag.Flag) string {
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return "bool"
+	}
+	switch f.Value.(type) {
+	case *stringList, *entryList:
+		return "list"
+	}
+	t := reflect.TypeOf(f.Value)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() != "flag" {
+		// e.g. glog's, which are set from text like "INFO".
+		return "string"
+	}
+	// The flag package's types are e.g. "durationValue".
+	name := strings.TrimSuffix(t.Name(), "Value")
+	if name == "float64" {
+		return "float"
+	}
+	return name
+}
+
+// manOptions returns all of the flags, sorted by name.  The defaults include
+// any env vars which are set.
+func manOptions() []manOption {
+	opts := []manOption{}
+	flag.VisitAll(func(f *flag.Flag) {
+		envs := envVarsForFlag(f.Name)
+		if envs == nil {
+			envs = []string{}
+		}
+		opts = append(opts, manOption{
+			Name:    f.Name,
+			EnvVars: envs,
+			Type:    flagType(f),
+			Default: f.DefValue,
+			Help:    f.Usage,
+		})
+	})
+	return opts
+}
+
+// writeManual writes the option reference to w in format.
+func writeManual(w io.Writer, format string) error {
+	opts := manOptions()
+	switch format {
+	case manFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(opts)
+	case manFormatMarkdown:
+		// The same columns as the parameters table in the README.
+		fmt.Fprintln(w, "| Environment Variable | Flag | Description | Default |")
+		fmt.Fprintln(w, "|----------------------|------|-------------|---------|")
+		for _, o := range opts {
+			def := o.Default
+			if o.Type == "string" {
+				def = fmt.Sprintf("%q", def)
+			}
+			_, err := fmt.Fprintf(w, "| %s | `--%s` | %s | %s |\n",
+				strings.Join(o.EnvVars, ", "), o.Name, mdEscape(o.Help), mdEscape(def))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// mdEscape makes s safe in a markdown table cell.
+func mdEscape(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlagType(t *testing.T) {
+	for name, expect := range map[string]string{
+		"repo":                "string",
+		"one-time":            "bool",
+		"wait":                "float",
+		"depth":               "int",
+		"ssh-control-persist": "duration",
+		"git-env":             "list",
+		"credential":          "list",
+		"v":                   "string",
+		"stderrthreshold":     "string",
+	} {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no flag %q", name)
+		}
+		if got := flagType(f); got != expect {
+			t.Errorf("%s: expected %q, got %q", name, expect, got)
+		}
+	}
+}
+
+func TestWriteManualJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeManual(buf, manFormatJSON); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := []manOption{}
+	if err := json.Unmarshal(buf.Bytes(), &opts); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	n := 0
+	flag.VisitAll(func(*flag.Flag) { n++ })
+	if len(opts) != n {
+		t.Errorf("expected %d flags, got %d", n, len(opts))
+	}
+	byName := map[string]manOption{}
+	for _, o := range opts {
+		byName[o.Name] = o
+	}
+	if o := byName["ssh-key-file"]; !reflect.DeepEqual(o.EnvVars, []string{"GIT_SSH_KEY_FILE"}) || o.Type != "string" || o.Default != "/etc/git-secret/ssh" {
+		t.Errorf("unexpected ssh-key-file: %+v", o)
+	}
+	if o := byName["man"]; o.EnvVars == nil || len(o.EnvVars) != 0 {
+		t.Errorf("expected no env vars for man, got %#v", o.EnvVars)
+	}
+}
+
+func TestWriteManualMarkdown(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeManual(buf, manFormatMarkdown); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, expect := range []string{
+		"| Environment Variable | Flag | Description | Default |\n",
+		"| GIT_SYNC_REPO | `--repo` | the git repository to clone | \"\" |\n",
+		"| GIT_SYNC_ONE_TIME | `--one-time` | exit after the first sync | false |\n",
+		"| GIT_SYNC_OTEL_EXPORTER_ENDPOINT, OTEL_EXPORTER_OTLP_ENDPOINT | `--otel-exporter-endpoint` |",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("expected %q in the output", expect)
+		}
+	}
+	if err := writeManual(buf, "yaml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+	if got, expect := mdEscape("a|b\nc"), `a\|b c`; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// What to do when --max-runtime is reached.
+const (
+	maxRuntimeExit   = "exit"
+	maxRuntimeReExec = "re-exec"
+)
+
+// processStart is when git-sync started, for --max-runtime.
+var processStart = time.Now()
+
+// maxRuntimeReached returns true if git-sync has run for longer than
+// maxRuntime as of now.  A maxRuntime of 0 is never reached.
+func maxRuntimeReached(maxRuntime time.Duration, now time.Time) bool {
+	return maxRuntime > 0 && now.Sub(processStart) >= maxRuntime
+}
+
+// endRuntime ends this run of git-sync, once --max-runtime is reached,
+// according to --max-runtime-action: either exiting, so that Kubernetes
+// restarts the container, or re-executing the binary in place.  This is only
+// called between syncs, so nothing is left half-done.  It never returns.
+func endRuntime() {
+	uptime := time.Since(processStart).Round(time.Second)
+	if *flMaxRuntimeAction == maxRuntimeReExec {
+		log.V(0).Info("max runtime reached, re-executing", "uptime", uptime.String())
+		err := reExec()
+		// If that worked, this is not reached.
+		log.Error(err, "can't re-execute, exiting instead")
+	} else {
+		log.V(0).Info("max runtime reached, exiting", "uptime", uptime.String())
+	}
+	exportFinalMetrics()
+	os.Exit(0)
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxRuntimeReached(t *testing.T) {
+	cases := []struct {
+		maxRuntime time.Duration
+		elapsed    time.Duration
+		expect     bool
+	}{
+		{0, 1000 * time.Hour, false},
+		{time.Hour, 59 * time.Minute, false},
+		{time.Hour, time.Hour, true},
+		{time.Hour, 2 * time.Hour, true},
+	}
+
+	for _, tc := range cases {
+		if got := maxRuntimeReached(tc.maxRuntime, processStart.Add(tc.elapsed)); got != tc.expect {
+			t.Errorf("max %v, elapsed %v: expected %v, got %v", tc.maxRuntime, tc.elapsed, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricRefLabel is the label which --metric-ref-label adds.
+const metricRefLabel = "ref"
+
+// metricLabelNameRE matches valid Prometheus label names.
+var metricLabelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseMetricLabels parses --metric-labels entries, in key=value form.
+func parseMetricLabels(entries []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, e := range entries {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not in key=value form", e)
+		}
+		name, value := kv[0], kv[1]
+		if !metricLabelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("%q is not a valid label name", name)
+		}
+		if _, found := labels[name]; found {
+			return nil, fmt.Errorf("label %q is given more than once", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// labeledGatherer adds constant labels to every metric which base gathers.
+// A metric's own labels take precedence over these.
+type labeledGatherer struct {
+	base   prometheus.Gatherer
+	labels []*dto.LabelPair
+}
+
+// newLabeledGatherer returns a gatherer which adds labels to base's metrics,
+// or base if there are no labels.
+func newLabeledGatherer(base prometheus.Gatherer, labels map[string]string) prometheus.Gatherer {
+	if len(labels) == 0 {
+		return base
+	}
+	g := labeledGatherer{base: base}
+	for name, value := range labels {
+		g.labels = append(g.labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	return g
+}
+
+func (g labeledGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.base.Gather()
+	for _, f := range families {
+		for _, m := range f.Metric {
+			have := map[string]bool{}
+			for _, l := range m.Label {
+				have[l.GetName()] = true
+			}
+			for _, l := range g.labels {
+				if !have[l.GetName()] {
+					m.Label = append(m.Label, l)
+				}
+			}
+			// The exposition format wants labels sorted by name.
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+	return families, err
+}
+
+// metricsGatherer gathers the metrics which are exported, with any
+// --metric-labels.
+var metricsGatherer = prometheus.DefaultGatherer
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseMetricLabels(t *testing.T) {
+	got, err := parseMetricLabels([]string{"team=payments", "alias=docs_site", "empty="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"team": "payments", "alias": "docs_site", "empty": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, bad := range [][]string{
+		{"team"},
+		{"=payments"},
+		{"1team=payments"},
+		{"te-am=payments"},
+		{"__name__=x"},
+		{"team=a", "team=b"},
+	} {
+		if _, err := parseMetricLabels(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
+
+func TestLabeledGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "test"}, []string{"status"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "test"})
+	reg.MustRegister(counter, gauge)
+	counter.WithLabelValues("success").Inc()
+	gauge.Set(1)
+
+	if g := newLabeledGatherer(reg, nil); g != prometheus.Gatherer(reg) {
+		t.Errorf("expected the registry itself when there are no labels")
+	}
+
+	g := newLabeledGatherer(reg, map[string]string{"team": "payments", "status": "ignored", "alias": "docs"})
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := map[string][]string{}
+	for _, f := range families {
+		for _, m := range f.Metric {
+			for _, l := range m.Label {
+				got[f.GetName()] = append(got[f.GetName()], l.GetName()+"="+l.GetValue())
+			}
+		}
+	}
+	want := map[string][]string{
+		"test_total": {"alias=docs", "status=success", "team=payments"},
+		"test_gauge": {"alias=docs", "status=ignored", "team=payments"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
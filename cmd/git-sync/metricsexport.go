@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricsPushTimeout bounds the push to --metrics-pushgateway.
+const metricsPushTimeout = 30 * time.Second
+
+// writeMetricsTextfile writes the metrics which g gathers to path, in the
+// Prometheus text format.  The file is replaced atomically, since node
+// exporter's textfile collector may read it at any time.
+func writeMetricsTextfile(g prometheus.Gatherer, path string) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	for _, f := range families {
+		if _, err = expfmt.MetricFamilyToText(tmp, f); err != nil {
+			break
+		}
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile makes the file private, but the collector may run as
+		// another user.
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// isHTTPURL returns true if s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// pushMetrics pushes the metrics which g gathers to the Pushgateway at gateway,
+// replacing any which were pushed for the same job and instance before.
+func pushMetrics(g prometheus.Gatherer, gateway, job, instance string) error {
+	return push.New(gateway, job).
+		Gatherer(g).
+		Grouping("instance", instance).
+		Client(&http.Client{Timeout: metricsPushTimeout}).
+		Push()
+}
+
+// exportFinalMetrics writes or pushes the metrics as git-sync exits, if
+// --metrics-textfile or --metrics-pushgateway is set, so that runs which exit
+// (e.g. --one-time ones) can be observed after they are gone.  Errors are
+// logged, but don't change how git-sync exits.
+func exportFinalMetrics() {
+	if *flMetricsTextfile != "" {
+		if err := writeMetricsTextfile(metricsGatherer, *flMetricsTextfile); err != nil {
+			log.Error(err, "can't write metrics textfile", "path", *flMetricsTextfile)
+		} else {
+			log.V(1).Info("wrote metrics textfile", "path", *flMetricsTextfile)
+		}
+	}
+	if *flMetricsPushgateway != "" {
+		instance, err := os.Hostname()
+		if err != nil {
+			instance = fmt.Sprintf("pid-%d", os.Getpid())
+		}
+		if err := pushMetrics(metricsGatherer, *flMetricsPushgateway, *flMetricsPushgatewayJob, instance); err != nil {
+			log.Error(err, "can't push metrics", "url", secrets.redact(*flMetricsPushgateway))
+		} else {
+			log.V(1).Info("pushed metrics", "url", secrets.redact(*flMetricsPushgateway), "job", *flMetricsPushgatewayJob, "instance", instance)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func testMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "test"}, []string{"status"})
+	reg.MustRegister(counter)
+	counter.WithLabelValues("success").Inc()
+	return reg
+}
+
+func TestWriteMetricsTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "git-sync.prom")
+	g := newLabeledGatherer(testMetricsRegistry(), map[string]string{"team": "x"})
+	if err := writeMetricsTextfile(g, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `test_total{status="success",team="x"} 1`; !strings.Contains(string(data), want) {
+		t.Errorf("expected %q in:\n%s", want, data)
+	}
+	if fi, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v", fi.Mode().Perm())
+	}
+	entries, _ := ioutil.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left, got %d files", len(entries))
+	}
+
+	if err := writeMetricsTextfile(g, filepath.Join(path, "missing", "x.prom")); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	if err := pushMetrics(testMetricsRegistry(), server.URL, "git-sync", "pod-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if method != http.MethodPut {
+		t.Errorf("expected PUT, got %s", method)
+	}
+	if want := "/metrics/job/git-sync/instance/pod-1"; path != want {
+		t.Errorf("expected path %s, got %s", want, path)
+	}
+	if body == "" {
+		t.Errorf("expected metrics to be pushed")
+	}
+}
+
+func TestIsHTTPURL(t *testing.T) {
+	for s, want := range map[string]bool{
+		"http://pushgateway:9091":   true,
+		"https://pushgateway:9091/": true,
+		"pushgateway:9091":          false,
+		"ftp://pushgateway":         false,
+		"http://":                   false,
+	} {
+		if got := isHTTPURL(s); got != want {
+			t.Errorf("%q: expected %v, got %v", s, want, got)
+		}
+	}
+}
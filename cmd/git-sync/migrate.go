@@ -0,0 +1,302 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrateFlagsCmd is the subcommand which translates a v3 command line or set
+// of env vars into the equivalent for git-sync v4.
+const migrateFlagsCmd = "migrate-flags"
+
+// v4Setting describes how a v3 flag is given to git-sync v4.
+type v4Setting struct {
+	// name is the v4 flag, or "" if v4 doesn't need it.
+	name string
+	// convert translates the value, if its format changed.
+	convert func(string) (string, error)
+	// note explains the change.
+	note string
+}
+
+// v4Settings are the v3 flags which v4 supports.  Flags which are not listed
+// have no v4 equivalent.  --branch and --rev are handled separately, since
+// v4 combines them into --ref.
+var v4Settings = map[string]v4Setting{
+	"add-user":               {name: "add-user"},
+	"askpass-url":            {name: "askpass-url"},
+	"change-permissions":     {name: "change-permissions"},
+	"cookie-file":            {name: "cookie-file"},
+	"depth":                  {name: "depth"},
+	"dest":                   {name: "link", note: "--dest is now --link"},
+	"error-file":             {name: "error-file"},
+	"git":                    {name: "git"},
+	"git-config":             {name: "git-config"},
+	"http-bind":              {name: "http-bind"},
+	"http-metrics":           {name: "http-metrics"},
+	"http-pprof":             {name: "http-pprof"},
+	"max-sync-failures":      {name: "max-failures", note: "--max-sync-failures is now --max-failures"},
+	"one-time":               {name: "one-time"},
+	"password":               {name: "password"},
+	"password-file":          {name: "password-file"},
+	"repo":                   {name: "repo"},
+	"root":                   {name: "root"},
+	"sparse-checkout-file":   {name: "sparse-checkout-file"},
+	"ssh":                    {note: "--ssh is not needed, since SSH is used for SSH repo URLs"},
+	"ssh-key-file":           {name: "ssh-key-file"},
+	"ssh-known-hosts":        {name: "ssh-known-hosts"},
+	"ssh-known-hosts-file":   {name: "ssh-known-hosts-file"},
+	"stale-worktree-timeout": {name: "stale-worktree-timeout"},
+	"submodules":             {name: "submodules"},
+	"sync-hook-command":      {name: "exechook-command", note: "--sync-hook-command is now --exechook-command"},
+	"timeout":                {name: "sync-timeout", convert: secondsToDuration, note: "--timeout is now --sync-timeout, a duration"},
+	"username":               {name: "username"},
+	"wait":                   {name: "period", convert: secondsToDuration, note: "--wait is now --period, a duration"},
+	"webhook-backoff":        {name: "webhook-backoff"},
+	"webhook-method":         {name: "webhook-method"},
+	"webhook-success-status": {name: "webhook-success-status"},
+	"webhook-timeout":        {name: "webhook-timeout"},
+	"webhook-url":            {name: "webhook-url"},
+	// Logging.
+	"v":               {name: "verbose", note: "-v is now --verbose"},
+	"logtostderr":     {note: "logs always go to stderr"},
+	"alsologtostderr": {note: "logs always go to stderr"},
+}
+
+// v4Defaults are the defaults which changed in v4.  When the v3 default is
+// used, it is given explicitly, so that behavior doesn't change.
+var v4Defaults = []struct {
+	name  string
+	value string
+	note  string
+}{
+	{"ref", "master", "v4 syncs the remote's default branch (HEAD) by default, rather than master"},
+	{"depth", "0", "v4 syncs only one commit by default, rather than the full history"},
+	{"period", "1s", "v4 syncs every 10s by default, rather than every second"},
+}
+
+// v4EnvName returns the env var for a v4 flag.
+func v4EnvName(name string) string {
+	if name == "change-permissions" {
+		return "GITSYNC_PERMISSIONS"
+	}
+	return "GITSYNC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// secondsToDuration converts a number of seconds to a duration.
+func secondsToDuration(value string) (string, error) {
+	secs, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a number of seconds", value)
+	}
+	return time.Duration(secs * float64(time.Second)).String(), nil
+}
+
+// isSSHURL returns true if v4 would use SSH for repo: ssh:// URLs, and
+// scp-style "user@host:path" ones.
+func isSSHURL(repo string) bool {
+	if strings.HasPrefix(repo, "ssh://") {
+		return true
+	}
+	if strings.Contains(repo, "://") {
+		return false
+	}
+	at, colon := strings.Index(repo, "@"), strings.Index(repo, ":")
+	return at > 0 && colon > at
+}
+
+// v3Setting is one flag or env var from a v3 configuration.
+type v3Setting struct {
+	name  string
+	value string
+	env   bool
+}
+
+// parseV3Settings parses args, which are flags ("--name=value", "--name
+// value", or "--bool-name") and env vars ("NAME=value").
+func parseV3Settings(args []string) ([]v3Setting, []string) {
+	envFlags := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		for _, env := range envVarsForFlag(f.Name) {
+			envFlags[env] = f.Name
+		}
+	})
+
+	settings := []v3Setting{}
+	problems := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			parts := strings.SplitN(arg, "=", 2)
+			name, found := envFlags[parts[0]]
+			if len(parts) != 2 || !found {
+				problems = append(problems, fmt.Sprintf("%s: not a git-sync env var", parts[0]))
+				continue
+			}
+			settings = append(settings, v3Setting{name: name, value: parts[1], env: true})
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		f := flag.Lookup(name)
+		if f == nil {
+			problems = append(problems, fmt.Sprintf("--%s: not a git-sync flag", name))
+			continue
+		}
+		if !hasValue {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				problems = append(problems, fmt.Sprintf("--%s: no value", name))
+				continue
+			}
+		}
+		settings = append(settings, v3Setting{name: name, value: value})
+	}
+	return settings, problems
+}
+
+// migrateFlags translates a v3 configuration, as flags and env vars, to the
+// equivalent for v4.  Each setting keeps its form: flags become flags, and
+// env vars become GITSYNC_* env vars.  It returns the v4 settings, notes
+// about what changed, and anything which can't be migrated.
+func migrateFlags(args []string) ([]string, []string, []string) {
+	settings, problems := parseV3Settings(args)
+	out := []string{}
+	notes := []string{}
+	noted := map[string]bool{}
+	addNote := func(note string) {
+		if note != "" && !noted[note] {
+			noted[note] = true
+			notes = append(notes, note)
+		}
+	}
+	seen := map[string]bool{}
+	useEnv := len(settings) > 0
+	format := func(name, value string, env bool) string {
+		seen[name] = true
+		if env {
+			return v4EnvName(name) + "=" + value
+		}
+		return "--" + name + "=" + value
+	}
+
+	var branch, rev *v3Setting
+	repo := ""
+	ssh := false
+	for i := range settings {
+		s := settings[i]
+		useEnv = useEnv && s.env
+		switch s.name {
+		case "branch":
+			branch = &settings[i]
+			continue
+		case "rev":
+			rev = &settings[i]
+			continue
+		case "repo":
+			repo = s.value
+		case "ssh":
+			ssh, _ = strconv.ParseBool(s.value)
+		}
+
+		v4, found := v4Settings[s.name]
+		if !found {
+			problems = append(problems, fmt.Sprintf("--%s: not supported by v4", s.name))
+			continue
+		}
+		addNote(v4.note)
+		if v4.name == "" {
+			continue
+		}
+		value := s.value
+		if v4.convert != nil {
+			v, err := v4.convert(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("--%s: %v", s.name, err))
+				continue
+			}
+			value = v
+		}
+		out = append(out, format(v4.name, value, s.env))
+	}
+
+	// v4 has one --ref, which is the --rev if there is one, or else the
+	// --branch.
+	switch {
+	case rev != nil && rev.value != "HEAD":
+		out = append(out, format("ref", rev.value, rev.env))
+		addNote("--branch and --rev are now --ref")
+		if branch != nil {
+			addNote("--branch is dropped, since --rev is set")
+		}
+	case branch != nil:
+		out = append(out, format("ref", branch.value, branch.env))
+		addNote("--branch and --rev are now --ref")
+	}
+
+	if ssh && repo != "" && !isSSHURL(repo) {
+		problems = append(problems, fmt.Sprintf("--ssh: v4 uses SSH only for SSH repo URLs, and %q is not one", repo))
+	}
+
+	for _, d := range v4Defaults {
+		if !seen[d.name] {
+			out = append(out, format(d.name, d.value, useEnv))
+			addNote(d.note)
+		}
+	}
+	return out, notes, problems
+}
+
+// migrateFlagsMain runs the migrate-flags subcommand with args, which are v3
+// flags and env vars, and returns the exit code: 1 if anything can't be
+// migrated.
+func migrateFlagsMain(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "usage: %s %s [--flag=value | NAME=value]...\n", os.Args[0], migrateFlagsCmd)
+		return 2
+	}
+	out, notes, problems := migrateFlags(args)
+	if len(problems) != 0 {
+		fmt.Fprintf(stderr, "ERROR: can't migrate to v4:\n")
+		for _, p := range problems {
+			fmt.Fprintf(stderr, "  %s\n", p)
+		}
+		return 1
+	}
+	for _, s := range out {
+		fmt.Fprintln(stdout, s)
+	}
+	for _, n := range notes {
+		fmt.Fprintf(stderr, "NOTE: %s\n", n)
+	}
+	return 0
+}
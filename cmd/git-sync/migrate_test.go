@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateFlags(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		expect   []string
+		problems int
+	}{{
+		name: "renamed flags",
+		args: []string{"--repo=https://example.com/repo", "--dest", "app", "--wait=30", "--timeout=600", "--branch=main", "--depth=1", "--one-time"},
+		expect: []string{
+			"--repo=https://example.com/repo",
+			"--link=app",
+			"--period=30s",
+			"--sync-timeout=10m0s",
+			"--depth=1",
+			"--one-time=true",
+			"--ref=main",
+		},
+	}, {
+		name:   "rev wins over branch",
+		args:   []string{"--repo=https://example.com/repo", "--branch=main", "--rev=v1.0.0", "--depth=0", "--wait=1"},
+		expect: []string{"--repo=https://example.com/repo", "--depth=0", "--period=1s", "--ref=v1.0.0"},
+	}, {
+		name: "env vars and changed defaults",
+		args: []string{"GIT_SYNC_REPO=https://example.com/repo", "GIT_SYNC_HOOK_COMMAND=/hook", "GIT_SYNC_PERMISSIONS=0777", "GIT_ASKPASS_URL=http://localhost/creds"},
+		expect: []string{
+			"GITSYNC_REPO=https://example.com/repo",
+			"GITSYNC_EXECHOOK_COMMAND=/hook",
+			"GITSYNC_PERMISSIONS=0777",
+			"GITSYNC_ASKPASS_URL=http://localhost/creds",
+			"GITSYNC_REF=master",
+			"GITSYNC_DEPTH=0",
+			"GITSYNC_PERIOD=1s",
+		},
+	}, {
+		name:   "ssh is inferred",
+		args:   []string{"--repo=git@example.com:org/repo", "--ssh", "--ssh-key-file=/etc/git-secret/ssh", "--branch=main", "--depth=0", "--wait=1"},
+		expect: []string{"--repo=git@example.com:org/repo", "--ssh-key-file=/etc/git-secret/ssh", "--depth=0", "--period=1s", "--ref=main"},
+	}, {
+		name:     "ssh with an https URL",
+		args:     []string{"--repo=https://example.com/repo", "--ssh", "--branch=main", "--depth=0", "--wait=1"},
+		problems: 1,
+	}, {
+		name:     "unsupported flags and env vars",
+		args:     []string{"--repo=https://example.com/repo", "--gc-interval=1h", "GIT_SYNC_BLUE_GREEN=true", "HOME=/tmp", "--wait=soon"},
+		problems: 4,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, _, problems := migrateFlags(tc.args)
+			if len(problems) != tc.problems {
+				t.Fatalf("expected %d problems, got %q", tc.problems, problems)
+			}
+			if tc.problems == 0 && !reflect.DeepEqual(out, tc.expect) {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tc.expect, "\n"), strings.Join(out, "\n"))
+			}
+		})
+	}
+}
+
+func TestIsSSHURL(t *testing.T) {
+	cases := map[string]bool{
+		"ssh://git@example.com/org/repo": true,
+		"git@example.com:org/repo":       true,
+		"https://example.com/org/repo":   false,
+		"https://user@example.com:443/x": false,
+		"/local/path":                    false,
+	}
+	for repo, expect := range cases {
+		if got := isSSHURL(repo); got != expect {
+			t.Errorf("%q: expected %v, got %v", repo, expect, got)
+		}
+	}
+}
+
+func TestMigrateFlagsMain(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := migrateFlagsMain([]string{"--dest=app", "--gc-nice=5"}, stdout, stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "--gc-nice") {
+		t.Errorf("expected only an error naming --gc-nice, got %q and %q", stdout, stderr)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := migrateFlagsMain([]string{"--dest=app", "--branch=main", "--depth=0", "--wait=1"}, stdout, stderr); code != 0 {
+		t.Errorf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if stdout.String() != "--link=app\n--depth=0\n--period=1s\n--ref=main\n" {
+		t.Errorf("unexpected output: %q", stdout)
+	}
+	if !strings.Contains(stderr.String(), "NOTE: --dest is now --link") {
+		t.Errorf("expected a note about --dest, got %q", stderr)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// errNoReader is returned by writeFifo when nothing has the pipe open for
+// reading.
+var errNoReader = errors.New("no reader")
+
+// readPidFile returns the PID in the file at path.
+func readPidFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID in %s: %q", path, strings.TrimSpace(string(data)))
+	}
+	return pid, nil
+}
+
+// notifyConsumers tells co-located processes that hash was published, by
+// signalling --notify-pid or --notify-pid-file, and by writing the hash to
+// --notify-fifo.  Failures are logged, since the hash is already live.
+func notifyConsumers(hash string) {
+	pid := *flNotifyPid
+	if *flNotifyPidFile != "" {
+		var err error
+		if pid, err = readPidFile(*flNotifyPidFile); err != nil {
+			log.Error(err, "can't read notify PID file", "path", *flNotifyPidFile)
+		}
+	}
+	if pid > 0 {
+		log.V(1).Info("signalling consumer", "pid", pid, "signal", *flNotifySignal, "hash", hash)
+		if err := signalProcess(pid, *flNotifySignal); err != nil {
+			log.Error(err, "can't signal consumer", "pid", pid, "signal", *flNotifySignal)
+		}
+	}
+
+	if *flNotifyFifo != "" {
+		err := writeFifo(*flNotifyFifo, hash+"\n")
+		if err == errNoReader {
+			log.V(1).Info("nothing is reading the notify fifo", "path", *flNotifyFifo, "hash", hash)
+		} else if err != nil {
+			log.Error(err, "can't write to notify fifo", "path", *flNotifyFifo)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPidFile(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		content string
+		pid     int
+		err     bool
+	}{
+		{content: "1234\n", pid: 1234},
+		{content: "  42  ", pid: 42},
+		{content: "", err: true},
+		{content: "abc", err: true},
+		{content: "-1", err: true},
+		{content: "0", err: true},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(dir, "pid")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pid, err := readPidFile(path)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", tc.content, pid)
+			}
+			continue
+		}
+		if err != nil || pid != tc.pid {
+			t.Errorf("%q: expected %d, got %d, %v", tc.content, tc.pid, pid, err)
+		}
+	}
+	if _, err := readPidFile(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
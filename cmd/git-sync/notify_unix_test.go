@@ -0,0 +1,109 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"HUP", "hup", "SIGHUP", "sighup"} {
+		if sig, err := parseSignal(name); err != nil || sig != syscall.SIGHUP {
+			t.Errorf("%q: expected SIGHUP, got %v, %v", name, sig, err)
+		}
+	}
+	if _, err := parseSignal("KILL"); err == nil {
+		t.Errorf("expected an error for KILL")
+	}
+}
+
+func TestNotifyConsumers(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(pid int, pidFile, sig, fifo string) {
+		*flNotifyPid, *flNotifyPidFile, *flNotifySignal, *flNotifyFifo = pid, pidFile, sig, fifo
+	}(*flNotifyPid, *flNotifyPidFile, *flNotifySignal, *flNotifyFifo)
+
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Fatal(err)
+	}
+	*flNotifyPid = 0
+	*flNotifyPidFile = ""
+	*flNotifyFifo = fifo
+
+	// Nothing is reading yet, so this must not block.
+	if err := writeFifo(fifo, hash1+"\n"); err != errNoReader {
+		t.Errorf("expected errNoReader, got %v", err)
+	}
+	notifyConsumers(hash1)
+
+	// O_RDWR keeps the open from blocking until a writer shows up.
+	r, err := os.OpenFile(fifo, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	notifyConsumers(hash2)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil || line != hash2+"\n" {
+		t.Errorf("expected %q from the fifo, got %q, %v", hash2+"\n", line, err)
+	}
+
+	// Anything but a named pipe is refused, and left alone.
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(fifo, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{file, link, filepath.Join(dir, "missing")} {
+		if err := writeFifo(path, hash1+"\n"); err == nil || err == errNoReader {
+			t.Errorf("%s: expected an error, got %v", path, err)
+		}
+	}
+	if data, err := ioutil.ReadFile(file); err != nil || len(data) != 0 {
+		t.Errorf("expected the file to be left alone, got %q, %v", data, err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	*flNotifyFifo = ""
+	*flNotifyPid = os.Getpid()
+	*flNotifySignal = "USR2"
+	notifyConsumers(hash1)
+	select {
+	case <-sigs:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected a SIGUSR2")
+	}
+}
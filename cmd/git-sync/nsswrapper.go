@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// The files which seed the nss_wrapper databases.
+const (
+	systemPasswdFile = "/etc/passwd"
+	systemGroupFile  = "/etc/group"
+)
+
+// nssWrapperEnv are the environment variables which make a process use
+// nss_wrapper, which git-sync sets for itself, and so for git and ssh.
+var nssWrapperEnv = []string{"LD_PRELOAD", "NSS_WRAPPER_PASSWD", "NSS_WRAPPER_GROUP"}
+
+// setupNSSWrapper makes the processes git-sync runs look users up with the
+// nss_wrapper library at lib, in passwdFile rather than /etc/passwd, so that
+// --add-user works when /etc/passwd can't be written.  passwdFile is seeded
+// from /etc/passwd if it doesn't exist yet; groups are still looked up in
+// /etc/group.
+func setupNSSWrapper(lib, passwdFile string) error {
+	log.V(1).Info("setting up nss_wrapper", "lib", lib, "passwd", passwdFile)
+
+	if _, err := os.Stat(lib); err != nil {
+		return fmt.Errorf("can't find nss_wrapper: %w", err)
+	}
+	if _, err := os.Stat(passwdFile); os.IsNotExist(err) {
+		data, err := ioutil.ReadFile(systemPasswdFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := ioutil.WriteFile(passwdFile, data, 0644); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	preload := lib
+	if old := os.Getenv("LD_PRELOAD"); old != "" {
+		preload = lib + " " + old
+	}
+	for _, kv := range [][2]string{
+		{"LD_PRELOAD", preload},
+		{"NSS_WRAPPER_PASSWD", passwdFile},
+		{"NSS_WRAPPER_GROUP", systemGroupFile},
+	} {
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("can't set $%s: %w", kv[0], err)
+		}
+	}
+	// git and ssh need these to find the user, whatever --git-env says.
+	envAlwaysPassed = append(envAlwaysPassed, nssWrapperEnv...)
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestSetupNSSWrapper(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(always []string) { envAlwaysPassed = always }(envAlwaysPassed)
+	for _, name := range nssWrapperEnv {
+		defer func(name, v string, set bool) {
+			if set {
+				os.Setenv(name, v)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name, os.Getenv(name), os.Getenv(name) != "")
+	}
+
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "libnss_wrapper.so")
+	if err := ioutil.WriteFile(lib, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	passwd := filepath.Join(dir, "passwd")
+
+	if err := setupNSSWrapper(filepath.Join(dir, "missing.so"), passwd); err == nil {
+		t.Errorf("expected an error for a missing library")
+	}
+
+	os.Setenv("LD_PRELOAD", "other.so")
+	if err := setupNSSWrapper(lib, passwd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, _ := ioutil.ReadFile(systemPasswdFile)
+	if got, err := ioutil.ReadFile(passwd); err != nil || string(got) != string(want) {
+		t.Errorf("expected the passwd file to be seeded from %s, got %v", systemPasswdFile, err)
+	}
+	for name, want := range map[string]string{
+		"LD_PRELOAD":         lib + " other.so",
+		"NSS_WRAPPER_PASSWD": passwd,
+		"NSS_WRAPPER_GROUP":  systemGroupFile,
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("expected $%s to be %q, got %q", name, want, got)
+		}
+		if !(envFilter{allow: []string{"PATH"}}).passes(name) {
+			t.Errorf("expected $%s to always be passed", name)
+		}
+	}
+
+	// An existing file is kept, e.g. after a restart.
+	if err := ioutil.WriteFile(passwd, []byte("kept\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupNSSWrapper(lib, passwd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := ioutil.ReadFile(passwd); string(got) != "kept\n" {
+		t.Errorf("expected the existing passwd file to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseOCIRef(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	cases := []struct {
+		in   string
+		want ociRef
+		err  bool
+	}{
+		{in: "ghcr.io/org/manifests:v1", want: ociRef{"ghcr.io", "org/manifests", "v1"}},
+		{in: "oci://ghcr.io/org/manifests", want: ociRef{"ghcr.io", "org/manifests", "latest"}},
+		{in: "localhost:5000/manifests:v2", want: ociRef{"localhost:5000", "manifests", "v2"}},
+		{in: "localhost/a/b", want: ociRef{"localhost", "a/b", "latest"}},
+		{in: "example.com/a@" + digest, want: ociRef{"example.com", "a", digest}},
+		{in: "ubuntu", want: ociRef{"registry-1.docker.io", "library/ubuntu", "latest"}},
+		{in: "org/thing:1.0", want: ociRef{"registry-1.docker.io", "org/thing", "1.0"}},
+		{in: "", err: true},
+		{in: "example.com/a:", err: true},
+		{in: "example.com/", err: true},
+		{in: "example.com/a@sha256:1234", err: true},
+		{in: "example.com/a@md5:" + strings.Repeat("ab", 32), err: true},
+	}
+	for _, tc := range cases {
+		got, err := parseOCIRef(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %+v", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+		} else if got != tc.want {
+			t.Errorf("%q: expected %+v, got %+v", tc.in, tc.want, got)
+		}
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	got := parseAuthChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull,push",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	for _, name := range []string{"a", "a/b", "./a", "a/../b", "."} {
+		if _, err := safeJoin("/root", name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"..", "../a", "a/../../b", "/../a"} {
+		if _, err := safeJoin("/root", name); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+}
+
+// tarGz builds a gzipped tar of the specified files.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	zw.Close()
+	return buf.Bytes()
+}
+
+// fakeRegistry serves one manifest, and requires a bearer token.
+type fakeRegistry struct {
+	blobs    map[string][]byte
+	manifest []byte
+	digest   string
+}
+
+func newFakeRegistry(t *testing.T, layers []ociDescriptor, blobs [][]byte) *fakeRegistry {
+	r := &fakeRegistry{blobs: map[string][]byte{}}
+	for i := range layers {
+		layers[i].Digest = "sha256:" + sha256Hex(blobs[i])
+		layers[i].Size = int64(len(blobs[i]))
+		r.blobs[layers[i].Digest] = blobs[i]
+	}
+	m, err := json.Marshal(ociManifest{MediaType: ociManifestType, Layers: layers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.manifest = m
+	r.digest = "sha256:" + sha256Hex(m)
+	return r
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "pass" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token": "secret"}`))
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="test",scope="repository:org/artifact:pull"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case req.URL.Path == "/v2/org/artifact/manifests/v1" || req.URL.Path == "/v2/org/artifact/manifests/"+r.digest:
+		w.Header().Set("Content-Type", ociManifestType)
+		w.Header().Set("Docker-Content-Digest", r.digest)
+		w.Write(r.manifest)
+	case strings.HasPrefix(req.URL.Path, "/v2/org/artifact/blobs/"):
+		blob, found := r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/org/artifact/blobs/")]
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(blob)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestOCIPull(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	layers := []ociDescriptor{
+		{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip"},
+		{MediaType: "application/vnd.example.config", Annotations: map[string]string{ociTitleAnnotation: "config.yaml"}},
+	}
+	blobs := [][]byte{
+		tarGz(t, map[string]string{"manifests/app.yaml": "kind: Deployment\n"}),
+		[]byte("key: value\n"),
+	}
+	reg := newFakeRegistry(t, layers, blobs)
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	ref, err := parseOCIRef(strings.TrimPrefix(srv.URL, "http://") + "/org/artifact:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c := &ociClient{client: srv.Client()}
+	if _, err := c.resolve(ctx, ref); err == nil || !isAuthError(err) {
+		t.Fatalf("expected an authentication error without credentials, got %v", err)
+	}
+
+	c = &ociClient{client: srv.Client(), username: "user", password: "pass"}
+	digest, err := c.resolve(ctx, ref)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if digest != reg.digest {
+		t.Fatalf("expected digest %s, got %s", reg.digest, digest)
+	}
+
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := c.pull(ctx, ref, digest, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, want := range map[string]string{
+		"manifests/app.yaml": "kind: Deployment\n",
+		"config.yaml":        "key: value\n",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	// A corrupted blob must be rejected.
+	for d := range reg.blobs {
+		reg.blobs[d] = append(reg.blobs[d], 'x')
+	}
+	os.RemoveAll(dir)
+	if err := c.pull(ctx, ref, digest, dir); err == nil {
+		t.Errorf("expected an error for a corrupted blob")
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	for _, hdr := range []tar.Header{
+		{Name: "../evil", Typeflag: tar.TypeReg},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../../evil"},
+	} {
+		buf := bytes.Buffer{}
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&hdr)
+		tw.Close()
+		if err := extractTar(&buf, t.TempDir()); err == nil {
+			t.Errorf("%s: expected error", hdr.Name)
+		}
+	}
+}
+
+func TestExtractTarRejectsSymlinkChains(t *testing.T) {
+	cases := map[string][]tar.Header{
+		"nested-symlinks": {
+			{Name: "s1", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "s1/s2", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "s1/s2/evil", Typeflag: tar.TypeReg},
+		},
+		"file-through-symlink": {
+			{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "d/file", Typeflag: tar.TypeReg},
+		},
+		"overwrite-symlink": {
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "file"},
+			{Name: "link", Typeflag: tar.TypeReg},
+		},
+		"hardlink-to-symlink": {
+			{Name: "a/b/link", Typeflag: tar.TypeSymlink, Linkname: "../x"},
+			{Name: "copy", Typeflag: tar.TypeLink, Linkname: "a/b/link"},
+		},
+	}
+	for name, hdrs := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			tw := tar.NewWriter(&buf)
+			for i := range hdrs {
+				if err := tw.WriteHeader(&hdrs[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tw.Close()
+			root := t.TempDir()
+			dir := filepath.Join(root, "artifact")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := extractTar(&buf, dir); err == nil {
+				t.Errorf("expected error")
+			}
+			if _, err := os.Lstat(filepath.Join(root, "evil")); err == nil {
+				t.Errorf("file was written outside of the artifact")
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pauseMode says how much of the sync loop is paused.
+type pauseMode string
+
+const (
+	// pauseNone means syncing normally.
+	pauseNone pauseMode = ""
+	// pausePublish keeps fetching, but does not flip the link.
+	pausePublish pauseMode = "publish"
+	// pauseAll stops syncing entirely.
+	pauseAll pauseMode = "all"
+)
+
+// pauseFileName is the control file, under --root, which pauses syncing while
+// it exists.  It may contain a mode ("publish" or "all"); empty means
+// "publish".
+const pauseFileName = ".git-sync-pause"
+
+var pausedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "git_sync_paused",
+	Help: "Whether syncing is paused (1) or not (0), partitioned by mode (publish, all)",
+}, []string{"mode"})
+
+func init() {
+	prometheus.MustRegister(pausedGauge)
+}
+
+// pauseState tracks whether syncing has been paused through the API.
+type pauseState struct {
+	mutex sync.Mutex
+	api   pauseMode
+	// last is the most recently computed mode, to log changes.
+	last pauseMode
+}
+
+var pauses pauseState
+
+func parsePauseMode(s string) (pauseMode, error) {
+	switch pauseMode(s) {
+	case "", pausePublish:
+		return pausePublish, nil
+	case pauseAll:
+		return pauseAll, nil
+	}
+	return pauseNone, fmt.Errorf("invalid pause mode %q: must be %q or %q", s, pausePublish, pauseAll)
+}
+
+func (p *pauseState) set(mode pauseMode) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.api = mode
+}
+
+// mode returns the effective pause mode: the stronger of the mode set
+// through the API and the mode in the control file under gitRoot.
+func (p *pauseState) mode(gitRoot string) pauseMode {
+	fileMode := readPauseFile(gitRoot)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	mode := p.api
+	if mode == pauseNone || fileMode == pauseAll {
+		mode = fileMode
+	}
+
+	if mode != p.last {
+		if mode == pauseNone {
+			log.V(0).Info("syncing resumed")
+		} else {
+			log.V(0).Info("syncing paused", "mode", mode)
+		}
+		p.last = mode
+	}
+	for _, m := range []pauseMode{pausePublish, pauseAll} {
+		v := 0.0
+		if m == mode {
+			v = 1
+		}
+		pausedGauge.WithLabelValues(string(m)).Set(v)
+	}
+	return mode
+}
+
+// readPauseFile returns the mode in the control file under gitRoot, or
+// pauseNone if there is no control file.
+func readPauseFile(gitRoot string) pauseMode {
+	data, err := ioutil.ReadFile(filepath.Join(gitRoot, pauseFileName))
+	if os.IsNotExist(err) {
+		return pauseNone
+	}
+	if err != nil {
+		log.Error(err, "can't read pause file, assuming publishing is paused")
+		return pausePublish
+	}
+	mode, err := parsePauseMode(strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Error(err, "invalid pause file, assuming publishing is paused")
+		return pausePublish
+	}
+	return mode
+}
+
+// servePause handles requests to the /api/v1/pause endpoint.  The "mode"
+// query parameter selects the pause mode, which defaults to "publish".
+func servePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mode, err := parsePauseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pauses.set(mode)
+	fmt.Fprintf(w, "paused: %s\n", pauses.mode(*flRoot))
+}
+
+// serveResume handles requests to the /api/v1/resume endpoint, and starts a
+// sync right away if nothing else keeps syncing paused.  This does not remove
+// the control file, if there is one.
+func serveResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pauses.set(pauseNone)
+	if mode := pauses.mode(*flRoot); mode != pauseNone {
+		fmt.Fprintf(w, "still paused by %s: %s\n", pauseFileName, mode)
+		return
+	}
+	triggerSync(triggerHTTP)
+	fmt.Fprintln(w, "resumed")
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestPauseMode(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	root := t.TempDir()
+	controlFile := filepath.Join(root, pauseFileName)
+
+	cases := []struct {
+		name string
+		api  pauseMode
+		file *string
+		want pauseMode
+	}{
+		{name: "none", want: pauseNone},
+		{name: "api publish", api: pausePublish, want: pausePublish},
+		{name: "api all", api: pauseAll, want: pauseAll},
+		{name: "empty file", file: strPtr(""), want: pausePublish},
+		{name: "file all", file: strPtr("all\n"), want: pauseAll},
+		{name: "invalid file", file: strPtr("bogus"), want: pausePublish},
+		{name: "file overrides weaker api", api: pausePublish, file: strPtr("all"), want: pauseAll},
+		{name: "api overrides weaker file", api: pauseAll, file: strPtr("publish"), want: pauseAll},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := pauseState{api: tc.api}
+			if tc.file != nil {
+				if err := ioutil.WriteFile(controlFile, []byte(*tc.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(controlFile)
+			}
+			if got := p.mode(root); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestServePause(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	root := t.TempDir()
+	oldRoot := *flRoot
+	*flRoot = root
+	defer func() {
+		*flRoot = oldRoot
+		pauses.set(pauseNone)
+	}()
+
+	do := func(method, url string, handler http.HandlerFunc) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, url, nil))
+		return w.Code
+	}
+
+	if code := do("GET", "/api/v1/pause", servePause); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected %d, got %d", http.StatusMethodNotAllowed, code)
+	}
+	if code := do("POST", "/api/v1/pause?mode=bogus", servePause); code != http.StatusBadRequest {
+		t.Errorf("bad mode: expected %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := do("POST", "/api/v1/pause?mode=all", servePause); code != http.StatusOK {
+		t.Errorf("pause: expected %d, got %d", http.StatusOK, code)
+	}
+	if got := pauses.mode(root); got != pauseAll {
+		t.Errorf("expected %q, got %q", pauseAll, got)
+	}
+	if code := do("POST", "/api/v1/resume", serveResume); code != http.StatusOK {
+		t.Errorf("resume: expected %d, got %d", http.StatusOK, code)
+	}
+	if got := pauses.mode(root); got != pauseNone {
+		t.Errorf("expected %q, got %q", pauseNone, got)
+	}
+	select {
+	case reason := <-syncNow:
+		if reason != triggerHTTP {
+			t.Errorf("expected a sync requested by %q, got %q", triggerHTTP, reason)
+		}
+	default:
+		t.Errorf("expected resuming to request a sync")
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var peersReadyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_peers_ready",
+	Help: "How many peers have fetched the hash that is waiting to be published",
+})
+
+var peerQuorumTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_peer_quorum_timeouts_total",
+	Help: "How many times a hash was not published because a quorum of peers had not fetched it in time",
+})
+
+func init() {
+	prometheus.MustRegister(peersReadyGauge)
+	prometheus.MustRegister(peerQuorumTimeouts)
+}
+
+// errNoQuorum is returned when a quorum of peers has not fetched the hash to
+// be published within --peer-timeout.
+var errNoQuorum = errors.New("peer quorum not reached")
+
+// peerPollInterval is how often peers are asked for their status while
+// waiting for a quorum.
+const peerPollInterval = time.Second
+
+// peerGateEnabled returns true if publishing is gated on peers.
+func peerGateEnabled() bool {
+	return *flPeerURLs != "" || *flPeerDNS != ""
+}
+
+// peerScheme returns the scheme of peers' URLs with --peer-dns.  All replicas
+// share their flags, so peers serve TLS if this one does.
+func peerScheme() string {
+	if *flHTTPTLSCert != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// peerEndpoints returns the base URLs of all peers.  With --peer-dns, the
+// name is resolved again on every call, so that the list follows scaling.
+func peerEndpoints(ctx context.Context) ([]string, error) {
+	if *flPeerURLs != "" {
+		urls := []string{}
+		for _, u := range strings.Split(*flPeerURLs, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, strings.TrimSuffix(u, "/"))
+			}
+		}
+		return urls, nil
+	}
+
+	host, port, err := net.SplitHostPort(*flPeerDNS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --peer-dns: %v", err)
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve peers: %v", err)
+	}
+	sort.Strings(addrs)
+	urls := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		urls = append(urls, peerScheme()+"://"+net.JoinHostPort(a, port))
+	}
+	return urls, nil
+}
+
+// peerClient returns the client with which peers are asked for their status.
+// Peers' certificates are verified against --peer-ca-file, if set, or the
+// system's roots.  With --peer-dns, peers are reached by address, so their
+// certificates must be valid for the --peer-dns host name instead.
+func peerClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if *flPeerCAFile != "" {
+		ca, err := ioutil.ReadFile(*flPeerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read --peer-ca-file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("can't parse --peer-ca-file %s", *flPeerCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if *flPeerDNS != "" {
+		host, _, err := net.SplitHostPort(*flPeerDNS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --peer-dns: %v", err)
+		}
+		tlsConfig.ServerName = host
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: peerPollInterval, Transport: transport}, nil
+}
+
+// quorumSize returns how many of n peers must agree.  A quorum of 0 means a
+// majority.
+func quorumSize(n, quorum int) int {
+	if quorum <= 0 {
+		return n/2 + 1
+	}
+	return quorum
+}
+
+// peerHasHash asks the peer at baseURL whether it has fetched or published
+// hash.
+func peerHasHash(ctx context.Context, client *http.Client, baseURL, hash string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/v1/status", nil)
+	if err != nil {
+		return false, err
+	}
+	apiToken.authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var st statusReport
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return false, fmt.Errorf("can't decode status: %v", err)
+	}
+	return st.Hash == hash || st.Fetched == hash, nil
+}
+
+// countPeers returns how many of the peers at urls have fetched or published
+// hash.  Peers which can't be reached don't count.
+func countPeers(ctx context.Context, client *http.Client, urls []string, hash string) int {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		count int
+	)
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			ok, err := peerHasHash(ctx, client, u, hash)
+			if err != nil {
+				log.V(2).Info("can't get peer status", "peer", u, "error", err.Error())
+				return
+			}
+			if ok {
+				mutex.Lock()
+				count++
+				mutex.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+	return count
+}
+
+// waitForPeers waits until a quorum of peers has fetched or published hash,
+// or returns errNoQuorum after --peer-timeout.
+func waitForPeers(ctx context.Context, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, *flPeerTimeout)
+	defer cancel()
+	client, err := peerClient()
+	if err != nil {
+		return err
+	}
+
+	for {
+		urls, err := peerEndpoints(ctx)
+		if err != nil {
+			log.Error(err, "can't list peers")
+		} else {
+			need := quorumSize(len(urls), *flPeerQuorum)
+			have := countPeers(ctx, client, urls, hash)
+			peersReadyGauge.Set(float64(have))
+			if have >= need {
+				log.V(0).Info("peer quorum reached", "hash", hash, "peers", len(urls), "ready", have, "quorum", need)
+				return nil
+			}
+			log.V(1).Info("waiting for peers", "hash", hash, "peers", len(urls), "ready", have, "quorum", need)
+		}
+
+		select {
+		case <-ctx.Done():
+			peerQuorumTimeouts.Inc()
+			return errNoQuorum
+		case <-time.After(peerPollInterval):
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestQuorumSize(t *testing.T) {
+	cases := []struct{ n, quorum, want int }{
+		{1, 0, 1},
+		{2, 0, 2},
+		{3, 0, 2},
+		{4, 0, 3},
+		{5, 2, 2},
+	}
+	for _, tc := range cases {
+		if got := quorumSize(tc.n, tc.quorum); got != tc.want {
+			t.Errorf("quorumSize(%d, %d): expected %d, got %d", tc.n, tc.quorum, tc.want, got)
+		}
+	}
+}
+
+// fakePeer serves a fixed /api/v1/status.
+func fakePeer(t *testing.T, st statusReport) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/status" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(st)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWaitForPeers(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(urls string, quorum int, timeout time.Duration) {
+		*flPeerURLs, *flPeerQuorum, *flPeerTimeout = urls, quorum, timeout
+	}(*flPeerURLs, *flPeerQuorum, *flPeerTimeout)
+
+	published := fakePeer(t, statusReport{Hash: hash1})
+	fetched := fakePeer(t, statusReport{Hash: hash2, Fetched: hash1})
+	behind := fakePeer(t, statusReport{Hash: hash2})
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	ctx := context.Background()
+	urls := []string{published.URL, fetched.URL, behind.URL, down.URL}
+	if got := countPeers(ctx, http.DefaultClient, urls, hash1); got != 2 {
+		t.Errorf("expected 2 peers to have the hash, got %d", got)
+	}
+
+	*flPeerURLs = strings.Join(urls, ", ")
+	*flPeerTimeout = 100 * time.Millisecond
+	*flPeerQuorum = 2
+	if err := waitForPeers(ctx, hash1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	*flPeerQuorum = 0
+	if err := waitForPeers(ctx, hash1); err != errNoQuorum {
+		t.Errorf("expected %v, got %v", errNoQuorum, err)
+	}
+}
+
+func TestWaitForPeersTLS(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(urls, ca string, quorum int, timeout time.Duration) {
+		*flPeerURLs, *flPeerCAFile, *flPeerQuorum, *flPeerTimeout = urls, ca, quorum, timeout
+	}(*flPeerURLs, *flPeerCAFile, *flPeerQuorum, *flPeerTimeout)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(statusReport{Hash: hash1})
+	}))
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	*flPeerURLs = srv.URL
+	*flPeerTimeout = 100 * time.Millisecond
+	*flPeerQuorum = 1
+	*flPeerCAFile = ""
+	if err := waitForPeers(ctx, hash1); err != errNoQuorum {
+		t.Errorf("expected an unverified peer not to count, got %v", err)
+	}
+	*flPeerCAFile = caFile
+	if err := waitForPeers(ctx, hash1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPeerEndpointsScheme(t *testing.T) {
+	defer func(urls, dns, cert string) {
+		*flPeerURLs, *flPeerDNS, *flHTTPTLSCert = urls, dns, cert
+	}(*flPeerURLs, *flPeerDNS, *flHTTPTLSCert)
+
+	*flPeerURLs = ""
+	*flPeerDNS = "127.0.0.1:8080"
+	for cert, want := range map[string]string{"": "http://127.0.0.1:8080", "/tls/cert.pem": "https://127.0.0.1:8080"} {
+		*flHTTPTLSCert = cert
+		urls, err := peerEndpoints(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(urls) != 1 || urls[0] != want {
+			t.Errorf("expected [%s], got %v", want, urls)
+		}
+	}
+}
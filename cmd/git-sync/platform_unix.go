@@ -0,0 +1,261 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// canChangePermissions is true if --change-permissions is supported.
+const canChangePermissions = true
+
+// canMatchRootGroup is true if --match-root-group is supported.
+const canMatchRootGroup = true
+
+// canLockRoot is true if --lock-timeout is supported.
+const canLockRoot = true
+
+// canCheckFreeSpace is true if --min-free-space is supported.
+const canCheckFreeSpace = true
+
+// canReExec is true if --max-runtime-action=re-exec is supported.
+const canReExec = true
+
+// canMultiplexSSH is true if --ssh-control-persist is supported.
+const canMultiplexSSH = true
+
+// Put the current UID/GID into the passwd file at path (normally /etc/passwd)
+// so SSH can look it up.  This assumes that we have the permissions to write
+// to it.
+func addUser(path string) error {
+	home := os.Getenv("HOME")
+	if home == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("can't get working directory and $HOME is not set: %w", err)
+		}
+		home = cwd
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	str := fmt.Sprintf("git-sync:x:%d:%d::%s:/sbin/nologin\n", os.Getuid(), os.Getgid(), home)
+	_, err = f.WriteString(str)
+	return err
+}
+
+// replaceSymlink atomically points link (relative to gitRoot) at target.
+func replaceSymlink(ctx context.Context, gitRoot, target, link string) error {
+	const tmplink = "tmp-link"
+	log.V(1).Info("creating tmp symlink", "root", gitRoot, "dst", target, "src", tmplink)
+	if _, err := runCommand(ctx, gitRoot, "ln", "-snf", target, tmplink); err != nil {
+		return fmt.Errorf("error creating symlink: %v", err)
+	}
+
+	log.V(1).Info("renaming symlink", "root", gitRoot, "old_name", tmplink, "new_name", link)
+	if _, err := runCommand(ctx, gitRoot, "mv", "-T", tmplink, link); err != nil {
+		return fmt.Errorf("error replacing symlink: %v", err)
+	}
+	return nil
+}
+
+// changePermissions recursively applies mode to everything under dir.
+func changePermissions(ctx context.Context, dir string, mode int) error {
+	_, err := runCommand(ctx, "", "chmod", "-R", fmt.Sprintf("%#o", mode), dir)
+	return err
+}
+
+// pathGroup returns the group ID which owns path.
+func pathGroup(path string) (int, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("can't get the owner of %s", path)
+	}
+	return int(st.Gid), nil
+}
+
+// matchGroup changes the group of everything under dir to gid, and gives the
+// group the same read and execute access as the owner.  Directories also get
+// the setgid bit, so files created in them later (e.g. by hooks) keep gid.
+func matchGroup(dir string, gid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, -1, gid); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		mode := info.Mode() | (info.Mode()&0500)>>3
+		if info.IsDir() {
+			mode |= os.ModeSetgid | 0050
+		}
+		if mode == info.Mode() {
+			return nil
+		}
+		return os.Chmod(path, mode)
+	})
+}
+
+// setProcessGroup makes cmd the leader of a new process group, so that
+// killProcessGroup can reach any children it forks.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd and everything else in its process group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// signalsByName are the signals which --notify-signal accepts.
+var signalsByName = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal returns the signal named by name, with or without a "SIG"
+// prefix.
+func parseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalsByName[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// signalProcess sends the named signal to pid.
+func signalProcess(pid int, name string) error {
+	sig, err := parseSignal(name)
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// writeFifo writes msg to the named pipe at path, without blocking.  If
+// nothing is reading the pipe, nothing is written, and errNoReader is
+// returned.  path must be a named pipe itself, not a symlink or a regular
+// file, which would be followed or overwritten.
+func writeFifo(path, msg string) error {
+	if fi, err := os.Lstat(path); err != nil {
+		return err
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s is not a named pipe", path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK|syscall.O_NOFOLLOW, 0)
+	if errors.Is(err, syscall.ENXIO) {
+		return errNoReader
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// In case it was replaced since the Lstat.
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s is not a named pipe", path)
+	}
+	_, err = f.WriteString(msg)
+	return err
+}
+
+// tryLockFile takes an exclusive flock on f, without blocking.  It returns
+// false if another process holds it.
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases a lock taken by tryLockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// maxRSS returns the peak resident set size of a finished process, in bytes.
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	// Linux reports kilobytes; macOS reports bytes.
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss), true
+	}
+	return int64(ru.Maxrss) * 1024, true
+}
+
+// reExec replaces this process with a new run of the same binary, with the
+// same args and environment, keeping its PID.  It only returns on error.
+func reExec() error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, os.Args, os.Environ())
+}
+
+// Filesystem types, from statfs(2), which are backed by memory on Linux.
+const (
+	fsTypeTmpfs = 0x01021994
+	fsTypeRamfs = 0x858458f6
+)
+
+// statFilesystem returns the space on the filesystem which holds path.
+func statFilesystem(path string) (fsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+	typ := int64(st.Type)
+	return fsStats{
+		fsType: fmt.Sprintf("%#x", typ),
+		memory: runtime.GOOS == "linux" && (typ == fsTypeTmpfs || typ == fsTypeRamfs),
+		free:   uint64(st.Bavail) * uint64(st.Bsize),
+		total:  uint64(st.Blocks) * uint64(st.Bsize),
+	}, nil
+}
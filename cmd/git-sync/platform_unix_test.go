@@ -0,0 +1,93 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddUser(t *testing.T) {
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", "/home/x")
+	path := filepath.Join(t.TempDir(), "passwd")
+	if err := ioutil.WriteFile(path, []byte("root:x:0:0::/root:/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := addUser(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("root:x:0:0::/root:/bin/sh\ngit-sync:x:%d:%d::/home/x:/sbin/nologin\n", os.Getuid(), os.Getgid())
+	if string(data) != want {
+		t.Errorf("expected %q, got %q", want, string(data))
+	}
+}
+
+func TestMatchGroup(t *testing.T) {
+	gid := os.Getgid()
+	if os.Getuid() == 0 {
+		gid = 4321
+	}
+
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sub, "file")
+	if err := ioutil.WriteFile(file, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(sub, "script")
+	if err := ioutil.WriteFile(script, []byte("x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(sub, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := matchGroup(dir, gid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		sub:    os.ModeDir | os.ModeSetgid | 0750,
+		file:   0640,
+		script: 0750,
+	} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != want {
+			t.Errorf("%s: expected mode %v, got %v", path, want, fi.Mode())
+		}
+		if got, err := pathGroup(path); err != nil || got != gid {
+			t.Errorf("%s: expected gid %d, got %d (%v)", path, gid, got, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// canChangePermissions is true if --change-permissions is supported.  Windows
+// does not have unix-style permission bits.
+const canChangePermissions = false
+
+// canMatchRootGroup is true if --match-root-group is supported.  Windows
+// does not have unix-style groups.
+const canMatchRootGroup = false
+
+// canLockRoot is true if --lock-timeout is supported.  Windows does not have
+// flock.
+const canLockRoot = false
+
+// canCheckFreeSpace is true if --min-free-space is supported.  Windows does
+// not have statfs.
+const canCheckFreeSpace = false
+
+// canReExec is true if --max-runtime-action=re-exec is supported.  Windows
+// can't replace a running process.
+const canReExec = false
+
+// canMultiplexSSH is true if --ssh-control-persist is supported.  OpenSSH for
+// Windows has no ControlMaster.
+const canMultiplexSSH = false
+
+// addUser is not needed on Windows, which does not use /etc/passwd.
+func addUser(path string) error {
+	return fmt.Errorf("--add-user is not supported on Windows")
+}
+
+// replaceSymlink points link (relative to gitRoot) at target.  Windows can't
+// rename over an existing directory link, so unlike other platforms this is
+// not atomic: readers may briefly find no link.  Creating symlinks requires
+// either Developer Mode or the SeCreateSymbolicLinkPrivilege; if that fails,
+// this falls back to a directory junction, which must use an absolute path.
+func replaceSymlink(ctx context.Context, gitRoot, target, link string) error {
+	const tmplink = "tmp-link"
+	tmpPath := filepath.Join(gitRoot, tmplink)
+	linkPath := filepath.Join(gitRoot, link)
+
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing old tmp symlink: %v", err)
+	}
+	log.V(1).Info("creating tmp symlink", "root", gitRoot, "dst", target, "src", tmplink)
+	if err := os.Symlink(target, tmpPath); err != nil {
+		log.V(0).Info("can't create symlink, trying a junction", "error", err.Error())
+		abs := filepath.Join(gitRoot, target)
+		if _, err := runCommand(ctx, gitRoot, "cmd", "/c", "mklink", "/J", tmplink, abs); err != nil {
+			return fmt.Errorf("error creating junction: %v", err)
+		}
+	}
+
+	log.V(1).Info("renaming symlink", "root", gitRoot, "old_name", tmplink, "new_name", link)
+	if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing old symlink: %v", err)
+	}
+	if err := os.Rename(tmpPath, linkPath); err != nil {
+		return fmt.Errorf("error replacing symlink: %v", err)
+	}
+	return nil
+}
+
+// changePermissions is not supported on Windows.
+func changePermissions(ctx context.Context, dir string, mode int) error {
+	return fmt.Errorf("changing permissions is not supported on Windows")
+}
+
+// pathGroup is not supported on Windows.
+func pathGroup(path string) (int, error) {
+	return 0, fmt.Errorf("file groups are not supported on Windows")
+}
+
+// matchGroup is not supported on Windows.
+func matchGroup(dir string, gid int) error {
+	return fmt.Errorf("file groups are not supported on Windows")
+}
+
+// setProcessGroup is a no-op on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills cmd itself on Windows.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// parseSignal is not supported on Windows.
+func parseSignal(name string) (int, error) {
+	return 0, fmt.Errorf("signals are not supported on Windows")
+}
+
+// signalProcess is not supported on Windows.
+func signalProcess(pid int, name string) error {
+	return fmt.Errorf("signals are not supported on Windows")
+}
+
+// writeFifo is not supported on Windows.
+func writeFifo(path, msg string) error {
+	return fmt.Errorf("named pipes are not supported on Windows")
+}
+
+// tryLockFile is not supported on Windows.
+func tryLockFile(f *os.File) (bool, error) {
+	return false, fmt.Errorf("--lock-timeout is not supported on Windows")
+}
+
+// unlockFile is not supported on Windows.
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+// maxRSS is not supported on Windows.
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
+
+// reExec is not supported on Windows.
+func reExec() error {
+	return fmt.Errorf("--max-runtime-action=re-exec is not supported on Windows")
+}
+
+// statFilesystem is not supported on Windows.
+func statFilesystem(path string) (fsStats, error) {
+	return fsStats{}, fmt.Errorf("--min-free-space is not supported on Windows")
+}
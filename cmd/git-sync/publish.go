@@ -0,0 +1,240 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// metadataDir is where --metadata-link's directories are made, under --root.
+const metadataDir = ".metadata"
+
+// publishStep is one part of publishing a worktree, which can be undone if a
+// later part fails.
+type publishStep struct {
+	name string
+	do   func() error
+	undo func() error
+}
+
+// runPublishSteps runs steps in order.  If one fails, the ones before it are
+// undone, in reverse order, so that either all of them take effect or none
+// do.
+func runPublishSteps(steps []publishStep) error {
+	for i, step := range steps {
+		err := step.do()
+		if err == nil {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if uerr := steps[j].undo(); uerr != nil {
+				log.Error(uerr, "can't undo partial publish", "step", steps[j].name)
+			}
+		}
+		return fmt.Errorf("can't publish %s: %w", step.name, err)
+	}
+	return nil
+}
+
+// linkStep returns a step which points the link named link at target (both
+// relative to gitRoot), and which points it back at where it was on undo.
+func linkStep(ctx context.Context, gitRoot, link, target string) publishStep {
+	old, err := os.Readlink(filepath.Join(gitRoot, link))
+	hadLink := err == nil
+	return publishStep{
+		name: link,
+		do: func() error {
+			return replaceSymlink(ctx, gitRoot, target, link)
+		},
+		undo: func() error {
+			if !hadLink {
+				return os.Remove(filepath.Join(gitRoot, link))
+			}
+			return replaceSymlink(ctx, gitRoot, old, link)
+		},
+	}
+}
+
+// blueGreenStep returns a step which flips the --blue-green content
+// directories to worktree, and which restores the pointer file on undo.
+func blueGreenStep(gitRoot, dest, worktree string) publishStep {
+	path := filepath.Join(gitRoot, blueGreenPointerFile(dest))
+	old, err := ioutil.ReadFile(path)
+	hadPointer := err == nil
+	return publishStep{
+		name: blueGreenPointerFile(dest),
+		do: func() error {
+			return publishBlueGreen(gitRoot, dest, worktree)
+		},
+		undo: func() error {
+			if !hadPointer {
+				return os.Remove(path)
+			}
+			tmp := path + ".tmp"
+			if err := ioutil.WriteFile(tmp, old, 0644); err != nil {
+				return err
+			}
+			return os.Rename(tmp, path)
+		},
+	}
+}
+
+// writeMetadata makes the --metadata-link directory for the worktree named
+// name, which holds hash, and returns its path relative to gitRoot.
+func writeMetadata(gitRoot, name, hash string, now time.Time) (string, error) {
+	rel := filepath.Join(metadataDir, name)
+	dir := filepath.Join(gitRoot, rel)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	files := map[string]string{
+		"hash": hash + "\n",
+		"time": now.UTC().Format(time.RFC3339) + "\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+	return rel, nil
+}
+
+// pruneHashDirs removes the per-worktree directories under dir (e.g. those of
+// --metadata-link) other than the ones for the worktrees named in keep.
+func pruneHashDirs(dir string, keep ...string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	for _, hash := range keep {
+		kept[hash] = true
+	}
+	for _, fi := range entries {
+		if kept[fi.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishWorktree makes worktree the published one, by flipping the link at
+// dest and, if enabled, the --blue-green content directories, the
+// --metadata-link, the --render-link, and the --previous-link, and makes its
+// --by-hash-dir link and --manifest.  Either all of these flip or none do.  If there was a
+// previous worktree, this returns the path to it.
+func publishWorktree(ctx context.Context, gitRoot, dest, worktree string) (string, error) {
+	oldWorktree, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error accessing current worktree: %v", err)
+	}
+	// The links are relative, so that the volume can be mounted at another
+	// path and they still work.
+	target, err := filepath.Rel(gitRoot, worktree)
+	if err != nil {
+		return "", fmt.Errorf("error converting to relative path: %v", err)
+	}
+	// The metadata and rendered directories are named like the worktree, so
+	// that they don't give away its hash with --worktree-names.
+	name := filepath.Base(worktree)
+
+	if *flManifest == manifestTree {
+		if err := writeManifest(worktree, filepath.Join(worktree, *flManifestName), *flManifestName); err != nil {
+			return "", fmt.Errorf("can't write manifest: %w", err)
+		}
+	}
+
+	var steps []publishStep
+	if *flBlueGreen {
+		steps = append(steps, blueGreenStep(gitRoot, dest, worktree))
+	}
+	if *flMetadataLink != "" {
+		meta, err := writeMetadata(gitRoot, name, worktreeHash(worktree), time.Now())
+		if err != nil {
+			return "", err
+		}
+		if *flManifest == manifestMetadata {
+			if err := writeManifest(worktree, filepath.Join(gitRoot, meta, *flManifestName), *flManifestName); err != nil {
+				return "", fmt.Errorf("can't write manifest: %w", err)
+			}
+		}
+		steps = append(steps, linkStep(ctx, gitRoot, *flMetadataLink, meta))
+	}
+	if *flRenderLink != "" {
+		rendered, err := writeRendered(gitRoot, worktree, fileRenderer)
+		if err != nil {
+			return "", err
+		}
+		steps = append(steps, linkStep(ctx, gitRoot, *flRenderLink, rendered))
+	}
+	if *flPreviousLink != "" && oldWorktree != "" && oldWorktree != worktree {
+		previous, err := filepath.Rel(gitRoot, oldWorktree)
+		if err != nil {
+			return "", fmt.Errorf("error converting to relative path: %v", err)
+		}
+		steps = append(steps, linkStep(ctx, gitRoot, *flPreviousLink, previous))
+	}
+	if *flByHashDir != "" {
+		steps = append(steps, byHashStep(ctx, gitRoot, *flByHashDir, worktree))
+	}
+	// The main link goes last, so that a failure leaves it alone.
+	steps = append(steps, linkStep(ctx, gitRoot, dest, target))
+	if err := runPublishSteps(steps); err != nil {
+		return "", err
+	}
+
+	keep := []string{name}
+	if oldWorktree != "" {
+		keep = append(keep, filepath.Base(oldWorktree))
+	}
+	if *flMetadataLink != "" {
+		if err := pruneHashDirs(filepath.Join(gitRoot, metadataDir), keep...); err != nil {
+			log.Error(err, "can't prune old metadata")
+		}
+	}
+	if *flRenderLink != "" {
+		if err := pruneHashDirs(filepath.Join(gitRoot, renderedDir), keep...); err != nil {
+			log.Error(err, "can't prune old rendered trees")
+		}
+	}
+	return oldWorktree, nil
+}
+
+// removePreviousLink removes the --previous-link, if it points at worktree,
+// which is about to be removed.
+func removePreviousLink(gitRoot, link, worktree string) error {
+	target, err := os.Readlink(filepath.Join(gitRoot, link))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if filepath.Base(target) != filepath.Base(worktree) {
+		return nil
+	}
+	log.V(0).Info("removing previous link, whose worktree is being removed", "link", link, "worktree", worktree)
+	return os.Remove(filepath.Join(gitRoot, link))
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRunPublishSteps(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	var calls []string
+	step := func(name string, fail bool) publishStep {
+		return publishStep{
+			name: name,
+			do: func() error {
+				calls = append(calls, "do "+name)
+				if fail {
+					return errors.New("failed")
+				}
+				return nil
+			},
+			undo: func() error {
+				calls = append(calls, "undo "+name)
+				return nil
+			},
+		}
+	}
+
+	if err := runPublishSteps([]publishStep{step("a", false), step("b", false)}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if expect := []string{"do a", "do b"}; !reflect.DeepEqual(calls, expect) {
+		t.Errorf("expected %q, got %q", expect, calls)
+	}
+
+	calls = nil
+	err := runPublishSteps([]publishStep{step("a", false), step("b", false), step("c", true), step("d", false)})
+	if err == nil || !strings.Contains(err.Error(), "can't publish c") {
+		t.Errorf("expected an error from c, got %v", err)
+	}
+	if expect := []string{"do a", "do b", "do c", "undo b", "undo a"}; !reflect.DeepEqual(calls, expect) {
+		t.Errorf("expected %q, got %q", expect, calls)
+	}
+}
+
+func TestPublishWorktree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(link string) { *flMetadataLink = link }(*flMetadataLink)
+	*flMetadataLink = "meta"
+
+	root := t.TempDir()
+	for _, hash := range []string{hash1, hash2} {
+		if err := os.Mkdir(filepath.Join(root, hash), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	readMeta := func() string {
+		t.Helper()
+		data, err := ioutil.ReadFile(filepath.Join(root, "meta", "hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	old, err := publishWorktree(ctx, root, "repo", filepath.Join(root, hash1))
+	if err != nil || old != "" {
+		t.Fatalf("unexpected result: %q, %v", old, err)
+	}
+	if got := readMeta(); got != hash1 {
+		t.Errorf("expected metadata for %s, got %s", hash1, got)
+	}
+
+	old, err = publishWorktree(ctx, root, "repo", filepath.Join(root, hash2))
+	if err != nil || old != filepath.Join(root, hash1) {
+		t.Fatalf("unexpected result: %q, %v", old, err)
+	}
+	if got := readMeta(); got != hash2 {
+		t.Errorf("expected metadata for %s, got %s", hash2, got)
+	}
+
+	// If the main link can't be flipped, neither is the metadata link.
+	other := t.TempDir()
+	if err := os.Mkdir(filepath.Join(other, hash1), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(other, "repo", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publishWorktree(ctx, other, "repo", filepath.Join(other, hash1)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, err := os.Lstat(filepath.Join(other, "meta")); !os.IsNotExist(err) {
+		t.Errorf("expected the metadata link to be removed, got %v", err)
+	}
+}
+
+func TestPreviousLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(link string) { *flPreviousLink = link }(*flPreviousLink)
+	*flPreviousLink = "previous"
+
+	root := t.TempDir()
+	for _, hash := range []string{hash1, hash2} {
+		if err := os.Mkdir(filepath.Join(root, hash), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	previous := filepath.Join(root, "previous")
+
+	if _, err := publishWorktree(ctx, root, "repo", filepath.Join(root, hash1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(previous); !os.IsNotExist(err) {
+		t.Errorf("expected no previous link after the first publish, got %v", err)
+	}
+
+	if _, err := publishWorktree(ctx, root, "repo", filepath.Join(root, hash2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if target, err := filepath.EvalSymlinks(previous); err != nil || filepath.Base(target) != hash1 {
+		t.Errorf("expected the previous link to point at %s, got %q, %v", hash1, target, err)
+	}
+
+	// The previously published worktree is not stale.
+	if err := cleanupStaleWorktrees(ctx, root, "repo", 0, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, hash1)); err != nil {
+		t.Errorf("expected the previous worktree to be kept, got %v", err)
+	}
+
+	// Only a link to the worktree being removed is removed.
+	if err := removePreviousLink(root, "previous", filepath.Join(root, hash2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(previous); err != nil {
+		t.Errorf("expected the previous link to stay, got %v", err)
+	}
+	if err := removePreviousLink(root, "previous", filepath.Join(root, hash1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(previous); !os.IsNotExist(err) {
+		t.Errorf("expected the previous link to be removed, got %v", err)
+	}
+}
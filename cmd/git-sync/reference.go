@@ -0,0 +1,126 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// alternatesFile is where git lists the object stores, such as a
+// --reference-repo, that a clone borrows objects from.
+const alternatesFile = ".git/objects/info/alternates"
+
+// errReferenceMissing is logged when the object store of a --reference-repo
+// disappears out from under the clone.
+var errReferenceMissing = errors.New("reference repo is missing")
+
+// missingAlternates returns the object stores listed in the clone at
+// gitRoot which no longer exist.
+func missingAlternates(gitRoot string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(gitRoot, alternatesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path := line
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(gitRoot, ".git", "objects", path)
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			missing = append(missing, line)
+		}
+	}
+	return missing, nil
+}
+
+// checkReferenceRepo makes sure that the clone at gitRoot can still reach
+// all of its objects.  If an object store it borrows from has disappeared,
+// the clone is dissociated from its object stores, if it has all of the
+// objects it needs without that one.  Otherwise it is broken, so everything
+// under gitRoot is removed and the next clone starts over without it.
+func checkReferenceRepo(ctx context.Context, gitRoot string) error {
+	missing, err := missingAlternates(gitRoot)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	log.Error(errReferenceMissing, "reference repo has disappeared, dissociating", "path", gitRoot, "missing", missing)
+	err = dissociate(ctx, gitRoot)
+	if err == nil {
+		return nil
+	}
+	log.Error(err, "can't dissociate from the reference repo, re-cloning", "path", gitRoot)
+	recordKubeEvent(kubeEventWarning, kubeReasonRepoReinitialized, "reference repo %s has disappeared, re-cloning %s", strings.Join(missing, ", "), gitRoot)
+	return clearRoot(gitRoot)
+}
+
+// dissociate copies the objects which the clone at gitRoot borrows from the
+// object stores which are still there into its own, and stops it borrowing
+// from any, like `git clone --dissociate`.  This fails if any objects which
+// the clone needs were only in a store which is gone.
+func dissociate(ctx context.Context, gitRoot string) error {
+	path := filepath.Join(gitRoot, alternatesFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	missing, err := missingAlternates(gitRoot)
+	if err != nil {
+		return err
+	}
+	gone := map[string]bool{}
+	for _, m := range missing {
+		gone[m] = true
+	}
+	kept := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !gone[strings.TrimSpace(line)] {
+			kept = append(kept, line)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(kept, "\n")), 0644); err != nil {
+		return err
+	}
+
+	// This deletes the clone's old packs, which an integrity check may be
+	// reading.
+	objectsBusy <- struct{}{}
+	defer func() { <-objectsBusy }()
+	if _, err := runCommand(ctx, gitRoot, *flGitCmd, "repack", "-a", "-d"); err != nil {
+		return fmt.Errorf("can't copy objects into the clone: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if _, err := runCommand(ctx, gitRoot, *flGitCmd, "fsck", "--connectivity-only", "--no-progress", "--no-dangling"); err != nil {
+		return fmt.Errorf("the clone is missing objects: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestMissingAlternates(t *testing.T) {
+	root := t.TempDir()
+	if got, err := missingAlternates(root); err != nil || len(got) != 0 {
+		t.Errorf("expected nothing missing without alternates, got %v, %v", got, err)
+	}
+
+	present := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, ".git/objects/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".git/objects/relative"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gone := filepath.Join(root, "gone")
+	data := present + "\n# comment\n\nrelative\n" + gone + "\n"
+	if err := ioutil.WriteFile(filepath.Join(root, alternatesFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := missingAlternates(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{gone}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckReferenceRepo(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	ctx := context.Background()
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command(*flGitCmd, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	ref := filepath.Join(t.TempDir(), "ref")
+	if err := os.MkdirAll(ref, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(ref, "init", "-q")
+	if err := ioutil.WriteFile(filepath.Join(ref, "file"), []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(ref, "add", "file")
+	git(ref, "commit", "-q", "-m", "test")
+
+	clone := func() string {
+		root := filepath.Join(t.TempDir(), "root")
+		git("", "clone", "-q", "--no-checkout", "--reference", ref, "file://"+ref, root)
+		return root
+	}
+
+	// Another store which is gone doesn't matter: the clone is dissociated
+	// and keeps working.
+	root := clone()
+	f, err := os.OpenFile(filepath.Join(root, alternatesFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(filepath.Join(t.TempDir(), "gone") + "\n")
+	f.Close()
+	if err := checkReferenceRepo(ctx, root); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, alternatesFile)); !os.IsNotExist(err) {
+		t.Errorf("expected the clone to be dissociated, got %v", err)
+	}
+	git(root, "cat-file", "-e", "HEAD:file")
+
+	// Without the reference, the clone is missing objects, so it is removed.
+	root = clone()
+	if err := os.RemoveAll(ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkReferenceRepo(ctx, root); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("expected the clone to be removed, got %v", err)
+	}
+}
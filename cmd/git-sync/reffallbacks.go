@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var refFallbackIndex = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_ref_fallback_index",
+	Help: "The position in --ref-fallbacks of the branch being tracked, from 0",
+})
+
+func init() {
+	prometheus.MustRegister(refFallbackIndex)
+}
+
+// refFallbackSelected is true once --ref-fallbacks has been resolved.  It is
+// only accessed under syncLock.
+var refFallbackSelected bool
+
+// firstExistingBranch returns the index of the first of branches which is
+// listed in output, from `git ls-remote`, or -1 if none are.
+func firstExistingBranch(output string, branches []string) int {
+	for i, b := range branches {
+		if parseLsRemote(output, refForRev(b, "HEAD")) != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveRefFallbacks returns the index of the first of branches which exists
+// in repo, asking for all of them at once.
+func resolveRefFallbacks(ctx context.Context, repo string, branches []string) (int, error) {
+	args := []string{"ls-remote", "-q", repo}
+	for _, b := range branches {
+		args = append(args, refForRev(b, "HEAD"))
+	}
+	var output string
+	err := withRetries(ctx, "ls-remote", *flLsRemoteRetries, *flRetryBackoff, func() error {
+		return runStage(ctx, stageLsRemote, *flLsRemoteTimeout, func(ctx context.Context) error {
+			var err error
+			output, err = runCommand(ctx, "", *flGitCmd, args...)
+			countRoundTrip("ls-remote", err)
+			return err
+		})
+	})
+	if err != nil {
+		return -1, err
+	}
+	i := firstExistingBranch(output, branches)
+	if i < 0 {
+		return -1, fmt.Errorf("%w: none of --ref-fallbacks=%s", errRefMissing, strings.Join(branches, ","))
+	}
+	return i, nil
+}
+
+// selectRefFallback sets --branch to the first of --ref-fallbacks which exists
+// upstream.  This is done before each sync, so that a higher priority branch
+// is tracked as soon as it is created.  It must be called under syncLock.
+func selectRefFallback(ctx context.Context) error {
+	i, err := resolveRefFallbacks(ctx, *flRepo, flRefFallbacks.items)
+	if err != nil {
+		return err
+	}
+	branch := flRefFallbacks.items[i]
+	refFallbackIndex.Set(float64(i))
+	if !refFallbackSelected {
+		log.V(0).Info("tracking the first of --ref-fallbacks which exists", "branch", branch)
+		refFallbackSelected = true
+	} else if branch != *flBranch {
+		log.V(0).Info("switching to another of --ref-fallbacks", "from", *flBranch, "to", branch)
+		// Moving between branches is not a history rewrite.
+		refSwitched = true
+	}
+	*flBranch = branch
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFirstExistingBranch(t *testing.T) {
+	output := hash1 + "\trefs/heads/main\n" + hash2 + "\trefs/heads/release-1.28\n"
+	cases := []struct {
+		branches []string
+		exp      int
+	}{
+		{[]string{"release-1.29", "release-1.28", "main"}, 1},
+		{[]string{"main", "release-1.28"}, 0},
+		{[]string{"release-1.29", "release-1"}, -1},
+		{nil, -1},
+	}
+	for _, tc := range cases {
+		if got := firstExistingBranch(output, tc.branches); got != tc.exp {
+			t.Errorf("%v: expected %d, got %d", tc.branches, tc.exp, got)
+		}
+	}
+}
+
+func TestResolveRefFallbacks(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+
+	upstream := filepath.Join(t.TempDir(), "upstream")
+	git := func(args ...string) {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = upstream
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	if out, err := exec.Command(*flGitCmd, "init", "-q", "-b", "main", upstream).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	git("commit", "-q", "--allow-empty", "-m", "one")
+
+	ctx := context.Background()
+	branches := []string{"release-2", "release-1", "main"}
+	if i, err := resolveRefFallbacks(ctx, upstream, branches); err != nil || i != 2 {
+		t.Errorf("expected main, got %d, %v", i, err)
+	}
+	git("branch", "release-1")
+	if i, err := resolveRefFallbacks(ctx, upstream, branches); err != nil || i != 1 {
+		t.Errorf("expected release-1, got %d, %v", i, err)
+	}
+	if _, err := resolveRefFallbacks(ctx, upstream, []string{"nope"}); !errors.Is(err, errRefMissing) {
+		t.Errorf("expected errRefMissing, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --on-ref-missing.
+const (
+	// refMissingFail fails every sync until the ref comes back.
+	refMissingFail = "fail"
+	// refMissingKeepLast keeps the last published hash until the ref comes
+	// back.
+	refMissingKeepLast = "keep-last"
+	// refMissingFallbackPrefix, followed by a branch name, syncs that branch
+	// until the ref comes back.
+	refMissingFallbackPrefix = "fallback-ref="
+)
+
+var refMissingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_ref_missing",
+	Help: "Whether the tracked branch or tag is missing upstream (1) or not (0)",
+})
+
+func init() {
+	prometheus.MustRegister(refMissingGauge)
+}
+
+// errRefMissing is returned when the tracked ref does not exist upstream and
+// --on-ref-missing is fail.
+var errRefMissing = errors.New("ref not found upstream")
+
+// refMissing is true while the tracked ref is missing upstream.  It is only
+// accessed under syncLock.
+var refMissing bool
+
+// refSwitched is true from when the tracked ref goes missing or comes back
+// until the next publish.  Moving to or from a fallback branch is not a
+// history rewrite.  It is only accessed under syncLock.
+var refSwitched bool
+
+// parseRefMissingPolicy splits an --on-ref-missing value into the policy and,
+// for fallback-ref, the branch to fall back to.
+func parseRefMissingPolicy(value string) (string, string, error) {
+	switch {
+	case value == refMissingFail || value == refMissingKeepLast:
+		return value, "", nil
+	case strings.HasPrefix(value, refMissingFallbackPrefix):
+		branch := strings.TrimPrefix(value, refMissingFallbackPrefix)
+		if branch == "" {
+			return "", "", fmt.Errorf("%q needs a branch name", refMissingFallbackPrefix)
+		}
+		return refMissingFallbackPrefix, branch, nil
+	}
+	return "", "", fmt.Errorf("must be %q, %q, or %q followed by a branch name", refMissingFail, refMissingKeepLast, refMissingFallbackPrefix)
+}
+
+// setRefMissing records whether the tracked ref is missing, logging when that
+// changes.
+func setRefMissing(missing bool, ref string) {
+	if missing == refMissing {
+		return
+	}
+	refMissing = missing
+	refSwitched = true
+	if missing {
+		refMissingGauge.Set(1)
+		log.Error(errRefMissing, "tracked ref is missing upstream", "ref", ref, "policy", *flOnRefMissing)
+		return
+	}
+	refMissingGauge.Set(0)
+	log.V(0).Info("tracked ref is back upstream", "ref", ref)
+}
+
+// handleMissingRef decides what to sync while the tracked ref (branch, rev)
+// is missing upstream, according to --on-ref-missing.  It returns the branch
+// to sync instead and its upstream hash, or "" for both if nothing should be
+// synced.
+func handleMissingRef(ctx context.Context, target, branch, rev string) (string, string, error) {
+	ref := refForRev(branch, rev)
+	setRefMissing(true, ref)
+
+	policy, fallback, err := parseRefMissingPolicy(*flOnRefMissing)
+	if err != nil {
+		return "", "", err
+	}
+	switch policy {
+	case refMissingKeepLast:
+		log.V(1).Info("tracked ref is missing upstream, keeping the published hash", "ref", ref)
+		return "", "", nil
+	case refMissingFallbackPrefix:
+		fallbackRef := refForRev(fallback, "HEAD")
+		remote, err := remoteHashForRef(ctx, fallbackRef, target)
+		if err != nil {
+			return "", "", err
+		}
+		if remote == "" {
+			return "", "", fmt.Errorf("%w: %s, and neither is the fallback %s", errRefMissing, ref, fallbackRef)
+		}
+		log.V(1).Info("tracked ref is missing upstream, using the fallback", "ref", ref, "fallback", fallbackRef, "hash", remote)
+		return fallback, remote, nil
+	}
+	return "", "", fmt.Errorf("%w: %s", errRefMissing, ref)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseRefMissingPolicy(t *testing.T) {
+	testCases := []struct {
+		value    string
+		policy   string
+		fallback string
+		err      bool
+	}{
+		{value: "fail", policy: refMissingFail},
+		{value: "keep-last", policy: refMissingKeepLast},
+		{value: "fallback-ref=main", policy: refMissingFallbackPrefix, fallback: "main"},
+		{value: "fallback-ref=", err: true},
+		{value: "fallback", err: true},
+		{value: "", err: true},
+	}
+	for _, tc := range testCases {
+		policy, fallback, err := parseRefMissingPolicy(tc.value)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.value)
+			}
+			continue
+		}
+		if err != nil || policy != tc.policy || fallback != tc.fallback {
+			t.Errorf("%q: expected %q, %q, got %q, %q, %v", tc.value, tc.policy, tc.fallback, policy, fallback, err)
+		}
+	}
+}
+
+func TestHandleMissingRef(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(policy string) {
+		*flOnRefMissing = policy
+		setRefMissing(false, "")
+		refSwitched = false
+	}(*flOnRefMissing)
+
+	ctx := context.Background()
+	*flOnRefMissing = refMissingKeepLast
+	fallback, remote, err := handleMissingRef(ctx, "", "main", "HEAD")
+	if err != nil || fallback != "" || remote != "" {
+		t.Errorf("keep-last: expected nothing to sync, got %q, %q, %v", fallback, remote, err)
+	}
+	if !refMissing || !refSwitched {
+		t.Errorf("expected the ref to be marked missing")
+	}
+
+	*flOnRefMissing = refMissingFail
+	if _, _, err := handleMissingRef(ctx, "", "main", "HEAD"); !errors.Is(err, errRefMissing) {
+		t.Errorf("fail: expected errRefMissing, got %v", err)
+	}
+
+	refSwitched = false
+	setRefMissing(false, "refs/heads/main")
+	if refMissing || !refSwitched {
+		t.Errorf("expected the ref to be marked present, and switched")
+	}
+}
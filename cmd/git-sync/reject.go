@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var rejectedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_upstream_rejected",
+	Help: "Whether the upstream hash is refused by --reject-hashes-file (1) or not (0)",
+})
+
+var rejectedCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_rejected_hashes_total",
+	Help: "How many distinct upstream hashes were refused by --reject-hashes-file",
+})
+
+func init() {
+	prometheus.MustRegister(rejectedGauge)
+	prometheus.MustRegister(rejectedCount)
+}
+
+// errHashRejected is returned when the hash to be published is in the
+// --reject-hashes-file.
+var errHashRejected = errors.New("hash is rejected")
+
+// lastRejected is the most recently rejected hash, so that each hash is only
+// alerted on once.  This is protected by syncLock.
+var lastRejected string
+
+// minRejectPrefix is the shortest hash prefix which is treated as a hash
+// rather than as a ref.
+const minRejectPrefix = 7
+
+// parseRejectList parses the contents of a --reject-hashes-file: one hash
+// (or unambiguous prefix) or ref per line.  Blank lines and anything after a
+// '#' are ignored.
+func parseRejectList(data string) []string {
+	entries := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// isHashPrefix returns true if s looks like a (possibly abbreviated) hash.
+func isHashPrefix(s string) bool {
+	if len(s) < minRejectPrefix || len(s) > 64 {
+		return false
+	}
+	for _, r := range strings.ToLower(s) {
+		if !((r >= '0' && r <= '9') || (r >= 'a' && r <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectedBy returns the entry in entries which matches hash, or "" if none
+// do.  Refs are resolved with resolve; those which can't be resolved don't
+// match anything.
+func rejectedBy(hash string, entries []string, resolve func(ref string) (string, error)) string {
+	for _, e := range entries {
+		if isHashPrefix(e) {
+			if strings.HasPrefix(hash, strings.ToLower(e)) {
+				return e
+			}
+			continue
+		}
+		if resolve == nil {
+			continue
+		}
+		resolved, err := resolve(e)
+		if err != nil {
+			log.V(2).Info("can't resolve rejected ref", "ref", e, "error", err.Error())
+			continue
+		}
+		if resolved == hash {
+			return e
+		}
+	}
+	return ""
+}
+
+// checkRejected returns errHashRejected if hash is refused by the
+// --reject-hashes-file.  The file is read on every call, so changes take
+// effect on the next sync.  Refs are only resolved for git repos, after the
+// fetch, so that new tags are known.
+func checkRejected(ctx context.Context, gitRoot, hash string) error {
+	if *flRejectHashesFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*flRejectHashesFile)
+	if err != nil {
+		return fmt.Errorf("can't read --reject-hashes-file: %v", err)
+	}
+
+	var resolve func(string) (string, error)
+	if *flSource == sourceRepo && *flVCS == "git" {
+		resolve = func(ref string) (string, error) {
+			out, err := runCommand(ctx, gitRoot, *flGitCmd, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+			return strings.TrimSpace(out), err
+		}
+	}
+	entry := rejectedBy(hash, parseRejectList(string(data)), resolve)
+	if entry == "" {
+		rejectedGauge.Set(0)
+		return nil
+	}
+
+	rejectedGauge.Set(1)
+	if hash != lastRejected {
+		lastRejected = hash
+		rejectedCount.Inc()
+		log.Error(errHashRejected, "refusing to publish", "hash", hash, "entry", entry)
+		emitEvent(eventRejected, hash, nil)
+		recordKubeEvent(kubeEventWarning, kubeReasonHashRejected, "refusing to publish %s, which is rejected by %q", hash, entry)
+	}
+	return errHashRejected
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseRejectList(t *testing.T) {
+	input := "# known-bad commits\n\n" + hash1 + "\n  v1.2.3   # broke prod\n#" + hash2 + "\n"
+	want := []string{hash1, "v1.2.3"}
+	if got := parseRejectList(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRejectedBy(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	resolve := func(ref string) (string, error) {
+		if ref == "bad-tag" {
+			return hash2, nil
+		}
+		return "", fmt.Errorf("unknown ref %q", ref)
+	}
+
+	cases := []struct {
+		name    string
+		hash    string
+		entries []string
+		resolve func(string) (string, error)
+		want    string
+	}{
+		{name: "full hash", hash: hash1, entries: []string{hash2, hash1}, want: hash1},
+		{name: "prefix", hash: hash1, entries: []string{"1111111"}, want: "1111111"},
+		{name: "uppercase prefix", hash: "abcdef0123456789abcdef0123456789abcdef01", entries: []string{"ABCDEF0"}, want: "ABCDEF0"},
+		{name: "short prefix is a ref", hash: hash1, entries: []string{"111"}, resolve: resolve, want: ""},
+		{name: "no match", hash: hash1, entries: []string{hash2}, want: ""},
+		{name: "ref", hash: hash2, entries: []string{"bad-tag"}, resolve: resolve, want: "bad-tag"},
+		{name: "ref for another hash", hash: hash1, entries: []string{"bad-tag"}, resolve: resolve, want: ""},
+		{name: "unknown ref", hash: hash2, entries: []string{"nope", hash2}, resolve: resolve, want: hash2},
+		{name: "refs not resolvable", hash: hash2, entries: []string{"bad-tag"}, want: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rejectedBy(tc.hash, tc.entries, tc.resolve); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// These flags can be changed in the --config file while git-sync runs.
+// Changes to any other flag require a restart.
+var reloadableFlags = map[string]bool{
+	"wait":              true,
+	"timeout":           true,
+	"max-sync-failures": true,
+	"username":          true,
+	"password":          true,
+}
+
+// watchedFile is a file which git-sync reads at startup and which, when it
+// changes, needs to be re-applied.
+type watchedFile struct {
+	name     string
+	path     string
+	sum      [sha256.Size]byte
+	onChange func(ctx context.Context) error
+}
+
+// fileReloader notices changes to files and applies them.  This watches the
+// directories which hold the files, rather than the files themselves, and
+// compares file contents rather than mtimes, because Kubernetes updates
+// Secrets and ConfigMaps by atomically swapping a symlink to a new directory.
+type fileReloader struct {
+	mutex sync.Mutex
+	files []*watchedFile
+}
+
+// watch adds a file to be watched.  If path is empty, this does nothing.
+func (r *fileReloader) watch(name, path string, onChange func(ctx context.Context) error) {
+	if path == "" {
+		return
+	}
+	wf := &watchedFile{name: name, path: path, onChange: onChange}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		wf.sum = sha256.Sum256(data)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.files = append(r.files, wf)
+}
+
+// check re-reads all watched files, and calls the onChange handler for any
+// which have changed.  Errors are logged, and the handler will be retried on
+// the next check.
+func (r *fileReloader) check(ctx context.Context) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, wf := range r.files {
+		data, err := ioutil.ReadFile(wf.path)
+		if err != nil {
+			log.Error(err, "can't read watched file", "file", wf.name, "path", wf.path)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if sum == wf.sum {
+			continue
+		}
+		log.V(0).Info("file changed, reloading", "file", wf.name, "path", wf.path)
+		if err := wf.onChange(ctx); err != nil {
+			log.Error(err, "can't reload file", "file", wf.name, "path", wf.path)
+			continue
+		}
+		wf.sum = sum
+	}
+}
+
+// changed returns the names of the watched files whose contents differ from
+// what was last applied.
+func (r *fileReloader) changed() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	names := []string{}
+	for _, wf := range r.files {
+		data, err := ioutil.ReadFile(wf.path)
+		if err != nil || sha256.Sum256(data) != wf.sum {
+			names = append(names, wf.name)
+		}
+	}
+	return names
+}
+
+// run watches the directories of the watched files, and when any of the
+// files changes, wakes the sync loop, which applies the change before it
+// syncs.  If the files can't be watched, changes are still found before each
+// sync.  This returns when ctx is done.
+func (r *fileReloader) run(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error(err, "can't watch files, will check them before each sync")
+		return
+	}
+	defer watcher.Close()
+
+	r.mutex.Lock()
+	dirs := map[string]bool{}
+	for _, wf := range r.files {
+		dirs[filepath.Dir(wf.path)] = true
+	}
+	r.mutex.Unlock()
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.Error(err, "can't watch directory, will check it before each sync", "path", dir)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			log.V(3).Info("watched directory changed", "path", ev.Name, "op", ev.Op.String())
+			if names := r.changed(); len(names) > 0 {
+				log.V(1).Info("watched files changed", "files", names)
+				triggerSync(triggerReload)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error(err, "error watching files")
+		}
+	}
+}
+
+// newFileReloader returns a fileReloader which watches all of the files that
+// git-sync knows how to reload.
+func newFileReloader() *fileReloader {
+	r := &fileReloader{}
+
+	r.watch("config", *flConfig, func(ctx context.Context) error {
+		changed, err := reloadConfigFile(*flConfig)
+		if err != nil {
+			return err
+		}
+		if changed["username"] || changed["password"] {
+			return reloadGitAuth(ctx)
+		}
+		return nil
+	})
+
+	if *flUsername != "" {
+		r.watch("password-file", *flPasswordFile, reloadGitAuth)
+	}
+
+	for _, c := range hostCredentials {
+		c := c
+		r.watch("credential password-file for "+c.url, c.passwordFile, func(ctx context.Context) error {
+			return storeHostCredential(ctx, c)
+		})
+	}
+
+	if *flSSH {
+		reloadSSH := func(ctx context.Context) error {
+			if err := setupGitSSH(*flSSHKnownHosts); err != nil {
+				return err
+			}
+			if *flSSHControlPersist > 0 {
+				return setupSSHMultiplexing(*flSSHControlPersist)
+			}
+			return nil
+		}
+		r.watch("ssh-key-file", *flSSHKeyFile, reloadSSH)
+		if *flSSHKnownHosts {
+			r.watch("ssh-known-hosts-file", *flSSHKnownHostsFile, reloadSSH)
+		}
+	}
+
+	if *flHTTPTLSCert != "" {
+		r.watch("http-tls-cert", *flHTTPTLSCert, reloadHTTPCert)
+		r.watch("http-tls-key", *flHTTPTLSKey, reloadHTTPCert)
+	}
+	r.watch("sparse-checkout-file", *flSparseCheckoutFile, func(ctx context.Context) error {
+		return reapplySparseCheckout(ctx, *flRoot, *flDest)
+	})
+	r.watch("http-token-file", *flHTTPTokenFile, reloadHTTPToken)
+	r.watch("scm-webhook-secret-file", *flSCMWebhookSecretFile, reloadSCMSecret)
+
+	return r
+}
+
+// reloadGitAuth re-reads the password file, if there is one, and re-stores
+// the git credentials.
+func reloadGitAuth(ctx context.Context) error {
+	if *flUsername == "" {
+		return nil
+	}
+	if *flPasswordFile != "" {
+		passwordFileBytes, err := ioutil.ReadFile(*flPasswordFile)
+		if err != nil {
+			return err
+		}
+		*flPassword = string(passwordFileBytes)
+	}
+	if *flSource == sourceOCI {
+		// The OCI client reads the password for each request.
+		return nil
+	}
+	return setupGitAuth(ctx, *flUsername, *flPassword, *flRepo)
+}
+
+// reloadConfigFile re-reads the --config file and applies changes to any
+// reloadable flags which were not set on the command line or by env vars.
+// A reloadable flag which was removed from the file goes back to its default.
+// This returns the set of flags which changed.
+func reloadConfigFile(path string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfigFile(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	fromArgs := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		fromArgs[f.Name] = true
+	})
+
+	changed := map[string]bool{}
+	present := map[string]bool{}
+	for _, kv := range values {
+		present[kv.key] = true
+		f := flag.Lookup(kv.key)
+		if f == nil || fromArgs[kv.key] || flagSetFromEnv(kv.key) {
+			continue
+		}
+		if f.Value.String() == kv.val {
+			continue
+		}
+		if !reloadableFlags[kv.key] {
+			log.V(0).Info("config change requires a restart, ignoring", "flag", kv.key)
+			continue
+		}
+		old := f.Value.String()
+		if err := f.Value.Set(kv.val); err != nil {
+			return changed, err
+		}
+		if f.Value.String() != old {
+			log.V(0).Info("config changed", "flag", kv.key)
+			changed[kv.key] = true
+		}
+	}
+
+	for key := range configFileKeys {
+		f := flag.Lookup(key)
+		if present[key] || f == nil || fromArgs[key] || flagSetFromEnv(key) {
+			continue
+		}
+		if f.Value.String() == f.DefValue {
+			continue
+		}
+		if !reloadableFlags[key] {
+			log.V(0).Info("config change requires a restart, ignoring", "flag", key)
+			continue
+		}
+		if err := f.Value.Set(f.DefValue); err != nil {
+			return changed, err
+		}
+		log.V(0).Info("config removed, using the default", "flag", key)
+		changed[key] = true
+	}
+	configFileKeys = present
+	return changed, nil
+}
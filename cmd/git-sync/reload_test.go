@@ -0,0 +1,239 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFileReloader(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	dir, err := ioutil.TempDir("", "git-sync-reload-test")
+	if err != nil {
+		t.Fatalf("can't make temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatalf("can't write file: %v", err)
+	}
+
+	calls := 0
+	var failNext error
+	r := &fileReloader{}
+	r.watch("empty", "", func(ctx context.Context) error {
+		t.Errorf("unexpected call for empty path")
+		return nil
+	})
+	r.watch("file", path, func(ctx context.Context) error {
+		calls++
+		return failNext
+	})
+	ctx := context.Background()
+
+	r.check(ctx)
+	if calls != 0 {
+		t.Errorf("expected no calls for an unchanged file, got %d", calls)
+	}
+
+	// Rewriting the same content is not a change.
+	if err := ioutil.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatalf("can't write file: %v", err)
+	}
+	r.check(ctx)
+	if calls != 0 {
+		t.Errorf("expected no calls for unchanged content, got %d", calls)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("two"), 0644); err != nil {
+		t.Fatalf("can't write file: %v", err)
+	}
+	failNext = fmt.Errorf("oops")
+	r.check(ctx)
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	// A failed reload is retried.
+	failNext = nil
+	r.check(ctx)
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+
+	// A successful reload is not repeated.
+	r.check(ctx)
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestReloadConfigFileRemovedKey(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(wait float64, depth int, keys map[string]bool) {
+		*flWait, *flDepth, configFileKeys = wait, depth, keys
+	}(*flWait, *flDepth, configFileKeys)
+	configFileKeys = map[string]bool{}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("wait: 30\ndepth: 5\n")
+	if err := loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if *flWait != 30 || *flDepth != 5 {
+		t.Fatalf("expected the config to be loaded, got wait=%v depth=%v", *flWait, *flDepth)
+	}
+
+	write("# nothing\n")
+	changed, err := reloadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed["wait"] || *flWait != 1 {
+		t.Errorf("expected wait to go back to its default, got %v (changed %v)", *flWait, changed)
+	}
+	// depth isn't reloadable, so it keeps its value until a restart.
+	if changed["depth"] || *flDepth != 5 {
+		t.Errorf("expected depth to be unchanged, got %v (changed %v)", *flDepth, changed)
+	}
+}
+
+func TestFileReloaderRun(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &fileReloader{}
+	r.watch("file", path, func(ctx context.Context) error { return nil })
+	if names := r.changed(); len(names) != 0 {
+		t.Errorf("expected no changes, got %v", names)
+	}
+
+	// Drain any earlier request.
+	select {
+	case <-syncNow:
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	// Give the watcher time to start.
+	time.Sleep(100 * time.Millisecond)
+	if err := ioutil.WriteFile(path, []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-syncNow:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a change to wake the sync loop")
+	}
+	if names := r.changed(); len(names) != 1 || names[0] != "file" {
+		t.Errorf("expected the file to have changed, got %v", names)
+	}
+	r.check(context.Background())
+	if names := r.changed(); len(names) != 0 {
+		t.Errorf("expected the change to be applied, got %v", names)
+	}
+}
+
+func TestReapplySparseCheckout(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(file string, policy string) {
+		*flSparseCheckoutFile, *flRepoConfigPolicy = file, policy
+	}(*flSparseCheckoutFile, *flRepoConfigPolicy)
+
+	tmp := t.TempDir()
+	upstream := filepath.Join(tmp, "upstream")
+	root := filepath.Join(tmp, "root")
+	rules := filepath.Join(tmp, "sparse")
+	*flSparseCheckoutFile, *flRepoConfigPolicy = rules, repoPolicyOff
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(upstream, "init", "-q", "-b", "main")
+	write(filepath.Join(upstream, "a"), "a")
+	write(filepath.Join(upstream, "b"), "b")
+	git(upstream, "add", ".")
+	git(upstream, "commit", "-q", "-m", "one")
+	hash := git(upstream, "rev-parse", "HEAD")
+	git(tmp, "clone", "-q", "--no-checkout", upstream, root)
+
+	write(rules, "/a\n")
+	worktree := filepath.Join(root, hash)
+	if err := checkoutWorktree(context.Background(), root, "main", hash, 0, submodulesOff, worktree); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(hash, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(worktree, name))
+		return err == nil
+	}
+	if !exists("a") || exists("b") {
+		t.Fatalf("expected only a to be checked out")
+	}
+
+	write(rules, "/b\n")
+	if err := reapplySparseCheckout(context.Background(), root, "link"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists("a") || !exists("b") {
+		t.Errorf("expected only b to be checked out after the rules changed")
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// renderedDir is where --render-link's directories are made, under --root.
+const renderedDir = ".rendered"
+
+// Values for --render-engine.
+const (
+	renderEngineTemplate = "template"
+	renderEngineEnvsubst = "envsubst"
+)
+
+// renderSuffix is dropped from the names of rendered files.
+const renderSuffix = ".tmpl"
+
+// renderEnvBlocked are never visible to templates, so that a repo can't
+// publish git-sync's own credentials.
+var renderEnvBlocked = []string{"GIT_SYNC_*"}
+
+// renderer renders the files of a worktree which match --render-files.
+type renderer struct {
+	patterns []string
+	engine   string
+	env      envFilter
+}
+
+// fileRenderer is set from --render-files, or nil if that is not set.
+var fileRenderer *renderer
+
+// renderData is the data passed to Go templates.
+type renderData struct {
+	// Hash is the hash of the worktree being rendered.
+	Hash string
+	// Env holds the environment variables which templates may use.
+	Env map[string]string
+}
+
+// matches returns true if the file at rel (slash-separated, relative to the
+// top of the tree) should be rendered.  Patterns with a slash are matched
+// against the whole path, and others against the file's name.
+func (r *renderer) matches(rel string) bool {
+	for _, p := range r.patterns {
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = filepath.Base(rel)
+		}
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// environ returns the environment variables which templates may use.
+func (r *renderer) environ() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || matchesEnvPattern(parts[0], renderEnvBlocked) || !r.env.passes(parts[0]) {
+			continue
+		}
+		env[parts[0]] = parts[1]
+	}
+	return env
+}
+
+// renderFile renders the content of one file, which is named name in errors.
+func (r *renderer) renderFile(name string, content []byte, data renderData) ([]byte, error) {
+	if r.engine == renderEngineEnvsubst {
+		return []byte(os.Expand(string(content), func(v string) string { return data.Env[v] })), nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(renderFuncs(data.Env)).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderFuncs are the functions available to templates, beyond the
+// built-in ones.
+func renderFuncs(env map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"env": func(name string) string {
+			return env[name]
+		},
+		"default": func(def, val string) string {
+			if val == "" {
+				return def
+			}
+			return val
+		},
+		"required": func(msg, val string) (string, error) {
+			if val == "" {
+				return "", fmt.Errorf("%s", msg)
+			}
+			return val, nil
+		},
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+		"trim":    strings.TrimSpace,
+		"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"quote":   func(s string) string { return fmt.Sprintf("%q", s) },
+	}
+}
+
+// render recreates the tree at worktree under dst, with the files which
+// match the patterns rendered, and the rest hard-linked.  A ".tmpl" suffix is
+// dropped from the names of rendered files.  Any .git file or directory is
+// left out.
+func (r *renderer) render(worktree, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := linkTree(worktree, dst); err != nil {
+		return err
+	}
+	data := renderData{Hash: worktreeHash(worktree), Env: r.environ()}
+	count := 0
+	err := filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !r.matches(rel) {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := r.renderFile(rel, content, data)
+		if err != nil {
+			return fmt.Errorf("can't render %s: %w", rel, err)
+		}
+		// The file is a hard link to the worktree, which must not change,
+		// so it is replaced rather than written.
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		target := path
+		if info.Name() != renderSuffix {
+			target = strings.TrimSuffix(path, renderSuffix)
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := ioutil.WriteFile(target, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.V(1).Info("rendered templates", "path", dst, "count", count)
+	return nil
+}
+
+// writeRendered renders worktree into the --render-link directory for its
+// hash, and returns its path relative to gitRoot.
+func writeRendered(gitRoot, worktree string, r *renderer) (string, error) {
+	rel := filepath.Join(renderedDir, filepath.Base(worktree))
+	if err := os.MkdirAll(filepath.Join(gitRoot, renderedDir), 0755); err != nil {
+		return "", err
+	}
+	if err := r.render(worktree, filepath.Join(gitRoot, rel)); err != nil {
+		os.RemoveAll(filepath.Join(gitRoot, rel))
+		return "", err
+	}
+	return rel, nil
+}
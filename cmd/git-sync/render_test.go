@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRendererMatches(t *testing.T) {
+	r := &renderer{patterns: []string{"*.tmpl", "config/*.yaml"}}
+	cases := map[string]bool{
+		"a.tmpl":         true,
+		"dir/b.tmpl":     true,
+		"config/c.yaml":  true,
+		"other/c.yaml":   false,
+		"config/d/e.yml": false,
+		"README.md":      false,
+	}
+	for rel, expect := range cases {
+		if got := r.matches(rel); got != expect {
+			t.Errorf("%s: expected %v, got %v", rel, expect, got)
+		}
+	}
+}
+
+func TestRenderFile(t *testing.T) {
+	data := renderData{Hash: hash1, Env: map[string]string{"REGION": "eu", "EMPTY": ""}}
+	cases := []struct {
+		engine string
+		in     string
+		expect string
+		err    bool
+	}{
+		{renderEngineTemplate, "region={{ .Env.REGION }} hash={{ .Hash }}", "region=eu hash=" + hash1, false},
+		{renderEngineTemplate, `{{ env "EMPTY" | default "x" | upper }}`, "X", false},
+		{renderEngineTemplate, "{{ .Env.MISSING }}", "", true},
+		{renderEngineTemplate, `{{ env "EMPTY" | required "EMPTY is required" }}`, "", true},
+		{renderEngineTemplate, "{{ .Env.REGION", "", true},
+		{renderEngineEnvsubst, "region=${REGION} $REGION {{ .Env.REGION }} $MISSING.", "region=eu eu {{ .Env.REGION }} .", false},
+	}
+	for _, tc := range cases {
+		r := &renderer{engine: tc.engine}
+		out, err := r.renderFile("f", []byte(tc.in), data)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s %q: expected an error", tc.engine, tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: unexpected error: %v", tc.engine, tc.in, err)
+		} else if string(out) != tc.expect {
+			t.Errorf("%s %q: expected %q, got %q", tc.engine, tc.in, tc.expect, out)
+		}
+	}
+}
+
+func TestRenderTree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links need privileges on Windows")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	os.Setenv("GIT_SYNC_TEST_REGION", "eu")
+	defer os.Unsetenv("GIT_SYNC_TEST_REGION")
+	os.Setenv("RENDER_TEST_REGION", "us")
+	defer os.Unsetenv("RENDER_TEST_REGION")
+
+	root := t.TempDir()
+	worktree := filepath.Join(root, hash1)
+	files := map[string]string{
+		".git":            "gitdir: ../.git/worktrees/x\n",
+		"app.conf.tmpl":   "region={{ .Env.RENDER_TEST_REGION }}\n",
+		"app.conf":        "stale\n",
+		"static/index.md": "{{ .Env.RENDER_TEST_REGION }}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(worktree, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &renderer{patterns: []string{"*.tmpl"}, engine: renderEngineTemplate}
+	if env := r.environ(); env["GIT_SYNC_TEST_REGION"] != "" || env["RENDER_TEST_REGION"] != "us" {
+		t.Errorf("unexpected environment: GIT_SYNC_TEST_REGION=%q RENDER_TEST_REGION=%q", env["GIT_SYNC_TEST_REGION"], env["RENDER_TEST_REGION"])
+	}
+	rel, err := writeRendered(root, worktree, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dst := filepath.Join(root, rel)
+	read := func(path string) string {
+		t.Helper()
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := read(filepath.Join(dst, "app.conf")); got != "region=us\n" {
+		t.Errorf("unexpected rendered file: %q", got)
+	}
+	if got := read(filepath.Join(dst, "static", "index.md")); !strings.Contains(got, "{{") {
+		t.Errorf("expected a file which doesn't match to be left alone, got %q", got)
+	}
+	for _, name := range []string{".git", "app.conf.tmpl"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be left out, got %v", name, err)
+		}
+	}
+	// The worktree itself is untouched.
+	if got := read(filepath.Join(worktree, "app.conf")); got != "stale\n" {
+		t.Errorf("expected the worktree to be unchanged, got %q", got)
+	}
+
+	// A failed render leaves nothing behind.
+	bad := &renderer{patterns: []string{"*.md"}, engine: renderEngineTemplate, env: envFilter{allow: []string{"NOTHING"}}}
+	if _, err := writeRendered(root, worktree, bad); err == nil {
+		t.Errorf("expected an error")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("expected the rendered tree to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --repo-config-policy.
+const (
+	repoPolicyFail = "fail"
+	repoPolicyWarn = "warn"
+	repoPolicyOff  = "off"
+)
+
+// Values for the "check" label of git_sync_repo_policy_violations_total.
+const (
+	policyCheckConfig    = "config"
+	policyCheckSubmodule = "submodule"
+)
+
+var repoPolicyViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_repo_policy_violations_total",
+	Help: "How many violations of --repo-config-policy were found, partitioned by what was checked",
+}, []string{"check"})
+
+func init() {
+	prometheus.MustRegister(repoPolicyViolations)
+}
+
+// errRepoPolicy is returned when the clone's config or a submodule violates
+// --repo-config-policy=fail.
+var errRepoPolicy = errors.New("repo config policy violation")
+
+// execConfigKeys are git config keys (as "git config --list" prints them,
+// with "*" for a subsection) whose values git may run as commands.  git-sync
+// never sets them in the clone, so if they are there, something else wrote
+// them.
+var execConfigKeys = []string{
+	"core.fsmonitor",
+	"core.hookspath",
+	"core.sshcommand",
+	"core.gitproxy",
+	"core.askpass",
+	"core.editor",
+	"core.pager",
+	"sequence.editor",
+	"diff.external",
+	"diff.*.command",
+	"diff.*.textconv",
+	"filter.*.clean",
+	"filter.*.smudge",
+	"filter.*.process",
+	"merge.*.driver",
+	"gpg.program",
+	"gpg.*.program",
+	"uploadpack.packobjectshook",
+	"credential.helper",
+	"credential.*.helper",
+	// These can pull in any of the above from elsewhere.
+	"include.path",
+	"includeif.*.path",
+}
+
+// containmentConfigs returns the git configs which git-sync forces on every
+// git command, so that nothing in the clone can make git run hooks or other
+// commands.
+func containmentConfigs() []keyVal {
+	configs := []keyVal{}
+	if *flGitHooksPath != "" {
+		configs = append(configs, keyVal{"core.hooksPath", *flGitHooksPath})
+	}
+	if *flRepoConfigPolicy != repoPolicyOff {
+		configs = append(configs,
+			keyVal{"core.fsmonitor", "false"},
+			keyVal{"protocol.ext.allow", "never"})
+	}
+	return configs
+}
+
+// parseConfigList parses the output of "git config --list -z".
+func parseConfigList(output string) []keyVal {
+	entries := []keyVal{}
+	for _, entry := range strings.Split(output, "\x00") {
+		if entry == "" {
+			continue
+		}
+		// A key with no value (which means true) has no newline.
+		kv := strings.SplitN(entry, "\n", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		entries = append(entries, keyVal{kv[0], kv[1]})
+	}
+	return entries
+}
+
+// configViolations returns the entries which could make git run a command.
+func configViolations(entries []keyVal) []string {
+	violations := []string{}
+	for _, kv := range entries {
+		key := strings.ToLower(kv.key)
+		if key == "core.fsmonitor" && (kv.val == "" || kv.val == "false") {
+			continue
+		}
+		for _, pattern := range execConfigKeys {
+			if configKeyMatches(pattern, key) {
+				violations = append(violations, fmt.Sprintf("%s=%s", kv.key, kv.val))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// configKeyMatches returns true if key matches pattern, in which "*" stands
+// for a subsection.  Unlike path.Match, it matches subsections with slashes.
+func configKeyMatches(pattern, key string) bool {
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return key == pattern
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(key) > len(prefix)+len(suffix) && strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix)
+}
+
+// hostAllowed returns true if host matches one of the globs in allow.
+func hostAllowed(host string, allow []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allow {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// submoduleViolations returns the entries of a .gitmodules file which could
+// make git run a command, or which fetch from a host which isn't in allow.
+// If allow is empty, any host is allowed.  Relative URLs, which are on the
+// same host as the superproject, and repoHost are always allowed.
+func submoduleViolations(entries []keyVal, allow []string, repoHost string) []string {
+	violations := []string{}
+	for _, kv := range entries {
+		key := strings.ToLower(kv.key)
+		switch {
+		case strings.HasSuffix(key, ".update"):
+			// git ignores these from .gitmodules, but no honest repo sets them.
+			if strings.HasPrefix(kv.val, "!") {
+				violations = append(violations, fmt.Sprintf("%s=%s runs a command", kv.key, kv.val))
+			}
+		case strings.HasSuffix(key, ".url"):
+			if strings.HasPrefix(kv.val, "-") {
+				violations = append(violations, fmt.Sprintf("%s=%s looks like an option", kv.key, kv.val))
+				continue
+			}
+			if len(allow) == 0 || strings.HasPrefix(kv.val, "./") || strings.HasPrefix(kv.val, "../") {
+				continue
+			}
+			u, err := parseRepoURL(kv.val)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s=%s is not a valid URL: %v", kv.key, kv.val, err))
+				continue
+			}
+			switch {
+			case u.kind != repoKindURL && u.kind != repoKindSCP:
+				violations = append(violations, fmt.Sprintf("%s=%s is not on a host", kv.key, kv.val))
+			case strings.EqualFold(u.host, repoHost):
+			case !hostAllowed(u.host, allow):
+				violations = append(violations, fmt.Sprintf("%s=%s is on %s, which is not in --submodule-host-allowlist", kv.key, kv.val, u.host))
+			}
+		}
+	}
+	return violations
+}
+
+// reportPolicyViolations counts, logs, and for --repo-config-policy=fail,
+// returns an error for violations.
+func reportPolicyViolations(check, where string, violations []string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.Strings(violations)
+	repoPolicyViolations.WithLabelValues(check).Add(float64(len(violations)))
+	if *flRepoConfigPolicy == repoPolicyFail {
+		return fmt.Errorf("%w in %s: %s", errRepoPolicy, where, strings.Join(violations, "; "))
+	}
+	log.V(0).Info("WARNING: repo config policy violation", "check", check, "where", where, "violations", violations)
+	return nil
+}
+
+// checkRepoConfig looks in the config of the clone at gitRoot, and of the
+// worktree at worktreePath, for settings which could make git run a command.
+func checkRepoConfig(ctx context.Context, gitRoot, worktreePath string) error {
+	if *flRepoConfigPolicy == repoPolicyOff {
+		return nil
+	}
+	scopes := [][]string{{"--local"}}
+	// git refuses --worktree once there are worktrees, unless
+	// extensions.worktreeConfig is set, so read the worktree's own config
+	// file, if it has one.
+	worktreeConfig := filepath.Join(worktreeAdminDir(gitRoot, worktreePath), "config.worktree")
+	if _, err := os.Stat(worktreeConfig); err == nil {
+		scopes = append(scopes, []string{"--file", worktreeConfig})
+	}
+	violations := []string{}
+	for _, scope := range scopes {
+		args := append([]string{"config"}, scope...)
+		output, err := runCommand(ctx, gitRoot, *flGitCmd, append(args, "--list", "-z")...)
+		if err != nil {
+			return fmt.Errorf("can't read git config: %w", err)
+		}
+		violations = append(violations, configViolations(parseConfigList(output))...)
+	}
+	return reportPolicyViolations(policyCheckConfig, "git config", dedupe(violations))
+}
+
+// checkSubmodules checks the .gitmodules file in dir, if there is one.
+func checkSubmodules(ctx context.Context, dir string) ([]keyVal, error) {
+	file := filepath.Join(dir, ".gitmodules")
+	if _, err := os.Lstat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+	output, err := runCommand(ctx, dir, *flGitCmd, "config", "--file", file, "--list", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %w", file, err)
+	}
+	entries := parseConfigList(output)
+	if *flRepoConfigPolicy == repoPolicyOff {
+		return entries, nil
+	}
+	violations := submoduleViolations(entries, flSubmoduleHostAllowlist.items, repoInfo.host)
+	return entries, reportPolicyViolations(policyCheckSubmodule, file, violations)
+}
+
+// updateSubmodules checks out the submodules of the worktree at dir.  With
+// --submodule-host-allowlist, it goes one level at a time, so that the
+// .gitmodules files of submodules are checked before git fetches what they
+// name.
+func updateSubmodules(ctx context.Context, dir, submoduleMode string, depth int) error {
+	args := []string{"submodule", "update", "--init"}
+	if depth != 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	entries, err := checkSubmodules(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(flSubmoduleHostAllowlist.items) == 0 || *flRepoConfigPolicy == repoPolicyOff {
+		if submoduleMode == submodulesRecursive {
+			args = append(args, "--recursive")
+		}
+		_, err := runCommand(ctx, dir, *flGitCmd, args...)
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if _, err := runCommand(ctx, dir, *flGitCmd, args...); err != nil {
+		return err
+	}
+	if submoduleMode != submodulesRecursive {
+		return nil
+	}
+	for _, kv := range entries {
+		if !strings.HasSuffix(strings.ToLower(kv.key), ".path") {
+			continue
+		}
+		sub := filepath.Join(dir, filepath.FromSlash(kv.val))
+		// Only recurse into submodules which git checked out; git would
+		// otherwise run in the superproject.
+		if _, err := os.Lstat(filepath.Join(sub, ".git")); err != nil {
+			continue
+		}
+		if err := updateSubmodules(ctx, sub, submoduleMode, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupe returns the sorted, unique strings in list.
+func dedupe(list []string) []string {
+	sort.Strings(list)
+	out := []string{}
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseConfigList(t *testing.T) {
+	output := "core.bare\nfalse\x00remote.origin.url\nhttps://example.com/repo\x00core.flag\x00multi.line\na\nb\x00"
+	expect := []keyVal{
+		{"core.bare", "false"},
+		{"remote.origin.url", "https://example.com/repo"},
+		{"core.flag", ""},
+		{"multi.line", "a\nb"},
+	}
+	if got := parseConfigList(output); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestConfigViolations(t *testing.T) {
+	entries := []keyVal{
+		{"core.bare", "false"},
+		{"core.fsmonitor", "false"},
+		{"core.fsmonitor", "/tmp/evil"},
+		{"filter.lfs.smudge", "git-lfs smudge %f"},
+		{"filter.a.b.process", "evil"},
+		{"filter.lfs.required", "true"},
+		{"diff.external", "evil"},
+		{"includeIf.gitdir:/x/.path", "/tmp/config"},
+		{"remote.origin.url", "https://example.com/repo"},
+	}
+	expect := []string{
+		"core.fsmonitor=/tmp/evil",
+		"filter.lfs.smudge=git-lfs smudge %f",
+		"filter.a.b.process=evil",
+		"diff.external=evil",
+		"includeIf.gitdir:/x/.path=/tmp/config",
+	}
+	if got := configViolations(entries); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestSubmoduleViolations(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry keyVal
+		allow []string
+		bad   bool
+	}{
+		{name: "any host", entry: keyVal{"submodule.a.url", "https://evil.example.org/a"}},
+		{name: "allowed host", entry: keyVal{"submodule.a.url", "https://github.com/a/b"}, allow: []string{"github.com"}},
+		{name: "allowed glob", entry: keyVal{"submodule.a.url", "git@git.example.com:a/b"}, allow: []string{"*.example.com"}},
+		{name: "other host", entry: keyVal{"submodule.a.url", "https://evil.example.org/a"}, allow: []string{"github.com"}, bad: true},
+		{name: "repo host", entry: keyVal{"submodule.a.url", "ssh://git@repo.example.net/a"}, allow: []string{"github.com"}},
+		{name: "relative", entry: keyVal{"submodule.a.url", "../a.git"}, allow: []string{"github.com"}},
+		{name: "local", entry: keyVal{"submodule.a.url", "/srv/a"}, allow: []string{"github.com"}, bad: true},
+		{name: "helper", entry: keyVal{"submodule.a.url", "ext::sh -c evil"}, allow: []string{"github.com"}, bad: true},
+		{name: "option", entry: keyVal{"submodule.a.url", "--upload-pack=evil"}, bad: true},
+		{name: "update command", entry: keyVal{"submodule.a.update", "!evil"}, bad: true},
+		{name: "update", entry: keyVal{"submodule.a.update", "rebase"}},
+		{name: "path", entry: keyVal{"submodule.a.path", "a"}, allow: []string{"github.com"}},
+	}
+	for _, tc := range cases {
+		got := submoduleViolations([]keyVal{tc.entry}, tc.allow, "repo.example.net")
+		if tc.bad != (len(got) != 0) {
+			t.Errorf("%s: expected bad=%v, got %v", tc.name, tc.bad, got)
+		}
+	}
+}
+
+func TestCheckRepoPolicy(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(policy string, allow []string) {
+		*flRepoConfigPolicy, flSubmoduleHostAllowlist.items = policy, allow
+	}(*flRepoConfigPolicy, flSubmoduleHostAllowlist.items)
+
+	repo := t.TempDir()
+	ctx := context.Background()
+	git := func(args ...string) {
+		t.Helper()
+		if _, err := runCommand(ctx, repo, *flGitCmd, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	gitmodules := "[submodule \"lib\"]\n\tpath = lib\n\turl = https://evil.example.org/lib\n"
+	if err := ioutil.WriteFile(filepath.Join(repo, ".gitmodules"), []byte(gitmodules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	*flRepoConfigPolicy = repoPolicyFail
+	flSubmoduleHostAllowlist.items = nil
+	if err := checkRepoConfig(ctx, repo, repo); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := checkSubmodules(ctx, repo); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	flSubmoduleHostAllowlist.items = []string{"github.com"}
+	if _, err := checkSubmodules(ctx, repo); !errors.Is(err, errRepoPolicy) || !strings.Contains(err.Error(), "evil.example.org") {
+		t.Errorf("expected errRepoPolicy for a submodule on another host, got %v", err)
+	}
+	// The submodule isn't fetched.
+	if err := updateSubmodules(ctx, repo, submodulesRecursive, 0); !errors.Is(err, errRepoPolicy) {
+		t.Errorf("expected errRepoPolicy from updateSubmodules, got %v", err)
+	}
+
+	git("config", "filter.x.smudge", "touch /tmp/evil")
+	if err := checkRepoConfig(ctx, repo, repo); !errors.Is(err, errRepoPolicy) || !strings.Contains(err.Error(), "filter.x.smudge") {
+		t.Errorf("expected errRepoPolicy for a filter driver, got %v", err)
+	}
+
+	*flRepoConfigPolicy = repoPolicyWarn
+	if err := checkRepoConfig(ctx, repo, repo); err != nil {
+		t.Errorf("expected only a warning, got %v", err)
+	}
+	*flRepoConfigPolicy = repoPolicyOff
+	if _, err := checkSubmodules(ctx, repo); err != nil {
+		t.Errorf("expected no check, got %v", err)
+	}
+
+	// A worktree with no .gitmodules has nothing to check.
+	if err := os.Remove(filepath.Join(repo, ".gitmodules")); err != nil {
+		t.Fatal(err)
+	}
+	*flRepoConfigPolicy = repoPolicyFail
+	if entries, err := checkSubmodules(ctx, repo); err != nil || len(entries) != 0 {
+		t.Errorf("expected nothing, got %v, %v", entries, err)
+	}
+}
+
+func TestCheckRepoConfigWorktree(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(policy string) { *flRepoConfigPolicy = policy }(*flRepoConfigPolicy)
+	*flRepoConfigPolicy = repoPolicyFail
+
+	repo := t.TempDir()
+	worktree := filepath.Join(repo, "wt-000001")
+	ctx := context.Background()
+	git := func(dir string, args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if _, err := runCommand(ctx, dir, *flGitCmd, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(repo, "init", "-q")
+	git(repo, "commit", "-q", "--allow-empty", "-m", "one")
+	git(repo, "worktree", "add", "-q", "--detach", worktree)
+
+	// With a worktree, and without extensions.worktreeConfig, there is no
+	// worktree config to read.
+	if err := checkRepoConfig(ctx, repo, worktree); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	git(repo, "config", "extensions.worktreeConfig", "true")
+	git(worktree, "config", "--worktree", "core.fsmonitor", "touch /tmp/evil")
+	if err := checkRepoConfig(ctx, repo, worktree); !errors.Is(err, errRepoPolicy) || !strings.Contains(err.Error(), "core.fsmonitor") {
+		t.Errorf("expected errRepoPolicy for the worktree's config, got %v", err)
+	}
+}
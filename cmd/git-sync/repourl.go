@@ -0,0 +1,282 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kinds of repo URL, by how git reaches the repo.
+const (
+	repoKindURL    = "url"    // scheme://[user@]host[:port]/path
+	repoKindSCP    = "scp"    // [user@]host:path, over SSH
+	repoKindLocal  = "local"  // a path, or file://
+	repoKindHelper = "helper" // transport::address, for a remote helper
+)
+
+// repoSchemes are the URL schemes git supports natively, and whether they
+// need a host.
+var repoSchemes = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"ssh":     true,
+	"git+ssh": true,
+	"ssh+git": true,
+	"git":     true,
+	"file":    false,
+}
+
+// defaultPorts are the ports which are left out of normalized URLs.
+var defaultPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"ssh":     "22",
+	"git+ssh": "22",
+	"ssh+git": "22",
+	"git":     "9418",
+}
+
+// windowsPathRE matches a Windows path with a drive letter, which git does
+// not treat as an scp-like URL.
+var windowsPathRE = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// helperRE matches the "transport::" prefix which selects a remote helper.
+var helperRE = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9+.-]*)::`)
+
+// scpPortRE matches the path of an scp-like URL which starts with what looks
+// like a port, e.g. "host:2222/repo".
+var scpPortRE = regexp.MustCompile(`^[0-9]+/`)
+
+// repoURL is --repo, taken apart the way git does.
+type repoURL struct {
+	kind   string
+	scheme string // for repoKindURL, and the transport for repoKindHelper
+	user   string
+	host   string // without brackets
+	port   string
+	path   string
+	// hasPassword is true if the URL holds a password, which is never kept.
+	hasPassword bool
+}
+
+// repoInfo is the parsed --repo, for git repos.
+var repoInfo repoURL
+
+// parseRepoURL parses and checks repo, so that mistakes are reported
+// clearly at startup rather than by git at the first fetch.
+func parseRepoURL(repo string) (repoURL, error) {
+	if repo == "" {
+		return repoURL{}, fmt.Errorf("the repo is empty")
+	}
+	if strings.TrimSpace(repo) != repo {
+		return repoURL{}, fmt.Errorf("the repo has leading or trailing whitespace")
+	}
+	for _, r := range repo {
+		if unicode.IsControl(r) {
+			return repoURL{}, fmt.Errorf("the repo contains a control character")
+		}
+	}
+
+	if m := helperRE.FindStringSubmatch(repo); m != nil {
+		return repoURL{kind: repoKindHelper, scheme: m[1], path: repo[len(m[0]):]}, nil
+	}
+	firstSlash := strings.Index(repo, "/")
+	switch {
+	case strings.Contains(repo, "://"):
+		return parseSchemeURL(repo)
+	case windowsPathRE.MatchString(repo):
+		return repoURL{kind: repoKindLocal, path: repo}, nil
+	}
+	colon := strings.Index(repo, ":")
+	if colon >= 0 && (firstSlash < 0 || colon < firstSlash) {
+		return parseSCPURL(repo)
+	}
+	return repoURL{kind: repoKindLocal, path: repo}, nil
+}
+
+// parseSchemeURL parses a "scheme://" URL.
+func parseSchemeURL(repo string) (repoURL, error) {
+	i := strings.Index(repo, "://")
+	scheme := strings.ToLower(repo[:i])
+	needsHost, known := repoSchemes[scheme]
+	if !known {
+		if scheme == "" {
+			return repoURL{}, fmt.Errorf("the repo has no scheme before \"://\"")
+		}
+		if !remoteHelperExists(scheme) {
+			return repoURL{}, fmt.Errorf("unsupported scheme %q: git supports http, https, ssh, git, and file, and others need a git-remote-%s helper", scheme, scheme)
+		}
+		return repoURL{kind: repoKindHelper, scheme: scheme, path: repo[i+3:]}, nil
+	}
+
+	// Check the host and port before url.Parse does, to give a clearer error.
+	rest := repo[i+3:]
+	authority := rest
+	if end := strings.IndexAny(rest, "/?#"); end >= 0 {
+		authority = rest[:end]
+	}
+	hostport := authority
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		hostport = authority[at+1:]
+	}
+	if !strings.HasPrefix(hostport, "[") && strings.Count(hostport, ":") > 1 {
+		return repoURL{}, fmt.Errorf("IPv6 addresses must be in brackets, e.g. %s://[2001:db8::1]:22/repo", scheme)
+	}
+
+	u, err := url.Parse(repo)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return repoURL{}, fmt.Errorf("can't parse the repo URL: %v", err)
+	}
+	r := repoURL{kind: repoKindURL, scheme: scheme, host: u.Hostname(), port: u.Port(), path: u.Path}
+	if u.User != nil {
+		r.user = u.User.Username()
+		_, r.hasPassword = u.User.Password()
+	}
+	if scheme == "file" {
+		r.kind = repoKindLocal
+	}
+	if needsHost && r.host == "" {
+		return repoURL{}, fmt.Errorf("the repo URL has no host")
+	}
+	if strings.HasSuffix(hostport, ":") {
+		return repoURL{}, fmt.Errorf("the repo URL has an empty port")
+	}
+	if r.port != "" {
+		if n, err := strconv.Atoi(r.port); err != nil || n < 1 || n > 65535 {
+			return repoURL{}, fmt.Errorf("invalid port %q", r.port)
+		}
+	}
+	if r.path == "" || r.path == "/" {
+		return repoURL{}, fmt.Errorf("the repo URL has no path")
+	}
+	return r, nil
+}
+
+// parseSCPURL parses an scp-like "[user@]host:path" URL.  IPv6 addresses
+// are in brackets, e.g. "git@[2001:db8::1]:repo".
+func parseSCPURL(repo string) (repoURL, error) {
+	r := repoURL{kind: repoKindSCP, scheme: "ssh"}
+	rest := repo
+	if at := strings.Index(rest, "@"); at >= 0 && at < strings.Index(rest, ":") {
+		r.user = rest[:at]
+		rest = rest[at+1:]
+	}
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return repoURL{}, fmt.Errorf("the repo has a \"[\" without a \"]\"")
+		}
+		r.host = rest[1:end]
+		rest = rest[end+1:]
+		if !strings.HasPrefix(rest, ":") {
+			return repoURL{}, fmt.Errorf("expected \":\" after the host in brackets")
+		}
+		r.path = rest[1:]
+	} else {
+		colon := strings.Index(rest, ":")
+		r.host, r.path = rest[:colon], rest[colon+1:]
+	}
+	if r.host == "" {
+		return repoURL{}, fmt.Errorf("the repo has no host before \":\"")
+	}
+	if strings.Contains(r.host, ":") && net.ParseIP(r.host) == nil {
+		return repoURL{}, fmt.Errorf("invalid host %q", r.host)
+	}
+	if r.path == "" {
+		return repoURL{}, fmt.Errorf("the repo has no path after \":\"")
+	}
+	return r, nil
+}
+
+// remoteHelperExists returns true if git has a remote helper for scheme,
+// either in PATH or in git's exec path.
+func remoteHelperExists(scheme string) bool {
+	name := "git-remote-" + scheme
+	if _, err := exec.LookPath(name); err == nil {
+		return true
+	}
+	out, err := exec.Command(*flGitCmd, "--exec-path").Output()
+	if err != nil {
+		return false
+	}
+	_, err = exec.LookPath(filepath.Join(strings.TrimSpace(string(out)), name))
+	return err == nil
+}
+
+// warnings returns advice about URLs which are valid, but probably not what
+// was meant.
+func (r repoURL) warnings() []string {
+	var w []string
+	if r.kind == repoKindSCP && scpPortRE.MatchString(r.path) {
+		parts := strings.SplitN(r.path, "/", 2)
+		user := ""
+		if r.user != "" {
+			user = r.user + "@"
+		}
+		w = append(w, fmt.Sprintf("scp-like URLs can't have a port, so %q is part of the path; to use it as a port, use ssh://%s%s:%s/%s", parts[0], user, r.hostPort(), parts[0], parts[1]))
+	}
+	return w
+}
+
+// hostPort returns the host, in brackets if it is an IPv6 address, and the
+// port if it is not the default.
+func (r repoURL) hostPort() string {
+	host := r.host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if r.port != "" && r.port != defaultPorts[r.scheme] {
+		host += ":" + r.port
+	}
+	return host
+}
+
+// String returns the repo in a normal form for logging: the scheme and host
+// in lower case, with no password or default port.
+func (r repoURL) String() string {
+	user := ""
+	if r.user != "" {
+		user = r.user
+		if r.hasPassword {
+			user += ":" + redactedText
+		}
+		user += "@"
+	}
+	switch r.kind {
+	case repoKindURL:
+		return r.scheme + "://" + user + strings.ToLower(r.hostPort()) + r.path
+	case repoKindSCP:
+		return user + strings.ToLower(r.hostPort()) + ":" + r.path
+	case repoKindHelper:
+		return r.scheme + "::" + r.path
+	}
+	if r.scheme == "file" {
+		return "file://" + r.path
+	}
+	return r.path
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxRetryBackoff caps the doubling of --retry-backoff.
+const maxRetryBackoff = 30 * time.Second
+
+var commandRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_command_retries_total",
+	Help: "How many times an idempotent git command was retried after failing, partitioned by operation (ls-remote, fetch)",
+}, []string{"op"})
+
+func init() {
+	prometheus.MustRegister(commandRetries)
+}
+
+// withRetries runs fn, and if it fails, runs it again up to retries more
+// times, waiting backoff before the first retry and twice as long before each
+// one after that.  It gives up early if ctx ends.  fn must be idempotent.
+func withRetries(ctx context.Context, op string, retries int, backoff time.Duration, fn func() error) error {
+	err := fn()
+	// Retrying right away would only make a throttling upstream angrier.
+	for i := 0; err != nil && !isThrottled(err) && i < retries; i++ {
+		if ctx.Err() != nil {
+			return err
+		}
+		log.V(0).Info("command failed, retrying", "op", op, "attempt", i+1, "retries", retries, "backoff", backoff.String(), "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		commandRetries.WithLabelValues(op).Inc()
+		err = fn()
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestWithRetries(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	ctx := context.Background()
+	flaky := errors.New("connection reset")
+
+	// Succeeds on the third attempt.
+	calls := 0
+	err := withRetries(ctx, "fetch", 3, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return flaky
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected success after 3 calls, got %d, %v", calls, err)
+	}
+
+	// Runs out of retries.
+	calls = 0
+	err = withRetries(ctx, "fetch", 2, time.Millisecond, func() error {
+		calls++
+		return flaky
+	})
+	if err != flaky || calls != 3 {
+		t.Errorf("expected failure after 3 calls, got %d, %v", calls, err)
+	}
+
+	// No retries.
+	calls = 0
+	withRetries(ctx, "fetch", 0, time.Millisecond, func() error {
+		calls++
+		return flaky
+	})
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	// Gives up when the context ends.
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	calls = 0
+	start := time.Now()
+	err = withRetries(ctx, "fetch", 5, time.Hour, func() error {
+		calls++
+		return flaky
+	})
+	if err != flaky || calls != 1 {
+		t.Errorf("expected to give up after 1 call, got %d, %v", calls, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected to give up promptly, took %v", d)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values for --on-history-rewrite.
+const (
+	// rewritePolicyForceSync publishes the rewritten history like any other
+	// update.
+	rewritePolicyForceSync = "force-sync"
+	// rewritePolicyFail refuses to publish it, and fails the sync.
+	rewritePolicyFail = "fail"
+	// rewritePolicyReclone publishes it from a fresh clone, so that the old
+	// history's objects are dropped.
+	rewritePolicyReclone = "reclone"
+)
+
+var historyRewrites = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_history_rewrites_total",
+	Help: "How many times the upstream hash was not a descendant of the published hash",
+})
+
+func init() {
+	prometheus.MustRegister(historyRewrites)
+}
+
+// errHistoryRewritten is returned when the upstream's history was rewritten
+// and --on-history-rewrite is not force-sync.
+var errHistoryRewritten = errors.New("upstream history was rewritten")
+
+// lastRewrite is the most recent rewritten hash that was seen, so that each
+// rewrite is only counted once, even if it fails to sync many times.
+var lastRewrite string
+
+// isAncestor returns true if ancestor is reachable from hash.
+func isAncestor(ctx context.Context, gitRoot, ancestor, hash string) (bool, error) {
+	_, err := runCommand(ctx, gitRoot, *flGitCmd, "merge-base", "--is-ancestor", ancestor, hash)
+	if err == nil {
+		return true, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// checkHistoryRewrite compares hash, which has just been fetched, with the
+// hash which is published under gitRoot.  If the published hash is not an
+// ancestor of the new one, the upstream was force-pushed or rebased: this is
+// counted, and errHistoryRewritten is returned unless the policy is to
+// publish it anyway.  Shallow clones don't have enough history to tell, so
+// they are not checked, and neither is a switch to or from a fallback branch.
+func checkHistoryRewrite(ctx context.Context, gitRoot, dest, hash string) error {
+	if *flSource != sourceRepo || *flVCS != "git" || len(shallowArgs(*flDepth)) != 0 || refSwitched {
+		return nil
+	}
+	current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	old := worktreeHash(current)
+	if old == "" || old == hash {
+		return nil
+	}
+	ok, err := isAncestor(ctx, gitRoot, old, hash)
+	if err != nil {
+		// The old hash may be gone from the clone, e.g. after a rollback
+		// to a worktree whose history has since been pruned.
+		log.V(1).Info("can't compare with the published hash", "old", old, "new", hash, "error", err.Error())
+		return nil
+	}
+	if ok {
+		return nil
+	}
+
+	if hash != lastRewrite {
+		lastRewrite = hash
+		historyRewrites.Inc()
+		recordKubeEvent(kubeEventWarning, kubeReasonHistoryRewritten, "upstream history was rewritten: %s is not a descendant of %s (policy %s)", hash, old, *flOnHistoryRewrite)
+	}
+	log.V(0).Info("upstream history was rewritten", "old", old, "new", hash, "policy", *flOnHistoryRewrite)
+	if *flOnHistoryRewrite == rewritePolicyForceSync {
+		return nil
+	}
+	return errHistoryRewritten
+}
+
+// recloneDir is where recloneRepo makes the new clone, under --root.
+const recloneDir = ".git-reclone"
+
+// recloneRepo replaces the clone at gitRoot with a fresh one, leaving the
+// worktrees in place, so that what is published stays available until the
+// next hash replaces it.  The objects of the old history are dropped with the
+// old clone.  If the swap fails part way, everything under gitRoot is removed,
+// so that the next sync starts over.
+func recloneRepo(ctx context.Context, repo, branch, rev string, depth int, gitRoot string) error {
+	log.V(0).Info("re-cloning after history rewrite", "path", gitRoot)
+	recordKubeEvent(kubeEventNormal, kubeReasonRepoReinitialized, "upstream history was rewritten, re-cloning %s", gitRoot)
+
+	tmp := filepath.Join(gitRoot, recloneDir)
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := cloneRepo(ctx, repo, branch, rev, depth, tmp); err != nil {
+		return err
+	}
+
+	gitDir := filepath.Join(gitRoot, ".git")
+	err := os.RemoveAll(gitDir)
+	if err == nil {
+		err = os.Rename(filepath.Join(tmp, ".git"), gitDir)
+	}
+	if err != nil {
+		log.Error(err, "can't swap in the new clone, starting over", "path", gitRoot)
+		return clearRoot(gitRoot)
+	}
+	return nil
+}
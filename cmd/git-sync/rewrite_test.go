@@ -0,0 +1,162 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestCheckHistoryRewrite(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(policy string, depth int) {
+		*flOnHistoryRewrite, *flDepth = policy, depth
+	}(*flOnHistoryRewrite, *flDepth)
+	*flDepth = 0
+
+	root := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "one")
+	first := git("rev-parse", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "two")
+	second := git("rev-parse", "HEAD")
+	// A rebase: a sibling of second.
+	git("checkout", "-q", "-b", "rebased", first)
+	git("commit", "-q", "--allow-empty", "-m", "two, again")
+	rebased := git("rev-parse", "HEAD")
+
+	publish := func(hash string) {
+		if err := os.MkdirAll(filepath.Join(root, hash), 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filepath.Join(root, "link"))
+		if err := os.Symlink(hash, filepath.Join(root, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	*flOnHistoryRewrite = rewritePolicyFail
+	if err := checkHistoryRewrite(ctx, root, "link", second); err != nil {
+		t.Errorf("expected nothing published to pass, got %v", err)
+	}
+	publish(first)
+	if err := checkHistoryRewrite(ctx, root, "link", second); err != nil {
+		t.Errorf("expected a fast-forward to pass, got %v", err)
+	}
+	publish(second)
+	if err := checkHistoryRewrite(ctx, root, "link", rebased); !errors.Is(err, errHistoryRewritten) {
+		t.Errorf("expected errHistoryRewritten, got %v", err)
+	}
+	*flOnHistoryRewrite = rewritePolicyForceSync
+	if err := checkHistoryRewrite(ctx, root, "link", rebased); err != nil {
+		t.Errorf("expected force-sync to pass, got %v", err)
+	}
+
+	// Shallow clones are not checked.
+	*flOnHistoryRewrite = rewritePolicyFail
+	*flDepth = 1
+	if err := checkHistoryRewrite(ctx, root, "link", rebased); err != nil {
+		t.Errorf("expected a shallow clone to pass, got %v", err)
+	}
+}
+
+func TestRecloneRepo(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(sparse, bundle, ref string) {
+		*flSparseCheckoutFile, *flFromBundle, *flReferenceRepo = sparse, bundle, ref
+	}(*flSparseCheckoutFile, *flFromBundle, *flReferenceRepo)
+	*flSparseCheckoutFile, *flFromBundle, *flReferenceRepo = "", "", ""
+
+	tmp := t.TempDir()
+	upstream := filepath.Join(tmp, "upstream")
+	root := filepath.Join(tmp, "root")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(upstream, "init", "-q", "-b", "main")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "old")
+	old := git(upstream, "rev-parse", "HEAD")
+
+	ctx := context.Background()
+	if err := cloneRepo(ctx, upstream, "main", "HEAD", 0, root); err != nil {
+		t.Fatal(err)
+	}
+	// Stands in for the published worktree, which must survive.
+	published := filepath.Join(root, old, "file")
+	if err := os.MkdirAll(filepath.Dir(published), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(published, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	git(upstream, "commit", "-q", "--amend", "--allow-empty", "-m", "new")
+	git(upstream, "reflog", "expire", "--expire=now", "--all")
+	git(upstream, "gc", "-q", "--prune=now")
+	rewritten := git(upstream, "rev-parse", "HEAD")
+
+	if err := recloneRepo(ctx, upstream, "main", "HEAD", 0, root); err != nil {
+		t.Fatal(err)
+	}
+	if got := git(root, "rev-parse", "origin/main"); got != rewritten {
+		t.Errorf("expected origin/main to be %s, got %s", rewritten, got)
+	}
+	cmd := exec.Command(*flGitCmd, "cat-file", "-e", old)
+	cmd.Dir = root
+	if err := cmd.Run(); err == nil {
+		t.Errorf("expected the old history to be gone")
+	}
+	if _, err := os.Stat(published); err != nil {
+		t.Errorf("expected the published worktree to survive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, recloneDir)); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be cleaned up", recloneDir)
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// standbyState tracks the most recently replaced worktree, which is kept on
+// disk (when --stale-worktree-timeout or --stale-worktree-max-count is set) so
+// that we can flip back to it without fetching anything.
+type standbyState struct {
+	mutex sync.Mutex
+	// The path to the previous worktree, or "" if there is none.
+	previous string
+	// The hash which was most recently rolled back from.  This hash will
+	// not be re-published until the upstream moves to a different hash.
+	rolledBack string
+	// Whether a rollback is being held: nothing is published until it is
+	// released.
+	held bool
+}
+
+var standby standbyState
+
+func (s *standbyState) get() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.previous
+}
+
+func (s *standbyState) set(worktree string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.previous = worktree
+}
+
+// forget clears the standby worktree if it is the specified worktree.
+func (s *standbyState) forget(worktree string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.previous == worktree {
+		s.previous = ""
+	}
+}
+
+func (s *standbyState) rolledBackFrom() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rolledBack
+}
+
+func (s *standbyState) setRolledBackFrom(hash string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rolledBack = hash
+}
+
+func (s *standbyState) isHeld() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.held
+}
+
+func (s *standbyState) setHeld(held bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.held = held
+}
+
+// retireWorktree marks a worktree which is no longer linked as the standby
+// for rollbacks.  The worktree's mtime is used as the time it was retired, so
+// that it can be cleaned up after --stale-worktree-timeout, or once it is not
+// one of the --stale-worktree-max-count most recently retired.
+func retireWorktree(worktree string) error {
+	now := time.Now()
+	if err := os.Chtimes(worktree, now, now); err != nil {
+		return fmt.Errorf("error marking worktree as stale: %v", err)
+	}
+	standby.set(worktree)
+	return nil
+}
+
+// isHashName returns true if the name looks like a git hash, which is how
+// worktrees are named by default (see --worktree-names).
+func isHashName(name string) bool {
+	if len(name) != 40 && len(name) != 64 {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= '0' && r <= '9') || (r >= 'a' && r <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+// retainingWorktrees returns true if replaced worktrees are kept, rather
+// than removed as soon as they are replaced.
+func retainingWorktrees() bool {
+	return *flStaleWorktreeTimeout > 0 || *flStaleWorktreeMaxCount > 0 || *flPreviousLink != ""
+}
+
+// worktreesToEvict returns the names of the retired worktrees which should be
+// removed.  If maxCount is set, those are all but the maxCount most recently
+// retired, regardless of age.  Otherwise they are those which were retired
+// more than timeout ago.
+func worktreesToEvict(retired []os.FileInfo, now time.Time, timeout time.Duration, maxCount int) []string {
+	sorted := append([]os.FileInfo(nil), retired...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ModTime().After(sorted[j].ModTime())
+	})
+	evict := []string{}
+	for i, fi := range sorted {
+		if maxCount > 0 {
+			if i >= maxCount {
+				evict = append(evict, fi.Name())
+			}
+		} else if now.Sub(fi.ModTime()) >= timeout {
+			evict = append(evict, fi.Name())
+		}
+	}
+	return evict
+}
+
+// cleanupStaleWorktrees removes any worktrees which are not currently linked
+// (by dest or --previous-link) and which are evicted by timeout or maxCount
+// (see worktreesToEvict).
+func cleanupStaleWorktrees(ctx context.Context, gitRoot, dest string, timeout time.Duration, maxCount int) error {
+	current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error accessing current worktree: %v", err)
+	}
+	previous := ""
+	if *flPreviousLink != "" {
+		previous, err = filepath.EvalSymlinks(filepath.Join(gitRoot, *flPreviousLink))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error accessing previous worktree: %v", err)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(gitRoot)
+	if err != nil {
+		return err
+	}
+	retired := []os.FileInfo{}
+	for _, fi := range entries {
+		if !fi.IsDir() || !isWorktreeName(fi.Name()) {
+			continue
+		}
+		if path := filepath.Join(gitRoot, fi.Name()); path == current || path == previous {
+			continue
+		}
+		retired = append(retired, fi)
+	}
+	for _, name := range worktreesToEvict(retired, time.Now(), timeout, maxCount) {
+		worktree := filepath.Join(gitRoot, name)
+		log.V(0).Info("removing stale worktree", "path", worktree)
+		if err := cleanupWorkTree(ctx, gitRoot, worktree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback flips the link back to the standby worktree and runs the hooks.
+// The worktree which was replaced becomes the new standby, so a second
+// rollback undoes the first.  If hold is true, nothing new is published until
+// release is called.  This returns the hash which is now published.
+func rollback(ctx context.Context, gitRoot, dest string, webhook *Webhook, hold bool) (string, error) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	unlockRoot, err := lockRoot(gitRoot, *flLockTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer unlockRoot()
+
+	previous := standby.get()
+	if previous == "" {
+		return "", fmt.Errorf("no previous worktree is available")
+	}
+	if _, err := os.Stat(previous); err != nil {
+		standby.forget(previous)
+		return "", fmt.Errorf("previous worktree is not available: %v", err)
+	}
+	hash := worktreeHash(previous)
+
+	log.V(0).Info("rolling back", "path", previous, "hash", hash)
+	replaced, err := publishWorktree(ctx, gitRoot, dest, previous)
+	if err != nil {
+		return "", err
+	}
+	auditFlip(auditActionRollback, triggerHTTP, replaced, previous)
+	recordPublished(ctx, gitRoot, hash)
+	notifyConsumers(hash)
+	events.publish(syncEvent{Type: eventPublished, Hash: hash, Rollback: true})
+	if replaced != "" {
+		if err := retireWorktree(replaced); err != nil {
+			return "", err
+		}
+		standby.setRolledBackFrom(worktreeHash(replaced))
+	}
+	if hold {
+		log.V(0).Info("holding rollback until released", "hash", hash)
+		standby.setHeld(true)
+	}
+
+	if webhook != nil {
+		webhook.SendRollback(hash)
+	}
+	if err := dispatchSyncHook(ctx, previous, true); err != nil {
+		return hash, err
+	}
+	return hash, nil
+}
+
+// serveRollback handles requests to the /admin/rollback endpoint.
+func serveRollback(w http.ResponseWriter, r *http.Request, webhook *Webhook) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hold := *flRollbackHold
+	if v := r.URL.Query().Get("hold"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid hold value %q", v), http.StatusBadRequest)
+			return
+		}
+		hold = b
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*time.Duration(*flSyncTimeout))
+	defer cancel()
+	hash, err := rollback(ctx, *flRoot, *flDest, webhook, hold)
+	if err != nil {
+		log.Error(err, "rollback failed")
+		if hash == "" {
+			http.Error(w, fmt.Sprintf("rollback failed: %v", err), http.StatusConflict)
+			return
+		}
+		http.Error(w, fmt.Sprintf("rolled back to %s, but the sync hook failed: %v", hash, err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, hash)
+}
+
+// release ends a held rollback, so that syncing publishes the upstream hash
+// again, even if that is the hash which was rolled back from.
+func release() error {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+
+	if !standby.isHeld() {
+		return fmt.Errorf("no rollback is being held")
+	}
+	log.V(0).Info("releasing rollback")
+	standby.setHeld(false)
+	standby.setRolledBackFrom("")
+	return nil
+}
+
+// serveRelease handles requests to the /admin/release endpoint.
+func serveRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := release(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	fmt.Fprintln(w, "released")
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestServeRelease(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func() {
+		standby.setHeld(false)
+		standby.setRolledBackFrom("")
+	}()
+
+	release := func() int {
+		w := httptest.NewRecorder()
+		serveRelease(w, httptest.NewRequest("POST", "/admin/release", nil))
+		return w.Code
+	}
+
+	if code := release(); code != http.StatusConflict {
+		t.Errorf("nothing held: expected %d, got %d", http.StatusConflict, code)
+	}
+
+	standby.setHeld(true)
+	standby.setRolledBackFrom(hash1)
+	if code := release(); code != http.StatusOK {
+		t.Errorf("held: expected %d, got %d", http.StatusOK, code)
+	}
+	if standby.isHeld() {
+		t.Errorf("expected the hold to be released")
+	}
+	if got := standby.rolledBackFrom(); got != "" {
+		t.Errorf("expected the rolled-back hash to be cleared, got %q", got)
+	}
+}
+
+func TestWorktreesToEvict(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	// Retired 1, 2, and 3 hours ago.
+	for i, name := range []string{hash1, hash2, "3333333333333333333333333333333333333333"} {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		when := now.Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retired, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		timeout  time.Duration
+		maxCount int
+		expect   []string
+	}{
+		{"timeout", 90 * time.Minute, 0, []string{hash2, "3333333333333333333333333333333333333333"}},
+		{"long timeout", 24 * time.Hour, 0, []string{}},
+		{"max count", 0, 2, []string{"3333333333333333333333333333333333333333"}},
+		{"max count retains old worktrees", time.Minute, 3, []string{}},
+		{"max count evicts new worktrees", 24 * time.Hour, 1, []string{hash2, "3333333333333333333333333333333333333333"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := worktreesToEvict(retired, now, tc.timeout, tc.maxCount); !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestServeRollbackRequests(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	w := httptest.NewRecorder()
+	serveRollback(w, httptest.NewRequest("GET", "/admin/rollback", nil), nil)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	serveRollback(w, httptest.NewRequest("POST", "/admin/rollback?hold=maybe", nil), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad hold: expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func() {
+		standby.set("")
+		standby.setHeld(false)
+		standby.setRolledBackFrom("")
+	}()
+
+	root := t.TempDir()
+	old := filepath.Join(root, hash1)
+	cur := filepath.Join(root, hash2)
+	for _, dir := range []string{old, cur} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(hash2, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	linked := func() string {
+		target, err := os.Readlink(filepath.Join(root, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.Base(target)
+	}
+
+	standby.set("")
+	if _, err := rollback(context.Background(), root, "link", nil, false); err == nil {
+		t.Errorf("expected an error without a previous worktree")
+	}
+
+	standby.set(filepath.Join(root, "missing"))
+	if _, err := rollback(context.Background(), root, "link", nil, false); err == nil {
+		t.Errorf("expected an error for a missing previous worktree")
+	}
+	if got := standby.get(); got != "" {
+		t.Errorf("expected the missing worktree to be forgotten, got %q", got)
+	}
+
+	standby.set(old)
+	hash, err := rollback(context.Background(), root, "link", nil, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash != hash1 || linked() != hash1 {
+		t.Errorf("expected to roll back to %s, got %s (linked %s)", hash1, hash, linked())
+	}
+	if got := standby.get(); got != cur {
+		t.Errorf("expected the replaced worktree to be the standby, got %q", got)
+	}
+	if got := standby.rolledBackFrom(); got != hash2 {
+		t.Errorf("expected to have rolled back from %s, got %q", hash2, got)
+	}
+	if standby.isHeld() {
+		t.Errorf("expected the rollback not to be held")
+	}
+
+	// A second rollback undoes the first.
+	hash, err = rollback(context.Background(), root, "link", nil, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash != hash2 || linked() != hash2 {
+		t.Errorf("expected to roll back to %s, got %s (linked %s)", hash2, hash, linked())
+	}
+	if !standby.isHeld() {
+		t.Errorf("expected the rollback to be held")
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// rootLockFile is the name of the lock file under --root.
+	rootLockFile = ".git-sync.lock"
+	// rootCloneDir is where the repo is cloned, under a --root which holds
+	// lock files or the git config file, before it is moved into place.  git
+	// won't clone into a non-empty directory.
+	rootCloneDir = ".git-sync-clone"
+	// rootLockPoll is how often a held lock is retried.
+	rootLockPoll = 100 * time.Millisecond
+)
+
+// errRootLocked is returned when another process holds the lock on --root
+// for longer than --lock-timeout.
+var errRootLocked = errors.New("the root is locked by another git-sync")
+
+// lockRoot takes an advisory lock on gitRoot, so that git-sync processes which
+// share it don't change it at the same time, waiting up to timeout for another
+// process to release it.  It returns a func which releases the lock.  If
+// timeout is 0, nothing is locked.
+func lockRoot(gitRoot string, timeout time.Duration) (func(), error) {
+	noop := func() {}
+	if timeout <= 0 {
+		return noop, nil
+	}
+
+	if err := os.MkdirAll(gitRoot, 0755); err != nil {
+		return noop, fmt.Errorf("can't create root: %w", err)
+	}
+	path := filepath.Join(gitRoot, rootLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return noop, fmt.Errorf("can't open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return noop, fmt.Errorf("can't lock %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+		holder := lockHolder(f)
+		if time.Now().After(deadline) {
+			f.Close()
+			return noop, fmt.Errorf("%w: gave up after %v waiting for %s, which is held by %s", errRootLocked, timeout, path, holder)
+		}
+		if !waiting {
+			log.V(0).Info("waiting for another git-sync to release the root", "path", path, "holder", holder, "timeout", timeout.String())
+			waiting = true
+		}
+		time.Sleep(rootLockPoll)
+	}
+
+	// Record who holds the lock, for the errors of anyone waiting for it.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(lockOwner()+"\n"), 0)
+	}
+	return func() {
+		if err := unlockFile(f); err != nil {
+			log.Error(err, "can't unlock root", "path", path)
+		}
+		f.Close()
+	}, nil
+}
+
+// lockOwner describes this process, as the holder of the lock.
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	return fmt.Sprintf("pid %d on %s", os.Getpid(), host)
+}
+
+// lockHolder returns the holder recorded in the lock file f.
+func lockHolder(f *os.File) string {
+	buf := make([]byte, 256)
+	n, _ := f.ReadAt(buf, 0)
+	if holder := strings.TrimSpace(string(buf[:n])); holder != "" {
+		return holder
+	}
+	return "an unknown process"
+}
+
+// rootControlFiles returns the names of the entries which git-sync keeps
+// directly under gitRoot, beside the repo: the lock files, and
+// --git-config-file and the credentials file beside it (or the directory
+// which holds them), if those are under gitRoot.
+func rootControlFiles(gitRoot string) []string {
+	names := []string{rootLockFile, rootLeaderFile}
+	if *flGitConfigFile == "" {
+		return names
+	}
+	rel, err := relToRoot(gitRoot, *flGitConfigFile)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return names
+	}
+	if dir := strings.SplitN(rel, string(filepath.Separator), 2); len(dir) == 2 {
+		return append(names, dir[0])
+	}
+	return append(names, rel, gitCredentialsFile)
+}
+
+// relToRoot returns path, which is absolute, relative to gitRoot, which may
+// not be.
+func relToRoot(gitRoot, path string) (string, error) {
+	abs, err := filepath.Abs(gitRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(abs, path)
+}
+
+func isRootControlFile(gitRoot, name string) bool {
+	for _, f := range rootControlFiles(gitRoot) {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRootControlFiles returns true if gitRoot holds any of the lock files or
+// the git config file.
+func hasRootControlFiles(gitRoot string) bool {
+	for _, name := range rootControlFiles(gitRoot) {
+		if _, err := os.Stat(filepath.Join(gitRoot, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// clearRoot removes everything under gitRoot, except the lock files, which
+// must stay in place while they are held, and the git config file.
+func clearRoot(gitRoot string) error {
+	atomic.AddInt32(&rootClears, 1)
+	if !hasRootControlFiles(gitRoot) {
+		return os.RemoveAll(gitRoot)
+	}
+	entries, err := ioutil.ReadDir(gitRoot)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		if isRootControlFile(gitRoot, fi.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(gitRoot, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isClearRoot returns true if gitRoot holds nothing but the lock files and
+// the git config file.
+func isClearRoot(gitRoot string) (bool, error) {
+	entries, err := ioutil.ReadDir(gitRoot)
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range entries {
+		if !isRootControlFile(gitRoot, fi.Name()) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,205 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestLockRoot(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	root := filepath.Join(t.TempDir(), "root")
+
+	unlock, err := lockRoot(root, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, rootLockFile))
+	if err != nil {
+		t.Fatalf("can't read lock file: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != lockOwner() {
+		t.Errorf("expected the lock file to name %q, got %q", lockOwner(), data)
+	}
+
+	// Each lock has its own open file, so this conflicts like another
+	// process would.
+	_, err = lockRoot(root, 300*time.Millisecond)
+	if !errors.Is(err, errRootLocked) {
+		t.Fatalf("expected errRootLocked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), lockOwner()) {
+		t.Errorf("expected the error to name the holder, got %v", err)
+	}
+
+	// Released locks can be taken, even while waiting for them.
+	unlock1 := unlock
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		unlock1()
+	}()
+	unlock2, err := lockRoot(root, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlock2()
+
+	// A timeout of 0 doesn't lock anything.
+	other := filepath.Join(t.TempDir(), "other")
+	unlock, err = lockRoot(other, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be created, got %v", err)
+	}
+}
+
+func TestClearRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{".git", hash1} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{rootLockFile, rootLeaderFile, "error.json"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if clear, err := isClearRoot(root); err != nil || clear {
+		t.Fatalf("expected a root which isn't clear, got %v, %v", clear, err)
+	}
+	if err := clearRoot(root); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clear, err := isClearRoot(root); err != nil || !clear {
+		t.Errorf("expected a clear root, got %v, %v", clear, err)
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+	if !reflect.DeepEqual(names, []string{rootLeaderFile, rootLockFile}) {
+		t.Errorf("expected only the lock files to be left, got %v", names)
+	}
+
+	// Without a lock file, the whole root is removed.
+	unlocked := filepath.Join(t.TempDir(), "unlocked")
+	if err := os.MkdirAll(filepath.Join(unlocked, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := clearRoot(unlocked); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(unlocked); !os.IsNotExist(err) {
+		t.Errorf("expected the root to be removed, got %v", err)
+	}
+}
+
+func TestClearRootKeepsGitConfigFile(t *testing.T) {
+	defer func(v string) { *flGitConfigFile = v }(*flGitConfigFile)
+
+	for _, tc := range []struct {
+		name   string
+		config string
+		keep   []string
+	}{
+		{"in-root", ".gitconfig", []string{".git-credentials", ".gitconfig"}},
+		{"in-subdir", "config/gitconfig", []string{"config"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			*flGitConfigFile = filepath.Join(root, tc.config)
+			if err := os.MkdirAll(filepath.Join(root, ".git"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(*flGitConfigFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			creds := filepath.Join(filepath.Dir(*flGitConfigFile), gitCredentialsFile)
+			for _, path := range []string{*flGitConfigFile, creds} {
+				if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := clearRoot(root); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if clear, err := isClearRoot(root); err != nil || !clear {
+				t.Errorf("expected a clear root, got %v, %v", clear, err)
+			}
+			entries, err := ioutil.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, fi := range entries {
+				names = append(names, fi.Name())
+			}
+			if !reflect.DeepEqual(names, tc.keep) {
+				t.Errorf("expected %v to be left, got %v", tc.keep, names)
+			}
+		})
+	}
+}
+
+func TestFileElector(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	root := filepath.Join(t.TempDir(), "root")
+	ctx := context.Background()
+
+	a := newFileElector(root)
+	if ok, holder, err := a.tryAcquire(ctx, time.Now()); err != nil || !ok || holder != lockOwner() {
+		t.Fatalf("expected to be elected, got %v, %q, %v", ok, holder, err)
+	}
+	// Each elector has its own open file, so this conflicts like another
+	// process would.
+	b := newFileElector(root)
+	if ok, holder, err := b.tryAcquire(ctx, time.Now()); err != nil || ok || holder != lockOwner() {
+		t.Fatalf("expected to follow, got %v, %q, %v", ok, holder, err)
+	}
+	if ok, _, err := a.tryAcquire(ctx, time.Now()); err != nil || !ok {
+		t.Fatalf("expected to stay the leader, got %v, %v", ok, err)
+	}
+
+	// When the leader goes away, the follower takes over.
+	a.f.Close()
+	if ok, _, err := b.tryAcquire(ctx, time.Now()); err != nil || !ok {
+		t.Errorf("expected to take over, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var commandDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	Name: "git_sync_command_duration_seconds",
+	Help: "Summary of the wall time of child processes, partitioned by command (e.g. 'git fetch')",
+}, []string{"command"})
+
+var commandCPU = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_command_cpu_seconds_total",
+	Help: "CPU time used by child processes, partitioned by command (e.g. 'git fetch') and mode (user, system)",
+}, []string{"command", "mode"})
+
+var commandMaxRSS = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "git_sync_command_max_rss_bytes",
+	Help: "The peak resident set size of the most recent child process, partitioned by command (e.g. 'git fetch')",
+}, []string{"command"})
+
+func init() {
+	prometheus.MustRegister(commandDuration)
+	prometheus.MustRegister(commandCPU)
+	prometheus.MustRegister(commandMaxRSS)
+}
+
+// commandLabel names a command for metrics.  For git, that is the subcommand,
+// e.g. "git fetch", skipping any global options before it, and any nice or
+// ionice wrapping it.
+func commandLabel(command string, args []string) string {
+	name := filepath.Base(command)
+	if (name == niceCmd || name == ioniceCmd) && len(args) > 2 {
+		// Both are only used as "<cmd> <opt> <value> <command>...".
+		return commandLabel(args[2], args[3:])
+	}
+	if command != *flGitCmd {
+		return name
+	}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c" || args[i] == "-C":
+			i++
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			return name + " " + args[i]
+		}
+	}
+	return name
+}
+
+// recordResourceUsage exports the wall time and, once the process has been
+// waited for, the CPU time and peak memory of a child process.  state is nil
+// if the process never started.
+func recordResourceUsage(label string, wall time.Duration, state *os.ProcessState) {
+	commandDuration.WithLabelValues(label).Observe(wall.Seconds())
+	if state == nil {
+		return
+	}
+	commandCPU.WithLabelValues(label, "user").Add(state.UserTime().Seconds())
+	commandCPU.WithLabelValues(label, "system").Add(state.SystemTime().Seconds())
+	if rss, ok := maxRSS(state); ok {
+		commandMaxRSS.WithLabelValues(label).Set(float64(rss))
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCommandLabel(t *testing.T) {
+	testCases := []struct {
+		command string
+		args    []string
+		expect  string
+	}{
+		{*flGitCmd, []string{"fetch", "-f", "origin"}, "git fetch"},
+		{*flGitCmd, []string{"-c", "protocol.version=2", "ls-remote", "-q", "origin"}, "git ls-remote"},
+		{*flGitCmd, []string{"-C", "/tmp", "--no-pager", "gc"}, "git gc"},
+		{*flGitCmd, []string{"--version"}, "git"},
+		{"nice", []string{"-n", "10", "ionice", "-c", "3", *flGitCmd, "-c", "pack.threads=1", "gc"}, "git gc"},
+		{"/bin/ln", []string{"-snf", "a", "b"}, "ln"},
+	}
+	for _, tc := range testCases {
+		if got := commandLabel(tc.command, tc.args); got != tc.expect {
+			t.Errorf("%s %v: expected %q, got %q", tc.command, tc.args, tc.expect, got)
+		}
+	}
+}
+
+func TestMaxRSS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("peak RSS is not supported on Windows")
+	}
+	cmd := exec.Command("sh", "-c", "true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("can't run sh: %v", err)
+	}
+	rss, ok := maxRSS(cmd.ProcessState)
+	if !ok || rss <= 0 {
+		t.Errorf("expected a peak RSS, got %d, %v", rss, ok)
+	}
+	// Doesn't panic.
+	recordResourceUsage("sh", time.Second, cmd.ProcessState)
+	recordResourceUsage("sh", time.Second, nil)
+}
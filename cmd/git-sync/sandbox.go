@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sandboxEnv are the environment variables, beyond envAlwaysPassed, which
+// git and the other commands git-sync runs get with --git-sandbox: those
+// which git needs to reach the upstream and to find CA certificates.
+var sandboxEnv = []string{
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY",
+	"http_proxy", "https_proxy", "no_proxy", "all_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR", "GIT_SSL_CAINFO", "GIT_SSL_CAPATH",
+	"LANG", "LC_*", "TZ", "TMPDIR", "USER",
+}
+
+// sandboxGitEnv returns the filter for git's environment with --git-sandbox.
+// Variables listed in --git-env are passed too.
+func sandboxGitEnv(allow, block []string) envFilter {
+	return envFilter{allow: append(append([]string{}, sandboxEnv...), allow...), block: block}
+}
+
+// sqQuote quotes s the way git quotes the values in $GIT_CONFIG_PARAMETERS.
+func sqQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// setupGitConfigParameters makes every git command git-sync runs, and the
+// git commands those run (e.g. for submodules), use configs.  They are set in
+// $GIT_CONFIG_PARAMETERS, as "git -c" does, so that they win over any config
+// file, including those in the clone.
+func setupGitConfigParameters(configs []keyVal) error {
+	if len(configs) == 0 {
+		return nil
+	}
+	keys := []string{}
+	for _, kv := range configs {
+		keys = append(keys, kv.key)
+	}
+	log.V(1).Info("setting git config parameters", "keys", keys)
+
+	params := []string{}
+	if prev := os.Getenv("GIT_CONFIG_PARAMETERS"); prev != "" {
+		params = append(params, prev)
+	}
+	for _, kv := range configs {
+		params = append(params, sqQuote(kv.key)+"="+sqQuote(kv.val))
+	}
+	if err := os.Setenv("GIT_CONFIG_PARAMETERS", strings.Join(params, " ")); err != nil {
+		return fmt.Errorf("can't set $GIT_CONFIG_PARAMETERS: %w", err)
+	}
+	return nil
+}
+
+// gitConfigSetsHooksPath returns true if --git-config sets core.hooksPath,
+// which --git-hooks-path would silently override.
+func gitConfigSetsHooksPath(configsFlag string) bool {
+	configs, err := parseGitConfigs(configsFlag)
+	if err != nil {
+		return false
+	}
+	for _, kv := range configs {
+		if strings.EqualFold(kv.key, "core.hooksPath") {
+			return true
+		}
+	}
+	return false
+}
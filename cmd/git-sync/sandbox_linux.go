@@ -0,0 +1,105 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// canSandboxProcess is true if --git-sandbox can restrict privileges, as well
+// as the environment.
+const canSandboxProcess = true
+
+// From linux/prctl.h.
+const (
+	prCapBSetDrop    = 24
+	prSetNoNewPrivs  = 38
+	capLastCapFile   = "/proc/sys/kernel/cap_last_cap"
+	capLastCapAssume = 40
+)
+
+// sandboxKeptCaps are the capabilities which --git-sandbox leaves in the
+// bounding set: those which git may need to write into a root it doesn't
+// own, as it can when it runs as root.  They are CAP_CHOWN, CAP_DAC_OVERRIDE,
+// CAP_DAC_READ_SEARCH, CAP_FOWNER, and CAP_FSETID.
+var sandboxKeptCaps = map[uintptr]bool{0: true, 1: true, 2: true, 3: true, 4: true}
+
+// capLastCap returns the highest capability the kernel knows.
+func capLastCap() uintptr {
+	b, err := ioutil.ReadFile(capLastCapFile)
+	if err != nil {
+		return capLastCapAssume
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n < 0 {
+		return capLastCapAssume
+	}
+	return uintptr(n)
+}
+
+// allThreadsPrctl runs prctl on every thread of git-sync, since Go may start
+// a subprocess from any of them.  This can't work in binaries built with cgo.
+func allThreadsPrctl(option, arg uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall6(syscall.SYS_PRCTL, option, arg, 0, 0, 0, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return fmt.Errorf("not supported in binaries built with cgo")
+		}
+		return errno
+	}
+	return nil
+}
+
+// sandboxProcess stops git-sync and everything it runs from gaining
+// privileges (e.g. through setuid binaries), and drops the capabilities
+// which git doesn't need from the bounding set, so that a subprocess can't
+// have them even if it runs as root.  git-sync itself keeps the capabilities
+// it has.
+func sandboxProcess() error {
+	if err := allThreadsPrctl(prSetNoNewPrivs, 1); err != nil {
+		return fmt.Errorf("can't set no_new_privs: %w", err)
+	}
+	dropped := 0
+	for c := uintptr(0); c <= capLastCap(); c++ {
+		if sandboxKeptCaps[c] {
+			continue
+		}
+		err := allThreadsPrctl(prCapBSetDrop, c)
+		if err == syscall.EPERM {
+			// Without CAP_SETPCAP there is nothing to drop: subprocesses
+			// of an unprivileged process can't gain capabilities anyway.
+			log.V(1).Info("can't drop capabilities without CAP_SETPCAP")
+			break
+		}
+		if err == syscall.EINVAL {
+			// Not a capability on this kernel.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("can't drop capability %d: %w", c, err)
+		}
+		dropped++
+	}
+	log.V(0).Info("sandboxed subprocesses", "noNewPrivs", true, "capsDropped", dropped)
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestSandboxGitEnv(t *testing.T) {
+	f := sandboxGitEnv([]string{"APP_*"}, []string{"LC_ALL"})
+	for name, expect := range map[string]bool{
+		"PATH":                  true,
+		"GIT_CONFIG_PARAMETERS": true,
+		"HTTPS_PROXY":           true,
+		"LC_CTYPE":              true,
+		"LC_ALL":                false,
+		"APP_TOKEN":             true,
+		"AWS_SECRET_ACCESS_KEY": false,
+		"GIT_SYNC_PASSWORD":     false,
+	} {
+		if got := f.passes(name); got != expect {
+			t.Errorf("%s: expected %v, got %v", name, expect, got)
+		}
+	}
+}
+
+func TestGitConfigSetsHooksPath(t *testing.T) {
+	cases := map[string]bool{
+		"":                                      false,
+		"core.hooksPath:/hooks":                 true,
+		"http.sslVerify:false,core.hookspath:x": true,
+		"core.hooksPathological:x":              false,
+	}
+	for flag, expect := range cases {
+		if got := gitConfigSetsHooksPath(flag); got != expect {
+			t.Errorf("%q: expected %v, got %v", flag, expect, got)
+		}
+	}
+}
+
+func TestSetupGitConfigParameters(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("GIT_CONFIG_PARAMETERS", v)
+		} else {
+			os.Unsetenv("GIT_CONFIG_PARAMETERS")
+		}
+	}(os.LookupEnv("GIT_CONFIG_PARAMETERS"))
+	os.Unsetenv("GIT_CONFIG_PARAMETERS")
+
+	repo := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "hook-ran")
+	ctx := context.Background()
+	git := func(args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if _, err := runCommand(ctx, repo, *flGitCmd, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	hook := "#!/bin/sh\ntouch " + marker + "\n"
+	if err := ioutil.WriteFile(filepath.Join(repo, ".git", "hooks", "post-commit"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	git("commit", "-q", "--allow-empty", "-m", "one")
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("expected the hook to run by default: %v", err)
+	}
+	os.Remove(marker)
+
+	if err := setupGitConfigParameters([]keyVal{{"core.hooksPath", os.DevNull}}); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-q", "--allow-empty", "-m", "two")
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the hook not to run, got %v", err)
+	}
+
+	// Other "git -c" options still work.
+	out, err := runCommand(ctx, repo, *flGitCmd, "-c", "test.key=value", "config", "test.key")
+	if err != nil || strings.TrimSpace(out) != "value" {
+		t.Errorf("expected git -c to work, got %q, %v", out, err)
+	}
+}
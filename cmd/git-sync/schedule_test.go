@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		"* * * foo *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	base := time.Date(2021, time.June, 16, 10, 7, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", base, time.Date(2021, 6, 16, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", base, time.Date(2021, 6, 16, 10, 15, 0, 0, time.UTC)},
+		{"0 * * * *", base, time.Date(2021, 6, 16, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", base, time.Date(2021, 6, 16, 11, 0, 0, 0, time.UTC)},
+		{"@daily", base, time.Date(2021, 6, 17, 0, 0, 0, 0, time.UTC)},
+		{"30 9-17 * * mon-fri", base, time.Date(2021, 6, 16, 10, 30, 0, 0, time.UTC)},
+		{"30 9-17 * * mon-fri", time.Date(2021, 6, 18, 17, 45, 0, 0, time.UTC), time.Date(2021, 6, 21, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", base, time.Date(2021, 6, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", base, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5,10 8 * * *", base, time.Date(2021, 6, 17, 8, 5, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches.
+		{"0 0 1 * fri", base, time.Date(2021, 6, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", base, time.Time{}},
+	}
+	for _, tc := range cases {
+		s, err := parseCron(tc.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if got := s.next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%q from %v: expected %v, got %v", tc.spec, tc.from, tc.want, got)
+		}
+	}
+}
+
+func TestNextSyncWait(t *testing.T) {
+	defer func() {
+		syncSchedule = nil
+		scheduleJitter = 0
+	}()
+
+	s, err := parseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncSchedule = s
+	scheduleJitter = 10 * time.Minute
+
+	now := time.Date(2021, 6, 16, 10, 5, 0, 0, time.UTC)
+	if got, want := nextSyncWait(now), 5*time.Minute; got != want {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	now = time.Date(2021, 6, 16, 10, 10, 0, 0, time.UTC)
+	if got, want := nextSyncWait(now), time.Hour; got != want {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if off := scheduleOffset(time.Minute); off < 0 || off >= time.Minute || off != scheduleOffset(time.Minute) {
+		t.Errorf("expected a stable offset in [0, 1m), got %v", off)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scmWebhookPath is where pushes from GitHub or GitLab are received.
+const scmWebhookPath = "/api/v1/scm-webhook"
+
+// scmWebhookMaxBody bounds the size of a push notification.  GitHub caps
+// its payloads at 25MB, but pushes are much smaller than that.
+const scmWebhookMaxBody = 5 << 20
+
+// Values for the "provider" label of git_sync_scm_webhooks_total.
+const (
+	scmProviderGitHub = "github"
+	scmProviderGitLab = "gitlab"
+	scmProviderNone   = "unknown"
+)
+
+// Values for the "result" label of git_sync_scm_webhooks_total.
+const (
+	scmResultTriggered    = "triggered"
+	scmResultIgnored      = "ignored"
+	scmResultUnauthorized = "unauthorized"
+	scmResultInvalid      = "invalid"
+)
+
+var scmWebhookCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_scm_webhooks_total",
+	Help: "How many push notifications were received on " + scmWebhookPath + ", partitioned by provider and result",
+}, []string{"provider", "result"})
+
+func init() {
+	prometheus.MustRegister(scmWebhookCount)
+}
+
+// scmSecret is the secret from --scm-webhook-secret-file, which GitHub signs
+// its notifications with, and GitLab sends as a token.
+var scmSecret httpToken
+
+// reloadSCMSecret re-reads --scm-webhook-secret-file.
+func reloadSCMSecret(ctx context.Context) error {
+	return scmSecret.load(*flSCMWebhookSecretFile)
+}
+
+var errSCMUnauthorized = errors.New("missing or invalid signature")
+
+// authenticateSCMWebhook checks the signature (GitHub) or token (GitLab) of
+// a notification against secret, and returns which provider sent it and
+// what event it is.
+func authenticateSCMWebhook(header http.Header, body []byte, secret string) (string, string, error) {
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expect := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(sig), []byte(expect)) {
+			return scmProviderGitHub, "", errSCMUnauthorized
+		}
+		return scmProviderGitHub, header.Get("X-GitHub-Event"), nil
+	}
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return scmProviderGitLab, "", errSCMUnauthorized
+		}
+		return scmProviderGitLab, header.Get("X-Gitlab-Event"), nil
+	}
+	return scmProviderNone, "", errSCMUnauthorized
+}
+
+// scmPushRef returns the ref which was pushed, from the body of a push
+// notification.  GitHub may send it form-encoded.
+func scmPushRef(contentType string, body []byte) (string, error) {
+	if mt, _, _ := mime.ParseMediaType(contentType); mt == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", err
+		}
+		body = []byte(form.Get("payload"))
+	}
+	push := struct {
+		Ref string `json:"ref"`
+	}{}
+	if err := json.Unmarshal(body, &push); err != nil {
+		return "", err
+	}
+	if push.Ref == "" {
+		return "", fmt.Errorf("no ref in push notification")
+	}
+	return push.Ref, nil
+}
+
+// scmRefMatches returns true if ref is one that git-sync syncs.
+func scmRefMatches(ref string) bool {
+	if ref == refForRev(*flBranch, *flRev) {
+		return true
+	}
+	for _, branch := range flRefFallbacks.items {
+		if ref == "refs/heads/"+branch {
+			return true
+		}
+	}
+	return false
+}
+
+// serveSCMWebhook handles push notifications from GitHub or GitLab.  A push
+// to the synced ref starts a sync now, rather than at the next --wait.
+func serveSCMWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, scmWebhookMaxBody+1))
+	if err != nil {
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > scmWebhookMaxBody {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	provider, event, err := authenticateSCMWebhook(r.Header, body, scmSecret.get())
+	if err != nil {
+		scmWebhookCount.WithLabelValues(provider, scmResultUnauthorized).Inc()
+		log.V(0).Info("rejected SCM webhook", "provider", provider, "remote", r.RemoteAddr, "error", err.Error())
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if provider == scmProviderGitHub && event == "ping" {
+		fmt.Fprintln(w, "pong")
+		return
+	}
+
+	ref, err := scmPushRef(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		scmWebhookCount.WithLabelValues(provider, scmResultInvalid).Inc()
+		http.Error(w, fmt.Sprintf("not a push notification: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !scmRefMatches(ref) {
+		scmWebhookCount.WithLabelValues(provider, scmResultIgnored).Inc()
+		log.V(1).Info("ignoring SCM webhook for another ref", "provider", provider, "event", event, "ref", ref)
+		fmt.Fprintf(w, "ignored: %s is not synced\n", ref)
+		return
+	}
+
+	scmWebhookCount.WithLabelValues(provider, scmResultTriggered).Inc()
+	log.V(0).Info("received SCM webhook, syncing now", "provider", provider, "event", event, "ref", ref)
+	// The push may be newer than what is cached.
+	remoteHashes.invalidate()
+	triggerSync(triggerWebhook)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "sync triggered for %s\n", strings.TrimSpace(ref))
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestSCMPushRef(t *testing.T) {
+	ref, err := scmPushRef("application/json", []byte(`{"ref":"refs/heads/main","after":"abc"}`))
+	if err != nil || ref != "refs/heads/main" {
+		t.Errorf("unexpected result: %q, %v", ref, err)
+	}
+	form := "payload=" + url.QueryEscape(`{"ref":"refs/tags/v1"}`)
+	ref, err = scmPushRef("application/x-www-form-urlencoded", []byte(form))
+	if err != nil || ref != "refs/tags/v1" {
+		t.Errorf("unexpected result for a form: %q, %v", ref, err)
+	}
+	if _, err := scmPushRef("application/json", []byte(`{"zen":"hi"}`)); err == nil {
+		t.Errorf("expected an error without a ref")
+	}
+}
+
+func TestServeSCMWebhook(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(branch, rev string, fallbacks []string) {
+		*flBranch, *flRev, flRefFallbacks.items = branch, rev, fallbacks
+	}(*flBranch, *flRev, flRefFallbacks.items)
+	*flBranch, *flRev, flRefFallbacks.items = "main", "HEAD", []string{"stable"}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := scmSecret.load(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { scmSecret = httpToken{} }()
+
+	sign := func(secret, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	push := func(ref string) string { return `{"ref":"` + ref + `"}` }
+
+	cases := []struct {
+		name    string
+		method  string
+		body    string
+		headers map[string]string
+		expect  int
+		trigger bool
+	}{{
+		name:    "github push",
+		body:    push("refs/heads/main"),
+		headers: map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("s3cret", push("refs/heads/main"))},
+		expect:  http.StatusAccepted,
+		trigger: true,
+	}, {
+		name:    "github push to a fallback",
+		body:    push("refs/heads/stable"),
+		headers: map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("s3cret", push("refs/heads/stable"))},
+		expect:  http.StatusAccepted,
+		trigger: true,
+	}, {
+		name:    "github push to another branch",
+		body:    push("refs/heads/dev"),
+		headers: map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("s3cret", push("refs/heads/dev"))},
+		expect:  http.StatusOK,
+	}, {
+		name:    "github bad signature",
+		body:    push("refs/heads/main"),
+		headers: map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("wrong", push("refs/heads/main"))},
+		expect:  http.StatusUnauthorized,
+	}, {
+		name:    "github ping",
+		body:    `{"zen":"hi"}`,
+		headers: map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": sign("s3cret", `{"zen":"hi"}`)},
+		expect:  http.StatusOK,
+	}, {
+		name:    "gitlab push",
+		body:    push("refs/heads/main"),
+		headers: map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "s3cret"},
+		expect:  http.StatusAccepted,
+		trigger: true,
+	}, {
+		name:    "gitlab bad token",
+		body:    push("refs/heads/main"),
+		headers: map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"},
+		expect:  http.StatusUnauthorized,
+	}, {
+		name:   "unsigned",
+		body:   push("refs/heads/main"),
+		expect: http.StatusUnauthorized,
+	}, {
+		name:    "not a push",
+		body:    `not json`,
+		headers: map[string]string{"X-Gitlab-Token": "s3cret"},
+		expect:  http.StatusBadRequest,
+	}, {
+		name:   "get",
+		method: "GET",
+		expect: http.StatusMethodNotAllowed,
+	}}
+	for _, tc := range cases {
+		// Drain any pending trigger.
+		select {
+		case <-syncNow:
+		default:
+		}
+		method := tc.method
+		if method == "" {
+			method = "POST"
+		}
+		req := httptest.NewRequest(method, scmWebhookPath, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		serveSCMWebhook(rec, req)
+		if rec.Code != tc.expect {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.expect, rec.Code, rec.Body.String())
+		}
+		triggered := false
+		select {
+		case <-syncNow:
+			triggered = true
+		default:
+		}
+		if triggered != tc.trigger {
+			t.Errorf("%s: expected triggered=%v", tc.name, tc.trigger)
+		}
+	}
+}
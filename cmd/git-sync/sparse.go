@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// usingSparseCheckout returns true if checkouts leave some files out.
+func usingSparseCheckout() bool {
+	return *flSparseCheckoutFile != "" || len(flExcludePaths.items) != 0
+}
+
+// validateExcludePath returns an error if pattern can't be used as an
+// --exclude-paths pattern.
+func validateExcludePath(pattern string) error {
+	switch {
+	case strings.TrimSpace(pattern) == "":
+		return fmt.Errorf("pattern is empty")
+	case strings.HasPrefix(pattern, "!") || strings.HasPrefix(pattern, "#"):
+		return fmt.Errorf("pattern %q can't start with %q", pattern, pattern[:1])
+	case strings.ContainsAny(pattern, "\r\n"):
+		return fmt.Errorf("pattern %q can't span lines", pattern)
+	}
+	return nil
+}
+
+// sparseCheckoutRules returns the sparse-checkout file for the clone and its
+// worktrees: the --sparse-checkout-file, or else everything, followed by a
+// negative rule for each of --exclude-paths.  These are non-cone rules, which
+// is the mode "git sparse-checkout init" uses.
+func sparseCheckoutRules() ([]byte, error) {
+	var buf bytes.Buffer
+	if *flSparseCheckoutFile != "" {
+		data, err := ioutil.ReadFile(*flSparseCheckoutFile)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	} else {
+		buf.WriteString("/*\n")
+	}
+	for _, pattern := range flExcludePaths.items {
+		fmt.Fprintf(&buf, "!%s\n", pattern)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeSparseCheckout writes the sparseCheckoutRules to the sparse-checkout
+// file in the git info directory infoDir.
+func writeSparseCheckout(infoDir string) error {
+	rules, err := sparseCheckoutRules()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(infoDir, "sparse-checkout"), rules, 0644)
+}
+
+// reapplySparseCheckout re-reads the sparse-checkout rules, for the clone and
+// the worktrees made from now on, and applies them to the published worktree
+// in place.
+func reapplySparseCheckout(ctx context.Context, gitRoot, dest string) error {
+	if _, err := sparseCheckoutRules(); err != nil {
+		return err
+	}
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	unlockRoot, err := lockRoot(gitRoot, *flLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlockRoot()
+
+	if err := writeSparseCheckout(filepath.Join(gitRoot, ".git", "info")); err != nil {
+		return err
+	}
+	worktree, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *flPublishWithoutGitdir {
+		log.V(0).Info("published worktree has no git metadata, new sparse-checkout rules apply to the next one", "path", worktree)
+		return nil
+	}
+	if err := writeSparseCheckout(filepath.Join(worktreeAdminDir(gitRoot, worktree), "info")); err != nil {
+		return err
+	}
+	log.V(0).Info("re-applying sparse checkout", "path", worktree)
+	if _, err := runCommand(ctx, worktree, *flGitCmd, "read-tree", "-mu", "HEAD"); err != nil {
+		return err
+	}
+	recordPublished(ctx, gitRoot, worktreeHash(worktree))
+	return nil
+}
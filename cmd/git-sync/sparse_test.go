@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSparseCheckoutRules(t *testing.T) {
+	defer func(file string, excludes []string) {
+		*flSparseCheckoutFile, flExcludePaths.items = file, excludes
+	}(*flSparseCheckoutFile, flExcludePaths.items)
+
+	*flSparseCheckoutFile, flExcludePaths.items = "", []string{"/docs/", "*.md"}
+	rules, err := sparseCheckoutRules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := "/*\n!/docs/\n!*.md\n"; string(rules) != expect {
+		t.Errorf("expected %q, got %q", expect, rules)
+	}
+
+	*flSparseCheckoutFile = filepath.Join(t.TempDir(), "sparse")
+	if err := ioutil.WriteFile(*flSparseCheckoutFile, []byte("/src/"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = sparseCheckoutRules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := "/src/\n!/docs/\n!*.md\n"; string(rules) != expect {
+		t.Errorf("expected %q, got %q", expect, rules)
+	}
+
+	for _, bad := range []string{"", " ", "!docs", "#docs", "docs\n/*"} {
+		if err := validateExcludePath(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestExcludePathsCheckout(t *testing.T) {
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(file string, excludes []string) {
+		*flSparseCheckoutFile, flExcludePaths.items = file, excludes
+	}(*flSparseCheckoutFile, flExcludePaths.items)
+	*flSparseCheckoutFile, flExcludePaths.items = "", []string{"/docs/", "*.md"}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = os.Environ()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	for _, name := range []string{"main.go", "README.md", "docs/index.html", "src/docs/keep.txt", "src/notes.md"} {
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "files")
+
+	if err := writeSparseCheckout(filepath.Join(repo, ".git", "info")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	git("sparse-checkout", "init")
+	git("reset", "-q", "--hard", "HEAD")
+
+	var got []string
+	filepath.Walk(repo, func(path string, fi os.FileInfo, err error) error {
+		if fi.IsDir() && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() {
+			rel, _ := filepath.Rel(repo, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(got)
+	if expect := "main.go src/docs/keep.txt"; strings.Join(got, " ") != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var spotCheckCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_spot_check_count_total",
+	Help: "How many spot checks of the published worktree have run, partitioned by state (success, error)",
+}, []string{"status"})
+
+var spotCheckCorruptFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_spot_check_corrupt_files",
+	Help: "How many files the last spot check found to differ from the published commit",
+})
+
+var spotCheckRepairCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_spot_check_repair_count_total",
+	Help: "How many damaged files have been checked out again, partitioned by state (success, error)",
+}, []string{"status"})
+
+func init() {
+	prometheus.MustRegister(spotCheckCount)
+	prometheus.MustRegister(spotCheckCorruptFiles)
+	prometheus.MustRegister(spotCheckRepairCount)
+}
+
+// Modes of the tree entries which are checked.  Submodules (160000) are
+// checked by their own repos, if at all.
+const (
+	treeModeFile       = "100644"
+	treeModeExecutable = "100755"
+	treeModeSymlink    = "120000"
+)
+
+// treeEntry is one file in the manifest of a commit.
+type treeEntry struct {
+	path string // slash-separated, relative to the worktree
+	mode string
+	blob string
+}
+
+// parseTreeEntries parses the output of `git ls-tree -r -z`, keeping the
+// regular files and symlinks.
+func parseTreeEntries(output string) ([]treeEntry, error) {
+	entries := []treeEntry{}
+	for _, rec := range strings.Split(output, "\x00") {
+		if rec == "" {
+			continue
+		}
+		tab := strings.IndexByte(rec, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("can't parse ls-tree output %q", rec)
+		}
+		fields := strings.Fields(rec[:tab])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("can't parse ls-tree output %q", rec)
+		}
+		switch fields[0] {
+		case treeModeFile, treeModeExecutable, treeModeSymlink:
+			entries = append(entries, treeEntry{path: rec[tab+1:], mode: fields[0], blob: fields[2]})
+		}
+	}
+	return entries, nil
+}
+
+// blobHash returns the git object name of a blob holding data, using the
+// same hash function as blob (SHA-1 or SHA-256).
+func blobHash(blob string, data io.Reader, size int64) (string, error) {
+	var h hash.Hash
+	if len(blob) == 64 {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	fmt.Fprintf(h, "blob %d\x00", size)
+	n, err := io.Copy(h, data)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("size changed while reading: expected %d bytes, read %d", size, n)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkTreeEntry returns nil if the file for e under worktree still has
+// the content it was published with.
+func checkTreeEntry(worktree string, e treeEntry) error {
+	path := filepath.Join(worktree, filepath.FromSlash(e.path))
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	var got string
+	if e.mode == treeModeSymlink {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("is no longer a symlink")
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		got, err = blobHash(e.blob, strings.NewReader(target), int64(len(target)))
+		if err != nil {
+			return err
+		}
+	} else {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("is no longer a regular file")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		got, err = blobHash(e.blob, f, info.Size())
+		if err != nil {
+			return err
+		}
+	}
+	if got != e.blob {
+		return fmt.Errorf("content hash is %s, expected %s", got, e.blob)
+	}
+	return nil
+}
+
+// spotChecker remembers the manifest of the published commit, and which of
+// its files to check next, so that successive checks cover all of them.
+type spotChecker struct {
+	mutex   sync.Mutex
+	hash    string
+	entries []treeEntry
+	next    int
+}
+
+var spotChecks spotChecker
+
+// record makes the manifest of hash, which has just been published.
+func (s *spotChecker) record(ctx context.Context, gitRoot, hash string) error {
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "ls-tree", "-r", "-z", "--full-tree", hash)
+	if err != nil {
+		return err
+	}
+	entries, err := parseTreeEntries(output)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hash = hash
+	s.entries = entries
+	s.next = 0
+	log.V(2).Info("recorded manifest", "hash", hash, "files", len(entries))
+	return nil
+}
+
+// recordPublished records the manifest of hash if spot checks are enabled.
+// This never fails a sync: the manifest is made again at the next check if
+// it is missing.
+func recordPublished(ctx context.Context, gitRoot, hash string) {
+	if *flSpotCheckInterval == 0 {
+		return
+	}
+	if err := spotChecks.record(ctx, gitRoot, hash); err != nil {
+		log.Error(err, "can't record manifest", "hash", hash)
+	}
+}
+
+// sample returns up to n (or all, if n is 0) entries of the manifest for
+// hash, carrying on from where the last sample stopped.
+func (s *spotChecker) sample(hash string, n int) ([]treeEntry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.hash != hash {
+		return nil, false
+	}
+	if n == 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+	out := make([]treeEntry, 0, n)
+	for i := 0; i < n; i++ {
+		if s.next >= len(s.entries) {
+			s.next = 0
+		}
+		out = append(out, s.entries[s.next])
+		s.next++
+	}
+	return out, true
+}
+
+// check compares a sample of the files in the published worktree with the
+// manifest, and checks out any damaged ones again if --spot-check-repair is
+// set.  It returns the paths of the damaged files.
+func (s *spotChecker) check(ctx context.Context, gitRoot, dest string) ([]string, error) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	unlockRoot, err := lockRoot(gitRoot, *flLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockRoot()
+
+	worktree, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if os.IsNotExist(err) && !getRepoReady() {
+		// Nothing published yet.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash := worktreeHash(worktree)
+	if hash == "" {
+		return nil, fmt.Errorf("published path %s is not a worktree", worktree)
+	}
+
+	sample, ok := s.sample(hash, *flSpotCheckFiles)
+	if !ok {
+		// E.g. after a restart, or a failure to record at publish time.
+		if err := s.record(ctx, gitRoot, hash); err != nil {
+			return nil, fmt.Errorf("can't record manifest: %w", err)
+		}
+		sample, _ = s.sample(hash, *flSpotCheckFiles)
+	}
+
+	damaged := []string{}
+	for _, e := range sample {
+		err := checkTreeEntry(worktree, e)
+		if os.IsNotExist(err) && usingSparseCheckout() {
+			// Left out by the sparse checkout.
+			continue
+		}
+		if err != nil {
+			log.Error(err, "published file is damaged", "hash", hash, "path", e.path)
+			damaged = append(damaged, e.path)
+		}
+	}
+	spotCheckCorruptFiles.Set(float64(len(damaged)))
+	if len(damaged) == 0 || !*flSpotCheckRepair {
+		return damaged, nil
+	}
+
+	args := append([]string{"checkout", "-f", hash, "--"}, damaged...)
+	if _, err := runCommand(ctx, worktree, *flGitCmd, args...); err != nil {
+		spotCheckRepairCount.WithLabelValues(metricKeyError).Add(float64(len(damaged)))
+		return damaged, fmt.Errorf("can't repair damaged files: %w", err)
+	}
+	spotCheckRepairCount.WithLabelValues(metricKeySuccess).Add(float64(len(damaged)))
+	log.V(0).Info("checked out damaged files again", "hash", hash, "files", len(damaged))
+	return damaged, nil
+}
+
+// runSpotChecks checks the published worktree every --spot-check-interval,
+// forever.  Damage is reported, but does not stop syncing.
+func runSpotChecks(gitRoot, dest string) {
+	for {
+		time.Sleep(*flSpotCheckInterval)
+
+		damaged, err := spotChecks.check(context.Background(), gitRoot, dest)
+		if err != nil {
+			spotCheckCount.WithLabelValues(metricKeyError).Inc()
+			log.Error(err, "spot check failed", "path", dest)
+		} else {
+			spotCheckCount.WithLabelValues(metricKeySuccess).Inc()
+		}
+		if len(damaged) > 0 {
+			recordKubeEvent(kubeEventWarning, kubeReasonContentCorrupted, "%d published files differ from the commit, including %s", len(damaged), damaged[0])
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseTreeEntries(t *testing.T) {
+	output := "100644 blob e69de29bb2d1d6434b8b29ae775ad8c2e48c5391\tdir/empty\x00" +
+		"100755 blob d95f3ad14dee633a758d2e331151e950dd13e4ed\trun.sh\x00" +
+		"120000 blob 1de565933b05f74c75ff9a6520af5f9f8a5a2f1d\tlink\x00" +
+		"160000 commit " + hash1 + "\tsubmodule\x00" +
+		"100644 blob e69de29bb2d1d6434b8b29ae775ad8c2e48c5391\twith\ttab\x00"
+	got, err := parseTreeEntries(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []treeEntry{
+		{path: "dir/empty", mode: treeModeFile, blob: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{path: "run.sh", mode: treeModeExecutable, blob: "d95f3ad14dee633a758d2e331151e950dd13e4ed"},
+		{path: "link", mode: treeModeSymlink, blob: "1de565933b05f74c75ff9a6520af5f9f8a5a2f1d"},
+		{path: "with\ttab", mode: treeModeFile, blob: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	if _, err := parseTreeEntries("garbage\x00"); err == nil {
+		t.Errorf("expected an error for malformed output")
+	}
+}
+
+func TestBlobHash(t *testing.T) {
+	testCases := []struct {
+		blob, data, want string
+	}{
+		{"e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", "", "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{"e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", "hello\n", "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{strings.Repeat("0", 64), "", "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813"},
+	}
+	for _, tc := range testCases {
+		got, err := blobHash(tc.blob, strings.NewReader(tc.data), int64(len(tc.data)))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.data, err)
+		} else if got != tc.want {
+			t.Errorf("%q: expected %s, got %s", tc.data, tc.want, got)
+		}
+	}
+	if _, err := blobHash(hash1, strings.NewReader("short"), 10); err == nil {
+		t.Errorf("expected an error when the size is wrong")
+	}
+}
+
+func TestSpotCheckerSample(t *testing.T) {
+	s := spotChecker{hash: hash1, entries: []treeEntry{{path: "a"}, {path: "b"}, {path: "c"}}}
+	paths := func(entries []treeEntry) []string {
+		out := []string{}
+		for _, e := range entries {
+			out = append(out, e.path)
+		}
+		return out
+	}
+
+	if _, ok := s.sample(hash2, 1); ok {
+		t.Errorf("expected no sample for another hash")
+	}
+	for i, want := range [][]string{{"a", "b"}, {"c", "a"}, {"b", "c"}} {
+		got, ok := s.sample(hash1, 2)
+		if !ok || !reflect.DeepEqual(paths(got), want) {
+			t.Errorf("sample %d: expected %v, got %v", i, want, paths(got))
+		}
+	}
+	if got, _ := s.sample(hash1, 0); len(got) != 3 {
+		t.Errorf("expected all entries, got %v", paths(got))
+	}
+}
+
+func TestSpotCheck(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(n int, repair bool, d time.Duration) {
+		*flSpotCheckFiles, *flSpotCheckRepair, *flLockTimeout = n, repair, d
+	}(*flSpotCheckFiles, *flSpotCheckRepair, *flLockTimeout)
+	*flSpotCheckFiles = 0
+	*flLockTimeout = time.Minute
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "file", "link")
+	git("commit", "-q", "-m", "test")
+	hash := git("rev-parse", "HEAD")
+	git("worktree", "add", "-q", "--detach", hash, hash)
+	if err := os.Symlink(hash, filepath.Join(dir, "published")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s := &spotChecker{}
+	damaged, err := s.check(ctx, dir, "published")
+	if err != nil || len(damaged) != 0 {
+		t.Fatalf("expected no damage, got %v, %v", damaged, err)
+	}
+
+	// Truncate the published file.
+	published := filepath.Join(dir, hash, "file")
+	if err := ioutil.WriteFile(published, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	damaged, err = s.check(ctx, dir, "published")
+	if err != nil || !reflect.DeepEqual(damaged, []string{"file"}) {
+		t.Fatalf("expected the file to be damaged, got %v, %v", damaged, err)
+	}
+
+	*flSpotCheckRepair = true
+	if _, err := s.check(ctx, dir, "published"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(published); string(data) != "content\n" {
+		t.Errorf("expected the file to be repaired, got %q", data)
+	}
+	damaged, err = s.check(ctx, dir, "published")
+	if err != nil || len(damaged) != 0 {
+		t.Errorf("expected no damage after repair, got %v, %v", damaged, err)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// isSSHRepo returns true if git reaches r over SSH.
+func isSSHRepo(r repoURL) bool {
+	switch r.kind {
+	case repoKindSCP:
+		return true
+	case repoKindURL:
+		return r.scheme == "ssh" || r.scheme == "git+ssh" || r.scheme == "ssh+git"
+	}
+	return false
+}
+
+// sshMultiplexOptions returns the ssh options which share one connection per
+// host, user, and port, through a socket in dir, which stays open for
+// persist after the last command which used it.
+func sshMultiplexOptions(dir string, persist time.Duration) string {
+	// %C is a hash of the connection, which keeps the path short enough
+	// for a unix socket.
+	return fmt.Sprintf("-o ControlMaster=auto -o ControlPath=%s -o ControlPersist=%d",
+		filepath.Join(dir, "%C"), int(persist/time.Second))
+}
+
+// sshControlDir is the directory which holds the SSH control sockets, or ""
+// if connections are not shared.
+var sshControlDir string
+
+// setupSSHMultiplexing makes the ssh commands git runs share a connection,
+// by adding to $GIT_SSH_COMMAND (which --ssh sets), or setting it.  The
+// sockets are in a new directory which only git-sync's user can use, so that
+// each git-sync process has its own connections.  It is called again when the
+// SSH key is reloaded, and then removes the old sockets, so that the next
+// command connects with the new key; the old connections close on their own
+// once persist passes.
+func setupSSHMultiplexing(persist time.Duration) error {
+	dir, err := ioutil.TempDir("", "git-sync-ssh-")
+	if err != nil {
+		return fmt.Errorf("can't make a directory for the SSH control socket: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	log.V(1).Info("sharing SSH connections", "socketDir", dir, "persist", persist.String())
+
+	cmd := os.Getenv("GIT_SSH_COMMAND")
+	if cmd == "" {
+		cmd = "ssh"
+	}
+	if err := os.Setenv("GIT_SSH_COMMAND", cmd+" "+sshMultiplexOptions(dir, persist)); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("can't set $GIT_SSH_COMMAND: %w", err)
+	}
+
+	if sshControlDir != "" {
+		if err := os.RemoveAll(sshControlDir); err != nil {
+			log.Error(err, "can't remove the old SSH control sockets", "socketDir", sshControlDir)
+		}
+	}
+	sshControlDir = dir
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestIsSSHRepo(t *testing.T) {
+	cases := map[string]bool{
+		"git@example.com:repo":       true,
+		"ssh://git@example.com/repo": true,
+		"git+ssh://example.com/repo": true,
+		"https://example.com/repo":   false,
+		"git://example.com/repo":     false,
+		"file:///srv/repo":           false,
+		"/srv/repo":                  false,
+	}
+	for repo, expect := range cases {
+		u, err := parseRepoURL(repo)
+		if err != nil {
+			t.Fatalf("%s: %v", repo, err)
+		}
+		if got := isSSHRepo(u); got != expect {
+			t.Errorf("%s: expected %v, got %v", repo, expect, got)
+		}
+	}
+}
+
+func TestSetupSSHMultiplexing(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(cmd string, dir string) {
+		os.Setenv("GIT_SSH_COMMAND", cmd)
+		if sshControlDir != "" {
+			os.RemoveAll(sshControlDir)
+		}
+		sshControlDir = dir
+	}(os.Getenv("GIT_SSH_COMMAND"), sshControlDir)
+	sshControlDir = ""
+
+	os.Setenv("GIT_SSH_COMMAND", "")
+	if err := setupSSHMultiplexing(90 * time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := sshControlDir
+	expect := "ssh -o ControlMaster=auto -o ControlPath=" + filepath.Join(first, "%C") + " -o ControlPersist=90"
+	if got := os.Getenv("GIT_SSH_COMMAND"); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+	if fi, err := os.Stat(first); err != nil {
+		t.Errorf("expected the socket dir to exist: %v", err)
+	} else if fi.Mode().Perm()&0077 != 0 {
+		t.Errorf("expected the socket dir to be private, got %v", fi.Mode())
+	}
+
+	// As when the SSH key is reloaded.
+	os.Setenv("GIT_SSH_COMMAND", "ssh -q -i /etc/git-secret/ssh")
+	if err := setupSSHMultiplexing(time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sshControlDir == first {
+		t.Errorf("expected a new socket dir")
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("expected the old socket dir to be removed, got %v", err)
+	}
+	if got := os.Getenv("GIT_SSH_COMMAND"); !strings.HasPrefix(got, "ssh -q -i /etc/git-secret/ssh -o ControlMaster=auto ") || !strings.HasSuffix(got, " -o ControlPersist=60") {
+		t.Errorf("expected the options to be added to the command, got %q", got)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sync stages with their own timeouts, for errors.
+const (
+	stageLsRemote  = "ls-remote"
+	stageFetch     = "fetch"
+	stageCheckout  = "checkout"
+	stageSubmodule = "submodule update"
+	stageSyncHook  = "sync hook"
+)
+
+// stageContext returns a context for one stage of a sync, which ends after
+// timeout, or with ctx.  A timeout of 0 means the stage is only bounded by
+// ctx.
+func stageContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stageError says which stage err came from, if it was a timeout: either the
+// stage's own, or the whole sync's --timeout.
+func stageError(ctx, stageCtx context.Context, stage string, timeout time.Duration, err error) error {
+	if err == nil || !errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("sync timed out during %s: %w", stage, err)
+	}
+	return fmt.Errorf("%s timed out after %v: %w", stage, timeout, err)
+}
+
+// runStage runs fn with a context from stageContext, and its error through
+// stageError.
+func runStage(ctx context.Context, stage string, timeout time.Duration, fn func(context.Context) error) error {
+	if state, found := stageStates[stage]; found {
+		stateFrom(ctx).enter(state)
+	}
+	stageCtx, cancel := stageContext(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err := fn(stageCtx)
+	summaryFrom(ctx).addStage(stage, time.Since(start))
+	return stageError(ctx, stageCtx, stage, timeout, err)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStage(t *testing.T) {
+	wait := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// The stage's own timeout.
+	err := runStage(context.Background(), stageFetch, 10*time.Millisecond, wait)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "fetch timed out after 10ms") {
+		t.Errorf("expected the stage in the error, got %q", err)
+	}
+
+	// The whole sync's timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = runStage(ctx, stageCheckout, 0, wait)
+	if !strings.HasPrefix(err.Error(), "sync timed out during checkout") {
+		t.Errorf("expected the stage in the error, got %q", err)
+	}
+
+	// Other errors pass through.
+	other := errors.New("other")
+	if err := runStage(context.Background(), stageFetch, time.Hour, func(context.Context) error { return other }); err != other {
+		t.Errorf("expected the error to pass through, got %v", err)
+	}
+	if err := runStage(context.Background(), stageFetch, time.Hour, func(context.Context) error { return nil }); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
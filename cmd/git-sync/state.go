@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The states of the sync loop.
+const (
+	// stateIdle is waiting for the next sync, or for the leader or a pause.
+	stateIdle = "idle"
+	// stateResolving is finding what the upstream ref points to.
+	stateResolving = "resolving"
+	// stateFetching is fetching or cloning from the upstream.
+	stateFetching = "fetching"
+	// stateCheckingOut is making the worktree for a new hash.
+	stateCheckingOut = "checking-out"
+	// statePublishing is flipping the link and running the sync hook.
+	statePublishing = "publishing"
+	// stateCleaning is removing old worktrees.
+	stateCleaning = "cleaning"
+	// stateBackoff is waiting to retry after a failed sync.
+	stateBackoff = "backoff"
+)
+
+// syncStates are all of the states, in the order a sync goes through them.
+var syncStates = []string{stateIdle, stateResolving, stateFetching, stateCheckingOut, statePublishing, stateCleaning, stateBackoff}
+
+// stageStates are the states which the stages run in.  The sync hook can run
+// after the sync is over, so it doesn't change the state.
+var stageStates = map[string]string{
+	stageLsRemote:  stateResolving,
+	stageFetch:     stateFetching,
+	stageCheckout:  stateCheckingOut,
+	stageSubmodule: stateCheckingOut,
+}
+
+var (
+	syncStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "git_sync_state",
+		Help: "The state of the sync loop: 1 for the current state, and 0 for the others",
+	}, []string{"state"})
+
+	syncStateSince = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "git_sync_state_entered_timestamp_seconds",
+		Help: "When the sync loop entered its current state",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(syncStateGauge)
+	prometheus.MustRegister(syncStateSince)
+}
+
+// stateTransitionHook is called when the sync loop goes from one state to
+// another, at the time it did.
+type stateTransitionHook func(from, to string, at time.Time)
+
+// syncStateMachine tracks the state of the sync loop.
+type syncStateMachine struct {
+	mutex sync.Mutex
+	state string
+	since time.Time
+	hooks []stateTransitionHook
+}
+
+// loopState is the state of the sync loop.  It starts idle, and its
+// transitions are logged and exported as metrics.
+var loopState = newSyncStateMachine(time.Now(), logStateTransition, exportStateTransition)
+
+func newSyncStateMachine(now time.Time, hooks ...stateTransitionHook) *syncStateMachine {
+	m := &syncStateMachine{state: stateIdle, since: now, hooks: hooks}
+	for _, hook := range hooks {
+		hook("", stateIdle, now)
+	}
+	return m
+}
+
+// onTransition adds a hook which is called, in order, after each transition.
+func (m *syncStateMachine) onTransition(hook stateTransitionHook) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// enter moves to state.  Entering the current state does nothing.  It is
+// safe to call on nil, e.g. outside of the sync loop.
+func (m *syncStateMachine) enter(state string) {
+	m.enterAt(state, time.Now())
+}
+
+func (m *syncStateMachine) enterAt(state string, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	from := m.state
+	if from == state {
+		m.mutex.Unlock()
+		return
+	}
+	m.state, m.since = state, now
+	hooks := m.hooks
+	m.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(from, state, now)
+	}
+}
+
+// current returns the state, and when it was entered.
+func (m *syncStateMachine) current() (string, time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.state, m.since
+}
+
+func logStateTransition(from, to string, at time.Time) {
+	if from != "" {
+		log.V(2).Info("sync state", "from", from, "to", to)
+	}
+}
+
+func exportStateTransition(from, to string, at time.Time) {
+	for _, s := range syncStates {
+		v := 0.0
+		if s == to {
+			v = 1
+		}
+		syncStateGauge.WithLabelValues(s).Set(v)
+	}
+	syncStateSince.Set(float64(at.UnixNano()) / 1e9)
+}
+
+type syncStateKey struct{}
+
+// withSyncState returns a context which carries m, so that the stages of a
+// sync which is run with it move m through the states.
+func withSyncState(ctx context.Context, m *syncStateMachine) context.Context {
+	return context.WithValue(ctx, syncStateKey{}, m)
+}
+
+// stateFrom returns the syncStateMachine in ctx, or nil if there is none,
+// e.g. for a sync hook which runs after the sync is over.
+func stateFrom(ctx context.Context) *syncStateMachine {
+	m, _ := ctx.Value(syncStateKey{}).(*syncStateMachine)
+	return m
+}
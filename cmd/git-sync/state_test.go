@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSyncStateMachine(t *testing.T) {
+	t0 := time.Now()
+	transitions := []string{}
+	m := newSyncStateMachine(t0, func(from, to string, at time.Time) {
+		transitions = append(transitions, from+">"+to)
+	})
+	if state, since := m.current(); state != stateIdle || !since.Equal(t0) {
+		t.Errorf("expected idle since %v, got %s since %v", t0, state, since)
+	}
+
+	t1 := t0.Add(time.Second)
+	m.enterAt(stateResolving, t1)
+	m.enterAt(stateResolving, t1.Add(time.Second))
+	m.enterAt(stateFetching, t1.Add(2*time.Second))
+	var hooked []string
+	m.onTransition(func(from, to string, at time.Time) {
+		hooked = append(hooked, to)
+	})
+	m.enterAt(stateBackoff, t1.Add(3*time.Second))
+
+	expect := []string{">idle", "idle>resolving", "resolving>fetching", "fetching>backoff"}
+	if !reflect.DeepEqual(transitions, expect) {
+		t.Errorf("expected %v, got %v", expect, transitions)
+	}
+	if !reflect.DeepEqual(hooked, []string{stateBackoff}) {
+		t.Errorf("expected the new hook to see only the last transition, got %v", hooked)
+	}
+	if state, since := m.current(); state != stateBackoff || !since.Equal(t1.Add(3*time.Second)) {
+		t.Errorf("unexpected state %s since %v", state, since)
+	}
+
+	// Outside of the sync loop, there is nothing to move.
+	var none *syncStateMachine
+	none.enter(stateFetching)
+	stateFrom(context.Background()).enter(stateFetching)
+}
+
+func TestStageStates(t *testing.T) {
+	m := newSyncStateMachine(time.Now())
+	ctx := withSyncState(context.Background(), m)
+	if stateFrom(ctx) != m {
+		t.Fatalf("expected the state machine from the context")
+	}
+	for stage, expect := range map[string]string{
+		stageLsRemote:  stateResolving,
+		stageFetch:     stateFetching,
+		stageSubmodule: stateCheckingOut,
+	} {
+		runStage(ctx, stage, 0, func(ctx context.Context) error {
+			if state, _ := stateFrom(ctx).current(); state != expect {
+				t.Errorf("%s: expected %s, got %s", stage, expect, state)
+			}
+			return nil
+		})
+	}
+	m.enter(statePublishing)
+	runStage(ctx, stageSyncHook, 0, func(ctx context.Context) error { return nil })
+	if state, _ := m.current(); state != statePublishing {
+		t.Errorf("expected the sync hook to leave the state alone, got %s", state)
+	}
+}
+
+// gaugeValue returns the value of g.
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestExportStateTransition(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	exportStateTransition(stateIdle, stateFetching, at)
+	for _, s := range syncStates {
+		expect := 0.0
+		if s == stateFetching {
+			expect = 1
+		}
+		if got := gaugeValue(t, syncStateGauge.WithLabelValues(s)); got != expect {
+			t.Errorf("%s: expected %v, got %v", s, expect, got)
+		}
+	}
+	if got := gaugeValue(t, syncStateSince); got != 1600000000 {
+		t.Errorf("expected the entry time, got %v", got)
+	}
+}
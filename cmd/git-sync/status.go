@@ -0,0 +1,263 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statusTracker records the outcome of recent syncs, for /api/v1/status.
+type statusTracker struct {
+	mutex      sync.Mutex
+	lastSync   time.Time
+	lastChange time.Time
+	lastError  string
+	// fetched is the most recent upstream hash which has been fetched,
+	// whether or not it has been published.
+	fetched string
+}
+
+var syncStatus statusTracker
+
+// record notes the result of a sync attempt.
+func (s *statusTracker) record(changed bool, err error, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil {
+		s.lastError = err.Error()
+		return
+	}
+	s.lastError = ""
+	s.lastSync = now
+	if changed {
+		s.lastChange = now
+	}
+}
+
+// setFetched notes that hash has been fetched and is ready to publish.
+func (s *statusTracker) setFetched(hash string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fetched = hash
+}
+
+// statusReport is the body of a /api/v1/status response.
+type statusReport struct {
+	Repo       string          `json:"repo"`
+	Branch     string          `json:"branch,omitempty"`
+	Rev        string          `json:"rev"`
+	Hash       string          `json:"hash,omitempty"`
+	Fetched    string          `json:"fetched,omitempty"`
+	Ready      bool            `json:"ready"`
+	Paused     pauseMode       `json:"paused,omitempty"`
+	Role       string          `json:"role,omitempty"`
+	Tag        *tagInfo        `json:"tag,omitempty"`
+	LastSync   *time.Time      `json:"lastSync,omitempty"`
+	LastChange *time.Time      `json:"lastChange,omitempty"`
+	LastError  string          `json:"lastError,omitempty"`
+	Worktree   string          `json:"worktree,omitempty"`
+	State      string          `json:"state"`
+	StateSince time.Time       `json:"stateSince"`
+	Files      []manifestEntry `json:"files,omitempty"`
+}
+
+// manifestEntry describes one file in the published tree.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Link is the target of a symlink.
+	Link string `json:"link,omitempty"`
+}
+
+// manifestCache holds the manifest of the most recently requested hash.
+// Worktrees never change once published, so it is valid until the hash
+// changes.
+var manifestCache struct {
+	mutex sync.Mutex
+	hash  string
+	files []manifestEntry
+}
+
+// buildManifest lists the files under dir, with their checksums.  VCS
+// metadata at the top of the tree is skipped.
+func buildManifest(dir, metaDir string) ([]manifestEntry, error) {
+	files := []manifestEntry{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if metaDir != "" && rel == metaDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entry := manifestEntry{Path: filepath.ToSlash(rel)}
+		switch {
+		case info.IsDir():
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			if entry.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			entry.Size = info.Size()
+			if entry.SHA256, err = fileSHA256(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		files = append(files, entry)
+		return nil
+	})
+	return files, err
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// publishedManifest returns the manifest for the worktree of hash, using the
+// cache if possible.
+func publishedManifest(worktree, hash string) ([]manifestEntry, error) {
+	manifestCache.mutex.Lock()
+	defer manifestCache.mutex.Unlock()
+	if manifestCache.hash == hash {
+		return manifestCache.files, nil
+	}
+	files, err := buildManifest(worktree, backend.metaDir())
+	if err != nil {
+		return nil, err
+	}
+	manifestCache.hash = hash
+	manifestCache.files = files
+	return files, nil
+}
+
+// currentStatus returns git-sync's status, without the manifest, and the
+// path of the published worktree, or "" if nothing is published.
+func currentStatus() (statusReport, string) {
+	report := statusReport{
+		Repo:   *flRepo,
+		Branch: *flBranch,
+		Rev:    *flRev,
+		Ready:  getRepoReady(),
+		Paused: pauses.mode(*flRoot),
+		Role:   leader.role(),
+		Tag:    currentTag(),
+	}
+	if *flSource == sourceOCI {
+		report.Repo = *flOCIRef
+		report.Branch = ""
+	}
+	syncStatus.mutex.Lock()
+	if !syncStatus.lastSync.IsZero() {
+		t := syncStatus.lastSync
+		report.LastSync = &t
+	}
+	if !syncStatus.lastChange.IsZero() {
+		t := syncStatus.lastChange
+		report.LastChange = &t
+	}
+	report.LastError = syncStatus.lastError
+	report.Fetched = syncStatus.fetched
+	syncStatus.mutex.Unlock()
+	report.State, report.StateSince = loopState.current()
+
+	// Worktrees are named for their hashes unless --worktree-names says
+	// otherwise, which worktreeHash accounts for.
+	worktree, err := filepath.EvalSymlinks(filepath.Join(*flRoot, *flDest))
+	if err == nil {
+		report.Hash = worktreeHash(worktree)
+		if name := filepath.Base(worktree); name != report.Hash {
+			report.Worktree = name
+		}
+		return report, worktree
+	}
+	if !os.IsNotExist(err) {
+		log.Error(err, "can't resolve the published worktree")
+	}
+	return report, ""
+}
+
+// serveStatus handles requests to the /api/v1/status endpoint.  If the
+// "manifest" query parameter is true, the response includes every file in
+// the published tree, with its checksum.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	withManifest := false
+	if v := r.URL.Query().Get("manifest"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid manifest parameter %q", v), http.StatusBadRequest)
+			return
+		}
+		withManifest = b
+	}
+
+	report, worktree := currentStatus()
+	if withManifest && worktree != "" {
+		files, err := publishedManifest(worktree, report.Hash)
+		if err != nil {
+			log.Error(err, "can't build manifest", "path", worktree)
+			http.Error(w, "can't build manifest", http.StatusInternalServerError)
+			return
+		}
+		report.Files = files
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Error(err, "can't encode status")
+		http.Error(w, "can't encode status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusTracker(t *testing.T) {
+	s := statusTracker{}
+	t1 := time.Unix(1600000000, 0)
+	t2 := t1.Add(time.Minute)
+
+	s.record(true, nil, t1)
+	s.record(false, nil, t2)
+	if !s.lastSync.Equal(t2) || !s.lastChange.Equal(t1) || s.lastError != "" {
+		t.Errorf("unexpected state: %v %v %q", s.lastSync, s.lastChange, s.lastError)
+	}
+	s.record(false, errors.New("boom"), t2.Add(time.Minute))
+	if !s.lastSync.Equal(t2) || s.lastError != "boom" {
+		t.Errorf("unexpected state after error: %v %q", s.lastSync, s.lastError)
+	}
+	s.record(false, nil, t2.Add(2*time.Minute))
+	if s.lastError != "" {
+		t.Errorf("expected error to be cleared: %q", s.lastError)
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":      "hello\n",
+		"sub/b.txt":  "",
+		".git":       "gitdir: ../.git/worktrees/x\n",
+		"sub/.git/x": "x",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := buildManifest(dir, ".git")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []manifestEntry{
+		{Path: "a.txt", Size: 6, SHA256: "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"},
+		{Path: "link", Link: "a.txt"},
+		{Path: "sub/.git/x", Size: 1, SHA256: "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881"},
+		{Path: "sub/b.txt", Size: 0, SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", want, got)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncSummary collects what happened during one sync, for the one log line
+// which sums it up.
+type syncSummary struct {
+	mutex   sync.Mutex
+	stages  map[string]time.Duration
+	objects int64
+	bytes   int64
+	// from and to are the hashes published before and by this sync.
+	from, to string
+}
+
+type syncSummaryKey struct{}
+
+// withSyncSummary returns a context which carries a new syncSummary.
+func withSyncSummary(ctx context.Context) (context.Context, *syncSummary) {
+	s := &syncSummary{stages: map[string]time.Duration{}}
+	return context.WithValue(ctx, syncSummaryKey{}, s), s
+}
+
+// summaryFrom returns the syncSummary in ctx, or nil if there is none, e.g.
+// outside of the sync loop.  All of its methods are safe to call on nil.
+func summaryFrom(ctx context.Context) *syncSummary {
+	s, _ := ctx.Value(syncSummaryKey{}).(*syncSummary)
+	return s
+}
+
+// addStage adds d to the time spent in stage.
+func (s *syncSummary) addStage(stage string, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stages[stage] += d
+}
+
+// addFetch adds to the objects and bytes fetched.
+func (s *syncSummary) addFetch(objects, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects += objects
+	s.bytes += bytes
+}
+
+// setPublished records that the sync replaced the worktree for from (which
+// is "" for the first sync) with the one for to.
+func (s *syncSummary) setPublished(from, to string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.from, s.to = from, to
+}
+
+// stageTimes formats the time spent in each stage, e.g.
+// "fetch=1.2s,checkout=300ms", in the order the stages run.
+func (s *syncSummary) stageTimes() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	order := map[string]int{stageLsRemote: 0, stageFetch: 1, stageCheckout: 2, stageSubmodule: 3, stageSyncHook: 4}
+	names := make([]string, 0, len(s.stages))
+	for name := range s.stages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if order[names[i]] != order[names[j]] {
+			return order[names[i]] < order[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, s.stages[name].Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// countChangedFiles returns how many files differ between the from and to
+// hashes, or -1 if that is unknown, e.g. because a shallow clone doesn't have
+// from.
+func (s *syncSummary) countChangedFiles(ctx context.Context, gitRoot string) int {
+	s.mutex.Lock()
+	from, to := s.from, s.to
+	s.mutex.Unlock()
+	if from == "" || to == "" {
+		return -1
+	}
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "diff", "--no-renames", "--name-only", "-z", from, to)
+	if err != nil {
+		log.V(1).Info("can't count changed files", "from", from, "to", to, "error", err.Error())
+		return -1
+	}
+	return strings.Count(output, "\x00")
+}
+
+// log writes the summary of a sync which ended with result (one of the
+// metricKey values) after duration.
+func (s *syncSummary) log(ctx context.Context, gitRoot, result, hash string, duration time.Duration, err error) {
+	s.mutex.Lock()
+	objects, bytes := s.objects, s.bytes
+	s.mutex.Unlock()
+	kv := []interface{}{
+		"result", result,
+		"duration", duration.Round(time.Millisecond).String(),
+		"stages", s.stageTimes(),
+		"fetchedObjects", objects,
+		"fetchedBytes", bytes,
+		"hash", hash,
+	}
+	if result == metricKeySuccess {
+		if n := s.countChangedFiles(ctx, gitRoot); n >= 0 {
+			kv = append(kv, "changedFiles", n)
+		}
+	}
+	if err != nil {
+		kv = append(kv, "error", err.Error())
+	}
+	log.V(0).Info("sync summary", kv...)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestSyncSummary(t *testing.T) {
+	// Outside of a sync, there is nothing to record into.
+	summaryFrom(context.Background()).addStage(stageFetch, time.Second)
+	summaryFrom(context.Background()).addFetch(1, 2)
+
+	ctx, s := withSyncSummary(context.Background())
+	if summaryFrom(ctx) != s {
+		t.Fatalf("expected the summary from the context")
+	}
+	if got := s.stageTimes(); got != "" {
+		t.Errorf("expected no stages, got %q", got)
+	}
+	s.addStage(stageSyncHook, 2*time.Second)
+	s.addStage(stageFetch, time.Second)
+	s.addStage(stageLsRemote, 100*time.Millisecond)
+	s.addStage(stageFetch, 500*time.Millisecond)
+	if got, expect := s.stageTimes(), "ls-remote=100ms,fetch=1.5s,sync hook=2s"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	err := runStage(ctx, stageCheckout, 0, func(ctx context.Context) error { return nil })
+	if err != nil || !strings.Contains(s.stageTimes(), "checkout=") {
+		t.Errorf("expected runStage to record the stage, got %q, %v", s.stageTimes(), err)
+	}
+
+	s.addFetch(10, 1000)
+	s.addFetch(5, 500)
+	if s.objects != 15 || s.bytes != 1500 {
+		t.Errorf("unexpected fetch totals: %d objects, %d bytes", s.objects, s.bytes)
+	}
+}
+
+func TestCountChangedFiles(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = os.Environ()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("a", "1")
+	write("b", "1")
+	git("add", ".")
+	git("commit", "-q", "-m", "one")
+	one := git("rev-parse", "HEAD")
+	write("a", "2")
+	write("c", "1")
+	git("add", ".")
+	git("commit", "-q", "-m", "two")
+	two := git("rev-parse", "HEAD")
+
+	ctx, s := withSyncSummary(context.Background())
+	if n := s.countChangedFiles(ctx, repo); n != -1 {
+		t.Errorf("expected -1 before anything is published, got %d", n)
+	}
+	summaryFrom(ctx).setPublished(one, two)
+	if n := s.countChangedFiles(ctx, repo); n != 2 {
+		t.Errorf("expected 2 changed files, got %d", n)
+	}
+	s.setPublished(strings.Repeat("0", 40), two)
+	if n := s.countChangedFiles(ctx, repo); n != -1 {
+		t.Errorf("expected -1 for a missing hash, got %d", n)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var tagInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "git_sync_tag_info",
+	Help: "The tag given by --rev which was last synced, and the commit it points at (always 1)",
+}, []string{"tag", "commit", "annotated"})
+
+var tagMoveCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "git_sync_tag_moves_total",
+	Help: "How many times the tag given by --rev was seen to point at a different commit than before",
+})
+
+func init() {
+	prometheus.MustRegister(tagInfoGauge)
+	prometheus.MustRegister(tagMoveCount)
+}
+
+// errTagVerification is returned when the tag to be published fails one of
+// the --tag-require-* checks.
+var errTagVerification = errors.New("tag verification failed")
+
+// tagSignatureMarkers start the signatures which git appends to tag messages.
+var tagSignatureMarkers = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+}
+
+// tagInfo describes the tag given by --rev, for /api/v1/status.
+type tagInfo struct {
+	Name string `json:"name"`
+	// Object is the hash of the tag object, for an annotated tag.
+	Object    string `json:"object,omitempty"`
+	Commit    string `json:"commit"`
+	Annotated bool   `json:"annotated"`
+	Tagger    string `json:"tagger,omitempty"`
+	Signed    bool   `json:"signed,omitempty"`
+	// Verified is true if the signature was checked with --tag-require-signature.
+	Verified bool `json:"verified,omitempty"`
+}
+
+// tagState is the most recently checked tag.
+var tagState struct {
+	mutex   sync.Mutex
+	current *tagInfo
+}
+
+// currentTag returns the most recently checked tag, or nil.
+func currentTag() *tagInfo {
+	tagState.mutex.Lock()
+	defer tagState.mutex.Unlock()
+	if tagState.current == nil {
+		return nil
+	}
+	t := *tagState.current
+	return &t
+}
+
+// parseTagObject parses the output of `git cat-file tag`.
+func parseTagObject(output string) (tagInfo, error) {
+	t := tagInfo{Annotated: true}
+	headers, message := output, ""
+	if i := strings.Index(output, "\n\n"); i >= 0 {
+		headers, message = output[:i], output[i+2:]
+	}
+	for _, line := range strings.Split(headers, "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "object":
+			t.Commit = parts[1]
+		case "tag":
+			t.Name = parts[1]
+		case "tagger":
+			t.Tagger = taggerIdentity(parts[1])
+		}
+	}
+	if t.Commit == "" || t.Name == "" {
+		return t, fmt.Errorf("malformed tag object")
+	}
+	for _, m := range tagSignatureMarkers {
+		if strings.Contains(message, m) {
+			t.Signed = true
+		}
+	}
+	return t, nil
+}
+
+// taggerIdentity drops the timestamp from a tagger line, leaving
+// "Name <email>".
+func taggerIdentity(tagger string) string {
+	if i := strings.LastIndex(tagger, ">"); i >= 0 {
+		return tagger[:i+1]
+	}
+	return tagger
+}
+
+// taggerEmail returns the email address in "Name <email>".
+func taggerEmail(tagger string) string {
+	i, j := strings.LastIndex(tagger, "<"), strings.LastIndex(tagger, ">")
+	if i < 0 || j < i {
+		return ""
+	}
+	return tagger[i+1 : j]
+}
+
+// verifyTag checks t against the --tag-require-* flags.  verifySig is
+// called to check the signature, if one is required.
+func verifyTag(t *tagInfo, rev string, verifySig func() error) error {
+	if *flTagRequireAnnotated && !t.Annotated {
+		return fmt.Errorf("%w: %s is a lightweight tag, not an annotated one", errTagVerification, rev)
+	}
+	if !t.Annotated {
+		return nil
+	}
+	// A tag object which names another tag has been copied, not tagged.
+	if t.Name != rev {
+		return fmt.Errorf("%w: refs/tags/%s points at a tag object for %q", errTagVerification, rev, t.Name)
+	}
+	if len(flTagTaggers.items) != 0 {
+		email := taggerEmail(t.Tagger)
+		ok := false
+		for _, p := range flTagTaggers.items {
+			if match, _ := filepath.Match(p, email); match {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s was tagged by %q, which is not in --tag-taggers", errTagVerification, rev, t.Tagger)
+		}
+	}
+	if *flTagRequireSignature {
+		if !t.Signed {
+			return fmt.Errorf("%w: %s is not signed", errTagVerification, rev)
+		}
+		if err := verifySig(); err != nil {
+			return fmt.Errorf("%w: %s has a bad signature: %v", errTagVerification, rev, err)
+		}
+		t.Verified = true
+	}
+	return nil
+}
+
+// checkTag describes and verifies hash, if --rev is a tag, and records it for
+// status and metrics.  It returns an error wrapping errTagVerification if the
+// tag fails verification, in which case it is not recorded.
+func checkTag(ctx context.Context, gitRoot, rev, hash string) error {
+	if rev == "HEAD" || strings.HasPrefix(hash, rev) {
+		// Not a tag, but a branch or a hash.
+		return nil
+	}
+	kind, err := runCommand(ctx, gitRoot, *flGitCmd, "cat-file", "-t", hash)
+	if err != nil {
+		return fmt.Errorf("can't inspect tag %s: %w", rev, err)
+	}
+
+	t := tagInfo{Name: rev, Commit: hash}
+	if strings.TrimSpace(kind) == "tag" {
+		output, err := runCommand(ctx, gitRoot, *flGitCmd, "cat-file", "tag", hash)
+		if err != nil {
+			return fmt.Errorf("can't read tag %s: %w", rev, err)
+		}
+		if t, err = parseTagObject(output); err != nil {
+			return fmt.Errorf("can't parse tag %s: %w", rev, err)
+		}
+		t.Object = hash
+		// Tags can point at tags; the commit is what is checked out.
+		commit, err := runCommand(ctx, gitRoot, *flGitCmd, "rev-parse", hash+"^{commit}")
+		if err != nil {
+			return fmt.Errorf("can't peel tag %s: %w", rev, err)
+		}
+		t.Commit = strings.TrimSpace(commit)
+	}
+
+	verifySig := func() error {
+		_, err := runCommand(ctx, gitRoot, *flGitCmd, "verify-tag", hash)
+		return err
+	}
+	if err := verifyTag(&t, rev, verifySig); err != nil {
+		return err
+	}
+
+	tagState.mutex.Lock()
+	previous := tagState.current
+	tagState.current = &t
+	tagState.mutex.Unlock()
+	if previous != nil && previous.Name == t.Name && previous.Commit != t.Commit {
+		log.V(0).Info("tag moved", "tag", t.Name, "from", previous.Commit, "to", t.Commit)
+		tagMoveCount.Inc()
+	}
+	tagInfoGauge.Reset()
+	tagInfoGauge.WithLabelValues(t.Name, t.Commit, fmt.Sprint(t.Annotated)).Set(1)
+	return nil
+}
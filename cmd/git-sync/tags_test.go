@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseTagObject(t *testing.T) {
+	output := "object " + hash1 + "\n" +
+		"type commit\n" +
+		"tag v1.0\n" +
+		"tagger Jane Doe <jane@example.com> 1600000000 +0000\n" +
+		"\n" +
+		"Release 1.0\n" +
+		"-----BEGIN PGP SIGNATURE-----\n" +
+		"xyz\n" +
+		"-----END PGP SIGNATURE-----\n"
+	tag, err := parseTagObject(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := tagInfo{Name: "v1.0", Commit: hash1, Annotated: true, Tagger: "Jane Doe <jane@example.com>", Signed: true}
+	if tag != expect {
+		t.Errorf("expected %+v, got %+v", expect, tag)
+	}
+	if got := taggerEmail(tag.Tagger); got != "jane@example.com" {
+		t.Errorf("unexpected email: %q", got)
+	}
+
+	if _, err := parseTagObject("type commit\n\nno object\n"); err == nil {
+		t.Errorf("expected an error for a malformed tag")
+	}
+}
+
+func TestVerifyTag(t *testing.T) {
+	defer func(annotated, signature bool, taggers []string) {
+		*flTagRequireAnnotated, *flTagRequireSignature, flTagTaggers.items = annotated, signature, taggers
+	}(*flTagRequireAnnotated, *flTagRequireSignature, flTagTaggers.items)
+
+	good := func() error { return nil }
+	bad := func() error { return errors.New("no public key") }
+	annotated := tagInfo{Name: "v1", Annotated: true, Tagger: "Jane <jane@example.com>", Signed: true}
+	cases := []struct {
+		name      string
+		tag       tagInfo
+		annotated bool
+		signature bool
+		taggers   []string
+		verify    func() error
+		ok        bool
+	}{
+		{name: "lightweight", tag: tagInfo{Name: "v1"}, ok: true},
+		{name: "lightweight required annotated", tag: tagInfo{Name: "v1"}, annotated: true},
+		{name: "annotated", tag: annotated, annotated: true, ok: true},
+		{name: "renamed", tag: tagInfo{Name: "v0", Annotated: true}},
+		{name: "allowed tagger", tag: annotated, taggers: []string{"*@example.com"}, ok: true},
+		{name: "other tagger", tag: annotated, taggers: []string{"*@example.org"}},
+		{name: "signed", tag: annotated, signature: true, verify: good, ok: true},
+		{name: "bad signature", tag: annotated, signature: true, verify: bad},
+		{name: "unsigned", tag: tagInfo{Name: "v1", Annotated: true}, signature: true, verify: good},
+	}
+	for _, tc := range cases {
+		*flTagRequireAnnotated, *flTagRequireSignature, flTagTaggers.items = tc.annotated, tc.signature, tc.taggers
+		tag := tc.tag
+		err := verifyTag(&tag, "v1", tc.verify)
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, errTagVerification) {
+			t.Errorf("%s: expected errTagVerification, got %v", tc.name, err)
+		}
+		if tc.ok && tag.Verified != tc.signature {
+			t.Errorf("%s: expected verified=%v", tc.name, tc.signature)
+		}
+	}
+}
+
+func TestCheckTag(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(annotated bool) { *flTagRequireAnnotated = annotated }(*flTagRequireAnnotated)
+	defer func() { tagState.current = nil }()
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = os.Environ()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := exec.Command(*flGitCmd, "init", repo).Run(); err != nil {
+		t.Fatalf("git init failed: %v", err)
+	}
+	git("commit", "--allow-empty", "-m", "one")
+	one := git("rev-parse", "HEAD")
+	git("tag", "light")
+	git("tag", "-a", "-m", "release", "v1")
+	object := git("rev-parse", "v1")
+
+	ctx := context.Background()
+	*flTagRequireAnnotated = false
+	if err := checkTag(ctx, repo, "v1", object); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tag := currentTag()
+	if tag == nil || tag.Name != "v1" || tag.Object != object || tag.Commit != one || !tag.Annotated || tag.Tagger != "test <test@example.com>" {
+		t.Errorf("unexpected tag: %+v", tag)
+	}
+
+	*flTagRequireAnnotated = true
+	if err := checkTag(ctx, repo, "light", one); !errors.Is(err, errTagVerification) {
+		t.Errorf("expected errTagVerification for a lightweight tag, got %v", err)
+	}
+	if tag := currentTag(); tag.Name != "v1" {
+		t.Errorf("expected a failed tag not to be recorded, got %+v", tag)
+	}
+
+	// Hashes are not tags.
+	if err := checkTag(ctx, repo, one[:8], one); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
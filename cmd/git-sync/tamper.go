@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var tamperGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_tamper_suspected",
+	Help: "Whether the published worktree failed the last --tamper-check (1) or not (0)",
+})
+
+func init() {
+	prometheus.MustRegister(tamperGauge)
+}
+
+// tamperLock protects tamperReason.
+var tamperLock sync.Mutex
+
+// tamperReason says why the published worktree is suspect, or is "" if it
+// is not.
+var tamperReason string
+
+func getTamperSuspected() bool {
+	tamperLock.Lock()
+	defer tamperLock.Unlock()
+	return tamperReason != ""
+}
+
+// setTamperReason records why the published worktree is suspect ("" if it
+// isn't), and returns the previous reason.
+func setTamperReason(reason string) string {
+	tamperLock.Lock()
+	defer tamperLock.Unlock()
+	was := tamperReason
+	tamperReason = reason
+	return was
+}
+
+// tamperCheck finds why the worktree published at dest can't be trusted to
+// be what the upstream has, or returns "" if it can.  It returns an error if
+// it can't tell.
+func tamperCheck(ctx context.Context, gitRoot, dest, upstream string) (string, error) {
+	current, err := filepath.EvalSymlinks(filepath.Join(gitRoot, dest))
+	if err != nil {
+		return "", err
+	}
+	published := worktreeHash(current)
+	if published == "" {
+		return fmt.Sprintf("the link points at %s, which is not a worktree made by git-sync", filepath.Base(current)), nil
+	}
+
+	// The worktree must be checked out at the hash its name says it holds.
+	if _, err := os.Stat(filepath.Join(current, backend.metaDir())); err == nil {
+		head, err := runCommand(ctx, current, *flGitCmd, "rev-parse", "HEAD")
+		if err != nil {
+			return "", err
+		}
+		commit, err := runCommand(ctx, gitRoot, *flGitCmd, "rev-parse", published+"^{commit}")
+		if err != nil {
+			return fmt.Sprintf("the published hash %s is not in the clone", published), nil
+		}
+		if strings.TrimSpace(head) != strings.TrimSpace(commit) {
+			return fmt.Sprintf("the worktree for %s is checked out at %s", published, strings.TrimSpace(head)), nil
+		}
+	}
+
+	// The upstream must have had the published hash.
+	if upstream == "" || published == upstream {
+		return "", nil
+	}
+	ok, err := isAncestor(ctx, gitRoot, published, upstream)
+	if err != nil {
+		if len(shallowArgs(*flDepth)) != 0 {
+			// A shallow clone may not have enough history to tell.
+			return "", err
+		}
+		return fmt.Sprintf("the published hash %s can't be compared with the upstream hash %s: %v", published, upstream, err), nil
+	}
+	if !ok {
+		return fmt.Sprintf("the published hash %s is not the upstream hash %s or one of its ancestors", published, upstream), nil
+	}
+	return "", nil
+}
+
+// checkTamper runs tamperCheck for --tamper-check, and updates the metric and
+// the readiness state.  Failures to check are logged, and leave the previous
+// state in place.
+func checkTamper(ctx context.Context, gitRoot, dest, upstream string) {
+	reason, err := tamperCheck(ctx, gitRoot, dest, upstream)
+	if os.IsNotExist(err) {
+		// Nothing is published.
+		return
+	}
+	if err != nil {
+		log.V(0).Info("can't check the published worktree for tampering", "error", err.Error())
+		return
+	}
+	if reason != "" {
+		tamperGauge.Set(1)
+	} else {
+		tamperGauge.Set(0)
+	}
+	switch was := setTamperReason(reason); {
+	case reason != "" && reason != was:
+		log.Error(fmt.Errorf("%s", reason), "published worktree may have been tampered with", "upstream", upstream)
+		recordKubeEvent(kubeEventWarning, kubeReasonTamperSuspected, "published worktree may have been tampered with: %s", reason)
+	case reason == "" && was != "":
+		log.V(0).Info("published worktree matches the upstream again", "upstream", upstream)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestTamperCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer setTamperReason("")
+
+	root := t.TempDir()
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = os.Environ()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(root, "init", "-q", "-b", "main")
+	git(root, "commit", "--allow-empty", "-m", "one")
+	one := git(root, "rev-parse", "HEAD")
+	git(root, "commit", "--allow-empty", "-m", "two")
+	two := git(root, "rev-parse", "HEAD")
+	git(root, "checkout", "-q", "-b", "other", one)
+	git(root, "commit", "--allow-empty", "-m", "elsewhere")
+	elsewhere := git(root, "rev-parse", "HEAD")
+	git(root, "checkout", "-q", "main")
+
+	publish := func(name, hash string) {
+		t.Helper()
+		git(root, "worktree", "add", "-q", "--detach", name, hash)
+		os.Remove(filepath.Join(root, "repo"))
+		if err := os.Symlink(name, filepath.Join(root, "repo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	publish(one, one)
+	for _, upstream := range []string{one, two} {
+		if reason, err := tamperCheck(ctx, root, "repo", upstream); err != nil || reason != "" {
+			t.Errorf("expected %s to be trusted with upstream %s, got %q, %v", one, upstream, reason, err)
+		}
+	}
+
+	publish(elsewhere, elsewhere)
+	if reason, err := tamperCheck(ctx, root, "repo", two); err != nil || !strings.Contains(reason, "ancestors") {
+		t.Errorf("expected a hash the upstream never had to be suspect, got %q, %v", reason, err)
+	}
+	checkTamper(ctx, root, "repo", two)
+	if !getTamperSuspected() {
+		t.Errorf("expected tampering to be suspected")
+	}
+
+	// A worktree named for one hash but checked out at another.
+	publish(two, one)
+	if reason, err := tamperCheck(ctx, root, "repo", two); err != nil || !strings.Contains(reason, "checked out at") {
+		t.Errorf("expected a mislabeled worktree to be suspect, got %q, %v", reason, err)
+	}
+
+	publish("not-a-hash", two)
+	if reason, err := tamperCheck(ctx, root, "repo", two); err != nil || !strings.Contains(reason, "not a worktree made by git-sync") {
+		t.Errorf("expected an unnamed worktree to be suspect, got %q, %v", reason, err)
+	}
+
+	// Once the worktree is put right, it is trusted again.
+	git(filepath.Join(root, two), "checkout", "-q", "--detach", two)
+	os.Remove(filepath.Join(root, "repo"))
+	if err := os.Symlink(two, filepath.Join(root, "repo")); err != nil {
+		t.Fatal(err)
+	}
+	checkTamper(ctx, root, "repo", two)
+	if getTamperSuspected() {
+		t.Errorf("expected tampering not to be suspected any more")
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/git-sync/pkg/version"
+)
+
+// tracer records spans for the sync pipeline and exports them to an
+// OpenTelemetry collector, using the OTLP/HTTP JSON encoding.  This is not a
+// general purpose tracing library - it supports just enough to show where
+// time goes in a sync.
+type tracer struct {
+	endpoint string
+	client   *http.Client
+
+	mutex sync.Mutex
+	// Finished spans, keyed by trace ID, waiting for their root span to end.
+	finished map[string][]*span
+}
+
+// tracing is the process-wide tracer, or nil if tracing is disabled.
+var tracing *tracer
+
+// newTracer returns a tracer which exports to the OTLP/HTTP endpoint.  If the
+// endpoint has no path, the standard "/v1/traces" path is used.
+func newTracer(endpoint string) (*tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return &tracer{
+		endpoint: u.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		finished: map[string][]*span{},
+	}, nil
+}
+
+type span struct {
+	tracer   *tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+type spanKey struct{}
+
+func randomID(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		// This should never happen, and a bad ID only hurts the trace.
+		return fmt.Sprintf("%0*x", n*2, time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// startSpan starts a new span, as a child of the span in ctx if there is one.
+// The kvList is recorded as span attributes.  If tracing is disabled, this
+// returns ctx and a nil span, which is safe to use.
+func startSpan(ctx context.Context, name string, kvList ...interface{}) (context.Context, *span) {
+	if tracing == nil {
+		return ctx, nil
+	}
+	s := &span{
+		tracer: tracing,
+		spanID: randomID(8),
+		name:   name,
+		start:  time.Now(),
+		attrs:  map[string]string{},
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	for i := 0; i+1 < len(kvList); i += 2 {
+		s.attrs[fmt.Sprint(kvList[i])] = fmt.Sprint(kvList[i+1])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startChildSpan is like startSpan, but only starts a span if ctx already
+// has one.  This is useful for low-level operations which are only interesting
+// as part of a larger operation.
+func startChildSpan(ctx context.Context, name string, kvList ...interface{}) (context.Context, *span) {
+	if parent, ok := ctx.Value(spanKey{}).(*span); !ok || parent == nil {
+		return ctx, nil
+	}
+	return startSpan(ctx, name, kvList...)
+}
+
+// setAttr records an attribute on the span.
+func (s *span) setAttr(key string, val interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = fmt.Sprint(val)
+}
+
+// finish ends the span, recording err (which may be nil).  When the root span
+// of a trace finishes, the whole trace is exported in the background.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	t := s.tracer
+	t.mutex.Lock()
+	spans := append(t.finished[s.traceID], s)
+	if s.parentID != "" {
+		t.finished[s.traceID] = spans
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.finished, s.traceID)
+	t.mutex.Unlock()
+
+	go func() {
+		if err := t.export(spans); err != nil {
+			log.Error(err, "can't export trace", "endpoint", t.endpoint)
+		}
+	}()
+}
+
+// The following types are the subset of the OTLP JSON encoding that we need.
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func encodeSpans(spans []*span) otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		for k, v := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+		}
+		out = append(out, o)
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttr{{Key: "service.name", Value: otlpValue{StringValue: "git-sync"}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "git-sync", Version: version.VERSION},
+				Spans: out,
+			}},
+		}},
+	}
+}
+
+func (t *tracer) export(spans []*span) error {
+	body, err := json.Marshal(encodeSpans(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("collector returned status %d: %q", resp.StatusCode, string(msg))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestNewTracer(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+		fail   bool
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/traces", false},
+		{"http://localhost:4318/", "http://localhost:4318/v1/traces", false},
+		{"https://collector/custom/path", "https://collector/custom/path", false},
+		{"localhost:4318", "", true},
+		{"ftp://collector", "", true},
+	}
+
+	for _, tc := range cases {
+		tr, err := newTracer(tc.input)
+		if err != nil {
+			if !tc.fail {
+				t.Errorf("%q: unexpected error: %v", tc.input, err)
+			}
+			continue
+		}
+		if tc.fail {
+			t.Errorf("%q: unexpected success", tc.input)
+			continue
+		}
+		if tr.endpoint != tc.expect {
+			t.Errorf("%q: expected %q, got %q", tc.input, tc.expect, tr.endpoint)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	t.Run("tracing disabled", func(t *testing.T) {
+		tracing = nil
+		ctx, s := startSpan(context.Background(), "root")
+		if s != nil {
+			t.Fatalf("expected nil span")
+		}
+		// These must not panic.
+		s.setAttr("k", "v")
+		s.finish(nil)
+		if _, c := startChildSpan(ctx, "child"); c != nil {
+			t.Fatalf("expected nil child span")
+		}
+	})
+
+	t.Run("parent and child", func(t *testing.T) {
+		tr, err := newTracer("http://localhost:4318")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tracing = tr
+		defer func() { tracing = nil }()
+
+		if _, c := startChildSpan(context.Background(), "orphan"); c != nil {
+			t.Fatalf("expected nil span without a parent")
+		}
+
+		ctx, root := startSpan(context.Background(), "root", "key", "val")
+		_, child := startChildSpan(ctx, "child")
+		if child == nil {
+			t.Fatalf("expected child span")
+		}
+		if child.traceID != root.traceID {
+			t.Errorf("expected trace ID %q, got %q", root.traceID, child.traceID)
+		}
+		if child.parentID != root.spanID {
+			t.Errorf("expected parent ID %q, got %q", root.spanID, child.parentID)
+		}
+		child.finish(fmt.Errorf("oops"))
+
+		req := encodeSpans([]*span{child, root})
+		spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+		if len(spans) != 2 {
+			t.Fatalf("expected 2 spans, got %d", len(spans))
+		}
+		if spans[0].Status.Code != otlpStatusError || spans[0].Status.Message != "oops" {
+			t.Errorf("expected error status, got %+v", spans[0].Status)
+		}
+		if spans[1].Status.Code != otlpStatusOK {
+			t.Errorf("expected OK status, got %+v", spans[1].Status)
+		}
+		if len(spans[1].Attributes) != 1 || spans[1].Attributes[0].Key != "key" {
+			t.Errorf("expected attributes, got %+v", spans[1].Attributes)
+		}
+	})
+}
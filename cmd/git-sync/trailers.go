@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var trailerGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "git_sync_commit_trailer",
+	Help: "Whether the upstream commit has a trailer given by --skip-trailer or --metric-trailer (1) or not (0)",
+}, []string{"trailer"})
+
+func init() {
+	prometheus.MustRegister(trailerGauge)
+}
+
+// errTrailerSkip is returned when the hash to be published has a trailer
+// which matches --skip-trailer.
+var errTrailerSkip = errors.New("hash is skipped by a commit trailer")
+
+// lastTrailerSkipped is the most recently skipped hash, so that each hash is
+// only logged once.  This is protected by syncLock.
+var lastTrailerSkipped string
+
+// trailer is one "Key: value" trailer of a commit message.
+type trailer struct {
+	key   string
+	value string
+}
+
+// trailerMatch is one --skip-trailer or --metric-trailer: a key, and
+// optionally a value.
+type trailerMatch struct {
+	spec     string
+	key      string
+	value    string
+	anyValue bool
+}
+
+// parseTrailerMatch parses "Key" (any value) or "Key=value".
+func parseTrailerMatch(spec string) (trailerMatch, error) {
+	m := trailerMatch{spec: spec, anyValue: true}
+	m.key = spec
+	if i := strings.Index(spec, "="); i >= 0 {
+		m.key, m.value, m.anyValue = spec[:i], strings.TrimSpace(spec[i+1:]), false
+	}
+	m.key = strings.TrimSpace(m.key)
+	if m.key == "" || strings.ContainsAny(m.key, " \t:") {
+		return trailerMatch{}, fmt.Errorf("trailer %q is not in 'Key' or 'Key=value' form", spec)
+	}
+	return m, nil
+}
+
+// matches returns true if any of trailers matches.  Keys and values are
+// compared case-insensitively, as git does for keys.
+func (m trailerMatch) matches(trailers []trailer) bool {
+	for _, t := range trailers {
+		if strings.EqualFold(t.key, m.key) && (m.anyValue || strings.EqualFold(t.value, m.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrailers parses the output of `git log --format=%(trailers:only,unfold)`.
+func parseTrailers(output string) []trailer {
+	trailers := []trailer{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		trailers = append(trailers, trailer{key: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1])})
+	}
+	return trailers
+}
+
+// parseTrailerMatches parses a list of --skip-trailer or --metric-trailer.
+func parseTrailerMatches(specs []string) ([]trailerMatch, error) {
+	matches := []trailerMatch{}
+	for _, spec := range specs {
+		m, err := parseTrailerMatch(spec)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// checkTrailers reads the trailers of hash, updates the trailer metric, and
+// returns errTrailerSkip if any of them match --skip-trailer.
+func checkTrailers(ctx context.Context, gitRoot, hash string) error {
+	if len(flSkipTrailers.items) == 0 && len(flMetricTrailers.items) == 0 {
+		return nil
+	}
+	skip, err := parseTrailerMatches(flSkipTrailers.items)
+	if err != nil {
+		return err
+	}
+	metric, err := parseTrailerMatches(flMetricTrailers.items)
+	if err != nil {
+		return err
+	}
+
+	output, err := runCommand(ctx, gitRoot, *flGitCmd, "log", "-1", "--format=%(trailers:only,unfold)", hash, "--")
+	if err != nil {
+		return fmt.Errorf("can't read commit trailers: %w", err)
+	}
+	trailers := parseTrailers(output)
+
+	skippedBy := ""
+	for _, m := range append(skip, metric...) {
+		if m.matches(trailers) {
+			trailerGauge.WithLabelValues(m.spec).Set(1)
+		} else {
+			trailerGauge.WithLabelValues(m.spec).Set(0)
+		}
+	}
+	for _, m := range skip {
+		if m.matches(trailers) {
+			skippedBy = m.spec
+			break
+		}
+	}
+	if skippedBy == "" {
+		return nil
+	}
+
+	if hash != lastTrailerSkipped {
+		lastTrailerSkipped = hash
+		log.V(0).Info("not publishing, the commit has a --skip-trailer", "hash", hash, "trailer", skippedBy)
+		emitEvent(eventRejected, hash, errTrailerSkip)
+	}
+	return errTrailerSkip
+}
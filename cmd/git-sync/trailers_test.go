@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseTrailerMatch(t *testing.T) {
+	cases := []struct {
+		spec    string
+		expect  trailerMatch
+		wantErr bool
+	}{
+		{spec: "Deploy-Freeze", expect: trailerMatch{spec: "Deploy-Freeze", key: "Deploy-Freeze", anyValue: true}},
+		{spec: "Deploy-Freeze=true", expect: trailerMatch{spec: "Deploy-Freeze=true", key: "Deploy-Freeze", value: "true"}},
+		{spec: "Deploy-Freeze=", expect: trailerMatch{spec: "Deploy-Freeze=", key: "Deploy-Freeze", value: ""}},
+		{spec: "Note=a=b", expect: trailerMatch{spec: "Note=a=b", key: "Note", value: "a=b"}},
+		{spec: "", wantErr: true},
+		{spec: "=true", wantErr: true},
+		{spec: "Deploy Freeze", wantErr: true},
+		{spec: "Deploy-Freeze: true", wantErr: true},
+	}
+	for _, tc := range cases {
+		m, err := parseTrailerMatch(tc.spec)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.spec, m)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.spec, err)
+		} else if m != tc.expect {
+			t.Errorf("%q: expected %+v, got %+v", tc.spec, tc.expect, m)
+		}
+	}
+}
+
+func TestParseTrailers(t *testing.T) {
+	output := "Deploy-Freeze: true\nSigned-off-by: Jane Doe <jane@example.com>\n\n"
+	expect := []trailer{
+		{key: "Deploy-Freeze", value: "true"},
+		{key: "Signed-off-by", value: "Jane Doe <jane@example.com>"},
+	}
+	if got := parseTrailers(output); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %+v, got %+v", expect, got)
+	}
+	if got := parseTrailers(""); len(got) != 0 {
+		t.Errorf("expected no trailers, got %+v", got)
+	}
+}
+
+func TestTrailerMatches(t *testing.T) {
+	trailers := []trailer{{key: "Deploy-Freeze", value: "True"}}
+	cases := []struct {
+		spec   string
+		expect bool
+	}{
+		{"Deploy-Freeze", true},
+		{"deploy-freeze", true},
+		{"Deploy-Freeze=true", true},
+		{"Deploy-Freeze=false", false},
+		{"Deploy-Hold", false},
+	}
+	for _, tc := range cases {
+		m, err := parseTrailerMatch(tc.spec)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.spec, err)
+		}
+		if got := m.matches(trailers); got != tc.expect {
+			t.Errorf("%q: expected %v, got %v", tc.spec, tc.expect, got)
+		}
+	}
+}
+
+func TestCheckTrailers(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(skip, metric []string) {
+		flSkipTrailers.items, flMetricTrailers.items = skip, metric
+	}(flSkipTrailers.items, flMetricTrailers.items)
+	defer func(old string) { lastTrailerSkipped = old }(lastTrailerSkipped)
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = os.Environ()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := exec.Command(*flGitCmd, "init", repo).Run(); err != nil {
+		t.Fatalf("git init failed: %v", err)
+	}
+	git("commit", "--allow-empty", "-m", "plain")
+	plain := git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "frozen\n\nDeploy-Freeze: true")
+	frozen := git("rev-parse", "HEAD")
+
+	ctx := context.Background()
+	flSkipTrailers.items = []string{"Deploy-Freeze=true"}
+	flMetricTrailers.items = nil
+	if err := checkTrailers(ctx, repo, plain); err != nil {
+		t.Errorf("unexpected error for %s: %v", plain, err)
+	}
+	if err := checkTrailers(ctx, repo, frozen); !errors.Is(err, errTrailerSkip) {
+		t.Errorf("expected errTrailerSkip for %s, got %v", frozen, err)
+	}
+
+	// A metric-only trailer doesn't stop publication.
+	flSkipTrailers.items = nil
+	flMetricTrailers.items = []string{"Deploy-Freeze"}
+	if err := checkTrailers(ctx, repo, frozen); err != nil {
+		t.Errorf("unexpected error for %s: %v", frozen, err)
+	}
+}
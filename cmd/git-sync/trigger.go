@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// What started a sync, as recorded in the audit log.
+const (
+	// triggerStartup is the first sync.
+	triggerStartup = "startup"
+	// triggerPoll is a sync after --wait, at a --schedule time, or a retry.
+	triggerPoll = "poll"
+	// triggerSignal is a sync requested with SIGHUP.
+	triggerSignal = "signal"
+	// triggerGRPC is a sync requested with the gRPC API.
+	triggerGRPC = "grpc"
+	// triggerWebhook is a sync requested by a push webhook from the forge.
+	triggerWebhook = "scm-webhook"
+	// triggerReload is a sync after a reloaded file changed.
+	triggerReload = "reload"
+	// triggerHTTP is a change requested on the HTTP admin endpoints.
+	triggerHTTP = "http"
+)
+
+// syncNow wakes the sync loop early, with what asked for the sync.  It holds
+// at most one pending request, since one sync catches up with any number of
+// upstream changes.
+var syncNow = make(chan string, 1)
+
+// triggerSync asks the sync loop to start the next sync now, rather than
+// after --wait or at the next --schedule time.  It returns false if a sync
+// was already requested and hasn't started yet.
+func triggerSync(reason string) bool {
+	select {
+	case syncNow <- reason:
+		log.V(1).Info("sync requested", "reason", reason)
+		return true
+	default:
+		return false
+	}
+}
+
+// waitForSync waits for d, or until triggerSync is called, and returns what
+// the next sync is for.
+func waitForSync(d time.Duration) string {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return triggerPoll
+	case reason := <-syncNow:
+		return reason
+	}
+}
+
+// triggerOnSignal requests a sync every time git-sync gets SIGHUP, forever.
+func triggerOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		triggerSync(triggerSignal)
+	}
+}
+
+type syncTriggerKey struct{}
+
+// withSyncTrigger returns a context which carries what started a sync.
+func withSyncTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, syncTriggerKey{}, trigger)
+}
+
+// syncTrigger returns what started the sync which is run with ctx, or
+// triggerPoll if that is not known.
+func syncTrigger(ctx context.Context) string {
+	if trigger, ok := ctx.Value(syncTriggerKey{}).(string); ok {
+		return trigger
+	}
+	return triggerPoll
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestTriggerSync(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+
+	if !triggerSync("test") {
+		t.Fatalf("expected the first trigger to be accepted")
+	}
+	if triggerSync("test") {
+		t.Errorf("expected a second trigger to be coalesced with the first")
+	}
+
+	start := time.Now()
+	if got := waitForSync(time.Hour); got != "test" {
+		t.Errorf("expected the trigger's reason, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("expected the wait to end early, took %v", elapsed)
+	}
+	if got := waitForSync(time.Millisecond); got != triggerPoll {
+		t.Errorf("expected %q, got %q", triggerPoll, got)
+	}
+
+	// The pending trigger was consumed.
+	start = time.Now()
+	waitForSync(10 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected the wait to run out, took %v", elapsed)
+	}
+}
+
+func TestSyncTrigger(t *testing.T) {
+	if got := syncTrigger(context.Background()); got != triggerPoll {
+		t.Errorf("expected %q without a trigger, got %q", triggerPoll, got)
+	}
+	ctx := withSyncTrigger(context.Background(), triggerWebhook)
+	if got := syncTrigger(ctx); got != triggerWebhook {
+		t.Errorf("expected %q, got %q", triggerWebhook, got)
+	}
+}
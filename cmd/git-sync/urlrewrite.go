@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// stringList is a flag.Value for flags which may be given more than once.
+// Each value may also hold several comma-separated items, which is how lists
+// are given in environment variables and the config file.  The first value
+// which is set replaces the default.
+type stringList struct {
+	items []string
+	set   bool
+}
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.items, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if !l.set {
+		l.items = nil
+		l.set = true
+	}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			l.items = append(l.items, item)
+		}
+	}
+	return nil
+}
+
+// stringListFlag defines a repeatable flag, with a comma-separated default.
+func stringListFlag(name, def, usage string) *stringList {
+	l := &stringList{}
+	l.Set(def)
+	l.set = false
+	flag.Var(l, name, usage)
+	return l
+}
+
+// urlRewrite is one --url-rewrite rule: URLs starting with from are fetched
+// from to instead.
+type urlRewrite struct {
+	from string
+	to   string
+}
+
+// parseURLRewrite parses a rule in "from=to" form.
+func parseURLRewrite(rule string) (urlRewrite, error) {
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return urlRewrite{}, fmt.Errorf("rule %q is not in 'from=to' form", rule)
+	}
+	return urlRewrite{from: parts[0], to: parts[1]}, nil
+}
+
+// setupURLRewrites configures git to apply the --url-rewrite rules, with
+// url.<to>.insteadOf.  Since this is global config, it also applies to
+// submodules.
+func setupURLRewrites(ctx context.Context, rules []string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	log.V(1).Info("setting URL rewrites")
+	for _, rule := range rules {
+		rw, err := parseURLRewrite(rule)
+		if err != nil {
+			return err
+		}
+		key := "url." + rw.to + ".insteadOf"
+		// --replace-all with a value pattern is idempotent, and leaves other
+		// rewrites to the same base alone.
+		if _, err := runCommand(ctx, "", *flGitCmd, "config", "--global", "--replace-all", key, rw.from, "^"+regexpQuote(rw.from)+"$"); err != nil {
+			return fmt.Errorf("error configuring %q %q: %v", key, rw.from, err)
+		}
+	}
+	return nil
+}
+
+// regexpQuote escapes s for use in a git config value pattern, which is a
+// POSIX extended regex.
+func regexpQuote(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\.+*?()|[]{}^$`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestStringList(t *testing.T) {
+	l := &stringList{}
+	l.Set("a, b")
+	l.set = false
+	if !reflect.DeepEqual(l.items, []string{"a", "b"}) {
+		t.Errorf("expected the default [a b], got %v", l.items)
+	}
+	// The first explicit value replaces the default; later ones append.
+	l.Set("c")
+	l.Set("d,,e")
+	if !reflect.DeepEqual(l.items, []string{"c", "d", "e"}) {
+		t.Errorf("expected [c d e], got %v", l.items)
+	}
+	if l.String() != "c,d,e" {
+		t.Errorf("expected \"c,d,e\", got %q", l.String())
+	}
+}
+
+func TestParseURLRewrite(t *testing.T) {
+	rw, err := parseURLRewrite("https://github.com/=ssh://git@github.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw.from != "https://github.com/" || rw.to != "ssh://git@github.example.com/" {
+		t.Errorf("unexpected rule: %+v", rw)
+	}
+	for _, bad := range []string{"", "no-equals", "=to", "from="} {
+		if _, err := parseURLRewrite(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSetupURLRewrites(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", t.TempDir())
+
+	rules := []string{
+		"https://github.com/=ssh://mirror.example.com/",
+		"https://gitlab.com/=ssh://mirror.example.com/",
+		"https://github.com/=ssh://mirror.example.com/",
+	}
+	if err := setupURLRewrites(context.Background(), rules); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(*flGitCmd, "config", "--global", "--get-all", "url.ssh://mirror.example.com/.insteadOf").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Fields(string(out))
+	want := []string{"https://github.com/", "https://gitlab.com/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	out, err = exec.Command(*flGitCmd, "ls-remote", "--get-url", "https://github.com/kubernetes/git-sync").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "ssh://mirror.example.com/kubernetes/git-sync" {
+		t.Errorf("expected the URL to be rewritten, got %q", got)
+	}
+}
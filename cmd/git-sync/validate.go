@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// validateCmd is the subcommand which checks the flags, env vars, and config
+// file, reports every problem, and exits.
+const validateCmd = "validate"
+
+// validating is true when running validateCmd.  handleError records errors
+// in validationErrors rather than exiting.
+var validating bool
+var validationErrors []string
+
+// validateFiles checks that the files which the flags name can be read.
+func validateFiles() {
+	files := []struct {
+		flag string
+		path string
+		used bool
+	}{
+		{"ssh-key-file", *flSSHKeyFile, *flSSH},
+		{"ssh-known-hosts-file", *flSSHKnownHostsFile, *flSSH && *flSSHKnownHosts},
+		{"cookie-file-path", *flCookieFilePath, *flCookieFile},
+		{"password-file", *flPasswordFile, *flPasswordFile != ""},
+		{"sparse-checkout-file", *flSparseCheckoutFile, *flSparseCheckoutFile != ""},
+		{"from-bundle", *flFromBundle, *flFromBundle != ""},
+		{"passwd-file", *flPasswdFile, *flAddUser && *flNSSWrapperLib == ""},
+		{"nss-wrapper-lib", *flNSSWrapperLib, *flNSSWrapperLib != ""},
+	}
+	for _, f := range files {
+		if !f.used {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			handleError(false, "ERROR: can't access --%s: %v", f.flag, err)
+		}
+	}
+	for _, c := range hostCredentials {
+		if c.passwordFile == "" {
+			continue
+		}
+		if _, err := os.Stat(c.passwordFile); err != nil {
+			handleError(false, "ERROR: can't access the password-file of --credential for %s: %v", c.url, err)
+		}
+	}
+}
+
+// finishValidation reports the result of validateCmd, and exits.
+func finishValidation() {
+	validateFiles()
+	if len(validationErrors) > 0 {
+		for _, e := range validationErrors {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		fmt.Fprintf(os.Stderr, "found %d problem(s)\n", len(validationErrors))
+		os.Exit(1)
+	}
+	root, err := filepath.Abs(*flRoot)
+	if err != nil {
+		root = *flRoot
+	}
+	fmt.Printf("configuration is valid: --root=%s, link=%s\n", root, filepath.Join(root, *flDest))
+	os.Exit(0)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateFiles(t *testing.T) {
+	defer func(v bool, errs []string) {
+		validating = v
+		validationErrors = errs
+	}(validating, validationErrors)
+	defer func(ssh, knownHosts bool, key, hosts, passwordFile string) {
+		*flSSH = ssh
+		*flSSHKnownHosts = knownHosts
+		*flSSHKeyFile = key
+		*flSSHKnownHostsFile = hosts
+		*flPasswordFile = passwordFile
+	}(*flSSH, *flSSHKnownHosts, *flSSHKeyFile, *flSSHKnownHostsFile, *flPasswordFile)
+
+	dir := t.TempDir()
+	key := filepath.Join(dir, "ssh")
+	if err := ioutil.WriteFile(key, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	validating = true
+	validationErrors = nil
+	*flSSH = true
+	*flSSHKnownHosts = true
+	*flSSHKeyFile = key
+	*flSSHKnownHostsFile = filepath.Join(dir, "known_hosts")
+	*flPasswordFile = filepath.Join(dir, "password")
+	validateFiles()
+
+	// Both missing files are reported, rather than just the first.
+	if len(validationErrors) != 2 {
+		t.Fatalf("expected 2 errors, got %q", validationErrors)
+	}
+	if !strings.Contains(validationErrors[0], "--ssh-known-hosts-file") {
+		t.Errorf("expected an error about --ssh-known-hosts-file, got %q", validationErrors[0])
+	}
+	if !strings.Contains(validationErrors[1], "--password-file") {
+		t.Errorf("expected an error about --password-file, got %q", validationErrors[1])
+	}
+}
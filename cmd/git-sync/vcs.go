@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// vcsBackend holds the repository operations which differ between version
+// control systems.  Everything else (one worktree per hash under --root, the
+// symlink swap, hooks, webhooks, and rollback) is shared.
+type vcsBackend interface {
+	// command returns the executable this backend runs.
+	command() string
+	// metaDir returns the name of the metadata directory (or file) which
+	// marks a worktree created by this backend.
+	metaDir() string
+	// clone makes the initial clone of repo into gitRoot, without checking
+	// out any files.
+	clone(ctx context.Context, repo, branch, rev string, depth int, gitRoot string) error
+	// localHash returns the hash that rev resolves to in the clone at
+	// gitRoot.
+	localHash(ctx context.Context, branch, rev, gitRoot string) (string, error)
+	// revs returns the hash checked out in the worktree at target, and the
+	// hash that rev resolves to upstream, as known to the clone at gitRoot.
+	revs(ctx context.Context, gitRoot, target, branch, rev string) (string, string, error)
+	// fetch updates the clone at gitRoot from upstream.
+	fetch(ctx context.Context, gitRoot, branch string, depth int) error
+	// hasCommit returns an error if hash is not known in the clone at
+	// gitRoot.
+	hasCommit(ctx context.Context, gitRoot, hash string) error
+	// revIsHash reports whether rev names a specific commit, rather than a
+	// branch or tag which can move.
+	revIsHash(ctx context.Context, rev, gitRoot string) (bool, error)
+	// checkout creates a new worktree at worktreePath with hash checked out.
+	checkout(ctx context.Context, gitRoot, branch, hash string, depth int, submoduleMode, worktreePath string) error
+	// removeWorktree removes the worktree at worktreePath.
+	removeWorktree(ctx context.Context, gitRoot, worktreePath string) error
+}
+
+// vcsBackends holds the available backends, by --vcs name.
+var vcsBackends = map[string]vcsBackend{}
+
+// registerVCS makes a backend available to --vcs.
+func registerVCS(name string, b vcsBackend) {
+	if _, found := vcsBackends[name]; found {
+		panic(fmt.Sprintf("VCS backend %q registered twice", name))
+	}
+	vcsBackends[name] = b
+}
+
+// vcsNames returns the names of all registered backends, sorted.
+func vcsNames() []string {
+	names := make([]string, 0, len(vcsBackends))
+	for name := range vcsBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// backend is the backend selected by --vcs.
+var backend vcsBackend = gitBackend{}
+
+func init() {
+	registerVCS("git", gitBackend{})
+}
+
+// gitBackend syncs git repositories.  Worktrees are git worktrees which
+// share the clone's object store.
+type gitBackend struct{}
+
+func (gitBackend) command() string {
+	return *flGitCmd
+}
+
+func (gitBackend) metaDir() string {
+	return ".git"
+}
+
+func (gitBackend) clone(ctx context.Context, repo, branch, rev string, depth int, gitRoot string) error {
+	return cloneRepo(ctx, repo, branch, rev, depth, gitRoot)
+}
+
+func (gitBackend) localHash(ctx context.Context, branch, rev, gitRoot string) (string, error) {
+	return localHashForRev(ctx, rev, gitRoot)
+}
+
+func (gitBackend) revs(ctx context.Context, gitRoot, target, branch, rev string) (string, string, error) {
+	if !*flPublishWithoutGitdir {
+		return getRevs(ctx, target, branch, rev)
+	}
+
+	// The published worktree is not a git worktree any more, but its
+	// name says which hash it holds.
+	current, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", "", err
+	}
+	remote, err := remoteHashForRef(ctx, refForRev(branch, rev), gitRoot)
+	if err != nil {
+		return "", "", err
+	}
+	return worktreeHash(current), remote, nil
+}
+
+func (gitBackend) fetch(ctx context.Context, gitRoot, branch string, depth int) error {
+	args := append(fetchNegotiationArgs(), "fetch", "-f", "--tags")
+	args = append(args, shallowArgs(depth)...)
+	args = append(args, "origin", branch)
+	if err := fetch(ctx, gitRoot, args); err != nil {
+		return err
+	}
+
+	// GC clone
+	if err := gcRepo(ctx, gitRoot); err != nil {
+		return err
+	}
+	if *flGitMaintenance {
+		// This only makes later syncs faster, so it doesn't fail this one.
+		if err := maintainRepo(ctx, gitRoot); err != nil {
+			log.Error(err, "can't maintain the clone", "path", gitRoot)
+		}
+	}
+	return nil
+}
+
+func (gitBackend) hasCommit(ctx context.Context, gitRoot, hash string) error {
+	_, err := revIsHash(ctx, hash, gitRoot)
+	return err
+}
+
+func (gitBackend) revIsHash(ctx context.Context, rev, gitRoot string) (bool, error) {
+	return revIsHash(ctx, rev, gitRoot)
+}
+
+func (gitBackend) checkout(ctx context.Context, gitRoot, branch, hash string, depth int, submoduleMode, worktreePath string) error {
+	return checkoutWorktree(ctx, gitRoot, branch, hash, depth, submoduleMode, worktreePath)
+}
+
+func (gitBackend) removeWorktree(ctx context.Context, gitRoot, worktreePath string) error {
+	if err := os.RemoveAll(worktreePath); err != nil {
+		return fmt.Errorf("error removing directory: %v", err)
+	}
+	_, err := runCommand(ctx, gitRoot, *flGitCmd, "worktree", "prune")
+	return err
+}
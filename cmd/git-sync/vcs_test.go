@@ -0,0 +1,48 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVCSNames(t *testing.T) {
+	if got, want := vcsNames(), []string{"git", "hg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestHgRevs(t *testing.T) {
+	cases := []struct {
+		branch, rev       string
+		revset, remoteRev string
+	}{
+		{"default", "HEAD", `max(branch("default"))`, "default"},
+		{"stable", "HEAD", `max(branch("stable"))`, "stable"},
+		{"default", "v1.0", "v1.0", "v1.0"},
+		{"default", "0123abcd", "0123abcd", "0123abcd"},
+	}
+	for _, tc := range cases {
+		if got := hgRevset(tc.branch, tc.rev); got != tc.revset {
+			t.Errorf("hgRevset(%q, %q): expected %q, got %q", tc.branch, tc.rev, tc.revset, got)
+		}
+		if got := hgRemoteRev(tc.branch, tc.rev); got != tc.remoteRev {
+			t.Errorf("hgRemoteRev(%q, %q): expected %q, got %q", tc.branch, tc.rev, tc.remoteRev, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var verifyCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "git_sync_verify_count_total",
+	Help: "How many --verify checks completed, partitioned by state (success, error)",
+}, []string{"status"})
+
+var verifyDrift = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_verify_drift",
+	Help: "Whether the published hash differs from the upstream hash (1) or not (0), as of the last --verify check",
+})
+
+var verifyDriftSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "git_sync_verify_drift_seconds",
+	Help: "How long the published hash has differed from the upstream hash, as seen by --verify, or 0 if it doesn't",
+})
+
+func init() {
+	prometheus.MustRegister(verifyCount)
+	prometheus.MustRegister(verifyDrift)
+	prometheus.MustRegister(verifyDriftSeconds)
+}
+
+// errDrift is returned by --one-time --verify when the published hash differs
+// from the upstream hash.
+var errDrift = errors.New("the published hash does not match the upstream")
+
+// verifier tracks drift between the published hash and the upstream hash,
+// for --verify.
+type verifier struct {
+	// driftSince is when the current drift was first seen, or zero if the
+	// hashes matched at the last check.
+	driftSince time.Time
+	// last is the last plan which was logged.
+	last dryRunPlan
+}
+
+// record updates the drift metrics from plan, which was checked at now, and
+// logs changes.  It returns true if the hashes differ.
+func (v *verifier) record(plan dryRunPlan, now time.Time) bool {
+	verifyCount.WithLabelValues(metricKeySuccess).Inc()
+	changed := plan.Current != v.last.Current || plan.Upstream != v.last.Upstream
+	v.last = plan
+
+	if !plan.WouldSync {
+		if changed || !v.driftSince.IsZero() {
+			log.V(0).Info("published hash matches upstream", "hash", plan.Current)
+		}
+		v.driftSince = time.Time{}
+		verifyDrift.Set(0)
+		verifyDriftSeconds.Set(0)
+		return false
+	}
+
+	if v.driftSince.IsZero() {
+		v.driftSince = now
+	}
+	if changed {
+		log.V(0).Info("published hash does not match upstream", "published", plan.Current, "upstream", plan.Upstream, "since", v.driftSince)
+	}
+	verifyDrift.Set(1)
+	verifyDriftSeconds.Set(now.Sub(v.driftSince).Seconds())
+	return true
+}
+
+// runVerify checks, every --wait, whether the hash published at dest matches
+// the upstream, forever, without changing anything under gitRoot.  With
+// --one-time it checks once, and exits with an error if they differ.
+func runVerify(repo, branch, rev string, depth int, gitRoot, dest string) {
+	v := &verifier{}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*flSyncTimeout))
+		plan, err := planSync(ctx, repo, branch, rev, depth, gitRoot, dest)
+		cancel()
+		if err != nil {
+			verifyCount.WithLabelValues(metricKeyError).Inc()
+			log.Error(err, "can't verify the published hash")
+			if *flOneTime {
+				os.Exit(1)
+			}
+		} else {
+			drifted := v.record(plan, time.Now())
+			setRepoReady()
+			if *flOneTime {
+				if drifted {
+					log.Error(errDrift, "verification failed", "published", plan.Current, "upstream", plan.Upstream)
+					os.Exit(1)
+				}
+				os.Exit(0)
+			}
+		}
+		time.Sleep(waitTime(*flWait))
+	}
+}
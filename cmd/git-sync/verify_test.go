@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestVerifierRecord(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	now := time.Now()
+	v := &verifier{}
+
+	steps := []struct {
+		current  string
+		upstream string
+		at       time.Duration
+		drifted  bool
+		since    time.Duration
+	}{
+		{current: hash1, upstream: hash1, at: 0, drifted: false},
+		{current: hash1, upstream: hash2, at: time.Minute, drifted: true, since: time.Minute},
+		{current: hash1, upstream: hash2, at: 2 * time.Minute, drifted: true, since: time.Minute},
+		{current: hash2, upstream: hash2, at: 3 * time.Minute, drifted: false},
+		// Nothing published counts as drift.
+		{current: "", upstream: hash2, at: 4 * time.Minute, drifted: true, since: 4 * time.Minute},
+	}
+	for i, step := range steps {
+		plan := dryRunPlan{Current: step.current, Upstream: step.upstream, WouldSync: step.current != step.upstream}
+		if drifted := v.record(plan, now.Add(step.at)); drifted != step.drifted {
+			t.Errorf("step %d: expected drifted=%v, got %v", i, step.drifted, drifted)
+		}
+		if step.drifted {
+			if !v.driftSince.Equal(now.Add(step.since)) {
+				t.Errorf("step %d: expected drift since %v, got %v", i, now.Add(step.since), v.driftSince)
+			}
+		} else if !v.driftSince.IsZero() {
+			t.Errorf("step %d: expected no drift, got drift since %v", i, v.driftSince)
+		}
+	}
+}
@@ -17,10 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
@@ -35,17 +41,27 @@ type Webhook struct {
 	Success int
 	// Timeout for the http/s request
 	Timeout time.Duration
-	// Backoff for failed webhook calls
+	// Backoff for failed webhook calls, doubled after each failure
 	Backoff time.Duration
+	// MaxBackoff caps the doubling of Backoff
+	MaxBackoff time.Duration
+	// MaxRetries is how many times a failed call is retried before it is
+	// given up on and written to the dead letters.  -1 retries forever.
+	MaxRetries int
+	// Template for the request body, if any
+	Template *template.Template
+	// ContentType of the request body, if there is a Template
+	ContentType string
 
 	// Holds the data as it crosses from producer to consumer.
 	Data *webhookData
 }
 
 type webhookData struct {
-	ch    chan struct{}
-	mutex sync.Mutex
-	hash  string
+	ch       chan struct{}
+	mutex    sync.Mutex
+	hash     string
+	rollback bool
 }
 
 func NewWebhookData() *webhookData {
@@ -59,19 +75,29 @@ func (d *webhookData) events() chan struct{} {
 }
 
 func (d *webhookData) get() string {
+	hash, _ := d.getWithRollback()
+	return hash
+}
+
+func (d *webhookData) getWithRollback() (string, bool) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
-	return d.hash
+	return d.hash, d.rollback
 }
 
-func (d *webhookData) set(newHash string) {
+func (d *webhookData) set(newHash string, rollback bool) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	d.hash = newHash
+	d.rollback = rollback
 }
 
 func (d *webhookData) send(newHash string) {
-	d.set(newHash)
+	d.sendWithRollback(newHash, false)
+}
+
+func (d *webhookData) sendWithRollback(newHash string, rollback bool) {
+	d.set(newHash, rollback)
 
 	// Non-blocking write.  If the channel is full, the consumer will see the
 	// newest value.  If the channel was not full, the consumer will get another
@@ -86,18 +112,41 @@ func (w *Webhook) Send(hash string) {
 	w.Data.send(hash)
 }
 
-func (w *Webhook) Do(hash string) error {
-	req, err := http.NewRequest(w.Method, w.URL, nil)
+// SendRollback is like Send, but marks the webhook as a rollback.
+func (w *Webhook) SendRollback(hash string) {
+	w.Data.sendWithRollback(hash, true)
+}
+
+func (w *Webhook) Do(hash string, rollback bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if w.Template != nil {
+		info, err := getCommitInfo(ctx, *flRoot, hash)
+		if err != nil {
+			log.Error(err, "can't get commit info for webhook", "hash", hash)
+		}
+		data, err := renderWebhookTemplate(w.Template, webhookPayload{commitInfo: info, Rollback: rollback})
+		if err != nil {
+			return fmt.Errorf("can't render --webhook-template: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.Method, w.URL, body)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Gitsync-Hash", hash)
+	if rollback {
+		req.Header.Set("Gitsync-Rollback", "true")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", w.ContentType)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), w.Timeout)
-	defer cancel()
-	req = req.WithContext(ctx)
-
-	log.V(0).Info("sending webhook", "hash", hash, "url", w.URL, "method", w.Method, "timeout", w.Timeout)
+	log.V(0).Info("sending webhook", "hash", hash, "rollback", rollback, "url", secrets.redact(w.URL), "method", w.Method, "timeout", w.Timeout)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -106,35 +155,125 @@ func (w *Webhook) Do(hash string) error {
 
 	// If the webhook has a success statusCode, check against it
 	if w.Success != -1 && resp.StatusCode != w.Success {
-		return fmt.Errorf("received response code %d expected %d", resp.StatusCode, w.Success)
+		err := fmt.Errorf("received response code %d expected %d", resp.StatusCode, w.Success)
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return retryAfterError{err: err, after: after}
+		}
+		return err
 	}
 
 	return nil
 }
 
+// retryAfterError is returned by Do when the receiver asked, with a
+// Retry-After header, to be retried no sooner than after.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %v)", e.err, e.after)
+}
+
+func (e retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date, into how long to wait from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	when, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := when.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// nextBackoff doubles backoff, up to max.
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	if backoff *= 2; max > 0 && backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// giveUp records a notification which failed too many times, so it is not
+// retried any more.
+func (w *Webhook) giveUp(hash string, rollback bool, attempts int, err error) {
+	log.Error(err, "webhook failed too many times, giving up", "url", secrets.redact(w.URL), "hash", hash, "attempts", attempts)
+	webhookDeadLetterCount.Inc()
+	rec := deadLetter{
+		Time:     time.Now().UTC(),
+		URL:      secrets.redact(w.URL),
+		Method:   w.Method,
+		Hash:     hash,
+		Rollback: rollback,
+		Attempts: attempts,
+		Error:    err.Error(),
+	}
+	if err := webhookDeadLetters.append(rec); err != nil {
+		log.Error(err, "can't write webhook dead letter", "path", webhookDeadLetters.path, "hash", hash)
+	}
+}
+
 // Wait for trigger events from the channel, and send webhooks when triggered
 func (w *Webhook) run() {
 	var lastHash string
 
 	// Wait for trigger from webhookData.Send
 	for range w.Data.events() {
+		var tryHash string
+		var attempts int
+		var backoff time.Duration
+
 		// Retry in case of error
 		for {
 			// Always get the latest value, in case we fail-and-retry and the
 			// value changed in the meantime.  This means that we might not send
 			// every single hash.
-			hash := w.Data.get()
+			hash, rollback := w.Data.getWithRollback()
 			if hash == lastHash {
 				break
 			}
+			if hash != tryHash {
+				// A new notification gets its own retries.
+				tryHash, attempts, backoff = hash, 0, w.Backoff
+			}
 
-			if err := w.Do(hash); err != nil {
-				log.Error(err, "webhook failed", "url", w.URL, "method", w.Method, "timeout", w.Timeout)
-				time.Sleep(w.Backoff)
-			} else {
+			err := w.Do(hash, rollback)
+			if err == nil {
 				lastHash = hash
 				break
 			}
+			attempts++
+			if w.MaxRetries >= 0 && attempts > w.MaxRetries {
+				w.giveUp(hash, rollback, attempts, err)
+				lastHash = hash
+				break
+			}
+			wait := backoff
+			var ra retryAfterError
+			if errors.As(err, &ra) && ra.after > wait {
+				// The receiver knows better when it will be back.
+				wait = ra.after
+			}
+			log.Error(err, "webhook failed", "url", secrets.redact(w.URL), "method", w.Method, "timeout", w.Timeout, "attempt", attempts, "backoff", wait.String())
+			time.Sleep(wait)
+			backoff = nextBackoff(backoff, w.MaxBackoff)
 		}
 	}
 }
@@ -17,9 +17,18 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/go-logr/logr"
 )
 
 const (
@@ -90,9 +99,147 @@ func TestDo(t *testing.T) {
 			Backoff: time.Second * 3,
 			Data:    NewWebhookData(),
 		}
-		err := wh.Do("hash")
+		err := wh.Do("hash", false)
 		if err == nil {
 			t.Fatalf("expected error for invalid url but got none")
 		}
 	})
+
+	t.Run("test templated bodies are sent", func(t *testing.T) {
+		var got []byte
+		var contentType string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = ioutil.ReadAll(r.Body)
+			contentType = r.Header.Get("Content-Type")
+		}))
+		defer server.Close()
+
+		tmpl, err := parseWebhookTemplate(`{"hash":{{json .Hash}},"rollback":{{.Rollback}}}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		wh := Webhook{
+			URL:         server.URL,
+			Method:      "POST",
+			Success:     200,
+			Timeout:     time.Second,
+			Backoff:     time.Second * 3,
+			Template:    tmpl,
+			ContentType: "application/json",
+			Data:        NewWebhookData(),
+		}
+		// Only the hash is known without a repo.
+		defer func(old string) { *flSource = old }(*flSource)
+		*flSource = sourceOCI
+		if err := wh.Do("hash", true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(got) != `{"hash":"hash","rollback":true}` {
+			t.Errorf("unexpected body: %s", got)
+		}
+		if contentType != "application/json" {
+			t.Errorf("unexpected content type: %q", contentType)
+		}
+	})
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := time.Second
+	for _, expect := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		backoff = nextBackoff(backoff, 5*time.Second)
+		if backoff != expect {
+			t.Errorf("expected %v, got %v", expect, backoff)
+		}
+	}
+}
+
+func TestRunMaxRetries(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	path := filepath.Join(t.TempDir(), "dead-letters")
+	webhookDeadLetters.path = path
+	defer func() { webhookDeadLetters.path = "" }()
+
+	var mutex sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	wh := &Webhook{
+		URL:        server.URL,
+		Method:     "POST",
+		Success:    200,
+		Timeout:    time.Second,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 2 * time.Millisecond,
+		MaxRetries: 2,
+		Data:       NewWebhookData(),
+	}
+	go wh.run()
+	wh.Send(hash1)
+
+	var data []byte
+	for i := 0; i < 500 && len(data) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		data, _ = webhookDeadLetters.read()
+	}
+	var rec deadLetter
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("expected a dead letter, got %q: %v", data, err)
+	}
+	if rec.Hash != hash1 || rec.Attempts != 3 || rec.URL != server.URL {
+		t.Errorf("unexpected dead letter: %+v", rec)
+	}
+	mutex.Lock()
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+	mutex.Unlock()
+
+	rr := httptest.NewRecorder()
+	serveDeadLetters(rr, httptest.NewRequest("GET", webhookDeadLetterPath, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != string(data) {
+		t.Errorf("unexpected response %d: %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value  string
+		expect time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Fri, 01 Jan 2021 00:00:30 GMT", 30 * time.Second, true},
+		{"Thu, 31 Dec 2020 00:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.expect || ok != tc.ok {
+			t.Errorf("%q: expected %v, %v, got %v, %v", tc.value, tc.expect, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestDoRetryAfter(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	wh := Webhook{URL: server.URL, Method: "POST", Success: 200, Timeout: time.Second, Data: NewWebhookData()}
+	var ra retryAfterError
+	if err := wh.Do("hash", false); !errors.As(err, &ra) || ra.after != 30*time.Second {
+		t.Errorf("expected a retryAfterError of 30s, got %v", err)
+	}
 }
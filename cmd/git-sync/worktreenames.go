@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Values for --worktree-names.
+const (
+	worktreeNamesHash     = "hash"
+	worktreeNamesSequence = "sequence"
+	worktreeNamesHMAC     = "hmac"
+)
+
+// worktreeNamesFile is in --root, and records which hash each worktree which
+// is not named for its hash holds.
+const worktreeNamesFile = ".git-sync-worktrees.json"
+
+// opaqueNameRE matches the names of worktrees which are not named for their
+// hashes.
+var opaqueNameRE = regexp.MustCompile(`^wt-[0-9a-f]{1,64}$`)
+
+// worktreeNameKey is the key for --worktree-names=hmac.
+var worktreeNameKey []byte
+
+// worktreeNameMap is the content of the worktreeNamesFile.
+type worktreeNameMap struct {
+	// Next is the number of the next --worktree-names=sequence worktree.
+	Next int `json:"next"`
+	// Worktrees maps worktree names to hashes.
+	Worktrees map[string]string `json:"worktrees"`
+}
+
+// worktreeNamesMutex serializes changes to the worktreeNamesFile.
+var worktreeNamesMutex sync.Mutex
+
+// isWorktreeName returns true if name is that of a worktree which git-sync
+// made: either a hash, or an opaque name.
+func isWorktreeName(name string) bool {
+	return isHashName(name) || opaqueNameRE.MatchString(name)
+}
+
+// readWorktreeNames reads the worktreeNamesFile in gitRoot.  It is read each
+// time, rather than cached, since the root can be cleared.
+func readWorktreeNames(gitRoot string) (worktreeNameMap, error) {
+	m := worktreeNameMap{Next: 1, Worktrees: map[string]string{}}
+	data, err := ioutil.ReadFile(filepath.Join(gitRoot, worktreeNamesFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("can't parse %s: %w", worktreeNamesFile, err)
+	}
+	if m.Worktrees == nil {
+		m.Worktrees = map[string]string{}
+	}
+	return m, nil
+}
+
+// writeWorktreeNames atomically writes m to the worktreeNamesFile in gitRoot.
+func writeWorktreeNames(gitRoot string, m worktreeNameMap) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(gitRoot, worktreeNamesFile)
+	tmp, err := ioutil.TempFile(gitRoot, "."+worktreeNamesFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// hmacWorktreeName returns the --worktree-names=hmac name for hash.
+func hmacWorktreeName(key []byte, hash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+	return "wt-" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// worktreeNameFor returns the name of the worktree for hash, per
+// --worktree-names, and records which hash it holds.  Other worktrees which
+// are gone are forgotten; the one for hash may not have been made yet.
+func worktreeNameFor(gitRoot, hash string) (string, error) {
+	if *flWorktreeNames == worktreeNamesHash {
+		return hash, nil
+	}
+	worktreeNamesMutex.Lock()
+	defer worktreeNamesMutex.Unlock()
+
+	m, err := readWorktreeNames(gitRoot)
+	if err != nil {
+		return "", err
+	}
+	for name, h := range m.Worktrees {
+		if h == hash {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(gitRoot, name)); os.IsNotExist(err) {
+			delete(m.Worktrees, name)
+		}
+	}
+
+	name := ""
+	switch *flWorktreeNames {
+	case worktreeNamesHMAC:
+		name = hmacWorktreeName(worktreeNameKey, hash)
+	case worktreeNamesSequence:
+		for n, h := range m.Worktrees {
+			if h == hash {
+				name = n
+			}
+		}
+		for name == "" {
+			n := fmt.Sprintf("wt-%06d", m.Next)
+			m.Next++
+			// The file may have been lost, so don't reuse a worktree.
+			if _, err := os.Lstat(filepath.Join(gitRoot, n)); os.IsNotExist(err) {
+				name = n
+			}
+		}
+	}
+	m.Worktrees[name] = hash
+	if err := writeWorktreeNames(gitRoot, m); err != nil {
+		return "", fmt.Errorf("can't record worktree name: %w", err)
+	}
+	return name, nil
+}
+
+// worktreeAdminDir returns the directory in the clone at gitRoot in which
+// git keeps the state of the worktree at worktreePath.  git names it for the
+// worktree's directory, which is not its hash with --worktree-names.
+func worktreeAdminDir(gitRoot, worktreePath string) string {
+	return filepath.Join(gitRoot, ".git", "worktrees", filepath.Base(worktreePath))
+}
+
+// worktreeHash returns the hash which the worktree at path holds, or "" if
+// it is not a worktree which git-sync made.
+func worktreeHash(path string) string {
+	name := filepath.Base(path)
+	if isHashName(name) {
+		return name
+	}
+	if !opaqueNameRE.MatchString(name) {
+		return ""
+	}
+	m, err := readWorktreeNames(filepath.Dir(path))
+	if err != nil {
+		log.Error(err, "can't read worktree names")
+		return ""
+	}
+	return m.Worktrees[name]
+}
+
+// readWorktreeNameKey reads the key for --worktree-names=hmac.
+func readWorktreeNameKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("the key must be at least 16 bytes")
+	}
+	return key, nil
+}
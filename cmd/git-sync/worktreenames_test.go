@@ -0,0 +1,191 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestIsWorktreeName(t *testing.T) {
+	for name, expect := range map[string]bool{
+		strings.Repeat("a", 40): true,
+		"wt-000001":             true,
+		"wt-0123abcd":           true,
+		"wt-":                   false,
+		"wt-XYZ":                false,
+		"repo":                  false,
+		".git-sync.journal":     false,
+	} {
+		if got := isWorktreeName(name); got != expect {
+			t.Errorf("%q: expected %v, got %v", name, expect, got)
+		}
+	}
+}
+
+func TestWorktreeNameFor(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	defer func(names string, key []byte) {
+		*flWorktreeNames, worktreeNameKey = names, key
+	}(*flWorktreeNames, worktreeNameKey)
+	one, two := strings.Repeat("1", 40), strings.Repeat("2", 40)
+
+	root := t.TempDir()
+	*flWorktreeNames = worktreeNamesHash
+	if name, err := worktreeNameFor(root, one); err != nil || name != one {
+		t.Errorf("expected %q, got %q, %v", one, name, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, worktreeNamesFile)); !os.IsNotExist(err) {
+		t.Errorf("expected no names file for hash names")
+	}
+
+	*flWorktreeNames = worktreeNamesSequence
+	first, err := worktreeNameFor(root, one)
+	if err != nil || first != "wt-000001" {
+		t.Fatalf("expected wt-000001, got %q, %v", first, err)
+	}
+	// The same hash keeps its name until it is made.
+	if again, _ := worktreeNameFor(root, one); again != first {
+		t.Errorf("expected %q again, got %q", first, again)
+	}
+	if err := os.Mkdir(filepath.Join(root, first), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A worktree which is in the way isn't reused.
+	if err := os.Mkdir(filepath.Join(root, "wt-000002"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	second, err := worktreeNameFor(root, two)
+	if err != nil || second != "wt-000003" {
+		t.Fatalf("expected wt-000003, got %q, %v", second, err)
+	}
+	if got := worktreeHash(filepath.Join(root, first)); got != one {
+		t.Errorf("expected %q, got %q", one, got)
+	}
+	if got := worktreeHash(filepath.Join(root, second)); got != two {
+		t.Errorf("expected %q, got %q", two, got)
+	}
+	if got := worktreeHash(filepath.Join(root, one)); got != one {
+		t.Errorf("expected a hash name to be its own hash, got %q", got)
+	}
+	for _, name := range []string{"wt-000002", "repo"} {
+		if got := worktreeHash(filepath.Join(root, name)); got != "" {
+			t.Errorf("%s: expected no hash, got %q", name, got)
+		}
+	}
+
+	// Worktrees which are gone are forgotten when the next is named.
+	if err := os.Remove(filepath.Join(root, first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktreeNameFor(root, two); err != nil {
+		t.Fatal(err)
+	}
+	if got := worktreeHash(filepath.Join(root, first)); got != "" {
+		t.Errorf("expected %s to be forgotten, got %q", first, got)
+	}
+
+	*flWorktreeNames, worktreeNameKey = worktreeNamesHMAC, []byte("0123456789abcdef")
+	name, err := worktreeNameFor(root, one)
+	if err != nil || name != hmacWorktreeName(worktreeNameKey, one) || !isWorktreeName(name) || strings.Contains(name, one) {
+		t.Errorf("unexpected hmac name %q, %v", name, err)
+	}
+	if other := hmacWorktreeName([]byte("another key 1234"), one); other == name {
+		t.Errorf("expected the name to depend on the key")
+	}
+	if got := worktreeHash(filepath.Join(root, name)); got != one {
+		t.Errorf("expected %q, got %q", one, got)
+	}
+}
+
+func TestReadWorktreeNameKey(t *testing.T) {
+	dir := t.TempDir()
+	short, long := filepath.Join(dir, "short"), filepath.Join(dir, "long")
+	for path, content := range map[string]string{short: "key", long: "0123456789abcdef"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := readWorktreeNameKey(short); err == nil {
+		t.Errorf("expected an error for a short key")
+	}
+	if _, err := readWorktreeNameKey(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+	if key, err := readWorktreeNameKey(long); err != nil || string(key) != "0123456789abcdef" {
+		t.Errorf("unexpected key %q, %v", key, err)
+	}
+}
+
+func TestCheckoutWorktreeSparseWithOpaqueNames(t *testing.T) {
+	log = &customLogger{Logger: logr.Discard()}
+	if _, err := exec.LookPath(*flGitCmd); err != nil {
+		t.Skipf("%s not found", *flGitCmd)
+	}
+	defer func(names string, excludes []string, policy string) {
+		*flWorktreeNames, flExcludePaths.items, *flRepoConfigPolicy = names, excludes, policy
+	}(*flWorktreeNames, flExcludePaths.items, *flRepoConfigPolicy)
+	*flWorktreeNames, flExcludePaths.items, *flRepoConfigPolicy = worktreeNamesSequence, []string{"*.md"}, repoPolicyOff
+
+	tmp := t.TempDir()
+	upstream := filepath.Join(tmp, "upstream")
+	root := filepath.Join(tmp, "root")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command(*flGitCmd, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(upstream, "init", "-q", "-b", "main")
+	for _, name := range []string{"README.md", "file.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(upstream, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(upstream, "add", ".")
+	git(upstream, "commit", "-q", "-m", "one")
+	hash := git(upstream, "rev-parse", "HEAD")
+	git(tmp, "clone", "-q", "--no-checkout", upstream, root)
+
+	name, err := worktreeNameFor(root, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree := filepath.Join(root, name)
+	if err := checkoutWorktree(context.Background(), root, "main", hash, 0, submodulesOff, worktree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(worktree, "file.txt")); err != nil {
+		t.Errorf("expected file.txt to be checked out: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(worktree, "README.md")); !os.IsNotExist(err) {
+		t.Errorf("expected README.md to be excluded, got %v", err)
+	}
+}
@@ -1,10 +1,17 @@
 module k8s.io/git-sync
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-logr/glogr v1.0.0-rc1
 	github.com/go-logr/logr v1.0.0-rc1
+	github.com/golang/protobuf v1.5.0
 	github.com/google/go-licenses v0.0.0-20210329231322-ce1d9163b77d
 	github.com/prometheus/client_golang v0.9.2
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4
+	github.com/prometheus/common v0.0.0-20181126121408-4724e9255275
+	google.golang.org/grpc v1.38.0
+	google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.1.0
+	google.golang.org/protobuf v1.26.0
 )
 
 go 1.16
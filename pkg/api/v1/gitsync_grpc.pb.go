@@ -0,0 +1,283 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// GitSyncClient is the client API for GitSync service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GitSyncClient interface {
+	// Sync starts the next sync now, rather than after --wait.
+	Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error)
+	// Status returns the same information as /api/v1/status.
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Pause pauses syncing, like /api/v1/pause.  It requires --http-admin.
+	Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error)
+	// Resume resumes syncing, like /api/v1/resume.  It requires --http-admin.
+	Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error)
+	// Watch streams sync lifecycle events, like /api/v1/events.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (GitSync_WatchClient, error)
+}
+
+type gitSyncClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGitSyncClient(cc grpc.ClientConnInterface) GitSyncClient {
+	return &gitSyncClient{cc}
+}
+
+func (c *gitSyncClient) Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error) {
+	out := new(SyncResponse)
+	err := c.cc.Invoke(ctx, "/gitsync.v1.GitSync/Sync", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gitSyncClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/gitsync.v1.GitSync/Status", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gitSyncClient) Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error) {
+	out := new(PauseResponse)
+	err := c.cc.Invoke(ctx, "/gitsync.v1.GitSync/Pause", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gitSyncClient) Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error) {
+	out := new(ResumeResponse)
+	err := c.cc.Invoke(ctx, "/gitsync.v1.GitSync/Resume", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gitSyncClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (GitSync_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GitSync_ServiceDesc.Streams[0], "/gitsync.v1.GitSync/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gitSyncWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GitSync_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type gitSyncWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *gitSyncWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GitSyncServer is the server API for GitSync service.
+// All implementations must embed UnimplementedGitSyncServer
+// for forward compatibility
+type GitSyncServer interface {
+	// Sync starts the next sync now, rather than after --wait.
+	Sync(context.Context, *SyncRequest) (*SyncResponse, error)
+	// Status returns the same information as /api/v1/status.
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	// Pause pauses syncing, like /api/v1/pause.  It requires --http-admin.
+	Pause(context.Context, *PauseRequest) (*PauseResponse, error)
+	// Resume resumes syncing, like /api/v1/resume.  It requires --http-admin.
+	Resume(context.Context, *ResumeRequest) (*ResumeResponse, error)
+	// Watch streams sync lifecycle events, like /api/v1/events.
+	Watch(*WatchRequest, GitSync_WatchServer) error
+	mustEmbedUnimplementedGitSyncServer()
+}
+
+// UnimplementedGitSyncServer must be embedded to have forward compatible implementations.
+type UnimplementedGitSyncServer struct {
+}
+
+func (UnimplementedGitSyncServer) Sync(context.Context, *SyncRequest) (*SyncResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sync not implemented")
+}
+func (UnimplementedGitSyncServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedGitSyncServer) Pause(context.Context, *PauseRequest) (*PauseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pause not implemented")
+}
+func (UnimplementedGitSyncServer) Resume(context.Context, *ResumeRequest) (*ResumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
+}
+func (UnimplementedGitSyncServer) Watch(*WatchRequest, GitSync_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedGitSyncServer) mustEmbedUnimplementedGitSyncServer() {}
+
+// UnsafeGitSyncServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GitSyncServer will
+// result in compilation errors.
+type UnsafeGitSyncServer interface {
+	mustEmbedUnimplementedGitSyncServer()
+}
+
+func RegisterGitSyncServer(s grpc.ServiceRegistrar, srv GitSyncServer) {
+	s.RegisterService(&GitSync_ServiceDesc, srv)
+}
+
+func _GitSync_Sync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GitSyncServer).Sync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.v1.GitSync/Sync",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GitSyncServer).Sync(ctx, req.(*SyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GitSync_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GitSyncServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.v1.GitSync/Status",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GitSyncServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GitSync_Pause_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GitSyncServer).Pause(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.v1.GitSync/Pause",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GitSyncServer).Pause(ctx, req.(*PauseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GitSync_Resume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GitSyncServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.v1.GitSync/Resume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GitSyncServer).Resume(ctx, req.(*ResumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GitSync_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GitSyncServer).Watch(m, &gitSyncWatchServer{stream})
+}
+
+type GitSync_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type gitSyncWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *gitSyncWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// GitSync_ServiceDesc is the grpc.ServiceDesc for GitSync service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GitSync_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gitsync.v1.GitSync",
+	HandlerType: (*GitSyncServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Sync",
+			Handler:    _GitSync_Sync_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _GitSync_Status_Handler,
+		},
+		{
+			MethodName: "Pause",
+			Handler:    _GitSync_Pause_Handler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    _GitSync_Resume_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _GitSync_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gitsync.proto",
+}
@@ -1,3 +1,6 @@
+//go:build !windows
+// +build !windows
+
 /*
 Copyright 2019 The Kubernetes Authors All rights reserved.
 
@@ -30,11 +33,43 @@ import (
 // this will return the exit code. If this returns an error, the child process
 // may not be terminated.
 func ReRun() (int, error) {
+	return reRun(nil)
+}
+
+// subreaperEnv is set in the environment of the child of ReRunAsSubreaper,
+// so that it knows not to do that again.
+const subreaperEnv = "GIT_SYNC_PID1_SUBREAPER_CHILD"
+
+// IsSubreaperChild returns true if the current process was started by
+// ReRunAsSubreaper.
+func IsSubreaperChild() bool {
+	return os.Getenv(subreaperEnv) != ""
+}
+
+// ReRunAsSubreaper is like ReRun, for a process which is not pid 1 but is
+// still the top of its process tree (e.g. when the pod shares its process
+// namespace).  It marks the current process as a child subreaper, so that
+// descendants which are orphaned (e.g. left behind by hook scripts) are
+// re-parented to it, rather than to the real pid 1, and reaped.  This is only
+// supported on Linux.
+func ReRunAsSubreaper() (int, error) {
+	if err := setSubreaper(); err != nil {
+		return 0, err
+	}
+	return reRun([]string{subreaperEnv + "=1"})
+}
+
+// reRun runs the current commandline as a child process, with env added to its
+// environment, and acts as init for it.
+func reRun(env []string) (int, error) {
 	bin, err := os.Readlink("/proc/self/exe")
 	if err != nil {
 		return 0, err
 	}
 	cmd := exec.Command(bin, os.Args[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pid1
+
+import (
+	"fmt"
+)
+
+// ReRun is not supported on Windows, which has no equivalent of pid 1 that a
+// container process could be.
+func ReRun() (int, error) {
+	return 0, fmt.Errorf("pid1 is not supported on Windows")
+}
+
+// IsSubreaperChild is always false on Windows.
+func IsSubreaperChild() bool {
+	return false
+}
+
+// ReRunAsSubreaper is not supported on Windows.
+func ReRunAsSubreaper() (int, error) {
+	return 0, fmt.Errorf("subreaper is not supported on Windows")
+}
@@ -17,3 +17,6 @@ limitations under the License.
 package version
 
 var VERSION = "UNKNOWN"
+
+// GIT_COMMIT is the commit from which the binary was built.
+var GIT_COMMIT = "UNKNOWN"
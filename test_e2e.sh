@@ -234,8 +234,8 @@ ln -s "$ROOT" "$DIR/rootlink" # symlink to test
       --dest="link" \
       > "$DIR"/log."$TESTCASE" 2>&1
   RET=$?
-  if [[ "$RET" != 1 ]]; then
-      fail "expected exit code 1, got $RET"
+  if [[ "$RET" != 11 ]]; then
+      fail "expected exit code 11, got $RET"
   fi
   assert_file_absent "$ROOT"/link
   assert_file_absent "$ROOT"/link/file
@@ -1371,8 +1371,8 @@ git -C "$REPO" commit -qam "$TESTCASE"
       --error-file="error.json" \
       > "$DIR"/log."$TESTCASE" 2>&1
   RET=$?
-  if [[ "$RET" != 1 ]]; then
-      fail "expected exit code 1, got $RET"
+  if [[ "$RET" != 11 ]]; then
+      fail "expected exit code 11, got $RET"
   fi
   assert_file_absent "$ROOT"/link
   assert_file_absent "$ROOT"/link/file
@@ -26,4 +26,8 @@ limitations under the License.
 //   4) go mod vendor  # yes, again
 package tools
 
-import _ "github.com/google/go-licenses"
+import (
+	_ "github.com/google/go-licenses"
+	_ "google.golang.org/grpc/cmd/protoc-gen-go-grpc"
+	_ "google.golang.org/protobuf/cmd/protoc-gen-go"
+)